@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andornaut/mrs/internal/agent"
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/config"
+)
+
+// runAgent holds vaults' keys on the agent's socket until it is interrupted.
+// It stays in the foreground, as a service manager or a terminal of its own
+// expects, and reports on stderr what it forgets and whom it refuses.
+func runAgent(timeout time.Duration) error {
+	if timeout <= 0 {
+		return cli.Usagef("--timeout must be more than 0, but was %s", timeout)
+	}
+	sock, err := config.AgentSocket()
+	if err != nil {
+		return err
+	}
+	l, err := agent.Listen(sock)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "Holding vault keys on %s, for %s after each use\n", sock, timeout)
+	a := agent.New(timeout, func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	})
+	return a.Serve(l)
+}
+
+// runAgentLock has the agent forget every key it holds.
+func runAgentLock() error {
+	err := agent.Lock()
+	if errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintln(os.Stderr, "No agent is running, so it holds no keys")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "The agent forgot every key it held")
+	return nil
+}
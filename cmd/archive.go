@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// runArchiveCreate writes every vault to a new archive at p, sealed under a
+// password of its own with --encrypt.
+func (o *rootOptions) runArchiveCreate(p string) error {
+	// An archive is never written over, since the one there may be the only
+	// copy of vaults that are no longer here.
+	if _, err := os.Lstat(p); err == nil {
+		return fmt.Errorf("%s already exists. Remove it, or write the archive elsewhere", p)
+	}
+	archive, vs, err := vault.CreateArchive(o.backups)
+	if err != nil {
+		return err
+	}
+	if o.encrypt {
+		password, err := prompt.GivenOrPromptConfirmedArchivePassword(o.passwordFile)
+		if err != nil {
+			return err
+		}
+		archive, err = vault.SealArchive(archive, password)
+		crypto.Wipe(password)
+		if err != nil {
+			return err
+		}
+	}
+	if err := fs.WriteFileAtomic(p, archive, 0600); err != nil && !errors.Is(err, fs.ErrDirSync) {
+		return err
+	}
+	what := cli.Plural(len(vs), "vault")
+	if o.backups {
+		what += " and their backups"
+	}
+	if o.encrypt {
+		fmt.Fprintf(os.Stderr, "Archived %d %s to %s, sealed under the archive's password\n", len(vs), what, p)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Archived %d %s to %s\n", len(vs), what, p)
+	return nil
+}
+
+// runArchiveRestore restores every vault in the archive at p, asking for the
+// archive's password first if it is sealed under one.
+func (o *rootOptions) runArchiveRestore(p string) error {
+	archive, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("could not read archive: %w", err)
+	}
+	if vault.IsSealedArchive(archive) {
+		password, err := prompt.GivenOrPromptArchivePassword(o.passwordFile)
+		if err != nil {
+			return err
+		}
+		archive, err = vault.OpenArchive(archive, password)
+		crypto.Wipe(password)
+		if err != nil {
+			return err
+		}
+	}
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+
+	// Asked for only to rename a vault whose name is sealed into it, and
+	// never from --password-file, which holds the archive's.
+	password := func(name string, team bool) ([]byte, error) {
+		if o.vaultPasswordFile != "" && team {
+			return prompt.GivenOrPromptIdentityPassword(o.vaultPasswordFile)
+		}
+		if o.vaultPasswordFile != "" {
+			return prompt.GivenOrPromptVaultPassword(o.vaultPasswordFile, name)
+		}
+		msg := "Password for vault " + name + ", to restore it under a new name"
+		if team {
+			msg = "Identity password, to restore team vault " + name + " under a new name"
+		}
+		p, err := prompt.Password(msg)
+		if errors.Is(err, prompt.ErrNoTerminal) {
+			return nil, fmt.Errorf("%w. Use --vault-password-file to supply it", err)
+		}
+		return p, err
+	}
+	restored, err := vault.RestoreArchive(archive, o.rename, password, keyfile, o.force)
+	for _, r := range restored {
+		name := r.Name()
+		if name != r.From {
+			name = fmt.Sprintf("%s as %s", r.From, r.Name())
+		}
+		if r.Backups > 0 {
+			fmt.Fprintf(os.Stderr, "Restored vault %s, with %d %s\n", name, r.Backups, cli.Plural(r.Backups, "backup"))
+		} else {
+			fmt.Fprintf(os.Stderr, "Restored vault %s\n", name)
+		}
+	}
+	return err
+}
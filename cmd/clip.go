@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/clipboard"
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// errAmbiguousMatch reports that a search that had to find one secret found
+// several. It exits 4, as a key that several secrets share does.
+var errAmbiguousMatch = errors.New("more than one secret matched")
+
+// clipSettings are the clipboard's commands and how long a value stays on it.
+type clipSettings struct {
+	clipboard config.Clipboard
+	timeout   time.Duration
+}
+
+// readClipSettings reads the clipboard's settings, which search --clip does
+// before it opens the vault, so that a clipboard that is not set up fails
+// before a password is asked for.
+func readClipSettings() (clipSettings, error) {
+	cb, err := config.ClipboardCommands()
+	if err != nil {
+		return clipSettings{}, err
+	}
+	timeout, err := config.ClipboardTimeout()
+	if err != nil {
+		return clipSettings{}, err
+	}
+	return clipSettings{cb, timeout}, nil
+}
+
+// runSearchClip copies the value of the one secret that q matches in uv to
+// the clipboard, rather than print it, and leaves a process behind to clear
+// it once the timeout has passed.
+func runSearchClip(c *cobra.Command, uv vault.UnlockedVault, q secret.Query, query string, clip clipSettings) error {
+	key, value, n, err := secret.SearchOne(uv, q)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(value)
+	switch {
+	case n == 0:
+		fmt.Fprintf(os.Stderr, "No secrets matched %q in vault %s\n", query, uv)
+		c.SilenceErrors = true
+		return errNoMatch
+	case n > 1:
+		return fmt.Errorf("%w %q in vault %s: %d did, and --clip copies one. Narrow the search to one secret", errAmbiguousMatch, query, uv, n)
+	}
+	if err := clipboard.Copy(clip.clipboard, value); err != nil {
+		return err
+	}
+	if err := clearClipboardLater(clipboard.Sum(value), clip.timeout); err != nil {
+		// The value is on the clipboard, and nothing will take it off.
+		return fmt.Errorf("copied secret %q to the clipboard, but could not arrange for it to be cleared, so clear it yourself: %w", key, err)
+	}
+	fmt.Fprintf(os.Stderr, "Copied secret %q from vault %s to the clipboard, which will be cleared in %s\n", key, uv, clip.timeout)
+	return nil
+}
+
+// clearClipboardLater starts `mrs clear-clipboard` in a session of its own,
+// so that closing the terminal does not stop it, and hands it the sum of the
+// value to clear on its stdin. The value itself does not outlive this process.
+func clearClipboardLater(sum [sha256.Size]byte, after time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	c := exec.Command(exe, "clear-clipboard", "--after", after.String())
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	_, err = stdin.Write(sum[:])
+	if cerr := stdin.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = c.Process.Kill()
+		_ = c.Wait()
+		return err
+	}
+	return c.Process.Release()
+}
+
+// runClearClipboard waits, then clears the clipboard if it still holds the
+// value whose sum is on stdin. Whatever the user copied in the meantime is
+// theirs, and is left alone.
+func runClearClipboard(after time.Duration) error {
+	if after <= 0 {
+		return cli.Usagef("--after must be more than 0, but was %s", after)
+	}
+	var sum [sha256.Size]byte
+	if _, err := io.ReadFull(os.Stdin, sum[:]); err != nil {
+		return fmt.Errorf("failed to read the sum of the value to clear: %w", err)
+	}
+	cb, err := config.ClipboardCommands()
+	if err != nil {
+		return err
+	}
+	time.Sleep(after)
+	_, err = clipboard.Clear(cb, sum)
+	return err
+}
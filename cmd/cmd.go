@@ -6,10 +6,12 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/andornaut/mrs/cmd/vaultcmd"
+	"github.com/andornaut/mrs/internal/agent"
 	"github.com/andornaut/mrs/internal/cli"
 	"github.com/andornaut/mrs/internal/crypto"
 	"github.com/andornaut/mrs/internal/prompt"
@@ -46,13 +48,17 @@ var Cmd = &cobra.Command{
 var errNoMatch = errors.New("no secrets matched")
 
 // Exit codes. 2 is kept for a wrong invocation so that a script can tell a
-// command it typed wrong from one that ran and failed, and 3 for a search that
-// matched nothing, which is neither. A run cut short by a signal exits
-// 128+signum, which cannot collide with any of these.
+// command it typed wrong from one that ran and failed, 3 for a command that
+// looked for secrets and matched nothing, which is neither, and 4 for one that
+// matched several where it needed one, which a script resolves differently
+// again. A run cut short by a signal exits 128+signum, which cannot collide
+// with any of these. mrs exec exits with the status of the command it ran,
+// once that command has started.
 const (
-	exitFailed  = 1
-	exitUsage   = 2
-	exitNoMatch = 3
+	exitFailed    = 1
+	exitUsage     = 2
+	exitNoMatch   = 3
+	exitAmbiguous = 4
 )
 
 // ExitCode returns the status that mrs should exit with for the given error,
@@ -61,9 +67,15 @@ func ExitCode(err error) int {
 	if err == nil {
 		return 0
 	}
-	if errors.Is(err, errNoMatch) {
+	if e, ok := errors.AsType[commandError](err); ok {
+		return e.status
+	}
+	if errors.Is(err, errNoMatch) || errors.Is(err, secret.ErrNoSuchKey) || errors.Is(err, secret.ErrNoSuchField) {
 		return exitNoMatch
 	}
+	if errors.Is(err, secret.ErrAmbiguousKey) || errors.Is(err, errAmbiguousMatch) {
+		return exitAmbiguous
+	}
 	if _, ok := errors.AsType[cli.UsageError](err); ok {
 		return exitUsage
 	}
@@ -83,17 +95,44 @@ func noEditorArgs(c *cobra.Command, args []string) error {
 }
 
 type rootOptions struct {
-	assumeYes     bool
-	force         bool
-	includeValues bool
-	namePrefix    string
-	passwordFile  string
+	all               bool
+	allVaults         bool
+	assumeYes         bool
+	backups           bool
+	clip              bool
+	create            bool
+	encrypt           bool
+	envs              []string
+	field             string
+	force             bool
+	format            string
+	generateKey       string
+	classes           string
+	importFormat      string
+	inputFile         string
+	includeValues     bool
+	keyfile           string
+	length            int
+	line              int
+	match             string
+	matchField        string
+	namePrefix        string
+	noAmbiguous       bool
+	outputFile        string
+	passwordFile      string
+	print             bool
+	rename            bool
+	separator         string
+	timeout           time.Duration
+	valueFile         string
+	vaultPasswordFile string
+	words             int
 }
 
 // unlocked resolves the vault, takes its exclusive lock and unlocks it with the
-// user's password, then hands it to fn. The order is the point: the lock is
-// taken before the password is asked for, so that a user does not type one for
-// a vault another process is already writing.
+// agent's key or the user's password, then hands it to fn. The order is the
+// point: the lock is taken before the password is asked for, so that a user
+// does not type one for a vault another process is already writing.
 func (o *rootOptions) unlocked(fn func(vault.UnlockedVault) error) error {
 	v, err := vault.Named(o.namePrefix)
 	if err != nil {
@@ -105,12 +144,11 @@ func (o *rootOptions) unlocked(fn func(vault.UnlockedVault) error) error {
 	}
 	defer unlock()
 
-	password, err := prompt.GivenOrPromptPassword(o.passwordFile)
+	uv, done, err := o.unlock(v)
 	if err != nil {
 		return err
 	}
-	uv := v.Unlocked(password)
-	defer uv.Wipe()
+	defer done()
 	return fn(uv)
 }
 
@@ -118,12 +156,23 @@ func init() {
 	opts := &rootOptions{}
 
 	add := &cobra.Command{
-		Use:                   "add",
-		Short:                 "Add secrets to a vault",
-		Long:                  "Use an editor ($EDITOR) to add secrets to a vault",
+		Use:   "add",
+		Short: "Add secrets to a vault",
+		Long: "Use an editor ($EDITOR) to add secrets to a vault.\n\n" +
+			"--generate <key> adds a secret whose value is a new password, or with --words\n" +
+			"a passphrase, made up as \"mrs generate\" makes one up, instead of opening an\n" +
+			"editor. It refuses a key that a secret already has, and --print prints the\n" +
+			"value once it is stored.",
+		Example:               "  mrs add\n  mrs add --generate github --no-ambiguous --print",
 		Args:                  noEditorArgs,
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			if err := checkAddFlags(c); err != nil {
+				return err
+			}
+			if c.Flags().Changed("generate") {
+				return opts.runAddGenerated(c)
+			}
 			return opts.unlocked(func(uv vault.UnlockedVault) error {
 				n, err := secret.Add(uv)
 				if err != nil {
@@ -166,7 +215,17 @@ func init() {
 		Short: "Search for secrets in a vault",
 		Long: "Search a vault for secrets whose key matches a regular expression.\n" +
 			"Several arguments are joined, so \"mrs search aws key\" matches \"aws key\"\n" +
-			"with any amount of whitespace between the words.",
+			"with any amount of whitespace between the words.\n\n" +
+			"Lines of a secret's value that read \"<name>: <value>\" are fields.\n" +
+			"--match-field matches the expression against one field's value instead\n" +
+			"of the key, and --field prints one field's value instead of the secret.\n\n" +
+			"--all-vaults searches every vault, asking for each password once, and\n" +
+			"skips a vault that does not open rather than stop.\n\n" +
+			"--clip copies the value of the one secret that matches, or its --field,\n" +
+			"to the clipboard instead, and clears it after $MRS_CLIPBOARD_TIMEOUT\n" +
+			"unless something else has been copied since.",
+		Example: "  mrs search github --field password\n  mrs search --match-field url github.com\n  mrs search --all-vaults aws\n" +
+			"  mrs search --clip '^github$' --field password",
 		Args: func(c *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return cli.Usagef("%s requires a regular expression, as in \"%s aws\"", c.CommandPath(), c.CommandPath())
@@ -178,50 +237,386 @@ func init() {
 		},
 	}
 
-	export := &cobra.Command{
-		Use:                   "export",
-		Short:                 "Print every secret in a vault",
-		Long:                  "Print a vault's secrets to stdout, in the shape a vault is written in",
-		Args:                  cli.NoArgs,
+	get := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print the value of one secret",
+		Long: "Print the value of the secret whose key is <key>, ignoring case: every line\n" +
+			"after the key, or only one of them with --line. Nothing else is printed, so\n" +
+			"that a script can use the value as it is. It exits 3 when no secret has the\n" +
+			"key, and 4 when several do.",
+		Args:                  cli.RequireArgs(1, 1, "the key of a secret"),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			// Reading, so it takes a prefix and falls back to the default
-			// vault, as search does. The two differ only in what they print.
+			if c.Flags().Changed("line") && opts.line < 1 {
+				return cli.Usagef("--line counts from 1, but was %d", opts.line)
+			}
 			v, err := vault.Named(opts.namePrefix)
 			if err != nil {
 				return err
 			}
-			password, err := prompt.GivenOrPromptPassword(opts.passwordFile)
+			uv, done, err := opts.unlock(v)
 			if err != nil {
 				return err
 			}
-			defer crypto.Wipe(password)
+			defer done()
 
-			secrets, err := vault.Export(v, password)
+			value, err := secret.Get(uv, args[0], opts.line)
 			if err != nil {
 				return err
 			}
-			defer crypto.Wipe(secrets)
-			_, err = os.Stdout.Write(secrets)
+			defer crypto.Wipe(value)
+			_, err = os.Stdout.Write(value)
 			return err
 		},
 	}
 
+	totpCmd := &cobra.Command{
+		Use:   "totp <key>",
+		Short: "Print a two-factor code from a secret",
+		Long: "Print the current time-based one-time password of the secret whose key is\n" +
+			"<key>, ignoring case, from the first line of its value that is an\n" +
+			"otpauth://totp/ URI, or from its totp field, which holds a URI or the bare\n" +
+			"base32 seed. The code is printed alone, and how many seconds it remains\n" +
+			"current is reported on stderr. The seed itself is never printed.",
+		Example:               "  mrs totp github\n  mrs totp -v work aws | wl-copy",
+		Args:                  cli.RequireArgs(1, 1, "the key of a secret"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runTOTP(args[0])
+		},
+	}
+
+	set := &cobra.Command{
+		Use:   "set <key>",
+		Short: "Set the value of one secret",
+		Long: "Give the secret whose key is <key>, ignoring case, the value read from stdin\n" +
+			"or --value-file, and add it if there is none. Trailing line breaks are\n" +
+			"removed, and a value cannot contain a blank line, since that separates one\n" +
+			"secret from the next.",
+		Args:                  cli.RequireArgs(1, 1, "the key of a secret"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			key := args[0]
+			return opts.unlocked(func(uv vault.UnlockedVault) error {
+				value, err := prompt.GivenOrReadValue(opts.valueFile)
+				if err != nil {
+					return err
+				}
+				defer crypto.Wipe(value)
+
+				n, err := secret.Set(uv, key, value, opts.all)
+				if err != nil {
+					return withAllHint(err)
+				}
+				switch n {
+				case 0:
+					fmt.Fprintf(os.Stderr, "Added secret %q to vault %s\n", key, uv)
+				case 1:
+					fmt.Fprintf(os.Stderr, "Replaced secret %q in vault %s\n", key, uv)
+				default:
+					fmt.Fprintf(os.Stderr, "Replaced %d secrets %q in vault %s with one\n", n, key, uv)
+				}
+				return nil
+			})
+		},
+	}
+
+	rm := &cobra.Command{
+		Use:                   "rm <key>",
+		Short:                 "Remove one secret",
+		Long:                  "Remove the secret whose key is <key>, ignoring case",
+		Args:                  cli.RequireArgs(1, 1, "the key of a secret"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			key := args[0]
+			return opts.unlocked(func(uv vault.UnlockedVault) error {
+				n, err := secret.Remove(uv, key, opts.all)
+				if err != nil {
+					return withAllHint(err)
+				}
+				fmt.Fprintf(os.Stderr, "Removed %d %s %q from vault %s\n", n, cli.Plural(n, "secret"), key, uv)
+				return nil
+			})
+		},
+	}
+
+	execCmd := &cobra.Command{
+		Use:   "exec --env NAME=key[:field]... [--] <command> [argument]...",
+		Short: "Run a command with secrets in its environment",
+		Long: "Run a command with environment variables set to secrets' values, read from\n" +
+			"a vault without writing them anywhere. NAME=key sets NAME to the value of\n" +
+			"the secret whose key is key, ignoring case, and NAME=key:field to one of its\n" +
+			"fields. A key that has a colon of its own ends in one, as in NAME=key:.\n\n" +
+			"mrs passes SIGTERM and SIGHUP on to the command, and leaves Ctrl-C and\n" +
+			"Ctrl-\\ to the terminal, which sends them to the command itself. It exits\n" +
+			"with the command's status.",
+		Example: "  mrs exec --env DB_PASSWORD=postgres:password -- psql -h db.example\n" +
+			"  mrs exec -v work --env TOKEN=github -- ./deploy.sh",
+		Args: func(c *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return cli.Usagef("%s requires a command to run, as in \"%s --env TOKEN=github -- ./deploy.sh\"", c.CommandPath(), c.CommandPath())
+			}
+			return nil
+		},
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runExec(c, opts.envs, args)
+		},
+	}
+	// The command's own flags are not mrs's, so they end at its name, with or
+	// without a "--" before it.
+	execCmd.Flags().SetInterspersed(false)
+
+	inject := &cobra.Command{
+		Use:   "inject -i <template> [-o <file>]",
+		Short: "Fill a template's placeholders with secrets",
+		Long: "Replace each {{ mrs \"vault/key\" }} in a template with the value of the\n" +
+			"secret whose key is key in that vault, and each {{ mrs \"vault/key\" \"field\" }}\n" +
+			"with one of its fields. A placeholder that names no vault, {{ mrs \"key\" }},\n" +
+			"reads the vault --vault names. Each vault is opened once, and a placeholder\n" +
+			"that names no value fails the whole template. Other {{ }} are left as they are.\n\n" +
+			"The result is written to stdout, or with --output-file to a file only you can read.",
+		Example:               "  mrs inject -i config.yaml.tmpl -o config.yaml",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runInject()
+		},
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import --format <format> [-v <vault>] [--create] [<file>]",
+		Short: "Import secrets from another password manager",
+		Long: "Import the secrets in another password manager's export into a vault.\n" +
+			"Each entry becomes a secret whose key is its title and whose lines are its\n" +
+			"fields, as \"name: value\". The secrets are added to the vault --vault names,\n" +
+			"or, with --create, to a new vault of that name.\n\n" +
+			"Formats: csv, 1password-csv, bitwarden-json, keepass-xml, dotenv, and pass,\n" +
+			"which reads a password store with \"pass show\": <file> is its directory,\n" +
+			"$PASSWORD_STORE_DIR or ~/.password-store if not given.",
+		Example: "  mrs import --format bitwarden-json -v personal bitwarden.json\n" +
+			"  mrs import --format pass -v personal --create",
+		Args:                  cli.RequireArgs(0, 1, "the export to import, and no more"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runImport(args)
+		},
+	}
+
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Print every secret in a vault",
+		Long: "Print a vault's secrets to stdout, in the shape a vault is written in, or\n" +
+			"in a format another tool reads: json, ndjson, csv, dotenv, or k8s-secret\n" +
+			"for the manifest of a Kubernetes Secret. --match exports only the secrets\n" +
+			"whose key matches a regular expression, as search matches them, and\n" +
+			"--field only one field's value of each.",
+		Example:               "  mrs export --format dotenv --match '^aws'\n  mrs export --format k8s-secret --field password | kubectl apply -f -",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runExport(c)
+		},
+	}
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Print a new password or passphrase",
+		Long: "Print a new password of random characters, with at least one from each class\n" +
+			"--classes names, or with --words a passphrase of random words from the EFF's\n" +
+			"large wordlist. Nothing else is printed, so it can be piped to \"mrs set\".",
+		Example:               "  mrs generate --length 32 --no-ambiguous\n  mrs generate --words 6 | mrs set wifi",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runGenerate(c)
+		},
+	}
+
+	agentCmd := &cobra.Command{
+		Use:   "agent [--timeout <duration>]",
+		Short: "Hold vault keys so that commands do not ask for passwords",
+		Long: "Hold the key that opens each vault once a command has been given its\n" +
+			"password, so that the commands that read or write secrets use it rather than\n" +
+			"ask again and derive it again. The agent holds keys, never passwords, and\n" +
+			"forgets them all once it has not been used for --timeout, on \"mrs agent lock\",\n" +
+			"and when it exits. It runs in the foreground, listening on\n" +
+			"$XDG_RUNTIME_DIR/mrs-agent.sock, and answers only processes of its own user.\n\n" +
+			"--password-file is used as it is given, rather than the key the agent holds.",
+		Example:               "  mrs agent --timeout 1h &\n  mrs agent lock",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runAgent(opts.timeout)
+		},
+	}
+	agentCmd.AddCommand(&cobra.Command{
+		Use:                   "lock",
+		Short:                 "Make the agent forget every key it holds",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runAgentLock()
+		},
+	})
+
+	identityCmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Manage the identity that opens your team vaults",
+		Long: "Manage your identity: the X25519 key pair that opens the team vaults you are a\n" +
+			"member of, in place of a password of each vault's own. Its private key is kept\n" +
+			"in $MRS_HOME/identity, sealed under a password, and its public key in\n" +
+			"$MRS_HOME/identity.pub, for a member of a team vault to add you with.",
+		Args:                  cli.NeedsCommand,
+		RunE:                  func(c *cobra.Command, args []string) error { return nil },
+		DisableFlagsInUseLine: true,
+	}
+	var identityName string
+	identityCreate := &cobra.Command{
+		Use:                   "create [--name <name>]",
+		Short:                 "Create your identity",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runIdentityCreate(identityName)
+		},
+	}
+	identityCreate.Flags().StringVar(&identityName, "name", "", "the name that the members of your vaults see you as, instead of user@host")
+	identityCreate.Flags().StringVarP(&opts.passwordFile, "password-file", "p", "", "path to a file that contains your password")
+	identityCmd.AddCommand(identityCreate, &cobra.Command{
+		Use:                   "show",
+		Short:                 "Print your public key",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runIdentityShow()
+		},
+	})
+
+	archiveCmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Move every vault to another machine in one file",
+		Long: "Write every vault, encrypted as it is on disk, to one file that \"mrs archive\n" +
+			"restore\" restores them from on another machine, along with a manifest of\n" +
+			"their names, salts and checksums. Lock files and leftover temporary files\n" +
+			"are never archived.",
+		Args:                  cli.NeedsCommand,
+		RunE:                  func(c *cobra.Command, args []string) error { return nil },
+		DisableFlagsInUseLine: true,
+	}
+	archiveCreate := &cobra.Command{
+		Use:   "create <file>",
+		Short: "Write every vault to an archive",
+		Long: "Write every vault to a new archive, and with --backups their backups too.\n" +
+			"Nothing is decrypted, so nothing is asked for, unless --encrypt seals the\n" +
+			"archive under a password of its own as well.",
+		Example:               "  mrs archive create --backups --encrypt vaults.mrs",
+		Args:                  cli.RequireArgs(1, 1, "the path of the archive to write"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if opts.passwordFile != "" && !opts.encrypt {
+				return cli.Usagef("--password-file is the archive's password, so it needs --encrypt")
+			}
+			return opts.runArchiveCreate(args[0])
+		},
+	}
+	archiveRestore := &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore every vault in an archive",
+		Long: "Restore every vault in an archive that \"mrs archive create\" wrote, under the\n" +
+			"name and salt it had, once every file in it matches the manifest. If a vault\n" +
+			"of the same name is here already, nothing is restored, unless --rename\n" +
+			"restores the archive's as <name>-restored. A vault renamed that way is\n" +
+			"re-encrypted under its new name, so its password is asked for.",
+		Example:               "  mrs archive restore vaults.mrs\n  mrs archive restore --rename vaults.mrs",
+		Args:                  cli.RequireArgs(1, 1, "the path of an archive"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runArchiveRestore(args[0])
+		},
+	}
+	archiveCreate.Flags().BoolVar(&opts.backups, "backups", false, "archive each vault's backups as well")
+	archiveCreate.Flags().BoolVar(&opts.encrypt, "encrypt", false, "seal the archive under a password of its own")
+	for _, c := range []*cobra.Command{archiveCreate, archiveRestore} {
+		c.Flags().StringVarP(&opts.passwordFile, "password-file", "p", "", "path to a file that contains the archive's password")
+	}
+	archiveRestore.Flags().BoolVar(&opts.rename, "rename", false, "restore a vault whose name is taken as <name>-restored")
+	archiveRestore.Flags().StringVar(&opts.vaultPasswordFile, "vault-password-file", "", "path to a file that contains the password of each vault --rename re-encrypts")
+	archiveRestore.Flags().StringVar(&opts.keyfile, "keyfile", "", "path to the keyfile of a vault that --rename re-encrypts, for one that has one")
+	archiveRestore.Flags().BoolVar(&opts.force, "force", false, "delete each vault's lock file first")
+	archiveCmd.AddCommand(archiveCreate, archiveRestore)
+
+	// Started by search --clip, which hands it the sum of what it copied.
+	var clearAfter time.Duration
+	clearClipboard := &cobra.Command{
+		Use:                   "clear-clipboard --after <duration>",
+		Short:                 "Clear a value search --clip copied, once it is due",
+		Hidden:                true,
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runClearClipboard(clearAfter)
+		},
+	}
+	clearClipboard.Flags().DurationVar(&clearAfter, "after", 0, "how long to wait before clearing it")
+
+	lock := &cobra.Command{
+		Use:   "lock [<vault>]",
+		Short: "Forget the keys kept for vaults",
+		Long: "Remove the key kept for a vault, or with no vault named for every vault,\n" +
+			"from mrs agent and from the kernel keyring $MRS_KEYRING names, so that the\n" +
+			"next command that opens it asks for its password.",
+		Example:               "  mrs lock\n  mrs lock work",
+		Args:                  cli.RequireArgs(0, 1, "the name of a vault, and no more"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return runLock(name)
+		},
+	}
+
 	// Every command here reads or writes secrets in a vault it does not create,
-	// destroy or move, so each takes the same two flags with the same meaning.
+	// destroy or move, so each takes the same three flags with the same meaning.
 	// The vault may be named by a prefix, which has to fit exactly one vault.
-	for _, c := range []*cobra.Command{add, edit, search, export} {
+	for _, c := range []*cobra.Command{add, edit, execCmd, get, importCmd, inject, set, rm, search, export, totpCmd} {
 		c.Flags().StringVarP(&opts.namePrefix, "vault", "v", "", "name of a vault, or the start of one")
 		c.Flags().StringVarP(&opts.passwordFile, "password-file", "p", "", "path to a file that contains your password")
+		c.Flags().StringVar(&opts.keyfile, "keyfile", "", "path to the vault's keyfile, for a vault that has one")
 	}
 	// --force has no short form, because it is not the flag a hurried -f is
 	// reaching for: it breaks another process's lock rather than overwriting
 	// anything, and is worth spelling out.
-	for _, c := range []*cobra.Command{add, edit} {
+	for _, c := range []*cobra.Command{add, edit, importCmd, set, rm} {
 		c.Flags().BoolVar(&opts.force, "force", false, "delete the vault's lock file first")
 	}
 	edit.Flags().BoolVarP(&opts.assumeYes, "yes", "y", false, "answer yes to the confirmation before emptying the vault")
+	for _, c := range []*cobra.Command{add, generateCmd} {
+		generateFlags(c, opts)
+	}
+	add.Flags().StringVar(&opts.generateKey, "generate", "", "add a secret with this key and a value made up for it, instead of using an editor")
+	add.Flags().BoolVar(&opts.print, "print", false, "print the value --generate made up once it is stored")
+	set.Flags().BoolVar(&opts.all, "all", false, "replace every secret that has the key with the one")
+	rm.Flags().BoolVar(&opts.all, "all", false, "remove every secret that has the key")
+	set.Flags().StringVar(&opts.valueFile, "value-file", "", "path to a file that contains the value, instead of stdin")
+	execCmd.Flags().StringArrayVar(&opts.envs, "env", nil, "NAME=key or NAME=key:field, an environment variable to set to a secret's value")
+	importCmd.Flags().StringVar(&opts.importFormat, "format", "", "the export's format: "+formatList(secret.ImportFormats))
+	importCmd.Flags().BoolVar(&opts.create, "create", false, "create the vault --vault names, rather than add to it")
+	inject.Flags().StringVarP(&opts.inputFile, "input-file", "i", "", "path to the template to fill")
+	inject.Flags().StringVarP(&opts.outputFile, "output-file", "o", "", "path to write the result to, instead of stdout")
+	agentCmd.Flags().DurationVar(&opts.timeout, "timeout", agent.DefaultTimeout, "forget every key once the agent has not been used for this long")
+	get.Flags().IntVarP(&opts.line, "line", "l", 0, "print only this line of the value, counting from 1")
 	search.Flags().BoolVarP(&opts.includeValues, "full", "f", false, "search the full contents, instead of the first line of each secret")
+	search.Flags().StringVar(&opts.field, "field", "", "print only the value of this field of each secret that matches")
+	search.Flags().StringVar(&opts.format, "format", string(cli.FormatText), "print text, json, or ndjson for one JSON object a line")
+	export.Flags().StringVar(&opts.format, "format", string(cli.FormatText), "print "+formatList(secret.ExportFormats))
+	export.Flags().StringVar(&opts.match, "match", "", "export only the secrets whose key matches this regular expression")
+	export.Flags().StringVar(&opts.field, "field", "", "export only the value of this field of each secret that has it")
+	search.Flags().BoolVar(&opts.allVaults, "all-vaults", false, "search every vault, instead of one")
+	search.Flags().BoolVar(&opts.clip, "clip", false, "copy the value of the one secret that matches to the clipboard, instead of printing it")
+	search.Flags().StringVar(&opts.matchField, "match-field", "", "search the value of this field, instead of the first line of each secret")
 	// Registered here so that cobra does not add it with a "-v" shorthand of
 	// its own, which would make -v mean --version on `mrs` and --vault on
 	// every command under it.
@@ -231,11 +626,21 @@ func init() {
 	// The generated completion command is noise in the listing of a program
 	// with this few commands, and still works when it is not listed.
 	Cmd.CompletionOptions.HiddenDefaultCmd = true
-	Cmd.AddCommand(add, edit, export, search, vaultcmd.Cmd)
+	Cmd.AddCommand(add, agentCmd, archiveCmd, clearClipboard, edit, execCmd, export, generateCmd, get, identityCmd, importCmd, inject, lock, rm, search, set, totpCmd, vaultcmd.Cmd)
 }
 
-// runSearch compiles the query, reads the vault, and reports what matched.
-func (o *rootOptions) runSearch(c *cobra.Command, args []string) error {
+// withAllHint names the flag that resolves a key that several secrets share,
+// for set and rm, which are the commands that have it.
+func withAllHint(err error) error {
+	if errors.Is(err, secret.ErrAmbiguousKey) {
+		return fmt.Errorf("%w. Use --all to change every one of them, or \"mrs edit\" to choose", err)
+	}
+	return err
+}
+
+// keyPattern compiles the regular expression that search's arguments, or
+// export's --match, are, and returns it along with what the user typed.
+func keyPattern(args []string) (*regexp.Regexp, string, error) {
 	// Internal whitespace is stripped by cobra, so we search for any amount of internal whitespace.
 	// Users can surround a single argument with quotation marks for more precise control of internal whitespace.
 	// Additionally, add a "case-insensitive" flag.
@@ -246,21 +651,79 @@ func (o *rootOptions) runSearch(c *cobra.Command, args []string) error {
 	query := strings.Join(args, " ")
 	r, err := regexp.Compile(rs)
 	if err != nil {
-		return fmt.Errorf("invalid regular expression %q: %w", query, err)
+		return nil, "", fmt.Errorf("invalid regular expression %q: %w", query, err)
+	}
+	return r, query, nil
+}
+
+// checkFieldFlags refuses a flag among names that was given a field name that
+// no line of a secret could have.
+func checkFieldFlags(c *cobra.Command, names ...string) error {
+	for _, name := range names {
+		if !c.Flags().Changed(name) {
+			continue
+		}
+		f, _ := c.Flags().GetString(name)
+		if strings.TrimSpace(f) == "" || strings.ContainsAny(f, ":\n") {
+			return cli.Usagef("--%s takes the name of a field, which is not blank and has no colon, but was %q", name, f)
+		}
+	}
+	return nil
+}
+
+// runSearch compiles the query, reads the vault, and reports what matched.
+func (o *rootOptions) runSearch(c *cobra.Command, args []string) error {
+	if err := checkFieldFlags(c, "field", "match-field"); err != nil {
+		return err
+	}
+	format, err := cli.ParseFormat(o.format, cli.FormatText, cli.FormatJSON, cli.FormatNDJSON)
+	if err != nil {
+		return err
+	}
+	if o.includeValues && o.matchField != "" {
+		return cli.Usagef("--full and --match-field choose different things to search, so they cannot be used together")
+	}
+	if o.allVaults && o.namePrefix != "" {
+		return cli.Usagef("--all-vaults searches every vault, so it cannot be used with --vault")
+	}
+	if o.clip && (o.allVaults || format != cli.FormatText) {
+		return cli.Usagef("--clip copies one secret's value, so it cannot be used with --all-vaults or --format")
+	}
+	r, query, err := keyPattern(args)
+	if err != nil {
+		return err
+	}
+	q := secret.Query{
+		Pattern:       r,
+		IncludeValues: o.includeValues,
+		MatchField:    strings.TrimSpace(o.matchField),
+		Field:         strings.TrimSpace(o.field),
+		Format:        format,
+	}
+	if o.allVaults {
+		return o.runSearchAll(c, q, query)
+	}
+	var clip clipSettings
+	if o.clip {
+		if clip, err = readClipSettings(); err != nil {
+			return err
+		}
 	}
 	v, err := vault.Named(o.namePrefix)
 	if err != nil {
 		return err
 	}
 
-	password, err := prompt.GivenOrPromptPassword(o.passwordFile)
+	uv, done, err := o.unlock(v)
 	if err != nil {
 		return err
 	}
-	uv := v.Unlocked(password)
-	defer uv.Wipe()
+	defer done()
 
-	secrets, n, err := secret.Search(uv, *r, o.includeValues)
+	if o.clip {
+		return runSearchClip(c, uv, q, query, clip)
+	}
+	secrets, n, err := secret.Search(uv, q)
 	if err != nil {
 		return err
 	}
@@ -269,7 +732,16 @@ func (o *rootOptions) runSearch(c *cobra.Command, args []string) error {
 	// `mrs search aws > keys` and `mrs search aws | less` carry the secrets
 	// alone, as `mrs export` already does.
 	if n == 0 {
-		fmt.Fprintf(os.Stderr, "No secrets matched %q in vault %s\n", query, uv)
+		if o.field != "" {
+			fmt.Fprintf(os.Stderr, "No secrets that have a field %q matched %q in vault %s\n", strings.TrimSpace(o.field), query, uv)
+		} else {
+			fmt.Fprintf(os.Stderr, "No secrets matched %q in vault %s\n", query, uv)
+		}
+		// --format json still prints its object, with a count of 0, so that a
+		// program reading it need not tell an empty stdout from a broken one.
+		if _, err := os.Stdout.Write(secrets); err != nil {
+			return err
+		}
 		// Matching nothing is a result, not a failure, so the error that
 		// carries the exit status is not printed as one.
 		c.SilenceErrors = true
@@ -279,3 +751,61 @@ func (o *rootOptions) runSearch(c *cobra.Command, args []string) error {
 	_, err = os.Stdout.Write(secrets)
 	return err
 }
+
+// runExport reads the vault and prints the secrets that --match and --field
+// select, in --format.
+func (o *rootOptions) runExport(c *cobra.Command) error {
+	if err := checkFieldFlags(c, "field"); err != nil {
+		return err
+	}
+	format, err := cli.ParseFormat(o.format, secret.ExportFormats...)
+	if err != nil {
+		return err
+	}
+	var (
+		r     *regexp.Regexp
+		query string
+	)
+	if c.Flags().Changed("match") {
+		if r, query, err = keyPattern([]string{o.match}); err != nil {
+			return err
+		}
+	}
+	// Reading, so it takes a prefix and falls back to the default vault, as
+	// search does. The two differ only in what they print.
+	v, err := vault.Named(o.namePrefix)
+	if err != nil {
+		return err
+	}
+	uv, done, err := o.unlock(v)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	field := strings.TrimSpace(o.field)
+	secrets, n, err := secret.Export(uv, secret.Query{Pattern: r, Field: field, Format: format})
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(secrets)
+	if _, err := os.Stdout.Write(secrets); err != nil {
+		return err
+	}
+	// An empty vault exports as nothing, as it should, but an export that
+	// selected nothing was likely not the one meant, and exits as a search
+	// that matched nothing does.
+	if n > 0 || (r == nil && field == "") {
+		return nil
+	}
+	switch {
+	case field != "" && r != nil:
+		fmt.Fprintf(os.Stderr, "No secrets that have a field %q matched %q in vault %s\n", field, query, uv)
+	case field != "":
+		fmt.Fprintf(os.Stderr, "No secrets have a field %q in vault %s\n", field, uv)
+	default:
+		fmt.Fprintf(os.Stderr, "No secrets matched %q in vault %s\n", query, uv)
+	}
+	c.SilenceErrors = true
+	return errNoMatch
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// A shell exits 127 for a command it cannot find and 126 for one it found but
+// could not run, and mrs exec does the same, so that a script can tell a
+// missing command from one that ran and failed.
+const (
+	exitCannotRun = 126
+	exitNotFound  = 127
+)
+
+// envName is what a POSIX shell accepts as the name of a variable.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// running is the command mrs exec started, while it runs. See ForwardSignal.
+var running atomic.Pointer[os.Process]
+
+// ForwardSignal passes s on to the command mrs exec is running, and reports
+// whether there was one. While there is, the signals that would otherwise end
+// mrs end the command instead, and mrs exits with whatever status the command
+// exits with, rather than leave it running without the process that started
+// it. SIGINT and SIGQUIT are not passed on: the command runs in mrs's process
+// group, so a terminal's Ctrl-C or Ctrl-\ has reached it already, and a second
+// one would look to it like the user pressing the keys again.
+func ForwardSignal(s os.Signal) bool {
+	p := running.Load()
+	if p == nil {
+		return false
+	}
+	if s != os.Interrupt && s != syscall.SIGQUIT {
+		_ = p.Signal(s)
+	}
+	return true
+}
+
+// commandError carries the status that mrs exec exits with for the command it
+// ran: the command's own, or 126 or 127 for one it could not start. err is
+// nil for a command that ran, whose failure is its own to report.
+type commandError struct {
+	status int
+	err    error
+}
+
+func (e commandError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("the command exited with status %d", e.status)
+	}
+	return e.err.Error()
+}
+
+func (e commandError) Unwrap() error { return e.err }
+
+// envMapping is one --env: the variable to set, and the value to set it to.
+type envMapping struct {
+	name string
+	ref  secret.Ref
+}
+
+// parseEnv reads --env NAME=key[:field]. The field is what follows the key's
+// last colon, so a key that has a colon of its own is written with a trailing
+// one, NAME=key:, which names its whole value.
+func parseEnv(envs []string) ([]envMapping, error) {
+	if len(envs) == 0 {
+		return nil, cli.Usagef("mrs exec requires at least one --env NAME=key, to say which secret to set")
+	}
+	mappings := make([]envMapping, 0, len(envs))
+	var names []string
+	for _, e := range envs {
+		name, ref, ok := strings.Cut(e, "=")
+		if !ok || ref == "" {
+			return nil, cli.Usagef("--env takes NAME=key or NAME=key:field, but was %q", e)
+		}
+		if !envName.MatchString(name) {
+			return nil, cli.Usagef("--env %q does not name an environment variable: a name is letters, digits and _, and does not start with a digit", e)
+		}
+		if slices.Contains(names, name) {
+			return nil, cli.Usagef("--env sets %s more than once", name)
+		}
+		names = append(names, name)
+		key, field := ref, ""
+		if i := strings.LastIndexByte(ref, ':'); i >= 0 {
+			key, field = ref[:i], ref[i+1:]
+		}
+		if strings.TrimSpace(key) == "" {
+			return nil, cli.Usagef("--env %q names no key", e)
+		}
+		mappings = append(mappings, envMapping{name, secret.Ref{Key: key, Field: strings.TrimSpace(field)}})
+	}
+	return mappings, nil
+}
+
+// runExec reads the values that envs name from the vault, and runs args with
+// them added to the environment, waiting for it to exit.
+func (o *rootOptions) runExec(c *cobra.Command, envs, args []string) error {
+	mappings, err := parseEnv(envs)
+	if err != nil {
+		return err
+	}
+	env, err := o.execEnv(mappings)
+	if err != nil {
+		return err
+	}
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	child.Env = env
+	if err := child.Start(); err != nil {
+		status := exitCannotRun
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			status = exitNotFound
+		}
+		return commandError{status, fmt.Errorf("failed to run %s: %w", args[0], err)}
+	}
+	running.Store(child.Process)
+	err = child.Wait()
+	running.Store(nil)
+
+	if child.ProcessState == nil {
+		return err
+	}
+	// The command reports its own failures, so mrs only passes on its status.
+	c.SilenceErrors = true
+	if ws, ok := child.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return commandError{status: 128 + int(ws.Signal())}
+	}
+	if status := child.ProcessState.ExitCode(); status != 0 {
+		return commandError{status: status}
+	}
+	return nil
+}
+
+// execEnv returns mrs's environment with the variables that mappings name set
+// to their values from the vault. The environment is strings, which cannot be
+// wiped, and the runtime copies it again to start the command. What mrs holds
+// of its own is wiped, and the vault is done with, its key kept for the next
+// command, before the command starts, so that mrs does not hold on to it while
+// the command runs.
+func (o *rootOptions) execEnv(mappings []envMapping) ([]string, error) {
+	v, err := vault.Named(o.namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	uv, done, err := o.unlock(v)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	refs := make([]secret.Ref, len(mappings))
+	for i, m := range mappings {
+		refs[i] = m.ref
+	}
+	values, err := secret.Lookup(uv, refs)
+	if err != nil {
+		return nil, err
+	}
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		return slices.ContainsFunc(mappings, func(m envMapping) bool { return m.name == name })
+	})
+	for i, m := range mappings {
+		env = append(env, m.name+"="+string(values[i]))
+		crypto.Wipe(values[i])
+	}
+	return env, nil
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/generate"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// passwordFlags choose what a password is made of, which a passphrase is not.
+var passwordFlags = []string{"length", "classes", "no-ambiguous"}
+
+// generateFlags adds the flags that choose what generate, and add --generate,
+// make up.
+func generateFlags(c *cobra.Command, o *rootOptions) {
+	c.Flags().IntVar(&o.length, "length", generate.DefaultLength, "number of characters in the password")
+	c.Flags().StringVar(&o.classes, "classes", joinClasses(generate.Classes),
+		"comma-separated classes of characters to use, at least one of each")
+	c.Flags().BoolVar(&o.noAmbiguous, "no-ambiguous", false, "leave out characters that are easily read as one another: "+generate.Ambiguous)
+	c.Flags().IntVar(&o.words, "words", 0, fmt.Sprintf("make a passphrase of this many words instead, such as %d", generate.DefaultWords))
+	c.Flags().StringVar(&o.separator, "separator", "-", "what to put between the words of a passphrase")
+}
+
+func joinClasses(classes []generate.Class) string {
+	names := make([]string, len(classes))
+	for i, c := range classes {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ",")
+}
+
+// generated makes up a password, or with --words a passphrase, as c's flags
+// ask. The caller is responsible for wiping it.
+func (o *rootOptions) generated(c *cobra.Command) ([]byte, error) {
+	if c.Flags().Changed("words") {
+		for _, name := range passwordFlags {
+			if c.Flags().Changed(name) {
+				return nil, cli.Usagef("--words makes a passphrase, which --%s does not apply to", name)
+			}
+		}
+		value, err := generate.Passphrase(o.words, o.separator)
+		if err != nil {
+			return nil, cli.Usage(err)
+		}
+		return value, nil
+	}
+	if c.Flags().Changed("separator") {
+		return nil, cli.Usagef("--separator goes between the words of a passphrase, so it needs --words")
+	}
+	classes, err := generate.ParseClasses(o.classes)
+	if err != nil {
+		return nil, cli.Usage(err)
+	}
+	value, err := generate.Password(o.length, classes, o.noAmbiguous)
+	if err != nil {
+		return nil, cli.Usage(err)
+	}
+	return value, nil
+}
+
+// runGenerate prints a new password or passphrase, and nothing else, so that
+// it can be piped to another command, such as mrs set.
+func (o *rootOptions) runGenerate(c *cobra.Command) error {
+	value, err := o.generated(c)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(value)
+	return printValue(value)
+}
+
+// runAddGenerated adds the secret --generate names, with a value made up as
+// the flags ask, and prints it with --print. The value is made up before the
+// vault is opened, so that a wrong invocation is reported before the password
+// is asked for.
+func (o *rootOptions) runAddGenerated(c *cobra.Command) error {
+	if strings.TrimSpace(o.generateKey) == "" {
+		return cli.Usagef("--generate takes the key of the secret to add, which cannot be blank")
+	}
+	value, err := o.generated(c)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(value)
+	return o.unlocked(func(uv vault.UnlockedVault) error {
+		if err := secret.Create(uv, o.generateKey, value); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Added secret %q to vault %s\n", o.generateKey, uv)
+		if o.print {
+			return printValue(value)
+		}
+		return nil
+	})
+}
+
+// checkAddFlags refuses the flags that only mean anything to add --generate,
+// given without it.
+func checkAddFlags(c *cobra.Command) error {
+	if c.Flags().Changed("generate") {
+		return nil
+	}
+	for _, name := range append([]string{"print", "words", "separator"}, passwordFlags...) {
+		if c.Flags().Changed(name) {
+			return cli.Usagef("--%s is for a value made up with --generate <key>", name)
+		}
+	}
+	return nil
+}
+
+// printValue writes value to stdout on a line of its own, as get prints one.
+func printValue(value []byte) error {
+	out := make([]byte, 0, len(value)+1)
+	out = append(append(out, value...), '\n')
+	defer crypto.Wipe(out)
+	_, err := os.Stdout.Write(out)
+	return err
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/identity"
+	"github.com/andornaut/mrs/internal/prompt"
+)
+
+// runIdentityCreate creates the user's identity, which goes by name, or when
+// none is given, by user@host as an SSH key would.
+func (o *rootOptions) runIdentityCreate(name string) error {
+	if name == "" {
+		name = defaultIdentityName()
+	}
+	password, err := prompt.GivenOrPromptConfirmedIdentityPassword(o.passwordFile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(password)
+	r, err := identity.Create(name, password)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created your identity. Its public key, which a member of a team vault adds you with, is:\n%s\n", r)
+	return nil
+}
+
+// defaultIdentityName returns user@host, or as much of it as can be found.
+func defaultIdentityName() string {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && name != "" {
+		name += "@" + host
+	}
+	return name
+}
+
+// runIdentityShow prints the user's public key, as `mrs vault member add`
+// takes it.
+func runIdentityShow() error {
+	r, err := identity.Public()
+	if err != nil {
+		return err
+	}
+	fmt.Println(r)
+	return nil
+}
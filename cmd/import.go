@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// formatList names formats for a flag's help.
+func formatList(formats []cli.Format) string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// runImport converts the export args names and adds its secrets to a vault.
+// The export is read before the vault is locked or a password asked for, so
+// that one that cannot be read costs nothing, and pass has finished asking
+// for its own passphrase before mrs asks for one.
+func (o *rootOptions) runImport(args []string) error {
+	if o.importFormat == "" {
+		return cli.Usagef("mrs import requires --format, one of %s", formatList(secret.ImportFormats))
+	}
+	format, err := cli.ParseFormat(o.importFormat, secret.ImportFormats...)
+	if err != nil {
+		return err
+	}
+	if o.create {
+		if o.namePrefix == "" {
+			return cli.Usagef("--create requires --vault, the name of the vault to create")
+		}
+		if err := vault.ValidateName(o.namePrefix); err != nil {
+			return err
+		}
+		taken, err := vault.Exists(o.namePrefix)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("a vault named %q already exists. Leave out --create to add to it", o.namePrefix)
+		}
+	}
+
+	src, from, err := readExport(format, args)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(src)
+	imported, n, err := secret.Convert(format, src)
+	if err != nil {
+		return fmt.Errorf("could not import %s: %w", from, err)
+	}
+	defer crypto.Wipe(imported)
+	if n == 0 {
+		return fmt.Errorf("found nothing to import in %s", from)
+	}
+
+	if o.create {
+		keyfile, err := vault.ReadKeyfile(o.keyfile)
+		if err != nil {
+			return err
+		}
+		defer crypto.Wipe(keyfile)
+		password, err := prompt.GivenOrPromptConfirmedPassword(o.passwordFile)
+		if err != nil {
+			return err
+		}
+		defer crypto.Wipe(password)
+		uv, err := secret.ImportNew(o.namePrefix, password, keyfile, imported, o.force)
+		if err != nil {
+			return err
+		}
+		defer uv.Wipe()
+		fmt.Fprintf(os.Stderr, "Created vault %s with %d %s from %s\n", uv, n, cli.Plural(n, "secret"), from)
+		return nil
+	}
+	return o.unlocked(func(uv vault.UnlockedVault) error {
+		added, err := secret.Import(uv, imported)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Imported %d %s from %s into vault %s\n", added, cli.Plural(added, "secret"), from, uv)
+		return nil
+	})
+}
+
+// readExport returns the export to convert, and what to call it. For pass it
+// is the password store's directory, which pass reads itself.
+func readExport(format cli.Format, args []string) ([]byte, string, error) {
+	if format == cli.FormatPass {
+		dir := os.Getenv("PASSWORD_STORE_DIR")
+		if len(args) > 0 {
+			dir = args[0]
+		} else if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, "", err
+			}
+			dir = filepath.Join(home, ".password-store")
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, "", fmt.Errorf("could not read the password store: %s is not a directory", dir)
+		}
+		return []byte(dir), "the password store " + dir, nil
+	}
+	if len(args) == 0 {
+		return nil, "", cli.Usagef("mrs import requires the file to import, as in \"mrs import --format %s <file>\"", format)
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read from import file %q: %w", args[0], err)
+	}
+	return b, args[0], nil
+}
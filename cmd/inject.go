@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// runInject fills the placeholders in the template at o.inputFile with the
+// values they name, and writes the result to o.outputFile, or to stdout.
+func (o *rootOptions) runInject() error {
+	if o.inputFile == "" {
+		return cli.Usagef("mrs inject requires --input-file, the template to fill")
+	}
+	tmpl, err := os.ReadFile(o.inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	placeholders, err := secret.ParseTemplate(o.inputFile, tmpl)
+	if err != nil {
+		return err
+	}
+
+	// Each vault is opened once, in the order the template first names it,
+	// however many of its values the template asks for.
+	var vaults []string
+	refs := map[string][]secret.Ref{}
+	for _, p := range placeholders {
+		if _, ok := refs[p.Vault]; !ok {
+			vaults = append(vaults, p.Vault)
+		}
+		refs[p.Vault] = append(refs[p.Vault], p.Ref)
+	}
+	values := map[string][][]byte{}
+	defer func() {
+		for _, vs := range values {
+			for _, v := range vs {
+				crypto.Wipe(v)
+			}
+		}
+	}()
+	for _, name := range vaults {
+		vs, err := o.lookupIn(name, refs[name])
+		if err != nil {
+			return err
+		}
+		values[name] = vs
+	}
+
+	ordered := make([][]byte, len(placeholders))
+	next := map[string]int{}
+	for i, p := range placeholders {
+		ordered[i] = values[p.Vault][next[p.Vault]]
+		next[p.Vault]++
+	}
+	out := secret.Render(tmpl, placeholders, ordered)
+	defer crypto.Wipe(out)
+
+	if o.outputFile == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if err := fs.WriteFileAtomic(o.outputFile, out, 0600); err != nil {
+		if !errors.Is(err, fs.ErrDirSync) {
+			return fmt.Errorf("failed to write %s: %w", o.outputFile, err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s was written but %s\n", o.outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Filled %d %s from %d %s into %s\n",
+		len(placeholders), cli.Plural(len(placeholders), "placeholder"), len(vaults), cli.Plural(len(vaults), "vault"), o.outputFile)
+	return nil
+}
+
+// lookupIn opens the vault a template names, or the one --vault names, or the
+// default, for the placeholders that name none, and returns the values refs
+// name in it.
+func (o *rootOptions) lookupIn(name string, refs []secret.Ref) ([][]byte, error) {
+	var (
+		v   vault.Vault
+		err error
+	)
+	// A template outlives the vaults it was written against, so it names a
+	// vault in full rather than by a prefix that a new vault could make
+	// ambiguous.
+	if name == "" {
+		v, err = vault.Named(o.namePrefix)
+	} else {
+		v, err = vault.Exact(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	uv, done, err := o.unlockAsking(v, func() ([]byte, error) {
+		return prompt.GivenOrPromptVaultPassword(o.passwordFile, v.Name())
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return secret.Lookup(uv, refs)
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/keycache"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// runLock removes the key kept for the vault namePrefix names, or for every
+// vault, from the agent and the kernel keyring, so that the next command asks
+// for the password.
+func runLock(namePrefix string) error {
+	var (
+		n    int
+		err  error
+		what = "every vault's key"
+	)
+	if namePrefix == "" {
+		n, err = keycache.Lock()
+	} else {
+		// Forgetting a key changes nothing on disk, so a prefix is as good a
+		// name for it as it is for reading the vault.
+		v, verr := vault.Named(namePrefix)
+		if verr != nil {
+			return verr
+		}
+		n, err = keycache.Forget(v.Path())
+		what = "the key to vault " + v.Name()
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Fprintln(os.Stderr, "No agent is running and MRS_KEYRING is not set, so no keys are kept")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Forgot %s\n", what)
+	return nil
+}
+
+// unlock unlocks v with the key kept for it by the agent or the kernel
+// keyring, or when neither keeps one that opens v, with the user's password.
+// The returned func keeps the key the password derived, once the caller is
+// done with the vault, and wipes it; the caller defers it. --password-file is
+// a password the user chose over any key that is kept, so none is looked for;
+// --keyfile is read all the same, so that one that cannot be read is reported
+// whether or not it turns out to be needed.
+func (o *rootOptions) unlock(v vault.Vault) (vault.UnlockedVault, func(), error) {
+	return o.unlockAsking(v, func() ([]byte, error) {
+		// A team vault opens with the user's identity, so it is that
+		// password that is asked for.
+		if team, err := vault.IsTeam(v); err == nil && team {
+			return prompt.GivenOrPromptIdentityPassword(o.passwordFile)
+		}
+		return prompt.GivenOrPromptPassword(o.passwordFile)
+	})
+}
+
+// unlockAsking is unlock with password asking for the password.
+func (o *rootOptions) unlockAsking(v vault.Vault, password func() ([]byte, error)) (vault.UnlockedVault, func(), error) {
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return vault.UnlockedVault{}, nil, err
+	}
+	if o.passwordFile == "" {
+		uv, ok, err := fromCache(v)
+		if err != nil {
+			crypto.Wipe(keyfile)
+			return vault.UnlockedVault{}, nil, err
+		}
+		if ok {
+			crypto.Wipe(keyfile)
+			return uv, uv.Wipe, nil
+		}
+	}
+	p, err := password()
+	if err != nil {
+		crypto.Wipe(keyfile)
+		return vault.UnlockedVault{}, nil, err
+	}
+	uv := v.UnlockedWithKeyfile(p, keyfile)
+	return uv, func() {
+		keepKey(v, &uv)
+		uv.Wipe()
+		crypto.Wipe(keyfile)
+	}, nil
+}
+
+// fromCache returns v unlocked with the key kept for it, if one is kept that
+// still opens v. One that does not - the password was changed by an mrs that
+// did not say so, or the file replaced - is forgotten, and the password asked
+// for instead.
+func fromCache(v vault.Vault) (vault.UnlockedVault, bool, error) {
+	k, err := keycache.Get(v.Path())
+	if err != nil {
+		cacheWarning(err)
+	}
+	if k == nil {
+		return vault.UnlockedVault{}, false, nil
+	}
+	uv := v.UnlockedWithKey(k)
+	k.Wipe()
+	// Opening it costs a decryption, with no key to derive, and finds a key
+	// that does not open the vault before a command has begun its work.
+	plaintext, err := uv.Decrypt()
+	crypto.Wipe(plaintext)
+	if err == nil {
+		return uv, true, nil
+	}
+	uv.Wipe()
+	if !errors.Is(err, vault.ErrCannotDecrypt) {
+		return vault.UnlockedVault{}, false, err
+	}
+	forgetKey(v)
+	return vault.UnlockedVault{}, false, nil
+}
+
+// keepKey keeps the key that opened uv, if the password opened it.
+func keepKey(v vault.Vault, uv *vault.UnlockedVault) {
+	k := uv.Key()
+	if k == nil {
+		return
+	}
+	defer k.Wipe()
+	if err := keycache.Put(v.Path(), k); err != nil {
+		cacheWarning(err)
+	}
+}
+
+// forgetKey forgets the key to v, which no longer opens it.
+func forgetKey(v vault.Vault) {
+	if _, err := keycache.Forget(v.Path()); err != nil {
+		cacheWarning(err)
+	}
+}
+
+// cacheWarning reports a place keys are kept that could not be used. A
+// command goes on without it, so it is not a failure, but one that is not
+// used is one the user is still typing passwords for.
+func cacheWarning(err error) {
+	if errs, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range errs.Unwrap() {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+}
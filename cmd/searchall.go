@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// runSearchAll runs q against every vault, and reports what matched in each.
+// A vault that cannot be opened is reported and skipped, so that one forgotten
+// password does not hide what the others hold.
+func (o *rootOptions) runSearchAll(c *cobra.Command, q secret.Query, query string) error {
+	vs, err := vault.All()
+	if err != nil {
+		return err
+	}
+	if len(vs) == 0 {
+		return errors.New("no vaults found. Run \"mrs vault create\" to create one")
+	}
+
+	// One keyfile is given for every vault that has one, and ignored for the
+	// rest.
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+
+	// Each password that opened a vault is tried on the next before the user
+	// is asked for another, so vaults that share a password ask for it once.
+	// A --password-file is the only password there is.
+	var passwords [][]byte
+	defer func() {
+		for _, p := range passwords {
+			crypto.Wipe(p)
+		}
+	}()
+	if o.passwordFile != "" {
+		p, err := prompt.GivenOrPromptPassword(o.passwordFile)
+		if err != nil {
+			return err
+		}
+		passwords = append(passwords, p)
+	}
+
+	var results []secret.Result
+	defer func() {
+		for _, r := range results {
+			crypto.Wipe(r.Secrets)
+		}
+	}()
+	var skipped []string
+	total, matchedIn := 0, 0
+	for _, v := range vs {
+		r, err := o.searchWithCache(v, q)
+		if errors.Is(err, vault.ErrCannotDecrypt) {
+			r, err = searchWith(v, passwords, keyfile, q)
+		}
+		// No password opens a vault that needs a keyfile it was not given, so
+		// none is asked for.
+		if errors.Is(err, vault.ErrCannotDecrypt) && !errors.Is(err, crypto.ErrNeedsKeyfile) && o.passwordFile == "" {
+			p, perr := prompt.VaultPasswordOrSkip(v.Name())
+			if perr != nil {
+				return perr
+			}
+			if len(p) > 0 {
+				r, err = searchWith(v, [][]byte{p}, keyfile, q)
+			}
+			if err == nil {
+				passwords = append(passwords, p)
+			} else {
+				crypto.Wipe(p)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, vault.ErrCannotDecrypt) && !errors.Is(err, crypto.ErrNeedsKeyfile) {
+				fmt.Fprintf(os.Stderr, "Warning: skipped vault %s, which the password did not open\n", v.Name())
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: skipped vault %s: %s\n", v.Name(), err)
+			}
+			skipped = append(skipped, v.Name())
+			continue
+		}
+		results = append(results, r)
+		if r.Count > 0 {
+			fmt.Fprintf(os.Stderr, "%d %s matched %q in vault %s\n", r.Count, cli.Plural(r.Count, "secret"), query, v.Name())
+			total += r.Count
+			matchedIn++
+		}
+	}
+	if len(skipped) == len(vs) {
+		return fmt.Errorf("could not open any of the %d %s", len(vs), cli.Plural(len(vs), "vault"))
+	}
+
+	out := secret.JoinResults(q.Format, results)
+	defer crypto.Wipe(out)
+	searched := len(results)
+	if total == 0 {
+		fmt.Fprintf(os.Stderr, "No secrets matched %q in %d %s\n", query, searched, cli.Plural(searched, "vault"))
+		if _, err := os.Stdout.Write(out); err != nil {
+			return err
+		}
+		c.SilenceErrors = true
+		return errNoMatch
+	}
+	fmt.Fprintf(os.Stderr, "%d %s matched %q in %d of %d %s searched\n\n",
+		total, cli.Plural(total, "secret"), query, matchedIn, searched, cli.Plural(searched, "vault"))
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// searchWithCache runs q against v with the key kept for it. It
+// returns an error that wraps vault.ErrCannotDecrypt when there is none that
+// opens v, as searchWith does, or when --password-file is the password to
+// use.
+func (o *rootOptions) searchWithCache(v vault.Vault, q secret.Query) (secret.Result, error) {
+	none := fmt.Errorf("%w vault %s", vault.ErrCannotDecrypt, v.Name())
+	if o.passwordFile != "" {
+		return secret.Result{}, none
+	}
+	uv, ok, err := fromCache(v)
+	if err != nil {
+		return secret.Result{}, err
+	}
+	if !ok {
+		return secret.Result{}, none
+	}
+	defer uv.Wipe()
+	secrets, n, err := secret.Search(uv, q)
+	if err != nil {
+		return secret.Result{}, err
+	}
+	return secret.Result{Vault: v.Name(), Count: n, Secrets: secrets}, nil
+}
+
+// searchWith runs q against v with each of passwords in turn, and keyfile,
+// until one opens it, and keeps the key that did. It returns an error that
+// wraps vault.ErrCannotDecrypt when none does, including when there are none
+// to try.
+func searchWith(v vault.Vault, passwords [][]byte, keyfile []byte, q secret.Query) (secret.Result, error) {
+	err := fmt.Errorf("%w vault %s", vault.ErrCannotDecrypt, v.Name())
+	for _, p := range passwords {
+		// The password is the caller's to wipe, so the UnlockedVault has a
+		// copy of its own, which it wipes along with the key it derives.
+		uv := v.UnlockedWithKeyfile(bytes.Clone(p), keyfile)
+		var (
+			secrets []byte
+			n       int
+		)
+		secrets, n, err = secret.Search(uv, q)
+		if err == nil {
+			keepKey(v, &uv)
+		}
+		uv.Wipe()
+		if err == nil {
+			return secret.Result{Vault: v.Name(), Count: n, Secrets: secrets}, nil
+		}
+		if !errors.Is(err, vault.ErrCannotDecrypt) || errors.Is(err, crypto.ErrNeedsKeyfile) {
+			return secret.Result{}, err
+		}
+	}
+	return secret.Result{}, err
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/secret"
+	"github.com/andornaut/mrs/internal/totp"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// runTOTP prints the current code of the two-factor seed in the secret whose
+// key is key, and on stderr how long it remains current. The seed is never
+// printed, and is wiped once the code is computed.
+func (o *rootOptions) runTOTP(key string) error {
+	v, err := vault.Named(o.namePrefix)
+	if err != nil {
+		return err
+	}
+	uv, done, err := o.unlock(v)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	seed, err := secret.TOTPSeed(uv, key)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(seed)
+	k, err := totp.Parse(seed)
+	if err != nil {
+		return fmt.Errorf("the secret %q in vault %s: %w", key, uv, err)
+	}
+	defer k.Wipe()
+
+	code, remaining := k.Code(time.Now())
+	if _, err := fmt.Println(code); err != nil {
+		return err
+	}
+	n := int(math.Ceil(remaining.Seconds()))
+	fmt.Fprintf(os.Stderr, "Valid for %d more %s\n", n, cli.Plural(n, "second"))
+	return nil
+}
@@ -0,0 +1,133 @@
+package vaultcmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// newMemberCmd returns `mrs vault member`, which manages who a team vault is
+// sealed to.
+func newMemberCmd(o *vaultOptions) *cobra.Command {
+	member := &cobra.Command{
+		Use:   "member",
+		Short: "Manage the members of a team vault",
+		Long: "Manage who a team vault, which \"mrs vault create --team\" creates, is sealed\n" +
+			"to. Each member opens it with their own identity, which \"mrs identity create\"\n" +
+			"creates, so no member learns another's password.",
+		Args:                  cli.NeedsCommand,
+		RunE:                  func(c *cobra.Command, args []string) error { return nil },
+		DisableFlagsInUseLine: true,
+	}
+
+	add := &cobra.Command{
+		Use:   "add <name> <public key> [<member name>]",
+		Short: "Add a member to a team vault",
+		Long: "Seal a team vault to another member's public key, which \"mrs identity show\"\n" +
+			"prints for them, along with the name they go by. It is sealed under the key\n" +
+			"it already has, so your identity's password is asked for to open it.",
+		Example:               "  mrs vault member add team $(cat bob.pub)",
+		Args:                  cli.RequireArgs(2, -1, "the name of a vault and a public key"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			r, err := crypto.ParseRecipient(strings.Join(args[1:], " "))
+			if err != nil {
+				return cli.Usage(err)
+			}
+			v, unlock, err := o.locked(args[0])
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			password, err := o.vaultPassword(v)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(password)
+			if err := vault.AddMember(v, password, r); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Added %s to vault %s\n", r, v)
+			return nil
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove <name> <public key or member name>",
+		Short: "Remove a member from a team vault, and re-key it",
+		Long: "Stop sealing a team vault to a member, named by their public key or by the\n" +
+			"name they go by. The vault is re-keyed, so that nothing the member kept from\n" +
+			"it opens what is saved from now on. Its backups are left as they were, and\n" +
+			"still open for them.",
+		Args:                  cli.RequireArgs(2, 2, "the name of a vault and a member"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			v, unlock, err := o.locked(args[0])
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			password, err := o.vaultPassword(v)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(password)
+			removed, err := vault.RemoveMember(v, password, args[1])
+			if err != nil {
+				return err
+			}
+			forgetKey(v)
+			fmt.Fprintf(os.Stderr, "Removed %s from vault %s, and re-keyed it\n", removed, v)
+			return nil
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list <name>",
+		Short: "List the members of a team vault",
+		Long: "Print the public key and name of each member of a team vault, one a line, as\n" +
+			"\"mrs vault member add\" takes them. The vault's header says who they are, so\n" +
+			"nothing is asked for.",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			v, err := vault.Exact(args[0])
+			if err != nil {
+				return err
+			}
+			members, err := vault.Members(v)
+			if err != nil {
+				return err
+			}
+			for _, m := range members {
+				fmt.Println(m)
+			}
+			return nil
+		},
+	}
+
+	for _, c := range []*cobra.Command{add, remove} {
+		c.Flags().StringVarP(&o.passwordFile, "password-file", "p", "", "path to a file that contains your identity's password")
+		c.Flags().BoolVar(&o.force, "force", false, "delete the vault's lock file first")
+	}
+	member.AddCommand(add, list, remove)
+	return member
+}
+
+// vaultPassword asks for what opens v: its password, or for a team vault the
+// password of the user's identity.
+func (o *vaultOptions) vaultPassword(v vault.Vault) ([]byte, error) {
+	if team, err := vault.IsTeam(v); err == nil && team {
+		return prompt.GivenOrPromptIdentityPassword(o.passwordFile)
+	}
+	return prompt.GivenOrPromptVaultPassword(o.passwordFile, v.Name())
+}
@@ -0,0 +1,79 @@
+package vaultcmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// newPaperBackupCmd returns `mrs vault paper-backup`, which prints a vault's
+// file as text to keep on paper.
+func newPaperBackupCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "paper-backup <name>",
+		Short: "Print a vault, encrypted, as text to keep on paper",
+		Long: "Print a vault's file, encrypted as it is on disk, as base32 text to be printed\n" +
+			"and kept offline. A header names the vault, its salt and its format, and\n" +
+			"each line ends in a checksum, so that \"mrs vault paper-restore\" catches a\n" +
+			"line that is mistyped or misread as that line. Nothing is asked for, and the\n" +
+			"backup opens with the password the vault has when it is printed.",
+		Example:               "  mrs vault paper-backup personal | lpr",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			v, err := vault.Exact(args[0])
+			if err != nil {
+				return err
+			}
+			text, err := vault.PaperBackup(v)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(text)
+			return err
+		},
+	}
+}
+
+// newPaperRestoreCmd returns `mrs vault paper-restore`, which recreates a
+// vault from what paper-backup printed.
+func newPaperRestoreCmd(o *vaultOptions) *cobra.Command {
+	restore := &cobra.Command{
+		Use:   "paper-restore",
+		Short: "Recreate a vault from a paper backup",
+		Long: "Read a paper backup that \"mrs vault paper-backup\" printed, typed or scanned\n" +
+			"back in, from stdin or --input-file, check every line's checksum, and\n" +
+			"recreate the vault with the name and salt it had. The digits 0, 1 and 8 are\n" +
+			"read as the letters O, I and B, which base32 has instead. A vault of that\n" +
+			"name is never replaced: delete or rename it first.",
+		Example:               "  mrs vault paper-restore < scanned.txt",
+		Args:                  cli.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			var text []byte
+			var err error
+			if o.inputFile != "" {
+				if text, err = os.ReadFile(o.inputFile); err != nil {
+					return fmt.Errorf("could not read from input file %q: %w", o.inputFile, err)
+				}
+			} else if text, err = prompt.ReadPaperBackup(); err != nil {
+				return err
+			}
+			v, err := vault.PaperRestore(text, o.force)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Restored vault %s from its paper backup. It opens with the password it had "+
+				"when the backup was printed\n", v)
+			return nil
+		},
+	}
+	restore.Flags().StringVarP(&o.inputFile, "input-file", "i", "", "path to the paper backup, instead of stdin")
+	restore.Flags().BoolVar(&o.force, "force", false, "delete the vault's lock file first")
+	return restore
+}
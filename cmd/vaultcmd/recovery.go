@@ -0,0 +1,163 @@
+package vaultcmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// newRecoveryCmd returns `mrs vault recovery`, which splits a recovery key for a
+// vault into shares that unlock it without its password.
+func newRecoveryCmd(o *vaultOptions) *cobra.Command {
+	recovery := &cobra.Command{
+		Use:   "recovery",
+		Short: "Split a recovery key into shares that unlock a vault without its password",
+		Long: "Split a recovery key for a vault into shares, to be printed and kept by people\n" +
+			"or in places apart, any few of which unlock the vault if its password is\n" +
+			"forgotten and fewer of which say nothing about it.",
+		Args:                  cli.NeedsCommand,
+		RunE:                  func(c *cobra.Command, args []string) error { return nil },
+		DisableFlagsInUseLine: true,
+	}
+
+	split := &cobra.Command{
+		Use:   "split <name>",
+		Short: "Print a new recovery key as shares, any --threshold of which unlock a vault",
+		Long: "Give a vault a new recovery key, and print it as --shares shares, one a line,\n" +
+			"any --threshold of which \"mrs vault recovery unlock\" unlocks the vault with.\n" +
+			"Each names the vault and carries a checksum, so a share that is mistyped is\n" +
+			"caught as that one. The shares keep unlocking the vault when its password or\n" +
+			"keyfile is changed, and after it is unlocked with them, until the vault is\n" +
+			"split again, which replaces them, or a backup from before the split is\n" +
+			"restored under another password.",
+		Example:               "  mrs vault recovery split --shares 5 --threshold 3 personal > shares.txt",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if !c.Flags().Changed("shares") || !c.Flags().Changed("threshold") {
+				return cli.Usagef("%s requires --shares and --threshold", c.CommandPath())
+			}
+			if o.threshold < 2 || o.threshold > o.shares || o.shares > 255 {
+				return cli.Usagef("--threshold must be from 2 to --shares, which is at most 255")
+			}
+			v, unlock, err := o.locked(args[0])
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			if err := vault.RequirePassword(v); err != nil {
+				return err
+			}
+			keyfile, err := vault.ReadKeyfile(o.keyfile)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(keyfile)
+			password, err := prompt.GivenOrPromptVaultPassword(o.passwordFile, v.Name())
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(password)
+
+			shares, err := vault.SplitRecovery(v, password, keyfile, o.threshold, o.shares)
+			if err != nil {
+				return err
+			}
+			forgetKey(v)
+			for _, s := range shares {
+				line := s.Marshal()
+				fmt.Printf("%s\n", line)
+				crypto.Wipe(line)
+				s.Wipe()
+			}
+			fmt.Fprintf(os.Stderr, "Split a new recovery key for vault %s into %d shares, any %d of which unlock it. "+
+				"Any shares split before no longer do\n", v, o.shares, o.threshold)
+			return nil
+		},
+	}
+
+	unlock := &cobra.Command{
+		Use:   "unlock <name>",
+		Short: "Unlock a vault with its shares, and give it a new password",
+		Long: "Read the shares that \"mrs vault recovery split\" printed from stdin, one a line,\n" +
+			"and with enough of them, change the vault's password as \"mrs vault\n" +
+			"change-password\" does. The vault opens without a keyfile afterwards, unless\n" +
+			"--new-keyfile gives it one, and the shares go on unlocking it.",
+		Example:               "  mrs vault recovery unlock personal < shares.txt",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			v, unlock, err := o.locked(args[0])
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			if err := vault.RequirePassword(v); err != nil {
+				return err
+			}
+			hadKeyfile, err := vault.UsesKeyfile(v)
+			if err != nil {
+				return err
+			}
+			newKeyfile, err := vault.ReadKeyfile(o.newKeyfile)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(newKeyfile)
+
+			text, err := prompt.ReadShares()
+			if err != nil {
+				return err
+			}
+			shares, err := vault.ParseRecoveryShares(text)
+			crypto.Wipe(text)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				for _, s := range shares {
+					s.Wipe()
+				}
+			}()
+
+			newPassword, err := prompt.GivenOrPromptNewPassword(o.newPasswordFile)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(newPassword)
+
+			uv, err := vault.Recover(v, shares, newPassword, newKeyfile)
+			if err != nil {
+				return err
+			}
+			defer uv.Wipe()
+			forgetKey(v)
+			switch {
+			case newKeyfile != nil:
+				fmt.Fprintf(os.Stderr, "Unlocked vault %s, and changed its password and keyfile\n", uv)
+			case hadKeyfile:
+				fmt.Fprintf(os.Stderr, "Unlocked vault %s, and changed its password. It no longer has a keyfile\n", uv)
+			default:
+				fmt.Fprintf(os.Stderr, "Unlocked vault %s, and changed its password\n", uv)
+			}
+			return nil
+		},
+	}
+
+	split.Flags().StringVarP(&o.passwordFile, "password-file", "p", "", "path to a file that contains your password")
+	split.Flags().StringVar(&o.keyfile, "keyfile", "", "path to the vault's keyfile, for a vault that has one")
+	split.Flags().IntVar(&o.shares, "shares", 0, "how many shares to print")
+	split.Flags().IntVar(&o.threshold, "threshold", 0, "how many of the shares unlock the vault")
+	split.Flags().BoolVar(&o.force, "force", false, "delete the vault's lock file first")
+	unlock.Flags().StringVarP(&o.newPasswordFile, "new-password-file", "n", "", "path to a file that contains your new password")
+	unlock.Flags().StringVar(&o.newKeyfile, "new-keyfile", "", "path to a keyfile that the vault opens with from now on")
+	unlock.Flags().BoolVar(&o.force, "force", false, "delete the vault's lock file first")
+	recovery.AddCommand(split, unlock)
+	return recovery
+}
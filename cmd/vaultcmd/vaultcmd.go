@@ -1,13 +1,23 @@
 package vaultcmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/andornaut/mrs/internal/cli"
 	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/identity"
+	"github.com/andornaut/mrs/internal/keycache"
 	"github.com/andornaut/mrs/internal/prompt"
 	"github.com/andornaut/mrs/internal/secret"
 	"github.com/andornaut/mrs/internal/vault"
@@ -27,12 +37,22 @@ var Cmd = &cobra.Command{
 }
 
 type vaultOptions struct {
+	all             bool
 	assumeYes       bool
 	force           bool
+	from            int
 	importFile      string
+	inputFile       string
+	format          string
 	isPath          bool
+	keyfile         string
+	newKeyfile      string
 	newPasswordFile string
 	passwordFile    string
+	removeKeyfile   bool
+	shares          int
+	team            bool
+	threshold       int
 }
 
 // locked resolves the vault named exactly by name and takes its exclusive
@@ -57,11 +77,25 @@ func init() {
 	opts := &vaultOptions{}
 
 	create := &cobra.Command{
-		Use:                   "create <name>",
-		Short:                 "Create a vault",
+		Use:   "create <name>",
+		Short: "Create a vault",
+		Long: "Create a vault, which opens with the password it is given. With --keyfile,\n" +
+			"its key is derived from the contents of that file as well, so that it opens\n" +
+			"only with both: keep the keyfile on removable media, and give it to every\n" +
+			"command that opens the vault with --keyfile.\n\n" +
+			"With --team, create a team vault instead, which opens with the identity of\n" +
+			"each of its members: you, to begin with, and whoever \"mrs vault member add\"\n" +
+			"adds. Nothing is asked for, since it is sealed to your public key.",
+		Example:               "  mrs vault create personal\n  mrs vault create --keyfile /media/usb/mrs.key work\n  mrs vault create --team ops",
 		Args:                  cli.RequireArgs(1, 1, "a name for the new vault"),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			if opts.team && opts.passwordFile != "" {
+				return cli.Usagef("--team creates a vault that opens with your identity, so it takes no --password-file")
+			}
+			if opts.team && opts.keyfile != "" {
+				return cli.Usagef("--team creates a vault that opens with your identity, so it takes no --keyfile")
+			}
 			name := args[0]
 			// The name and the import file are checked before anything is
 			// asked, so that a create that cannot succeed does not first make
@@ -84,33 +118,61 @@ func init() {
 				return err
 			}
 			defer crypto.Wipe(contents)
+			if opts.team {
+				return opts.createTeam(name, contents)
+			}
+			keyfile, err := vault.ReadKeyfile(opts.keyfile)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(keyfile)
 
 			password, err := prompt.GivenOrPromptConfirmedPassword(opts.passwordFile)
 			if err != nil {
 				return err
 			}
 
-			v, err := vault.Create(name, password, contents, opts.force)
+			v, err := vault.Create(name, password, keyfile, contents, opts.force)
 			if err != nil {
 				return err
 			}
 			defer v.Wipe()
+			if keyfile != nil {
+				fmt.Fprintf(os.Stderr, "Created vault %s, which opens with its password and its keyfile together\n", v)
+				return nil
+			}
 			fmt.Fprintf(os.Stderr, "Created vault %s\n", v)
 			return nil
 		},
 	}
 
 	changePassword := &cobra.Command{
-		Use:                   "change-password <name>",
-		Short:                 "Change a vault's password",
+		Use:   "change-password <name>",
+		Short: "Change a vault's password, and its keyfile",
+		Long: "Change a vault's password. A vault with a keyfile keeps it, unless\n" +
+			"--new-keyfile gives it another or --remove-keyfile takes it away; a vault\n" +
+			"without one is given one with --new-keyfile.",
+		Example:               "  mrs vault change-password work\n  mrs vault change-password --new-keyfile /media/usb/mrs.key work",
 		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			if opts.newKeyfile != "" && opts.removeKeyfile {
+				return cli.Usagef("--new-keyfile and --remove-keyfile cannot be used together")
+			}
 			v, unlock, err := opts.locked(args[0])
 			if err != nil {
 				return err
 			}
 			defer unlock()
+			if err := vault.RequirePassword(v); err != nil {
+				return err
+			}
+			oldKeyfile, newKeyfile, err := opts.changedKeyfiles(v)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(oldKeyfile)
+			defer crypto.Wipe(newKeyfile)
 
 			oldPassword, err := prompt.GivenOrPromptPassword(opts.passwordFile)
 			if err != nil {
@@ -124,12 +186,20 @@ func init() {
 			}
 			defer crypto.Wipe(newPassword)
 
-			uv, err := vault.ChangePassword(v, oldPassword, newPassword)
+			uv, err := vault.ChangePassword(v, oldPassword, oldKeyfile, newPassword, newKeyfile)
 			if err != nil {
 				return err
 			}
 			defer uv.Wipe()
-			fmt.Fprintf(os.Stderr, "Changed password of vault %s\n", uv)
+			forgetKey(v)
+			switch {
+			case opts.newKeyfile != "":
+				fmt.Fprintf(os.Stderr, "Changed password and keyfile of vault %s\n", uv)
+			case opts.removeKeyfile:
+				fmt.Fprintf(os.Stderr, "Changed password of vault %s, which no longer has a keyfile\n", uv)
+			default:
+				fmt.Fprintf(os.Stderr, "Changed password of vault %s\n", uv)
+			}
 			return nil
 		},
 	}
@@ -158,6 +228,7 @@ func init() {
 			if err := vault.Delete(v); err != nil {
 				return err
 			}
+			forgetKey(v)
 			fmt.Fprintf(os.Stderr, "Deleted vault %s\n", v.Name())
 			return nil
 		},
@@ -170,10 +241,17 @@ func init() {
 		Args:                  cli.NoArgs,
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			format, err := opts.listFormat()
+			if err != nil {
+				return err
+			}
 			v, err := vault.Default()
 			if err != nil {
 				return err
 			}
+			if format != cli.FormatText {
+				return printVaults(format, []vault.Vault{v}, false)
+			}
 			if opts.isPath {
 				fmt.Println(v.Path())
 			} else {
@@ -183,16 +261,37 @@ func init() {
 		},
 	}
 
+	info := &cobra.Command{
+		Use:   "info <name>",
+		Short: "Describe a vault's file, encryption and contents",
+		Long: "Describe a vault's file, how it is encrypted, whether it has a keyfile or a\n" +
+			"recovery key and how many secrets it holds, without printing any of them.\n" +
+			"The key derivation is the one that actually opened the vault, so the\n" +
+			"password is asked for.",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runInfo(args[0])
+		},
+	}
+
 	list := &cobra.Command{
 		Use:                   "list",
 		Short:                 "List all vaults",
 		Args:                  cli.NoArgs,
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			format, err := opts.listFormat()
+			if err != nil {
+				return err
+			}
 			vaults, err := vault.All()
 			if err != nil {
 				return err
 			}
+			if format != cli.FormatText {
+				return printVaults(format, vaults, true)
+			}
 			for _, v := range vaults {
 				if opts.isPath {
 					fmt.Println(v.Path())
@@ -217,35 +316,559 @@ func init() {
 			}
 			defer unlock()
 
-			if err := vault.Rename(v, targetName); err != nil {
+			// Asked for only if the vault has its name sealed into it, and
+			// only once the rename is known to be possible.
+			password := func() ([]byte, error) { return prompt.GivenOrPromptPassword(opts.passwordFile) }
+			keyfile, err := vault.ReadKeyfile(opts.keyfile)
+			if err != nil {
+				return err
+			}
+			defer crypto.Wipe(keyfile)
+			if err := vault.Rename(v, targetName, password, keyfile); err != nil {
 				return err
 			}
+			forgetKey(v)
 			fmt.Fprintf(os.Stderr, "Renamed vault %s to %s\n", sourceName, targetName)
 			return nil
 		},
 	}
 
-	for _, c := range []*cobra.Command{changePassword, create} {
+	restore := &cobra.Command{
+		Use:   "restore <name>",
+		Short: "List a vault's backups, or restore one",
+		Long: "List a vault's backups, newest first, with when each was made and how many\n" +
+			"secrets it holds. With --from, replace the vault's secrets with those of\n" +
+			"that backup; what they replace is kept as a new backup.",
+		Args:                  cli.RequireArgs(1, 1, "the name of a vault"),
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if c.Flags().Changed("from") {
+				return opts.runRestore(args[0])
+			}
+			return opts.listBackups(args[0])
+		},
+	}
+
+	upgrade := &cobra.Command{
+		Use:   "upgrade [<name>...]",
+		Short: "Re-encrypt vaults with the current format and key derivation",
+		Long: "Re-encrypt vaults written by an earlier version of mrs the way a save would\n" +
+			"write them now, without waiting for each one to be edited. A vault that is\n" +
+			"already current is left untouched.",
+		Args: func(c *cobra.Command, args []string) error {
+			if opts.all && len(args) > 0 {
+				return cli.Usagef("%s takes the names of vaults or --all, not both", c.CommandPath())
+			}
+			if !opts.all && len(args) == 0 {
+				return cli.Usagef("%s requires the name of a vault, or --all", c.CommandPath())
+			}
+			return nil
+		},
+		DisableFlagsInUseLine: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return opts.runUpgrade(args)
+		},
+	}
+
+	for _, c := range []*cobra.Command{changePassword, create, info, rename, restore, upgrade} {
 		c.Flags().StringVarP(&opts.passwordFile, "password-file", "p", "", "path to a file that contains your password")
 	}
+	// --keyfile has no short form, since -k would be read as a key by anyone
+	// used to the secret commands, which take keys.
+	for _, c := range []*cobra.Command{changePassword, info, rename, restore, upgrade} {
+		c.Flags().StringVar(&opts.keyfile, "keyfile", "", "path to the vault's keyfile, for a vault that has one")
+	}
+	create.Flags().StringVar(&opts.keyfile, "keyfile", "", "path to a keyfile that the vault opens with as well as its password")
 	// --force has no short form, because it is not the flag a hurried -f is
 	// reaching for: it breaks another process's lock rather than overwriting
 	// anything, and is worth spelling out.
-	for _, c := range []*cobra.Command{changePassword, create, deleteCmd, rename} {
+	for _, c := range []*cobra.Command{changePassword, create, deleteCmd, rename, restore, upgrade} {
 		c.Flags().BoolVar(&opts.force, "force", false, "delete the vault's lock file first")
 	}
 	deleteCmd.Flags().BoolVarP(&opts.assumeYes, "yes", "y", false, "answer yes to the confirmation")
 
 	changePassword.Flags().StringVarP(&opts.newPasswordFile, "new-password-file", "n", "", "path to a file that contains your new password")
+	changePassword.Flags().StringVar(&opts.newKeyfile, "new-keyfile", "", "path to a keyfile that the vault opens with from now on")
+	changePassword.Flags().BoolVar(&opts.removeKeyfile, "remove-keyfile", false, "open the vault with its password alone from now on")
+	upgrade.Flags().BoolVar(&opts.all, "all", false, "upgrade every vault")
+	restore.Flags().IntVar(&opts.from, "from", 0, "the backup to restore, counting the newest as 1")
 	create.Flags().StringVarP(&opts.importFile, "import-file", "i", "", "path to a file that contains unencrypted secrets")
+	create.Flags().BoolVar(&opts.team, "team", false, "create a team vault, sealed to your identity rather than a password")
 	// --path has no short form, so that -p means the password file on every
 	// command that has one. These two never take a password, but -p meaning
 	// two things under `mrs vault` is a trap for the person typing, not for
 	// the parser.
 	getDefault.Flags().BoolVar(&opts.isPath, "path", false, "print the vault path instead of the name")
 	list.Flags().BoolVar(&opts.isPath, "path", false, "print vault paths instead of names")
+	for _, c := range []*cobra.Command{getDefault, info, list} {
+		c.Flags().StringVar(&opts.format, "format", string(cli.FormatText), "print text, json, or ndjson for one JSON object a line")
+	}
+
+	Cmd.AddCommand(changePassword, create, deleteCmd, getDefault, info, list, newMemberCmd(opts), newPaperBackupCmd(), newPaperRestoreCmd(opts), newRecoveryCmd(opts), rename, restore, upgrade)
+}
+
+// createTeam creates a team vault holding contents, sealed to the user's
+// identity.
+func (o *vaultOptions) createTeam(name string, contents []byte) error {
+	founder, err := identity.Public()
+	if err != nil {
+		return err
+	}
+	v, err := vault.CreateTeam(name, founder, contents, o.force)
+	if err != nil {
+		return err
+	}
+	defer v.Wipe()
+	fmt.Fprintf(os.Stderr, "Created team vault %s, whose one member is %s\n", v, founder)
+	return nil
+}
+
+// changedKeyfiles reads the digests of the keyfile that v opens with now, and
+// of the one it opens with once its password is changed: the one
+// --new-keyfile names, none for --remove-keyfile, or otherwise the one it has,
+// if any. Both are read before anything is asked, so that a keyfile that
+// cannot be read does not come to light after the passwords are typed. The
+// caller is responsible for wiping both.
+func (o *vaultOptions) changedKeyfiles(v vault.Vault) ([]byte, []byte, error) {
+	oldKeyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var newKeyfile []byte
+	switch {
+	case o.newKeyfile != "":
+		newKeyfile, err = vault.ReadKeyfile(o.newKeyfile)
+	case o.removeKeyfile:
+	default:
+		var uses bool
+		if uses, err = vault.UsesKeyfile(v); err == nil && uses {
+			newKeyfile = bytes.Clone(oldKeyfile)
+		}
+	}
+	if err != nil {
+		crypto.Wipe(oldKeyfile)
+		return nil, nil, err
+	}
+	return oldKeyfile, newKeyfile, nil
+}
+
+// runUpgrade upgrades each of the named vaults, or every vault, in turn. Every
+// name is resolved before anything is asked, so that a typo in the last one
+// does not come to light after the user has typed passwords for the rest. A
+// vault that fails is reported and the rest are still upgraded, since each is
+// re-encrypted on its own and one failing says nothing about the others.
+func (o *vaultOptions) runUpgrade(names []string) error {
+	var vaults []vault.Vault
+	if o.all {
+		all, err := vault.All()
+		if err != nil {
+			return err
+		}
+		if len(all) == 0 {
+			return errors.New("no vaults found. Run \"mrs vault create\" to create one")
+		}
+		vaults = all
+	}
+	for _, name := range names {
+		v, err := vault.Exact(name)
+		if err != nil {
+			return err
+		}
+		vaults = append(vaults, v)
+	}
+
+	var failed int
+	for _, v := range vaults {
+		if err := o.upgrade(v); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to upgrade vault %s: %s\n", v, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to upgrade %d of %d %s", failed, len(vaults), cli.Plural(len(vaults), "vault"))
+	}
+	return nil
+}
+
+// upgrade upgrades one vault under its exclusive lock, taken before its
+// password is asked for.
+func (o *vaultOptions) upgrade(v vault.Vault) error {
+	unlock, err := v.ExclusiveLockForce(o.force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+	password, err := o.vaultPassword(v)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(password)
+
+	was, upgraded, err := vault.Upgrade(v, password, keyfile)
+	if err != nil {
+		return err
+	}
+	if upgraded {
+		forgetKey(v)
+	}
+	// Whether it has a keyfile is kept, so it is not what was upgraded.
+	costs := was.Params
+	costs.Keyfile = false
+	switch {
+	case !upgraded:
+		fmt.Fprintf(os.Stderr, "Vault %s is already current\n", v)
+	case costs != crypto.CurrentParams:
+		fmt.Fprintf(os.Stderr, "Upgraded vault %s from %s\n", v, was.Params)
+	default:
+		fmt.Fprintf(os.Stderr, "Upgraded vault %s\n", v)
+	}
+	return nil
+}
+
+// listFormat returns the format that `vault list`, `vault default` and `vault
+// info` print in. A JSON object carries both a vault's name and its path, so --path is
+// refused with it rather than quietly ignored.
+func (o *vaultOptions) listFormat() (cli.Format, error) {
+	format, err := cli.ParseFormat(o.format, cli.FormatText, cli.FormatJSON, cli.FormatNDJSON)
+	if err != nil {
+		return "", err
+	}
+	if format != cli.FormatText && o.isPath {
+		return "", cli.Usagef("--path prints paths as text, but --format is %s, whose objects have the path already", format)
+	}
+	return format, nil
+}
 
-	Cmd.AddCommand(changePassword, create, deleteCmd, getDefault, list, rename)
+// vaultEntry is how `vault list` and `vault default` print a vault as JSON.
+type vaultEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// printVaults prints vaults as JSON: as one object a line for ndjson, and for
+// json as the one vault `vault default` names, or, for a list, as an object
+// holding them and how many there are.
+func printVaults(format cli.Format, vaults []vault.Vault, isList bool) error {
+	entries := make([]vaultEntry, len(vaults))
+	for i, v := range vaults {
+		entries[i] = vaultEntry{Name: v.Name(), Path: v.Path()}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	switch {
+	case format == cli.FormatNDJSON:
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case isList:
+		return enc.Encode(struct {
+			Count  int          `json:"count"`
+			Vaults []vaultEntry `json:"vaults"`
+		}{len(entries), entries})
+	default:
+		return enc.Encode(entries[0])
+	}
+}
+
+// vaultInfo is what `mrs vault info` prints, with the field names that JSON
+// is written with.
+type vaultInfo struct {
+	Name          string    `json:"name"`
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	Modified      time.Time `json:"modified"`
+	Salt          string    `json:"salt"`
+	FormatVersion int       `json:"format_version"`
+	KDF           string    `json:"kdf"`
+	Iterations    uint32    `json:"iterations"`
+	MemoryKiB     uint32    `json:"memory_kib,omitempty"`
+	Threads       uint8     `json:"threads,omitempty"`
+	Current       bool      `json:"current"`
+	Keyfile       bool      `json:"keyfile"`
+	Recovery      bool      `json:"recovery"`
+	Members       []string  `json:"members,omitempty"`
+	Backups       []string  `json:"backups"`
+	TempFiles     []string  `json:"temp_files"`
+	Locked        bool      `json:"locked"`
+	Secrets       int       `json:"secrets"`
+	DuplicateKeys int       `json:"duplicate_keys"`
+}
+
+// runInfo describes one vault. It reads the vault without taking its lock, as
+// every other reader does, so that it can report a lock held by another
+// process rather than be refused by it.
+func (o *vaultOptions) runInfo(name string) error {
+	format, err := o.listFormat()
+	if err != nil {
+		return err
+	}
+	v, err := vault.Exact(name)
+	if err != nil {
+		return err
+	}
+	fi, err := vault.Stat(v)
+	if err != nil {
+		return err
+	}
+
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+	password, err := o.vaultPassword(v)
+	if err != nil {
+		return err
+	}
+	uv := v.UnlockedWithKeyfile(password, keyfile)
+	defer uv.Wipe()
+	plaintext, h, err := uv.DecryptWithHeader()
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(plaintext)
+	summary, err := secret.Summarize(plaintext)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", v, err)
+	}
+
+	info := vaultInfo{
+		Name:          v.Name(),
+		Path:          fi.Path,
+		Size:          fi.Size,
+		Modified:      fi.Modified,
+		Salt:          fi.Salt,
+		FormatVersion: int(h.Version),
+		KDF:           h.KDF.String(),
+		Iterations:    h.Iterations,
+		MemoryKiB:     h.MemoryKiB,
+		Threads:       h.Threads,
+		Current:       h.IsCurrent(),
+		Keyfile:       h.Keyfile,
+		Recovery:      h.RecoveryID != nil,
+		Members:       members(h),
+		Backups:       fi.Backups,
+		TempFiles:     fi.TempFiles,
+		Locked:        fi.Locked,
+		Secrets:       summary.Secrets,
+		DuplicateKeys: summary.DuplicateKeys,
+	}
+	// So that JSON holds empty lists rather than null.
+	if info.Backups == nil {
+		info.Backups = []string{}
+	}
+	if info.TempFiles == nil {
+		info.TempFiles = []string{}
+	}
+	switch format {
+	case cli.FormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	case cli.FormatNDJSON:
+		return json.NewEncoder(os.Stdout).Encode(info)
+	}
+	printInfo(info, h.Params)
+	return nil
+}
+
+func printInfo(info vaultInfo, p crypto.Params) {
+	format := fmt.Sprint(info.FormatVersion)
+	if info.FormatVersion == 0 {
+		format = "0 (no header)"
+	}
+	current := "yes"
+	if !info.Current {
+		current = fmt.Sprintf("no, run \"mrs vault upgrade %s\"", info.Name)
+	}
+	temps := "none"
+	if len(info.TempFiles) > 0 {
+		temps = strings.Join(info.TempFiles, ", ")
+	}
+	rows := [][2]string{
+		{"Name", info.Name},
+		{"Path", info.Path},
+		{"Size", fmt.Sprintf("%d bytes", info.Size)},
+		{"Modified", info.Modified.Format(time.RFC3339)},
+		{"Salt", info.Salt},
+		{"Format", format},
+		{"Key derivation", p.String()},
+		{"Keyfile", yesNo(info.Keyfile)},
+		{"Recovery key", yesNo(info.Recovery)},
+		{"Current", current},
+	}
+	// A team vault's members, one a row, since each is a whole public key.
+	for i, m := range info.Members {
+		label := ""
+		if i == 0 {
+			label = "Members"
+		}
+		rows = append(rows, [2]string{label, m})
+	}
+	rows = append(rows, [][2]string{
+		{"Backups", fmt.Sprint(len(info.Backups))},
+		{"Temp files", temps},
+		{"Locked", yesNo(info.Locked)},
+		{"Secrets", fmt.Sprint(info.Secrets)},
+		{"Duplicate keys", fmt.Sprint(info.DuplicateKeys)},
+	}...)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		if row[0] == "" {
+			fmt.Fprintf(w, "\t%s\n", row[1])
+			continue
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", row[0], row[1])
+	}
+	_ = w.Flush()
+}
+
+// members returns a team vault's members as "mrs vault member list" prints
+// them, or nil for a vault that opens with its password.
+func members(h crypto.Header) []string {
+	var ms []string
+	for _, r := range h.Recipients {
+		ms = append(ms, r.String())
+	}
+	return ms
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// earlierPassword explains a backup that does not open with a password that
+// opens the vault.
+const earlierPassword = "A backup made before a change of password opens only with the password the vault had then"
+
+// newestFirst returns a vault's backups numbered as --from counts them, with
+// the newest as 1.
+func newestFirst(v vault.Vault) ([]string, error) {
+	backups, err := vault.Backups(v)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(backups)
+	return backups, nil
+}
+
+// listBackups prints each of a vault's backups, with when it was made and how
+// many secrets it holds. Counting them needs the password, which a backup
+// from before a change of password does not open with; that one is listed all
+// the same, since it may be the one the user is looking for.
+func (o *vaultOptions) listBackups(name string) error {
+	v, err := vault.Exact(name)
+	if err != nil {
+		return err
+	}
+	backups, err := newestFirst(v)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(os.Stderr, "Vault %s has no backups\n", v)
+		return nil
+	}
+
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+	password, err := o.vaultPassword(v)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(password)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GENERATION\tMADE\tSECRETS")
+	var unopened int
+	for i, p := range backups {
+		made, err := vault.BackupTime(v, p)
+		if err != nil {
+			return err
+		}
+		secrets := "does not open with this password"
+		if plaintext, err := vault.DecryptBackup(v, p, password, keyfile); err == nil {
+			summary, err := secret.Summarize(plaintext)
+			crypto.Wipe(plaintext)
+			if err != nil {
+				return fmt.Errorf("backup %s of vault %s: %w", filepath.Base(p), v, err)
+			}
+			secrets = fmt.Sprint(summary.Secrets)
+		} else {
+			unopened++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", i+1, made.Local().Format(time.RFC3339), secrets)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if unopened > 0 {
+		fmt.Fprintln(os.Stderr, earlierPassword+".")
+	}
+	return nil
+}
+
+// runRestore restores one of a vault's backups under the vault's exclusive
+// lock, taken before the password is asked for.
+func (o *vaultOptions) runRestore(name string) error {
+	v, unlock, err := o.locked(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	backups, err := newestFirst(v)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("vault %s has no backups", v)
+	}
+	if o.from < 1 || o.from > len(backups) {
+		return fmt.Errorf("vault %s has %d %s, so --from must be from 1 to %d", v, len(backups), cli.Plural(len(backups), "backup"), len(backups))
+	}
+	p := backups[o.from-1]
+	made, err := vault.BackupTime(v, p)
+	if err != nil {
+		return err
+	}
+
+	keyfile, err := vault.ReadKeyfile(o.keyfile)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(keyfile)
+	password, err := o.vaultPassword(v)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(password)
+
+	samePassword, err := vault.Restore(v, p, password, keyfile)
+	if err != nil {
+		return err
+	}
+	forgetKey(v)
+	if !samePassword {
+		fmt.Fprintf(os.Stderr, "Warning: vault %s did not open with this password, which the backup does. "+
+			"The backup predates a change of password, so the vault now opens with the password it had then\n", v)
+	}
+	fmt.Fprintf(os.Stderr, "Restored vault %s from the backup made %s\n", v, made.Local().Format(time.RFC3339))
+	return nil
 }
 
 // readImportFile returns the secrets to seed a new vault with, and refuses a
@@ -267,3 +890,13 @@ func readImportFile(importFile string) ([]byte, error) {
 	}
 	return b, nil
 }
+
+// forgetKey has the agent and the kernel keyring forget the key kept for v,
+// whose file has a new password, a new name, or no longer exists. A stale key
+// is refused by the command that is next handed it, so a place that cannot be
+// told is reported and no more.
+func forgetKey(v vault.Vault) {
+	if _, err := keycache.Forget(v.Path()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+}
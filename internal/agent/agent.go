@@ -0,0 +1,332 @@
+// Package agent holds the keys that open vaults for mrs agent, so that a
+// session derives each one once, and asks a running agent for them.
+//
+// The agent never sees a password. It holds the vault's derived key, with the
+// parameters it was derived with, for the vault file it opened, and hands it
+// only to a process of the user it runs as.
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// DefaultTimeout is how long the agent holds its keys after it was last
+// asked for one, when mrs agent --timeout does not say.
+const DefaultTimeout = 15 * time.Minute
+
+// ErrNotRunning reports that no agent is listening, which to a command that
+// asks for a key means only that it asks for the password instead.
+var ErrNotRunning = errors.New("no agent is running")
+
+// One request is made on each connection: an op, the vault file it is about,
+// and for opPut the key; and one reply: a status, and for a key that opGet
+// found, the key. Numbers are big-endian.
+const (
+	opGet byte = iota + 1
+	opPut
+	opForget
+	opLock
+)
+
+const (
+	statusOK byte = iota
+	statusNone
+	statusError
+)
+
+const (
+	// maxPathLen bounds what a request may ask the agent to read.
+	maxPathLen = 4096
+	// ioTimeout bounds a connection, so that one that stops halfway holds
+	// nothing up.
+	ioTimeout = 5 * time.Second
+)
+
+// Agent holds keys by the path of the vault file they open.
+type Agent struct {
+	mu      sync.Mutex
+	keys    map[string]*crypto.Key
+	timeout time.Duration
+	idle    *time.Timer
+	uid     int
+	logf    func(format string, args ...any)
+}
+
+// New returns an Agent that forgets its keys once it has not been asked for
+// one for timeout, and reports what it does to logf.
+func New(timeout time.Duration, logf func(format string, args ...any)) *Agent {
+	a := &Agent{keys: map[string]*crypto.Key{}, timeout: timeout, uid: os.Getuid(), logf: logf}
+	a.idle = time.AfterFunc(timeout, a.expire)
+	return a
+}
+
+// Listen listens on the socket at p, which only its user may connect to. A
+// socket left there by an agent that did not exit cleanly is replaced, and
+// one that an agent still answers on is refused.
+func Listen(p string) (*net.UnixListener, error) {
+	if fi, err := os.Lstat(p); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket, so the agent will not replace it", p)
+		}
+		if c, err := net.DialTimeout("unix", p, ioTimeout); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("an agent is already running on %s", p)
+		}
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: p, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The socket is created under the umask, so it is narrowed here. Peers
+	// are checked whatever its mode, so this is not what keeps others out.
+	if err := os.Chmod(p, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve answers requests on l until it is closed, then forgets every key.
+func (a *Agent) Serve(l *net.UnixListener) error {
+	defer a.Lock()
+	for {
+		c, err := l.AcceptUnix()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go a.handle(c)
+	}
+}
+
+func (a *Agent) handle(c *net.UnixConn) {
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(ioTimeout))
+	uid, err := peerUID(c)
+	if err != nil {
+		a.logf("Refused a connection: %s", err)
+		return
+	}
+	if uid != a.uid {
+		a.logf("Refused a connection from user %d", uid)
+		return
+	}
+	op, p, err := readRequest(c)
+	if err != nil {
+		a.logf("Refused a request: %s", err)
+		_ = writeError(c, err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.idle.Reset(a.timeout)
+	switch op {
+	case opGet:
+		k, ok := a.keys[p]
+		if !ok {
+			_, _ = c.Write([]byte{statusNone})
+			return
+		}
+		body := k.Marshal()
+		reply := append([]byte{statusOK}, body...)
+		crypto.Wipe(body)
+		_, _ = c.Write(reply)
+		crypto.Wipe(reply)
+	case opPut:
+		k, err := readKey(c)
+		if err != nil {
+			_ = writeError(c, err)
+			return
+		}
+		if old, ok := a.keys[p]; ok {
+			old.Wipe()
+		}
+		a.keys[p] = k
+		_, _ = c.Write([]byte{statusOK})
+	case opForget:
+		if k, ok := a.keys[p]; ok {
+			k.Wipe()
+			delete(a.keys, p)
+		}
+		_, _ = c.Write([]byte{statusOK})
+	case opLock:
+		a.lock()
+		_, _ = c.Write([]byte{statusOK})
+	}
+}
+
+// Lock forgets every key.
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lock()
+}
+
+func (a *Agent) lock() {
+	for p, k := range a.keys {
+		k.Wipe()
+		delete(a.keys, p)
+	}
+}
+
+func (a *Agent) expire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.keys); n > 0 {
+		a.lock()
+		a.logf("Forgot %d %s after %s idle", n, cli.Plural(n, "key"), a.timeout)
+	}
+}
+
+func readRequest(r io.Reader) (byte, string, error) {
+	var head [3]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, "", err
+	}
+	op, n := head[0], binary.BigEndian.Uint16(head[1:])
+	if op < opGet || op > opLock {
+		return 0, "", fmt.Errorf("unknown op %d", op)
+	}
+	if n > maxPathLen {
+		return 0, "", fmt.Errorf("a path of %d bytes is too long", n)
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return 0, "", err
+	}
+	return op, string(p), nil
+}
+
+// readKey reads the parameters and key that follow a put, or that answer a
+// get. The caller is responsible for wiping the Key.
+func readKey(r io.Reader) (*crypto.Key, error) {
+	var b [crypto.MarshalledKeyLen]byte
+	defer crypto.Wipe(b[:])
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalKey(b[:])
+}
+
+func writeError(w io.Writer, err error) error {
+	msg := err.Error()
+	if len(msg) > maxPathLen {
+		msg = msg[:maxPathLen]
+	}
+	b := append([]byte{statusError, 0, 0}, msg...)
+	binary.BigEndian.PutUint16(b[1:3], uint16(len(msg)))
+	_, werr := w.Write(b)
+	return werr
+}
+
+// Get returns the key the agent holds for the vault file at p, or nil when it
+// holds none. The caller is responsible for wiping it.
+func Get(p string) (*crypto.Key, error) {
+	var k *crypto.Key
+	err := request(opGet, p, nil, func(status byte, r io.Reader) error {
+		if status != statusOK {
+			return nil
+		}
+		var err error
+		k, err = readKey(r)
+		return err
+	})
+	return k, err
+}
+
+// Put gives the agent the key to the vault file at p, in place of any it
+// holds for it. The caller still owns k.
+func Put(p string, k *crypto.Key) error {
+	body := k.Marshal()
+	defer crypto.Wipe(body)
+	return request(opPut, p, body, nil)
+}
+
+// Forget has the agent forget the key to the vault file at p, which a new
+// password, or a file that is not there any more, leaves it holding for
+// nothing.
+func Forget(p string) error {
+	return request(opForget, p, nil, nil)
+}
+
+// Lock has the agent forget every key it holds.
+func Lock() error {
+	return request(opLock, "", nil, nil)
+}
+
+// request makes one request of the agent, and hands a reply other than an
+// error to read, if there is one.
+func request(op byte, p string, body []byte, read func(byte, io.Reader) error) error {
+	sock, err := config.AgentSocket()
+	if err != nil {
+		return ErrNotRunning
+	}
+	if len(p) > maxPathLen {
+		return fmt.Errorf("a path of %d bytes is too long for the agent", len(p))
+	}
+	conn, err := net.DialTimeout("unix", sock, ioTimeout)
+	if err != nil {
+		// A socket that is not there, or that nothing listens on, is no
+		// agent, which is not a failure; anything else is.
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("could not reach the agent on %s: %w", sock, err)
+	}
+	c := conn.(*net.UnixConn)
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(ioTimeout))
+	// A key is handed only to its user, and taken only from them: a socket
+	// that another user put in its place would otherwise be given the keys,
+	// or hand back ones of its choosing.
+	uid, err := peerUID(c)
+	if err != nil {
+		return fmt.Errorf("could not check who runs the agent on %s: %w", sock, err)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("the agent on %s is run by user %d, not by you, so it is not used", sock, uid)
+	}
+
+	req := make([]byte, 3, 3+len(p)+len(body))
+	req[0] = op
+	binary.BigEndian.PutUint16(req[1:3], uint16(len(p)))
+	req = append(append(req, p...), body...)
+	defer crypto.Wipe(req)
+	if _, err := c.Write(req); err != nil {
+		return fmt.Errorf("could not reach the agent on %s: %w", sock, err)
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(c, status[:]); err != nil {
+		return fmt.Errorf("the agent on %s did not answer: %w", sock, err)
+	}
+	if status[0] == statusError {
+		var n [2]byte
+		if _, err := io.ReadFull(c, n[:]); err != nil {
+			return fmt.Errorf("the agent on %s did not answer: %w", sock, err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(n[:]))
+		_, _ = io.ReadFull(c, msg)
+		return fmt.Errorf("the agent refused the request: %s", msg)
+	}
+	if read == nil {
+		return nil
+	}
+	return read(status[0], c)
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// startAgent runs an agent on a socket in a new $XDG_RUNTIME_DIR, which is
+// where the client functions look for it.
+func startAgent(t *testing.T, timeout time.Duration) (*Agent, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	p := filepath.Join(dir, "mrs-agent.sock")
+	l, err := Listen(p)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	a := New(timeout, t.Logf)
+	done := make(chan error)
+	go func() { done <- a.Serve(l) }()
+	t.Cleanup(func() {
+		l.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+	})
+	return a, p
+}
+
+func testKey(t *testing.T, b byte) *crypto.Key {
+	t.Helper()
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = b
+	}
+	k, err := crypto.NewKey(crypto.CurrentParams, raw)
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	return k
+}
+
+func TestTheAgentHandsBackTheKeyItWasGiven(t *testing.T) {
+	_, p := startAgent(t, time.Minute)
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("failed to stat the socket: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %o, want 600", fi.Mode().Perm())
+	}
+
+	if k, err := Get("/vaults/work.salt"); err != nil || k != nil {
+		t.Fatalf("Get() before Put() = %v, %v; want no key", k, err)
+	}
+	if err := Put("/vaults/work.salt", testKey(t, 1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := Put("/vaults/work.salt", testKey(t, 2)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	k, err := Get("/vaults/work.salt")
+	if err != nil || k == nil {
+		t.Fatalf("Get() = %v, %v; want a key", k, err)
+	}
+	if want := testKey(t, 2); string(k.Marshal()) != string(want.Marshal()) {
+		t.Errorf("Get() = %+v, want the key put last", k.Params)
+	}
+	if k, _ := Get("/vaults/personal.salt"); k != nil {
+		t.Error("expected no key for a vault that none was put for")
+	}
+
+	if err := Forget("/vaults/work.salt"); err != nil {
+		t.Fatalf("Forget() failed: %v", err)
+	}
+	if k, _ := Get("/vaults/work.salt"); k != nil {
+		t.Error("expected Forget() to forget the key")
+	}
+}
+
+func TestLockForgetsEveryKey(t *testing.T) {
+	_, _ = startAgent(t, time.Minute)
+	for _, p := range []string{"/vaults/a.salt", "/vaults/b.salt"} {
+		if err := Put(p, testKey(t, 1)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	if err := Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	for _, p := range []string{"/vaults/a.salt", "/vaults/b.salt"} {
+		if k, _ := Get(p); k != nil {
+			t.Errorf("expected no key for %s after Lock()", p)
+		}
+	}
+}
+
+func TestKeysAreForgottenOnceTheAgentIsIdle(t *testing.T) {
+	a, _ := startAgent(t, 50*time.Millisecond)
+	if err := Put("/vaults/work.salt", testKey(t, 1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		n := len(a.keys)
+		a.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the key to be forgotten after the idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNoAgentIsNotAFailure(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if _, err := Get("/vaults/work.salt"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected ErrNotRunning with no socket, got %v", err)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if err := Put("/vaults/work.salt", testKey(t, 1)); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected ErrNotRunning with no XDG_RUNTIME_DIR, got %v", err)
+	}
+}
+
+// A socket that an agent left behind is replaced, but one that an agent still
+// answers on is not taken from it, and nor is a file that is not a socket.
+func TestListenReplacesOnlyAStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "mrs-agent.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: p, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(p); err != nil {
+		t.Fatalf("expected the stale socket to be left behind: %v", err)
+	}
+
+	l, err := Listen(p)
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, got %v", err)
+	}
+	defer l.Close()
+	if _, err := Listen(p); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("expected a second agent to be refused, got %v", err)
+	}
+
+	notSocket := filepath.Join(dir, "file")
+	if err := os.WriteFile(notSocket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(notSocket); err == nil {
+		t.Error("expected a file that is not a socket to be left alone")
+	}
+}
+
+func TestAMalformedRequestIsRefused(t *testing.T) {
+	_, p := startAgent(t, time.Minute)
+	c, err := net.Dial("unix", p)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte{99, 0, 0}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	var status [1]byte
+	if _, err := c.Read(status[:]); err != nil || status[0] != statusError {
+		t.Errorf("expected an error status, got %d, %v", status[0], err)
+	}
+}
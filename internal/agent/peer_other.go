@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID reports that this platform gives no way to learn who is at the
+// other end of a socket, without which the agent would hand its keys to
+// anyone who connects.
+func peerUID(c *net.UnixConn) (int, error) {
+	return 0, errors.New("the agent cannot check who connects to it on this platform")
+}
@@ -3,6 +3,7 @@ package cli
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -63,3 +64,36 @@ func Plural(n int, word string) string {
 	}
 	return word + "s"
 }
+
+// Format is the shape of what a command prints or reads: text for reading, or
+// JSON for a program to parse, as one document or as one object a line, or
+// the shape another tool writes or reads secrets in.
+type Format string
+
+const (
+	FormatText   Format = "text"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+
+	FormatCSV           Format = "csv"
+	FormatDotenv        Format = "dotenv"
+	FormatBitwardenJSON Format = "bitwarden-json"
+	FormatKeePassXML    Format = "keepass-xml"
+	Format1PasswordCSV  Format = "1password-csv"
+	FormatPass          Format = "pass"
+	FormatK8sSecret     Format = "k8s-secret"
+)
+
+// ParseFormat returns the format named s, which has to be one of allowed, the
+// formats a command can print.
+func ParseFormat(s string, allowed ...Format) (Format, error) {
+	names := make([]string, len(allowed))
+	for i, f := range allowed {
+		if string(f) == s {
+			return f, nil
+		}
+		names[i] = string(f)
+	}
+	return "", Usagef("--format must be %s or %s, but is %q",
+		strings.Join(names[:len(names)-1], ", "), names[len(names)-1], s)
+}
@@ -0,0 +1,58 @@
+// Package clipboard puts a value on the clipboard with the commands that
+// config.ClipboardCommands names, and clears it again only while the
+// clipboard still holds that value, so that whatever the user copied since is
+// left alone.
+package clipboard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// Sum is what is remembered of a value that was copied, to tell whether the
+// clipboard still holds it without keeping the value itself.
+func Sum(value []byte) [sha256.Size]byte {
+	return sha256.Sum256(value)
+}
+
+// Copy puts value on the clipboard, handing it to the copy command on its
+// stdin rather than as an argument, which any user could read.
+func Copy(c config.Clipboard, value []byte) error {
+	cmd := exec.Command(c.Copy[0], c.Copy[1:]...)
+	cmd.Stdin = bytes.NewReader(value)
+	// The programs that serve a clipboard keep running once they have been
+	// given a value, so the copy's output is not read, which would wait for
+	// them to exit.
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy to the clipboard with %s: %w", c.Copy[0], err)
+	}
+	return nil
+}
+
+// Holds reports whether the clipboard holds the value whose Sum is sum. A
+// paste command that ends what it prints with a newline, as some do, is
+// allowed it.
+func Holds(c config.Clipboard, sum [sha256.Size]byte) (bool, error) {
+	out, err := exec.Command(c.Paste[0], c.Paste[1:]...).Output()
+	defer crypto.Wipe(out)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the clipboard with %s: %w", c.Paste[0], err)
+	}
+	return Sum(out) == sum || Sum(bytes.TrimSuffix(out, []byte{'\n'})) == sum, nil
+}
+
+// Clear empties the clipboard, if it still holds the value whose Sum is sum.
+func Clear(c config.Clipboard, sum [sha256.Size]byte) (bool, error) {
+	holds, err := Holds(c, sum)
+	if err != nil || !holds {
+		return false, err
+	}
+	return true, Copy(c, nil)
+}
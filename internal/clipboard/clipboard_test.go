@@ -0,0 +1,44 @@
+package clipboard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/config"
+)
+
+// fileClipboard is a clipboard kept in a file, by sh.
+func fileClipboard(t *testing.T) (config.Clipboard, string) {
+	p := filepath.Join(t.TempDir(), "clipboard")
+	return config.Clipboard{
+		Copy:  []string{"sh", "-c", `cat > "$0"`, p},
+		Paste: []string{"sh", "-c", `cat "$0"; echo`, p},
+	}, p
+}
+
+func TestClearClearsOnlyItsOwnValue(t *testing.T) {
+	c, p := fileClipboard(t)
+	if err := Copy(c, []byte("a password")); err != nil {
+		t.Fatal(err)
+	}
+	// The paste command adds a newline, which does not make it another value.
+	cleared, err := Clear(c, Sum([]byte("a password")))
+	if err != nil || !cleared {
+		t.Fatalf("Clear() = %v, %v; want it cleared", cleared, err)
+	}
+	if b, _ := os.ReadFile(p); len(b) != 0 {
+		t.Fatalf("expected the clipboard to be empty, got %q", b)
+	}
+
+	if err := Copy(c, []byte("something else")); err != nil {
+		t.Fatal(err)
+	}
+	cleared, err = Clear(c, Sum([]byte("a password")))
+	if err != nil || cleared {
+		t.Fatalf("Clear() = %v, %v; want it left alone", cleared, err)
+	}
+	if b, _ := os.ReadFile(p); string(b) != "something else" {
+		t.Fatalf("expected the clipboard to be left alone, got %q", b)
+	}
+}
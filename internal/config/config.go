@@ -1,10 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 )
 
@@ -84,11 +88,146 @@ func splitArgs(s string) []string {
 	return argv
 }
 
+// DefaultBackups is how many backups of each vault are kept when
+// $MRS_BACKUPS does not say.
+const DefaultBackups = 5
+
+// Backups returns how many backups of each vault to keep, from $MRS_BACKUPS.
+// Zero keeps none.
+func Backups() (int, error) {
+	v := os.Getenv("MRS_BACKUPS")
+	if v == "" {
+		return DefaultBackups, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("MRS_BACKUPS must be a number of backups, 0 or more, but is %q", v)
+	}
+	return n, nil
+}
+
+// Keyring returns the kernel keyring that $MRS_KEYRING names for mrs to keep
+// vaults' keys in between commands: "session", which is shared by the
+// processes of one login, or "user", which is shared by every one of the
+// user's. It returns the empty string, for none, when it is not set.
+func Keyring() (string, error) {
+	switch v := os.Getenv("MRS_KEYRING"); v {
+	case "", "session", "user":
+		return v, nil
+	default:
+		return "", fmt.Errorf("MRS_KEYRING must be session or user, but is %q", v)
+	}
+}
+
+// DefaultKeyringTimeout is how long a key is kept in the kernel keyring after
+// it was last used, when $MRS_KEYRING_TIMEOUT does not say.
+const DefaultKeyringTimeout = 15 * time.Minute
+
+// KeyringTimeout returns how long a key is kept in the kernel keyring after it
+// was last used, from $MRS_KEYRING_TIMEOUT.
+func KeyringTimeout() (time.Duration, error) {
+	v := os.Getenv("MRS_KEYRING_TIMEOUT")
+	if v == "" {
+		return DefaultKeyringTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	// The kernel counts in whole seconds, and takes 0 to mean never.
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("MRS_KEYRING_TIMEOUT must be a duration of 1s or more, such as 15m, but is %q", v)
+	}
+	return d, nil
+}
+
+// Clipboard is the pair of commands that mrs puts a value on the clipboard
+// with, from their stdin, and reads back what the clipboard holds with, so
+// that it clears only a value of its own.
+type Clipboard struct {
+	Copy  []string
+	Paste []string
+}
+
+// clipboards are the clipboard programs mrs knows the pair of commands for,
+// in the order it looks for them, each where the display it needs is set.
+var clipboards = []struct {
+	display     string
+	copy, paste []string
+}{
+	{"WAYLAND_DISPLAY", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}},
+	{"DISPLAY", []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+	{"DISPLAY", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+	{"", []string{"pbcopy"}, []string{"pbpaste"}},
+}
+
+// ClipboardCommands returns the commands to copy to and paste from the
+// clipboard. $MRS_CLIPBOARD is the command that copies, split as $EDITOR is;
+// when it names one of wl-copy, xclip, xsel or pbcopy alone, the command that
+// pastes is known, and otherwise $MRS_CLIPBOARD_PASTE has to say. Without
+// $MRS_CLIPBOARD, the first of those programs that is installed, for a
+// display that is running, is used.
+func ClipboardCommands() (Clipboard, error) {
+	v := os.Getenv("MRS_CLIPBOARD")
+	copyArgv, pasteArgv := splitArgs(v), splitArgs(os.Getenv("MRS_CLIPBOARD_PASTE"))
+	switch {
+	case len(copyArgv) == 0 && len(pasteArgv) > 0:
+		return Clipboard{}, fmt.Errorf("MRS_CLIPBOARD_PASTE is set, but MRS_CLIPBOARD, the command it reads back, is not")
+	case len(copyArgv) == 0:
+		for _, c := range clipboards {
+			if c.display != "" && os.Getenv(c.display) == "" {
+				continue
+			}
+			if _, err := exec.LookPath(c.copy[0]); err == nil {
+				return Clipboard{Copy: c.copy, Paste: c.paste}, nil
+			}
+		}
+		return Clipboard{}, fmt.Errorf("no clipboard program was found. Install wl-clipboard, xclip or xsel, or set MRS_CLIPBOARD to the command that copies its stdin")
+	case len(pasteArgv) > 0:
+		return Clipboard{Copy: copyArgv, Paste: pasteArgv}, nil
+	}
+	if len(copyArgv) == 1 {
+		for _, c := range clipboards {
+			if c.copy[0] == copyArgv[0] {
+				return Clipboard{Copy: c.copy, Paste: c.paste}, nil
+			}
+		}
+	}
+	return Clipboard{}, fmt.Errorf("MRS_CLIPBOARD is %q, which mrs does not know how to read back. Set MRS_CLIPBOARD_PASTE to the command that prints what it copied", v)
+}
+
+// DefaultClipboardTimeout is how long a value stays on the clipboard, when
+// $MRS_CLIPBOARD_TIMEOUT does not say.
+const DefaultClipboardTimeout = 45 * time.Second
+
+// ClipboardTimeout returns how long a value stays on the clipboard before mrs
+// clears it, from $MRS_CLIPBOARD_TIMEOUT.
+func ClipboardTimeout() (time.Duration, error) {
+	v := os.Getenv("MRS_CLIPBOARD_TIMEOUT")
+	if v == "" {
+		return DefaultClipboardTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("MRS_CLIPBOARD_TIMEOUT must be a duration of more than 0, such as 45s, but is %q", v)
+	}
+	return d, nil
+}
+
 // HideEditorInstructions indicates that instructions comments should be omitted from the top of editor sessions
 func HideEditorInstructions() bool {
 	return os.Getenv("MRS_HIDE_EDITOR_INSTRUCTIONS") != ""
 }
 
+// AgentSocket returns the path of the socket that mrs agent listens on, in
+// $XDG_RUNTIME_DIR: a directory that only its user can enter, and that is
+// emptied when they log out, which is as long as a key should outlive the
+// agent that held it.
+func AgentSocket() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", fmt.Errorf("XDG_RUNTIME_DIR is not set, so there is no directory for the agent's socket")
+	}
+	return path.Join(dir, "mrs-agent.sock"), nil
+}
+
 // GetBaseDir returns the directory where mrs stores its files
 func GetBaseDir() (string, error) {
 	if b := os.Getenv("MRS_HOME"); b != "" {
@@ -6,6 +6,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetBaseDir(t *testing.T) {
@@ -128,3 +129,129 @@ func TestEditor(t *testing.T) {
 		})
 	}
 }
+
+func TestBackups(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int
+		wantErr  bool
+	}{
+		{"Default", "", DefaultBackups, false},
+		{"Custom", "12", 12, false},
+		{"None", "0", 0, false},
+		{"Negative", "-1", 0, true},
+		{"Not a number", "some", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MRS_BACKUPS", tt.value)
+			got, err := Backups()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Backups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("Backups() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAgentSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got, err := AgentSocket(); err != nil || got != "/run/user/1000/mrs-agent.sock" {
+		t.Errorf("AgentSocket() = %q, %v; want %q", got, err, "/run/user/1000/mrs-agent.sock")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if _, err := AgentSocket(); err == nil {
+		t.Error("expected an error with XDG_RUNTIME_DIR unset")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	for _, v := range []string{"", "session", "user"} {
+		t.Setenv("MRS_KEYRING", v)
+		if got, err := Keyring(); err != nil || got != v {
+			t.Errorf("Keyring() with MRS_KEYRING=%q = %q, %v", v, got, err)
+		}
+	}
+	t.Setenv("MRS_KEYRING", "thread")
+	if _, err := Keyring(); err == nil {
+		t.Error("expected an error for a keyring mrs does not use")
+	}
+}
+
+func TestKeyringTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultKeyringTimeout, false},
+		{"1h", time.Hour, false},
+		{"90s", 90 * time.Second, false},
+		{"500ms", 0, true},
+		{"-1m", 0, true},
+		{"15", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("MRS_KEYRING_TIMEOUT", tt.value)
+		got, err := KeyringTimeout()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("KeyringTimeout() with MRS_KEYRING_TIMEOUT=%q = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestClipboardCommands(t *testing.T) {
+	tests := []struct {
+		copy, paste string
+		want        Clipboard
+		wantErr     bool
+	}{
+		{"xclip", "", Clipboard{
+			Copy:  []string{"xclip", "-selection", "clipboard"},
+			Paste: []string{"xclip", "-selection", "clipboard", "-o"},
+		}, false},
+		{"wl-copy", "", Clipboard{Copy: []string{"wl-copy"}, Paste: []string{"wl-paste", "--no-newline"}}, false},
+		{"'/opt/my clip' --in", "/opt/paste", Clipboard{Copy: []string{"/opt/my clip", "--in"}, Paste: []string{"/opt/paste"}}, false},
+		{"xclip -selection primary", "", Clipboard{}, true},
+		{"my-clip", "", Clipboard{}, true},
+		{"", "wl-paste", Clipboard{}, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("MRS_CLIPBOARD", tt.copy)
+		t.Setenv("MRS_CLIPBOARD_PASTE", tt.paste)
+		got, err := ClipboardCommands()
+		if (err != nil) != tt.wantErr || !slices.Equal(got.Copy, tt.want.Copy) || !slices.Equal(got.Paste, tt.want.Paste) {
+			t.Errorf("ClipboardCommands() with MRS_CLIPBOARD=%q MRS_CLIPBOARD_PASTE=%q = %v, %v", tt.copy, tt.paste, got, err)
+		}
+	}
+}
+
+func TestClipboardCommandsWithoutAnyInstalled(t *testing.T) {
+	t.Setenv("MRS_CLIPBOARD", "")
+	t.Setenv("MRS_CLIPBOARD_PASTE", "")
+	t.Setenv("PATH", t.TempDir())
+	if _, err := ClipboardCommands(); err == nil || !strings.Contains(err.Error(), "MRS_CLIPBOARD") {
+		t.Errorf("expected an error that names MRS_CLIPBOARD, got %v", err)
+	}
+}
+
+func TestClipboardTimeout(t *testing.T) {
+	t.Setenv("MRS_CLIPBOARD_TIMEOUT", "")
+	if got, err := ClipboardTimeout(); err != nil || got != DefaultClipboardTimeout {
+		t.Errorf("ClipboardTimeout() = %v, %v; want %v", got, err, DefaultClipboardTimeout)
+	}
+	t.Setenv("MRS_CLIPBOARD_TIMEOUT", "10s")
+	if got, err := ClipboardTimeout(); err != nil || got != 10*time.Second {
+		t.Errorf("ClipboardTimeout() = %v, %v; want 10s", got, err)
+	}
+	for _, v := range []string{"0s", "-1s", "10"} {
+		t.Setenv("MRS_CLIPBOARD_TIMEOUT", v)
+		if _, err := ClipboardTimeout(); err == nil {
+			t.Errorf("expected an error for MRS_CLIPBOARD_TIMEOUT=%q", v)
+		}
+	}
+}
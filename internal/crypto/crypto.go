@@ -10,12 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-
-	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	minSaltLen        = 32
+	minSaltLen = 32
+	// maxNameLen is what the header's one-byte name length can hold.
+	maxNameLen = 255
+	// LegacyIterations and CurrentIterations are the PBKDF2 iteration counts
+	// of vaults written before the header existed, which say neither. Decrypt
+	// tries both on such a file, and no vault is written with either now.
 	LegacyIterations  = 4096
 	CurrentIterations = 600000
 )
@@ -30,38 +33,198 @@ func Wipe(buf []byte) {
 	}
 }
 
-// Decrypt returns decrypted data.
-func Decrypt(data []byte, password []byte, salt string) ([]byte, error) {
-	// Try the current (new) iterations first
-	k, err := key(password, salt, CurrentIterations)
+// Decrypt returns decrypted data, along with the header it was sealed under.
+// A file from before the header has a zero Version, and the parameters that
+// turned out to open it. A file with a header names them, so its key is
+// derived once; one without is tried at the two iteration counts that earlier
+// versions of mrs used. name is the vault the file is opened as, and a file
+// sealed as any other is refused with ErrWrongName.
+func Decrypt(data []byte, password []byte, salt, name string) ([]byte, Header, error) {
+	plaintext, h, k, err := DecryptKey(data, password, nil, salt, name)
+	if k != nil {
+		k.Wipe()
+	}
+	if r := h.Recovery(); r != nil {
+		r.Wipe()
+		h.recovery = nil
+	}
+	return plaintext, h, err
+}
+
+// DecryptKey is Decrypt, also returning the key that opened a file with a
+// header, so that the caller can open it again without deriving one. It is
+// nil for a file without a header. The caller is responsible for wiping it.
+// keyfile is the digest of the keyfile the file's key was derived from, if
+// any, as KeyfileDigest returns it; a file that names none is opened without
+// it, and one that does is refused with ErrNeedsKeyfile when it is nil.
+func DecryptKey(data []byte, password, keyfile []byte, salt, name string) ([]byte, Header, *Key, error) {
+	h, aad, body, err := parseHeader(data)
+	if err != nil {
+		// A file from before the header begins with a random nonce, which
+		// begins with the magic once in 2^32 files. It is read as what it is
+		// rather than refused as a header that makes no sense.
+		if plaintext, p, legacyErr := decryptLegacy(data, password, salt); legacyErr == nil {
+			return plaintext, Header{Params: p}, nil, nil
+		}
+		return nil, Header{}, nil, err
+	}
+	if h.Version == 0 {
+		plaintext, p, err := decryptLegacy(body, password, salt)
+		return plaintext, Header{Params: p}, nil, err
+	}
+	if h.IsTeam() {
+		return nil, Header{}, nil, ErrNeedsIdentity
+	}
+	k, err := h.key(password, keyfile, salt)
+	if err != nil {
+		return nil, Header{}, nil, err
+	}
+	key := &Key{Params: h.Params, k: *k}
+	Wipe(k[:])
+	plaintext, h, err := openWithKey(h, aad, body, key, name)
+	if err != nil {
+		key.Wipe()
+		return nil, Header{}, nil, err
+	}
+	return plaintext, h, key, nil
+}
+
+// DecryptWithKey is Decrypt with a key that DecryptKey returned, rather than a
+// password. It opens only a file with a header that names the parameters the
+// key was derived with, and fails on any other as it would for a wrong
+// password.
+func DecryptWithKey(data []byte, k *Key, name string) ([]byte, Header, error) {
+	h, aad, body, err := parseHeader(data)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	if h.Version == 0 || h.Params != k.Params {
+		return nil, Header{}, errKeyParams
+	}
+	return openWithKey(h, aad, body, k, name)
+}
+
+// errKeyParams reports a key derived with other parameters than a file names,
+// which could not open it whatever password it came from.
+var errKeyParams = errors.New("the key was derived with other parameters than the file's")
+
+// openWithKey opens the body of a file with a header under k, or a version 5
+// file's under the data key that k unwraps, which the header it returns holds.
+func openWithKey(h Header, aad, body []byte, k *Key, name string) ([]byte, Header, error) {
+	if h.Version != recoveryVersion {
+		plaintext, err := openHeaded(h, aad, body, &k.k, name)
+		return plaintext, h, err
+	}
+	return openRecovered(h, aad, body, h.passwordWrapped, &k.k, nil, name)
+}
+
+// openHeaded opens the body of a file with a header.
+func openHeaded(h Header, aad, body []byte, k *[32]byte, name string) ([]byte, error) {
+	plaintext, err := open(body, k, aad)
 	if err != nil {
 		return nil, err
 	}
-	defer Wipe(k[:])
+	// Compared only once the header is known to be authentic, so that the
+	// name in it is the one the file was sealed as rather than anything
+	// written over it since.
+	if h.Version >= 2 && h.Name != name {
+		Wipe(plaintext)
+		return nil, fmt.Errorf("%w, %q", ErrWrongName, h.Name)
+	}
+	return plaintext, nil
+}
 
-	decrypted, err := open(data, k)
-	if err == nil {
-		return decrypted, nil
+func decryptLegacy(data []byte, password []byte, salt string) ([]byte, Params, error) {
+	var err error
+	for _, iterations := range []uint32{CurrentIterations, LegacyIterations} {
+		p := Params{KDF: PBKDF2SHA256, Iterations: iterations}
+		var k *[32]byte
+		k, err = p.key(password, nil, salt)
+		if err != nil {
+			return nil, Params{}, err
+		}
+		var plaintext []byte
+		plaintext, err = open(data, k, nil)
+		Wipe(k[:])
+		if err == nil {
+			return plaintext, p, nil
+		}
 	}
+	return nil, Params{}, err
+}
 
-	// Fallback to legacy iterations
-	kLegacy, err := key(password, salt, LegacyIterations)
+// Encrypt returns encrypted data, headed by the parameters its key was derived
+// with so that Decrypt does not have to guess them, and by the name of the
+// vault it is sealed as so that it cannot pass for another.
+func Encrypt(data []byte, password []byte, salt, name string) ([]byte, error) {
+	return EncryptWithKeyfile(data, password, nil, salt, name)
+}
+
+// EncryptWithKeyfile is Encrypt with a key derived from the digest of a
+// keyfile as well as the password, which DecryptKey then needs both of. A nil
+// keyfile seals the file as Encrypt does.
+func EncryptWithKeyfile(data []byte, password, keyfile []byte, salt, name string) ([]byte, error) {
+	if len(name) > maxNameLen {
+		return nil, fmt.Errorf("vault name must be at most %d bytes to be sealed, but is %d", maxNameLen, len(name))
+	}
+	key, err := DeriveKey(password, keyfile, salt)
 	if err != nil {
 		return nil, err
 	}
-	defer Wipe(kLegacy[:])
+	defer key.Wipe()
+	return EncryptWithKey(data, key, name)
+}
 
-	return open(data, kLegacy)
+// DeriveKey returns the key that EncryptWithKeyfile seals a file under, with
+// CurrentParams. The caller is responsible for wiping it.
+func DeriveKey(password, keyfile []byte, salt string) (*Key, error) {
+	p := CurrentParams
+	p.Keyfile = keyfile != nil
+	k, err := p.key(password, keyfile, salt)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Params: p, k: *k}
+	Wipe(k[:])
+	return key, nil
 }
 
-// Encrypt returns encrypted data.
-func Encrypt(data []byte, password []byte, salt string) ([]byte, error) {
-	k, err := key(password, salt, CurrentIterations)
+// EncryptWithKey is Encrypt with a key that DecryptKey returned, rather than a
+// password. The file is headed by the parameters the key was derived with,
+// which are those of the file it came from rather than CurrentParams.
+func EncryptWithKey(data []byte, k *Key, name string) ([]byte, error) {
+	if k.KDF == X25519 {
+		return nil, errors.New("a team vault is sealed to its members, with EncryptToRecipients")
+	}
+	h := Header{Version: k.sealVersion(), Params: k.Params, Name: name}
+	if len(h.Name) > maxNameLen {
+		return nil, fmt.Errorf("vault name must be at most %d bytes to be sealed, but is %d", maxNameLen, len(name))
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	header := h.marshal()
+	sealed, err := seal(data, &k.k, header)
 	if err != nil {
 		return nil, err
 	}
-	defer Wipe(k[:])
-	return seal(data, k)
+	return append(header, sealed...), nil
+}
+
+// KeyfileDigest returns the SHA-256 digest of a keyfile's contents, read from
+// r, which is what a key is derived from in place of the contents themselves
+// so that a keyfile of any size can be used. An empty keyfile is refused,
+// since it would add nothing to the password.
+func KeyfileDigest(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("keyfile is empty")
+	}
+	return h.Sum(nil), nil
 }
 
 // seal and open are copied from github.com/gtank/cryptopasta (encrypt.go, as
@@ -70,13 +233,16 @@ func Encrypt(data []byte, password []byte, salt string) ([]byte, error) {
 // imported. It has had no commits since 2017, so it is vendored here instead
 // of depended on. Do not change the construction: 256-bit AES-GCM under a
 // random 96-bit nonce, written as nonce|ciphertext|tag. Every existing vault
-// is in this format, and any replacement belongs in a reviewed library rather
-// than in an edit to these functions.
+// holds this after its header, or with no header at all, and any replacement
+// belongs in a reviewed library rather than in an edit to these functions. The
+// one departure from the original is the additional data, which GCM
+// authenticates alongside the ciphertext without encrypting, and which is nil
+// for every file written before the header carried a name.
 //
 // seal encrypts with 256-bit AES-GCM under a nonce generated per call, which
 // must never repeat under one key. That holds here because a vault is
 // rewritten in full on every save rather than appended to.
-func seal(plaintext []byte, k *[32]byte) ([]byte, error) {
+func seal(plaintext []byte, k *[32]byte, additionalData []byte) ([]byte, error) {
 	gcm, err := newGCM(k)
 	if err != nil {
 		return nil, err
@@ -87,13 +253,13 @@ func seal(plaintext []byte, k *[32]byte) ([]byte, error) {
 	}
 	// Sealing into nonce prefixes it to the output, which is the layout open
 	// reads back.
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 
 // open decrypts what seal produced, and reports an error for ciphertext that
 // was altered as surely as for the wrong key: GCM authenticates before it
 // returns any plaintext.
-func open(ciphertext []byte, k *[32]byte) ([]byte, error) {
+func open(ciphertext []byte, k *[32]byte, additionalData []byte) ([]byte, error) {
 	gcm, err := newGCM(k)
 	if err != nil {
 		return nil, err
@@ -102,7 +268,7 @@ func open(ciphertext []byte, k *[32]byte) ([]byte, error) {
 	if len(ciphertext) < n {
 		return nil, errors.New("malformed ciphertext")
 	}
-	return gcm.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	return gcm.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
 }
 
 func newGCM(k *[32]byte) (cipher.AEAD, error) {
@@ -125,17 +291,6 @@ func Salt() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(unencodedSalt)[:minSaltLen], nil
 }
 
-func key(password []byte, salt string, iterations int) (*[32]byte, error) {
-	if len(salt) < minSaltLen {
-		return nil, fmt.Errorf("salt must be at least %d characters, but was %d", minSaltLen, len(salt))
-	}
-	var arr [32]byte
-	k := pbkdf2.Key(password, []byte(salt), iterations, 32, sha256.New)
-	copy(arr[:], k)
-	Wipe(k)
-	return &arr, nil
-}
-
 // SecureCompare performs a constant time comparison of two byte slices.
 func SecureCompare(a, b []byte) bool {
 	return subtle.ConstantTimeCompare(a, b) == 1
@@ -15,7 +15,7 @@ func TestEncryptDecrypt(t *testing.T) {
 
 	data := []byte("hello world")
 
-	encrypted, err := Encrypt(data, password, salt)
+	encrypted, err := Encrypt(data, password, salt, "test")
 	if err != nil {
 		t.Fatalf("encryption failed: %v", err)
 	}
@@ -24,7 +24,7 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Error("encrypted data should not match original data")
 	}
 
-	decrypted, err := Decrypt(encrypted, password, salt)
+	decrypted, _, err := Decrypt(encrypted, password, salt, "test")
 	if err != nil {
 		t.Fatalf("decryption failed: %v", err)
 	}
@@ -43,15 +43,18 @@ func TestLegacyDecrypt(t *testing.T) {
 
 	// Encrypted as an older version of mrs would have, so that Decrypt has to
 	// fall back to the old iteration count to read it.
-	k, _ := key(password, salt, LegacyIterations)
+	k, _ := Params{KDF: PBKDF2SHA256, Iterations: LegacyIterations}.key(password, nil, salt)
 	defer Wipe(k[:])
-	encrypted, _ := seal(data, k)
+	encrypted, _ := seal(data, k, nil)
 
-	decrypted, err := Decrypt(encrypted, password, salt)
+	decrypted, h, err := Decrypt(encrypted, password, salt, "test")
 	if err != nil {
 		t.Fatalf("Legacy decryption failed: %v", err)
 	}
 	defer Wipe(decrypted)
+	if h.Version != 0 || h.KDF != PBKDF2SHA256 || h.Iterations != LegacyIterations {
+		t.Errorf("expected no header and the legacy parameters to be reported, got %+v", h)
+	}
 
 	if !bytes.Equal(data, decrypted) {
 		t.Errorf("Legacy decrypted data does not match original; expected %q, got %q", string(data), string(decrypted))
@@ -66,9 +69,9 @@ func TestDecryptWithWrongPassword(t *testing.T) {
 	salt, _ := Salt()
 	data := []byte("sensitive info")
 
-	encrypted, _ := Encrypt(data, password, salt)
+	encrypted, _ := Encrypt(data, password, salt, "test")
 
-	_, err := Decrypt(encrypted, wrongPassword, salt)
+	_, _, err := Decrypt(encrypted, wrongPassword, salt, "test")
 	if err == nil {
 		t.Error("decryption should have failed with wrong password")
 	}
@@ -81,9 +84,9 @@ func TestDecryptWithWrongSalt(t *testing.T) {
 	salt2, _ := Salt()
 	data := []byte("sensitive info")
 
-	encrypted, _ := Encrypt(data, password, salt1)
+	encrypted, _ := Encrypt(data, password, salt1, "test")
 
-	_, err := Decrypt(encrypted, password, salt2)
+	_, _, err := Decrypt(encrypted, password, salt2, "test")
 	if err == nil {
 		t.Error("decryption should have failed with wrong salt")
 	}
@@ -0,0 +1,500 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDF identifies the function a vault's key is derived from its password with.
+// The values are written into a vault's header, so they must never be reused.
+type KDF byte
+
+const (
+	PBKDF2SHA256 KDF = 1
+	Argon2id     KDF = 2
+	// X25519 is a team vault's, whose key is not derived at all: it is random,
+	// and wrapped for each member's X25519 public key.
+	X25519 KDF = 3
+)
+
+func (k KDF) String() string {
+	switch k {
+	case PBKDF2SHA256:
+		return "pbkdf2-sha256"
+	case Argon2id:
+		return "argon2id"
+	case X25519:
+		return "x25519"
+	default:
+		return fmt.Sprintf("unknown KDF %d", byte(k))
+	}
+}
+
+// Params are a key derivation function and the costs it is run at.
+type Params struct {
+	KDF KDF
+	// Iterations is PBKDF2's iteration count, or Argon2id's number of passes
+	// over memory.
+	Iterations uint32
+	// MemoryKiB and Threads are Argon2id's, and zero for PBKDF2.
+	MemoryKiB uint32
+	Threads   uint8
+	// Keyfile is whether the key is derived from a keyfile's contents as well
+	// as the password, so that neither opens the vault without the other.
+	Keyfile bool
+}
+
+// CurrentParams are what every vault is written with. They are the second of
+// the two recommended settings in RFC 9106, for a machine that cannot spare
+// 2 GiB per derivation: 64 MiB, 3 passes, 4 lanes. Raising them only needs a
+// new value here, because a vault's header records what it was written with.
+var CurrentParams = Params{KDF: Argon2id, Iterations: 3, MemoryKiB: 64 * 1024, Threads: 4}
+
+// Bounds on what a header may ask for. A header is read before anything is
+// authenticated, so without them a damaged or hostile file could ask for an
+// hour of PBKDF2 or more memory than the machine has. Each leaves room for
+// CurrentParams to grow several times over.
+const (
+	maxPBKDF2Iterations = 10_000_000
+	maxArgon2Iterations = 64
+	maxArgon2MemoryKiB  = 1024 * 1024
+)
+
+func (p Params) String() string {
+	switch p.KDF {
+	case PBKDF2SHA256:
+		return fmt.Sprintf("%s, %d iterations", p.KDF, p.Iterations)
+	case Argon2id:
+		return fmt.Sprintf("%s, %d iterations, %d MiB, %d threads", p.KDF, p.Iterations, p.MemoryKiB/1024, p.Threads)
+	case X25519:
+		return "none, a random key sealed to each member with x25519"
+	default:
+		return p.KDF.String()
+	}
+}
+
+func (p Params) validate() error {
+	switch p.KDF {
+	case PBKDF2SHA256:
+		if p.Iterations < 1 || p.Iterations > maxPBKDF2Iterations {
+			return fmt.Errorf("%s iterations out of range: %d", p.KDF, p.Iterations)
+		}
+	case Argon2id:
+		if p.Iterations < 1 || p.Iterations > maxArgon2Iterations {
+			return fmt.Errorf("%s iterations out of range: %d", p.KDF, p.Iterations)
+		}
+		if p.Threads < 1 {
+			return fmt.Errorf("%s needs at least one thread", p.KDF)
+		}
+		// Argon2 needs 8 KiB per lane, and rounds anything less up to it.
+		if p.MemoryKiB < 8*uint32(p.Threads) || p.MemoryKiB > maxArgon2MemoryKiB {
+			return fmt.Errorf("%s memory out of range: %d KiB", p.KDF, p.MemoryKiB)
+		}
+	case X25519:
+		if p != (Params{KDF: X25519}) {
+			return fmt.Errorf("%s has no costs, but the header gives some", p.KDF)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, p.KDF)
+	}
+	return nil
+}
+
+// key derives a 256-bit key from a password and the salt in a vault's filename,
+// and for parameters that name one, from the digest of a keyfile that
+// KeyfileDigest returned. A keyfile is ignored by parameters that do not.
+func (p Params) key(password, keyfile []byte, salt string) (*[32]byte, error) {
+	if len(salt) < minSaltLen {
+		return nil, fmt.Errorf("salt must be at least %d characters, but was %d", minSaltLen, len(salt))
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.KDF == X25519 {
+		return nil, ErrNeedsIdentity
+	}
+	if p.Keyfile {
+		if keyfile == nil {
+			return nil, ErrNeedsKeyfile
+		}
+		if len(keyfile) != sha256.Size {
+			return nil, fmt.Errorf("a keyfile's digest is %d bytes, but was %d", sha256.Size, len(keyfile))
+		}
+		// The digest is a fixed length, so that no other password and
+		// keyfile run together into the same secret.
+		secret := make([]byte, 0, len(password)+len(keyfile))
+		secret = append(append(secret, password...), keyfile...)
+		defer Wipe(secret)
+		password = secret
+	}
+	var k []byte
+	switch p.KDF {
+	case PBKDF2SHA256:
+		k = pbkdf2.Key(password, []byte(salt), int(p.Iterations), 32, sha256.New)
+	case Argon2id:
+		k = argon2.IDKey(password, []byte(salt), p.Iterations, p.MemoryKiB, p.Threads, 32)
+	}
+	var arr [32]byte
+	copy(arr[:], k)
+	Wipe(k)
+	return &arr, nil
+}
+
+// Key is a vault's key, derived from its password, with the parameters it was
+// derived with, which a file has to name for the key to open it. It is what
+// the agent holds in place of the password, which it never sees.
+type Key struct {
+	Params
+	k [32]byte
+}
+
+// NewKey returns the key k, derived with p. The caller still owns k, and is
+// responsible for wiping the Key.
+func NewKey(p Params, k []byte) (*Key, error) {
+	if len(k) != 32 {
+		return nil, fmt.Errorf("a key is 32 bytes, but was %d", len(k))
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	key := &Key{Params: p}
+	copy(key.k[:], k)
+	return key, nil
+}
+
+// MarshalledKeyLen is the length of a Key as Marshal writes it: its
+// parameters, as a header writes them, then its flags, then the key.
+const MarshalledKeyLen = 1 + 4 + 4 + 1 + 1 + 32
+
+// Marshal returns the key with its parameters, for the agent or the kernel
+// keyring to hold. The caller is responsible for wiping it.
+func (k *Key) Marshal() []byte {
+	b := make([]byte, 0, MarshalledKeyLen)
+	b = append(b, byte(k.KDF))
+	b = binary.BigEndian.AppendUint32(b, k.Iterations)
+	b = binary.BigEndian.AppendUint32(b, k.MemoryKiB)
+	b = append(b, k.Threads, k.flags())
+	return append(b, k.k[:]...)
+}
+
+// UnmarshalKey returns the Key that Marshal wrote into b. The caller still
+// owns b, and is responsible for wiping the Key.
+func UnmarshalKey(b []byte) (*Key, error) {
+	if len(b) != MarshalledKeyLen {
+		return nil, fmt.Errorf("a key is %d bytes with its parameters, but was %d", MarshalledKeyLen, len(b))
+	}
+	p := Params{
+		KDF:        KDF(b[0]),
+		Iterations: binary.BigEndian.Uint32(b[1:5]),
+		MemoryKiB:  binary.BigEndian.Uint32(b[5:9]),
+		Threads:    b[9],
+	}
+	if err := p.setFlags(b[10]); err != nil {
+		return nil, err
+	}
+	return NewKey(p, b[MarshalledKeyLen-32:])
+}
+
+// Wipe zeroes the key, leaving a Key that opens nothing.
+func (k *Key) Wipe() {
+	Wipe(k.k[:])
+	k.Params = Params{}
+}
+
+// ErrUnsupportedFormat reports a vault file whose header names a format
+// version or a KDF that this version of mrs does not know, which is a file
+// written by a newer one.
+var ErrUnsupportedFormat = errors.New("written in a format that this version of mrs cannot read")
+
+// ErrNeedsKeyfile reports a vault file whose key is derived from a keyfile as
+// well as its password, opened without one.
+var ErrNeedsKeyfile = errors.New("needs its keyfile as well as its password")
+
+// ErrWrongName reports a vault file that decrypted, but holds the secrets of a
+// vault other than the one it was opened as: it was renamed or copied over
+// another by hand.
+var ErrWrongName = errors.New("holds the secrets of another vault")
+
+// magic begins every vault file that has a header. Files written before the
+// header existed begin with a random nonce instead.
+var magic = []byte("mrs\x00")
+
+// formatVersion is the layout of the header that Encrypt writes:
+//
+//	magic(4) | version(1) | kdf(1) | iterations(4) | memory KiB(4) | threads(1) | name length(1) | name
+//
+// with integers big-endian, followed by nonce|ciphertext|tag. The whole header
+// is authenticated as the ciphertext's associated data, so neither the
+// parameters nor the name can be changed without the file failing to open.
+//
+// Version 1 ended before the name and authenticated nothing beyond the
+// ciphertext. It is still read, and is rewritten as version 2 on save.
+const formatVersion = 2
+
+// recipientsVersion is the layout of a team vault's header, which
+// EncryptToRecipients writes: version 2's, with the KDF X25519 and no costs,
+// followed by
+//
+//	count(1) | count × (public key(32) | name length(1) | name | ephemeral key(32) | wrapped key(60))
+//
+// It is authenticated as a whole, as version 2's is.
+const recipientsVersion = 3
+
+// keyfileVersion is the layout of the header of a vault whose key is derived
+// from a keyfile as well as its password: version 2's, followed by
+//
+//	flags(1)
+//
+// whose lowest bit is set for the keyfile, and whose others are reserved. A
+// vault without a keyfile is still written as version 2, which every version
+// of mrs since the header can read.
+const keyfileVersion = 4
+
+// recoveryVersion is the layout of the header of a vault that a recovery key
+// opens as well as its password: version 4's, followed by
+//
+//	recovery id(4) | password wrapped key(60) | recovery wrapped key(60)
+//
+// Its body is sealed under a random data key rather than the key its password
+// derives, and the header carries the data key wrapped under each, so that a
+// change of password wraps it again and leaves the recovery key's copy, and
+// the shares of the recovery key, as they were. It is authenticated as a
+// whole, as version 2's is.
+const recoveryVersion = 5
+
+// flagKeyfile is the bit of a version 4 or 5 header's flags that says the key
+// is derived from a keyfile.
+const flagKeyfile = 1
+
+func (p Params) flags() byte {
+	if p.Keyfile {
+		return flagKeyfile
+	}
+	return 0
+}
+
+// setFlags sets what flags say on p, refusing any flag this version of mrs
+// does not know, which a newer one would have set for a reason.
+func (p *Params) setFlags(flags byte) error {
+	if flags&^flagKeyfile != 0 {
+		return fmt.Errorf("%w: flags %#02x", ErrUnsupportedFormat, flags)
+	}
+	p.Keyfile = flags&flagKeyfile != 0
+	return nil
+}
+
+// sealVersion is the header version a file sealed with p is written as.
+func (p Params) sealVersion() byte {
+	switch {
+	case p.KDF == X25519:
+		return recipientsVersion
+	case p.Keyfile:
+		return keyfileVersion
+	default:
+		return formatVersion
+	}
+}
+
+// fixedHeaderLen is the length of a version 1 header, and of a version 2 one
+// up to its name.
+const fixedHeaderLen = 4 + 1 + 1 + 4 + 4 + 1
+
+// Header is what a vault file says about how it was sealed. A file written
+// before headers existed has none, and is described by a zero Version.
+type Header struct {
+	Version byte
+	Params
+	// Name is the vault that the file was sealed as, from version 2 on.
+	Name string
+	// Recipients are the members of a team vault, in the order they were
+	// added, and stanzas their copies of its key.
+	Recipients []Recipient
+	stanzas    []stanza
+	// RecoveryID names the split of the recovery key that opens the vault as
+	// well as its password, from version 5 on, and is nil for a vault that no
+	// recovery key opens.
+	RecoveryID []byte
+	// passwordWrapped and recoveryWrapped are a version 5 vault's data key,
+	// wrapped under the key its password derives and under its recovery key.
+	passwordWrapped []byte
+	recoveryWrapped []byte
+	// recovery is what opened a version 5 vault's body, once it has been
+	// decrypted. See Recovery.
+	recovery *Recovery
+}
+
+// IsCurrent reports whether Encrypt, or for a team vault EncryptToRecipients,
+// would seal a file the same way now.
+func (h Header) IsCurrent() bool {
+	version := h.sealVersion()
+	if h.RecoveryID != nil {
+		version = recoveryVersion
+	}
+	if h.Version != version {
+		return false
+	}
+	if h.KDF == X25519 {
+		return true
+	}
+	p := h.Params
+	p.Keyfile = false
+	return p == CurrentParams
+}
+
+// IsTeam reports whether the file is a team vault's.
+func (h Header) IsTeam() bool {
+	return h.KDF == X25519
+}
+
+func (h Header) marshal() []byte {
+	b := make([]byte, 0, fixedHeaderLen+1+len(h.Name))
+	b = append(b, magic...)
+	b = append(b, h.Version, byte(h.KDF))
+	b = binary.BigEndian.AppendUint32(b, h.Iterations)
+	b = binary.BigEndian.AppendUint32(b, h.MemoryKiB)
+	b = append(b, h.Threads)
+	if h.Version < 2 {
+		return b
+	}
+	b = append(b, byte(len(h.Name)))
+	b = append(b, h.Name...)
+	switch h.Version {
+	case keyfileVersion:
+		return append(b, h.flags())
+	case recoveryVersion:
+		b = append(b, h.flags())
+		b = append(b, h.RecoveryID...)
+		b = append(b, h.passwordWrapped...)
+		return append(b, h.recoveryWrapped...)
+	case recipientsVersion:
+	default:
+		return b
+	}
+	b = append(b, byte(len(h.Recipients)))
+	for i, r := range h.Recipients {
+		b = append(b, r.PublicKey[:]...)
+		b = append(b, byte(len(r.Name)))
+		b = append(b, r.Name...)
+		b = append(b, h.stanzas[i].ephemeral[:]...)
+		b = append(b, h.stanzas[i].wrapped...)
+	}
+	return b
+}
+
+// ReadHeader returns what a vault file says about how it was sealed, without
+// decrypting it. A file from before the header has a zero Header.
+func ReadHeader(data []byte) (Header, error) {
+	h, _, _, err := parseHeader(data)
+	return h, err
+}
+
+// parseHeader splits a vault file into its header, the bytes of the header to
+// authenticate, and what follows it. A file that does not begin with the magic
+// is returned whole, with a zero Header.
+func parseHeader(data []byte) (Header, []byte, []byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return Header{}, nil, data, nil
+	}
+	if len(data) < fixedHeaderLen {
+		return Header{}, nil, nil, errors.New("malformed header")
+	}
+	h := Header{
+		Version: data[4],
+		Params: Params{
+			KDF:        KDF(data[5]),
+			Iterations: binary.BigEndian.Uint32(data[6:10]),
+			MemoryKiB:  binary.BigEndian.Uint32(data[10:14]),
+			Threads:    data[14],
+		},
+	}
+	n := fixedHeaderLen
+	var aad []byte
+	switch h.Version {
+	case 1:
+		// No name, and nothing authenticated but the ciphertext.
+	case formatVersion, recipientsVersion, keyfileVersion, recoveryVersion:
+		if len(data) < n+1 || len(data) < n+1+int(data[n]) {
+			return Header{}, nil, nil, errors.New("malformed header")
+		}
+		h.Name = string(data[n+1 : n+1+int(data[n])])
+		n += 1 + len(h.Name)
+		if (h.Version == recipientsVersion) != (h.KDF == X25519) {
+			return Header{}, nil, nil, errors.New("malformed header")
+		}
+		switch h.Version {
+		case recipientsVersion:
+			var err error
+			if n, err = h.parseStanzas(data, n); err != nil {
+				return Header{}, nil, nil, err
+			}
+		case keyfileVersion, recoveryVersion:
+			if len(data) < n+1 {
+				return Header{}, nil, nil, errors.New("malformed header")
+			}
+			if err := h.setFlags(data[n]); err != nil {
+				return Header{}, nil, nil, err
+			}
+			n++
+			if h.Version == recoveryVersion {
+				if len(data) < n+RecoveryIDLen+2*wrappedLen {
+					return Header{}, nil, nil, errors.New("malformed header")
+				}
+				h.RecoveryID = data[n : n+RecoveryIDLen]
+				n += RecoveryIDLen
+				h.passwordWrapped = data[n : n+wrappedLen]
+				n += wrappedLen
+				h.recoveryWrapped = data[n : n+wrappedLen]
+				n += wrappedLen
+			}
+		}
+		aad = data[:n]
+	default:
+		return Header{}, nil, nil, fmt.Errorf("%w: format version %d", ErrUnsupportedFormat, h.Version)
+	}
+	if err := h.validate(); err != nil {
+		return Header{}, nil, nil, err
+	}
+	return h, aad, data[n:], nil
+}
+
+// parseStanzas reads a team vault's members and their stanzas from data,
+// beginning at n, and returns where they end.
+func (h *Header) parseStanzas(data []byte, n int) (int, error) {
+	malformed := errors.New("malformed header")
+	if len(data) < n+1 {
+		return 0, malformed
+	}
+	count := int(data[n])
+	n++
+	if count == 0 {
+		return 0, malformed
+	}
+	for range count {
+		var r Recipient
+		var s stanza
+		if len(data) < n+32+1 {
+			return 0, malformed
+		}
+		copy(r.PublicKey[:], data[n:n+32])
+		nameLen := int(data[n+32])
+		n += 32 + 1
+		if len(data) < n+nameLen+32+wrappedLen {
+			return 0, malformed
+		}
+		r.Name = string(data[n : n+nameLen])
+		n += nameLen
+		copy(s.ephemeral[:], data[n:n+32])
+		n += 32
+		s.wrapped = data[n : n+wrappedLen]
+		n += wrappedLen
+		h.Recipients = append(h.Recipients, r)
+		h.stanzas = append(h.stanzas, s)
+	}
+	return n, nil
+}
@@ -0,0 +1,284 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncryptWritesAHeaderNamingTheCurrentParams(t *testing.T) {
+	password := []byte("password")
+	salt, _ := Salt()
+
+	encrypted, err := Encrypt([]byte("data"), password, salt, "test")
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if !bytes.HasPrefix(encrypted, magic) {
+		t.Fatalf("expected the file to begin with the magic, got %x", encrypted[:len(magic)])
+	}
+	h, err := ReadHeader(encrypted)
+	if err != nil {
+		t.Fatalf("ReadHeader() failed: %v", err)
+	}
+	if h.Version != formatVersion || h.Params != CurrentParams || h.Name != "test" {
+		t.Errorf("header = %+v, want version %d with %s for vault test", h, formatVersion, CurrentParams)
+	}
+
+	decrypted, got, err := Decrypt(encrypted, password, salt, "test")
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	defer Wipe(decrypted)
+	if !reflect.DeepEqual(got, h) || !got.IsCurrent() {
+		t.Errorf("Decrypt() reported %+v, want the current %+v", got, h)
+	}
+}
+
+// The header is what Decrypt derives a key from, so every field has to come
+// back as it was written, PBKDF2's included: a vault can be written with any
+// KDF that mrs still reads.
+func TestHeaderRoundTrips(t *testing.T) {
+	for _, h := range []Header{
+		{Version: formatVersion, Params: CurrentParams, Name: "personal"},
+		{Version: formatVersion, Params: Params{KDF: PBKDF2SHA256, Iterations: CurrentIterations}, Name: "x"},
+		{Version: keyfileVersion, Params: Params{KDF: Argon2id, Iterations: 3, MemoryKiB: 64 * 1024, Threads: 4, Keyfile: true}, Name: "x"},
+		{Version: recoveryVersion, Params: CurrentParams, Name: "x", RecoveryID: []byte{1, 2, 3, 4},
+			passwordWrapped: bytes.Repeat([]byte{5}, wrappedLen), recoveryWrapped: bytes.Repeat([]byte{6}, wrappedLen)},
+		{Version: 1, Params: CurrentParams},
+	} {
+		got, _, rest, err := parseHeader(append(h.marshal(), "body"...))
+		if err != nil {
+			t.Fatalf("parseHeader(%+v) failed: %v", h, err)
+		}
+		if !reflect.DeepEqual(got, h) || string(rest) != "body" {
+			t.Errorf("parseHeader() = %+v, %q; want %+v, %q", got, rest, h, "body")
+		}
+	}
+}
+
+func TestAHeaderFromANewerVersionIsReportedAsSuch(t *testing.T) {
+	salt, _ := Salt()
+	for desc, h := range map[string]Header{
+		"format version": {Version: recoveryVersion + 1, Params: CurrentParams},
+		"KDF":            {Version: formatVersion, Params: Params{KDF: 99, Iterations: 1}},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			_, _, err := Decrypt(append(h.marshal(), make([]byte, 32)...), []byte("password"), salt, "test")
+			if !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+			}
+		})
+	}
+}
+
+// A header is read before anything is authenticated, so what it asks for is
+// bounded before any of it is spent.
+func TestAHeaderAskingForTooMuchIsRefused(t *testing.T) {
+	salt, _ := Salt()
+	for desc, p := range map[string]Params{
+		"PBKDF2 iterations":   {KDF: PBKDF2SHA256, Iterations: maxPBKDF2Iterations + 1},
+		"Argon2id iterations": {KDF: Argon2id, Iterations: maxArgon2Iterations + 1, MemoryKiB: 64, Threads: 1},
+		"Argon2id memory":     {KDF: Argon2id, Iterations: 1, MemoryKiB: maxArgon2MemoryKiB + 1, Threads: 1},
+		"no threads":          {KDF: Argon2id, Iterations: 1, MemoryKiB: 64},
+		"no iterations":       {KDF: PBKDF2SHA256},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			h := Header{Version: formatVersion, Params: p}
+			if _, _, err := Decrypt(append(h.marshal(), make([]byte, 32)...), []byte("password"), salt, "test"); err == nil {
+				t.Error("expected the header to be refused")
+			}
+		})
+	}
+}
+
+// A file opened as a vault it was not sealed as has been renamed or copied by
+// hand, which is reported as such rather than as a wrong password.
+func TestDecryptRefusesAFileSealedAsAnotherVault(t *testing.T) {
+	password := []byte("password")
+	salt, _ := Salt()
+	encrypted, err := Encrypt([]byte("data"), password, salt, "work")
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+
+	_, _, err = Decrypt(encrypted, password, salt, "personal")
+	if !errors.Is(err, ErrWrongName) {
+		t.Fatalf("expected ErrWrongName, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"work"`) {
+		t.Errorf("expected the error to name the vault the file was sealed as, got %q", err)
+	}
+}
+
+// The name is authenticated rather than only compared, so rewriting it in the
+// header is caught as tampering before the comparison is reached.
+func TestTheHeaderIsAuthenticated(t *testing.T) {
+	password := []byte("password")
+	salt, _ := Salt()
+	encrypted, _ := Encrypt([]byte("data"), password, salt, "work")
+
+	h, _ := ReadHeader(encrypted)
+	h.Name = "wore"
+	forged := append(h.marshal(), encrypted[len(h.marshal()):]...)
+	if _, _, err := Decrypt(forged, password, salt, "wore"); err == nil || errors.Is(err, ErrWrongName) {
+		t.Errorf("expected the forged header to fail authentication, got %v", err)
+	}
+}
+
+// Version 1 files carry no name and authenticate no header. They are read as
+// they were written, whatever vault they are opened as.
+func TestAVersion1FileIsStillRead(t *testing.T) {
+	password := []byte("password")
+	salt, _ := Salt()
+	h := Header{Version: 1, Params: Params{KDF: PBKDF2SHA256, Iterations: LegacyIterations}}
+	k, _ := h.key(password, nil, salt)
+	sealed, _ := seal([]byte("data"), k, nil)
+
+	decrypted, got, err := Decrypt(append(h.marshal(), sealed...), password, salt, "any")
+	if err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	if string(decrypted) != "data" || !reflect.DeepEqual(got, h) || got.IsCurrent() {
+		t.Errorf("Decrypt() = %q, %+v; want %q, %+v", decrypted, got, "data", h)
+	}
+}
+
+// A key that DecryptKey returns opens the file again, and seals one that the
+// password still opens, without deriving another.
+func TestAKeyOpensWhatItsPasswordOpens(t *testing.T) {
+	password := []byte("password")
+	salt, _ := Salt()
+	encrypted, _ := Encrypt([]byte("data"), password, salt, "work")
+
+	_, h, k, err := DecryptKey(encrypted, password, nil, salt, "work")
+	if err != nil {
+		t.Fatalf("DecryptKey() failed: %v", err)
+	}
+	if k == nil || k.Params != h.Params {
+		t.Fatalf("DecryptKey() returned key %+v, want one derived with %s", k, h.Params)
+	}
+	// The key as the agent would hand it back.
+	k, err = UnmarshalKey(k.Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalKey() failed: %v", err)
+	}
+	defer k.Wipe()
+
+	decrypted, _, err := DecryptWithKey(encrypted, k, "work")
+	if err != nil || string(decrypted) != "data" {
+		t.Fatalf("DecryptWithKey() = %q, %v; want %q", decrypted, err, "data")
+	}
+	if _, _, err := DecryptWithKey(encrypted, k, "personal"); !errors.Is(err, ErrWrongName) {
+		t.Errorf("expected ErrWrongName opening the file as another vault, got %v", err)
+	}
+	resealed, err := EncryptWithKey([]byte("more"), k, "work")
+	if err != nil {
+		t.Fatalf("EncryptWithKey() failed: %v", err)
+	}
+	decrypted, _, err = Decrypt(resealed, password, salt, "work")
+	if err != nil || string(decrypted) != "more" {
+		t.Errorf("Decrypt() = %q, %v; want %q", decrypted, err, "more")
+	}
+}
+
+// A key opens only a file that names the parameters it was derived with, and
+// one from another password opens nothing; neither is mistaken for a file it
+// was never the key to.
+func TestAKeyOpensNothingElse(t *testing.T) {
+	salt, _ := Salt()
+	encrypted, _ := Encrypt([]byte("data"), []byte("password"), salt, "work")
+	_, _, k, err := DecryptKey(encrypted, []byte("password"), nil, salt, "work")
+	if err != nil {
+		t.Fatalf("DecryptKey() failed: %v", err)
+	}
+	defer k.Wipe()
+
+	other, _ := Encrypt([]byte("data"), []byte("other"), salt, "work")
+	if _, _, err := DecryptWithKey(other, k, "work"); err == nil {
+		t.Error("expected the key to fail on a file sealed with another password")
+	}
+	pbkdf2 := Params{KDF: PBKDF2SHA256, Iterations: CurrentIterations}
+	wrongParams, _ := NewKey(pbkdf2, k.Marshal()[MarshalledKeyLen-32:])
+	if _, _, err := DecryptWithKey(encrypted, wrongParams, "work"); err == nil {
+		t.Error("expected a key derived with other parameters to fail")
+	}
+	if _, err := NewKey(CurrentParams, make([]byte, 16)); err == nil {
+		t.Error("expected NewKey() to refuse a key that is not 32 bytes")
+	}
+	if _, err := UnmarshalKey(k.Marshal()[1:]); err == nil {
+		t.Error("expected UnmarshalKey() to refuse a key cut short")
+	}
+	if _, err := NewKey(Params{KDF: 9}, make([]byte, 32)); err == nil {
+		t.Error("expected NewKey() to refuse parameters mrs does not know")
+	}
+
+	k.Wipe()
+	if !bytes.Equal(k.Marshal(), make([]byte, MarshalledKeyLen)) {
+		t.Errorf("expected Wipe() to zero the key, got %x", k.Marshal())
+	}
+}
+
+// A file sealed with a keyfile opens only with the password and the keyfile
+// together, and says so when the keyfile is left out rather than failing as
+// it would for a wrong password. Its key carries the keyfile with it, so a
+// file sealed with the key needs the keyfile too.
+func TestAKeyfileIsNeededAlongWithThePassword(t *testing.T) {
+	password := []byte("password")
+	keyfile, _ := KeyfileDigest(strings.NewReader("the contents of a keyfile"))
+	other, _ := KeyfileDigest(strings.NewReader("the contents of another"))
+	salt, _ := Salt()
+	encrypted, err := EncryptWithKeyfile([]byte("data"), password, keyfile, salt, "work")
+	if err != nil {
+		t.Fatalf("EncryptWithKeyfile() failed: %v", err)
+	}
+
+	if _, _, err := Decrypt(encrypted, password, salt, "work"); !errors.Is(err, ErrNeedsKeyfile) {
+		t.Errorf("expected ErrNeedsKeyfile without the keyfile, got %v", err)
+	}
+	if _, _, _, err := DecryptKey(encrypted, password, other, salt, "work"); err == nil || errors.Is(err, ErrNeedsKeyfile) {
+		t.Errorf("expected another keyfile to fail as a wrong password does, got %v", err)
+	}
+	decrypted, h, k, err := DecryptKey(encrypted, password, keyfile, salt, "work")
+	if err != nil || string(decrypted) != "data" {
+		t.Fatalf("DecryptKey() = %q, %v; want %q", decrypted, err, "data")
+	}
+	if !h.Keyfile || h.Version != keyfileVersion || !h.IsCurrent() {
+		t.Errorf("DecryptKey() reported %+v, want a current header with a keyfile", h)
+	}
+	k, err = UnmarshalKey(k.Marshal())
+	if err != nil || !k.Keyfile {
+		t.Fatalf("UnmarshalKey() = %+v, %v; want a key with a keyfile", k, err)
+	}
+	defer k.Wipe()
+	resealed, err := EncryptWithKey([]byte("more"), k, "work")
+	if err != nil {
+		t.Fatalf("EncryptWithKey() failed: %v", err)
+	}
+	if _, _, err := Decrypt(resealed, password, salt, "work"); !errors.Is(err, ErrNeedsKeyfile) {
+		t.Errorf("expected a file sealed with the key to need the keyfile too, got %v", err)
+	}
+
+	// A keyfile given for a file sealed without one is not needed, and so
+	// not used.
+	plain, _ := Encrypt([]byte("data"), password, salt, "work")
+	if decrypted, h, _, err := DecryptKey(plain, password, keyfile, salt, "work"); err != nil || string(decrypted) != "data" || h.Keyfile {
+		t.Errorf("DecryptKey() = %q, %+v, %v; want %q without a keyfile", decrypted, h, err, "data")
+	}
+	if _, err := KeyfileDigest(strings.NewReader("")); err == nil {
+		t.Error("expected an empty keyfile to be refused")
+	}
+}
+
+// Flags this version does not know were set by a newer one, which gave them a
+// meaning that reading the file without them would ignore.
+func TestAnUnknownFlagIsReportedAsANewerFormat(t *testing.T) {
+	h := Header{Version: keyfileVersion, Params: CurrentParams, Name: "test"}
+	b := h.marshal()
+	b[len(b)-1] = 0x80
+	if _, err := ReadHeader(b); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
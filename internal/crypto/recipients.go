@@ -0,0 +1,306 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// A team vault is not sealed under a key derived from a password. Its body is
+// sealed under a random key, and its header carries that key wrapped once for
+// each member, as age does: a stanza per member holds an ephemeral X25519
+// public key, and the vault's key sealed under a key derived from what that
+// ephemeral key agrees with the member's. Each stanza names the member's
+// public key too, so that `vault member list` needs no identity, and so that
+// a member's identity finds its own stanza rather than trying every one.
+
+// ErrNeedsIdentity reports a team vault opened with a password, which no
+// password opens.
+var ErrNeedsIdentity = errors.New("is a team vault, which opens with a member's identity rather than a password")
+
+// ErrNotMember reports a team vault that the identity it was opened with is
+// not a member of.
+var ErrNotMember = errors.New("is a team vault that this identity is not a member of")
+
+// publicKeyPrefix begins a public key written as text.
+const publicKeyPrefix = "mrs1"
+
+var publicKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// wrapInfo is the HKDF info that a stanza's wrapping key is derived with, so
+// that it is never the same key as anything else derived from the same
+// agreement.
+const wrapInfo = "mrs team vault stanza"
+
+// PublicKey is a member's X25519 public key.
+type PublicKey [32]byte
+
+// String returns the public key as `mrs vault member add` takes it.
+func (p PublicKey) String() string {
+	return publicKeyPrefix + publicKeyEncoding.EncodeToString(p[:])
+}
+
+// ParsePublicKey reads a public key that String wrote.
+func ParsePublicKey(s string) (PublicKey, error) {
+	var p PublicKey
+	rest, ok := strings.CutPrefix(s, publicKeyPrefix)
+	if !ok {
+		return p, fmt.Errorf("%q is not a public key, which begins %q", s, publicKeyPrefix)
+	}
+	b, err := publicKeyEncoding.DecodeString(rest)
+	if err != nil || len(b) != len(p) {
+		return p, fmt.Errorf("%q is not a public key: it does not decode to %d bytes", s, len(p))
+	}
+	copy(p[:], b)
+	return p, nil
+}
+
+// Recipient is a member of a team vault: their public key, and the name they
+// go by, which is sealed into the header with it but only ever shown.
+type Recipient struct {
+	PublicKey
+	Name string
+}
+
+// maxRecipients is what the header's one-byte count of stanzas can hold.
+const maxRecipients = 255
+
+// ParseRecipient reads a recipient as String writes it: a public key, then
+// optionally a space and a name.
+func ParseRecipient(s string) (Recipient, error) {
+	key, name, _ := strings.Cut(strings.TrimSpace(s), " ")
+	p, err := ParsePublicKey(key)
+	if err != nil {
+		return Recipient{}, err
+	}
+	r := Recipient{PublicKey: p, Name: strings.TrimSpace(name)}
+	if err := r.validate(); err != nil {
+		return Recipient{}, err
+	}
+	return r, nil
+}
+
+func (r Recipient) validate() error {
+	if len(r.Name) > maxNameLen {
+		return fmt.Errorf("a member's name must be at most %d bytes, but is %d", maxNameLen, len(r.Name))
+	}
+	if strings.ContainsFunc(r.Name, unicode.IsControl) {
+		return fmt.Errorf("a member's name cannot contain control characters, but %q does", r.Name)
+	}
+	return nil
+}
+
+// String returns the recipient as ParseRecipient reads it.
+func (r Recipient) String() string {
+	if r.Name == "" {
+		return r.PublicKey.String()
+	}
+	return r.PublicKey.String() + " " + r.Name
+}
+
+// stanza is a team vault's key, wrapped for one of its members.
+type stanza struct {
+	ephemeral PublicKey
+	// wrapped is the key as seal writes it: nonce|ciphertext|tag.
+	wrapped []byte
+}
+
+// wrappedLen is the length of a key that seal wrapped.
+const wrappedLen = 12 + 32 + 16
+
+// Identity is a member's X25519 private key, from which their public key
+// follows.
+type Identity struct {
+	private [32]byte
+	public  PublicKey
+}
+
+// GenerateIdentity returns a new identity. The caller is responsible for
+// wiping it.
+func GenerateIdentity() (*Identity, error) {
+	var private [32]byte
+	if _, err := io.ReadFull(rand.Reader, private[:]); err != nil {
+		return nil, err
+	}
+	defer Wipe(private[:])
+	return UnmarshalIdentity(private[:])
+}
+
+// UnmarshalIdentity returns the identity whose private key Marshal wrote into
+// b. The caller still owns b, and is responsible for wiping the Identity.
+func UnmarshalIdentity(b []byte) (*Identity, error) {
+	if len(b) != 32 {
+		return nil, fmt.Errorf("an identity is 32 bytes, but was %d", len(b))
+	}
+	id := &Identity{}
+	copy(id.private[:], b)
+	public, err := curve25519.X25519(id.private[:], curve25519.Basepoint)
+	if err != nil {
+		id.Wipe()
+		return nil, err
+	}
+	copy(id.public[:], public)
+	return id, nil
+}
+
+// Marshal returns the identity's private key, to be sealed where it is kept.
+// The caller is responsible for wiping it.
+func (id *Identity) Marshal() []byte {
+	return append([]byte{}, id.private[:]...)
+}
+
+// PublicKey returns the public key that a vault is sealed to for this
+// identity to open it.
+func (id *Identity) PublicKey() PublicKey {
+	return id.public
+}
+
+// Wipe zeroes the identity, leaving one that opens nothing.
+func (id *Identity) Wipe() {
+	Wipe(id.private[:])
+	id.public = PublicKey{}
+}
+
+// NewTeamKey returns a new random key for a team vault. The caller is
+// responsible for wiping it.
+func NewTeamKey() (*Key, error) {
+	k := &Key{Params: Params{KDF: X25519}}
+	if _, err := io.ReadFull(rand.Reader, k.k[:]); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// wrapKey derives the key that a stanza's ephemeral key and a member's agree
+// on, bound to both public keys.
+func wrapKey(shared []byte, ephemeral, member PublicKey) (*[32]byte, error) {
+	salt := append(append(make([]byte, 0, 64), ephemeral[:]...), member[:]...)
+	k, err := hkdf.Key(sha256.New, shared, salt, wrapInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	var arr [32]byte
+	copy(arr[:], k)
+	Wipe(k)
+	return &arr, nil
+}
+
+// wrap wraps the key k for the member whose public key is to.
+func wrap(k *Key, to PublicKey) (stanza, error) {
+	e, err := GenerateIdentity()
+	if err != nil {
+		return stanza{}, err
+	}
+	defer e.Wipe()
+	shared, err := curve25519.X25519(e.private[:], to[:])
+	if err != nil {
+		return stanza{}, fmt.Errorf("cannot seal to %s: %w", to, err)
+	}
+	defer Wipe(shared)
+	wk, err := wrapKey(shared, e.public, to)
+	if err != nil {
+		return stanza{}, err
+	}
+	defer Wipe(wk[:])
+	wrapped, err := seal(k.k[:], wk, nil)
+	if err != nil {
+		return stanza{}, err
+	}
+	return stanza{ephemeral: e.public, wrapped: wrapped}, nil
+}
+
+// unwrap returns the key that s wraps for id.
+func (s stanza) unwrap(id *Identity) (*Key, error) {
+	shared, err := curve25519.X25519(id.private[:], s.ephemeral[:])
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(shared)
+	wk, err := wrapKey(shared, s.ephemeral, id.public)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(wk[:])
+	b, err := open(s.wrapped, wk, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(b)
+	return NewKey(Params{KDF: X25519}, b)
+}
+
+// EncryptToRecipients is EncryptWithKey for a team vault: the file is sealed
+// under k, which NewTeamKey or DecryptWithIdentity returned, and headed by k
+// wrapped for each of the recipients.
+func EncryptToRecipients(data []byte, k *Key, name string, recipients []Recipient) ([]byte, error) {
+	if k.KDF != X25519 {
+		return nil, errors.New("a team vault is sealed under a key of its own, not one derived from a password")
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("a team vault must have at least one member")
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("a team vault can have at most %d members, but would have %d", maxRecipients, len(recipients))
+	}
+	if len(name) > maxNameLen {
+		return nil, fmt.Errorf("vault name must be at most %d bytes to be sealed, but is %d", maxNameLen, len(name))
+	}
+	h := Header{Version: recipientsVersion, Params: k.Params, Name: name, Recipients: recipients}
+	for _, r := range recipients {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		s, err := wrap(k, r.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		h.stanzas = append(h.stanzas, s)
+	}
+	header := h.marshal()
+	sealed, err := seal(data, &k.k, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// DecryptWithIdentity is DecryptKey for a team vault, which id opens if it is
+// one of the vault's members. The key it returns opens the file again, as
+// DecryptWithKey, and seals it again, as EncryptToRecipients.
+func DecryptWithIdentity(data []byte, id *Identity, name string) ([]byte, Header, *Key, error) {
+	h, aad, body, err := parseHeader(data)
+	if err != nil {
+		return nil, Header{}, nil, err
+	}
+	if h.KDF != X25519 {
+		return nil, Header{}, nil, errors.New("is not a team vault, and opens with its password rather than an identity")
+	}
+	i := -1
+	for j, r := range h.Recipients {
+		if r.PublicKey == id.public {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return nil, Header{}, nil, ErrNotMember
+	}
+	key, err := h.stanzas[i].unwrap(id)
+	if err != nil {
+		return nil, Header{}, nil, err
+	}
+	plaintext, err := openHeaded(h, aad, body, &key.k, name)
+	if err != nil {
+		key.Wipe()
+		return nil, Header{}, nil, err
+	}
+	return plaintext, h, key, nil
+}
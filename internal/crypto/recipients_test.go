@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newIdentity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("GenerateIdentity() failed: %v", err)
+	}
+	t.Cleanup(id.Wipe)
+	return id
+}
+
+// Every member opens a team vault with their own identity, and the key each
+// gets back seals and opens it as the one it was sealed under.
+func TestEveryMemberOpensATeamVault(t *testing.T) {
+	alice, bob := newIdentity(t), newIdentity(t)
+	members := []Recipient{{alice.PublicKey(), "alice"}, {bob.PublicKey(), "bob"}}
+	k, err := NewTeamKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := EncryptToRecipients([]byte("data"), k, "team", members)
+	if err != nil {
+		t.Fatalf("EncryptToRecipients() failed: %v", err)
+	}
+	for _, id := range []*Identity{alice, bob} {
+		plaintext, h, got, err := DecryptWithIdentity(sealed, id, "team")
+		if err != nil {
+			t.Fatalf("DecryptWithIdentity() failed: %v", err)
+		}
+		if string(plaintext) != "data" || !h.IsTeam() || !h.IsCurrent() || len(h.Recipients) != 2 || h.Recipients[1] != members[1] {
+			t.Errorf("DecryptWithIdentity() = %q, %+v", plaintext, h)
+		}
+		if *got != *k {
+			t.Error("expected the key the vault was sealed under")
+		}
+		if again, _, err := DecryptWithKey(sealed, got, "team"); err != nil || string(again) != "data" {
+			t.Errorf("DecryptWithKey() = %q, %v", again, err)
+		}
+	}
+}
+
+func TestAnIdentityThatIsNotAMemberOpensNothing(t *testing.T) {
+	alice, mallory := newIdentity(t), newIdentity(t)
+	k, _ := NewTeamKey()
+	sealed, err := EncryptToRecipients([]byte("data"), k, "team", []Recipient{{PublicKey: alice.PublicKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := DecryptWithIdentity(sealed, mallory, "team"); !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+	salt, _ := Salt()
+	if _, _, err := Decrypt(sealed, []byte("password"), salt, "team"); !errors.Is(err, ErrNeedsIdentity) {
+		t.Errorf("expected a password to be refused with ErrNeedsIdentity, got %v", err)
+	}
+}
+
+// The members are authenticated with the rest of the header, so that a name
+// or a stanza cannot be swapped in by anyone who does not hold the key.
+func TestATeamVaultsMembersCannotBeAltered(t *testing.T) {
+	alice := newIdentity(t)
+	k, _ := NewTeamKey()
+	sealed, err := EncryptToRecipients([]byte("data"), k, "team", []Recipient{{alice.PublicKey(), "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(sealed, []byte("alice"), []byte("alicf"), 1)
+	if _, _, _, err := DecryptWithIdentity(tampered, alice, "team"); err == nil {
+		t.Error("expected a renamed member to fail to open")
+	}
+}
+
+func TestARecipientRoundTripsAsText(t *testing.T) {
+	id := newIdentity(t)
+	r := Recipient{id.PublicKey(), "alice@laptop"}
+	got, err := ParseRecipient(r.String() + "\n")
+	if err != nil || got != r {
+		t.Errorf("ParseRecipient(%q) = %+v, %v", r, got, err)
+	}
+	for _, s := range []string{"", "age1abc", "mrs1abc", r.PublicKey.String() + " a\x07b"} {
+		if _, err := ParseRecipient(s); err == nil {
+			t.Errorf("expected %q to be refused", s)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A vault that a recovery key opens is sealed under a random data key, which
+// its header carries twice: wrapped under the key its password derives, and
+// under the recovery key. The recovery key itself is never written anywhere
+// by mrs: it is split into shares, which are printed, and any of its holders
+// who come together unwrap the data key with what the shares combine into.
+
+// RecoveryKeyLen is the length of a recovery key.
+const RecoveryKeyLen = 32
+
+// RecoveryIDLen is the length of the random id that names one split of a
+// recovery key, which a vault's header and each of the shares carry.
+const RecoveryIDLen = 4
+
+// ErrNoRecovery reports a vault that no recovery key opens.
+var ErrNoRecovery = errors.New("has no recovery key")
+
+// Recovery is what a vault that a recovery key opens carries from one save to
+// the next: the data key its body is sealed under, and that key wrapped under
+// the recovery key, which cannot be wrapped again without it.
+type Recovery struct {
+	id      []byte
+	data    [32]byte
+	wrapped []byte
+}
+
+// NewRecovery returns a new data key and a new recovery key, with the one
+// wrapped under the other, for EncryptWithRecovery to seal a vault with. The
+// caller is responsible for wiping both.
+func NewRecovery() (*Recovery, []byte, error) {
+	r := &Recovery{id: make([]byte, RecoveryIDLen)}
+	if _, err := io.ReadFull(rand.Reader, r.id); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, r.data[:]); err != nil {
+		return nil, nil, err
+	}
+	var rk [RecoveryKeyLen]byte
+	defer Wipe(rk[:])
+	if _, err := io.ReadFull(rand.Reader, rk[:]); err != nil {
+		r.Wipe()
+		return nil, nil, err
+	}
+	// Sealed with its id, so that the id cannot be changed to pass one split's
+	// shares off as another's.
+	wrapped, err := seal(r.data[:], &rk, r.id)
+	if err != nil {
+		r.Wipe()
+		return nil, nil, err
+	}
+	r.wrapped = wrapped
+	return r, append([]byte{}, rk[:]...), nil
+}
+
+// ID returns the id of the split of the recovery key that r was made with.
+func (r *Recovery) ID() []byte {
+	return r.id
+}
+
+// Wipe zeroes the data key, leaving a Recovery that opens nothing.
+func (r *Recovery) Wipe() {
+	Wipe(r.data[:])
+}
+
+// Recovery returns what opened the body of a vault that a recovery key opens,
+// for EncryptWithRecovery to seal it with again, or nil for any other vault
+// and for a header that was only read. The caller is responsible for wiping
+// it.
+func (h Header) Recovery() *Recovery {
+	return h.recovery
+}
+
+// EncryptWithRecovery is EncryptWithKey for a vault that a recovery key opens
+// as well as the password that k was derived from: the file is sealed under
+// r's data key, which its header carries wrapped under k and under the
+// recovery key. A nil r seals the file as EncryptWithKey does.
+func EncryptWithRecovery(data []byte, k *Key, r *Recovery, name string) ([]byte, error) {
+	if r == nil {
+		return EncryptWithKey(data, k, name)
+	}
+	if k.KDF == X25519 {
+		return nil, errors.New("a team vault is opened by its members, not by a recovery key")
+	}
+	if len(name) > maxNameLen {
+		return nil, fmt.Errorf("vault name must be at most %d bytes to be sealed, but is %d", maxNameLen, len(name))
+	}
+	h := Header{Version: recoveryVersion, Params: k.Params, Name: name, RecoveryID: r.id, recoveryWrapped: r.wrapped}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(r.data[:], &k.k, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.passwordWrapped = wrapped
+	header := h.marshal()
+	sealed, err := seal(data, &r.data, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// DecryptWithRecovery is Decrypt for a vault that a recovery key opens, with
+// that key in place of the password. The header it returns holds the data key
+// it unwrapped, for EncryptWithRecovery to seal the file with again under a
+// new password. The caller still owns recoveryKey.
+func DecryptWithRecovery(data, recoveryKey []byte, name string) ([]byte, Header, error) {
+	h, aad, body, err := parseHeader(data)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	if h.Version != recoveryVersion {
+		return nil, Header{}, ErrNoRecovery
+	}
+	if len(recoveryKey) != RecoveryKeyLen {
+		return nil, Header{}, fmt.Errorf("a recovery key is %d bytes, but was %d", RecoveryKeyLen, len(recoveryKey))
+	}
+	var rk [RecoveryKeyLen]byte
+	copy(rk[:], recoveryKey)
+	defer Wipe(rk[:])
+	return openRecovered(h, aad, body, h.recoveryWrapped, &rk, h.RecoveryID, name)
+}
+
+// openRecovered opens a version 5 file's body under the data key that k
+// unwraps from wrapped, sealed with wrappedAAD, and returns the header with
+// what it took to open it.
+func openRecovered(h Header, aad, body, wrapped []byte, k *[32]byte, wrappedAAD []byte, name string) ([]byte, Header, error) {
+	data, err := open(wrapped, k, wrappedAAD)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	defer Wipe(data)
+	if len(data) != 32 {
+		return nil, Header{}, errors.New("malformed header")
+	}
+	r := &Recovery{id: append([]byte{}, h.RecoveryID...), wrapped: append([]byte{}, h.recoveryWrapped...)}
+	copy(r.data[:], data)
+	plaintext, err := openHeaded(h, aad, body, &r.data, name)
+	if err != nil {
+		r.Wipe()
+		return nil, Header{}, err
+	}
+	h.recovery = r
+	return plaintext, h, nil
+}
@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// A recovery key opens a vault through any change of password, since only the
+// password's copy of the data key is wrapped again, and the vault's password
+// opens it as any other vault's does.
+func TestARecoveryKeyOpensAVaultThroughAChangeOfPassword(t *testing.T) {
+	salt, _ := Salt()
+	r, rk, err := NewRecovery()
+	if err != nil {
+		t.Fatalf("NewRecovery() failed: %v", err)
+	}
+	defer r.Wipe()
+	k, err := DeriveKey([]byte("a password"), nil, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := EncryptWithRecovery([]byte("data"), k, r, "test")
+	if err != nil {
+		t.Fatalf("EncryptWithRecovery() failed: %v", err)
+	}
+	plaintext, h, k2, err := DecryptKey(sealed, []byte("a password"), nil, salt, "test")
+	if err != nil || string(plaintext) != "data" || !h.IsCurrent() || !bytes.Equal(h.RecoveryID, r.ID()) {
+		t.Fatalf("DecryptKey() = %q, %+v, %v", plaintext, h, err)
+	}
+	if *k2 != *k {
+		t.Error("expected the key the password derives, for the agent to hold")
+	}
+
+	// A new password, with what opening the vault with the old one gave.
+	k3, err := DeriveKey([]byte("a new password"), nil, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resealed, err := EncryptWithRecovery(plaintext, k3, h.Recovery(), "test")
+	if err != nil {
+		t.Fatalf("EncryptWithRecovery() failed: %v", err)
+	}
+	if _, _, err := Decrypt(resealed, []byte("a password"), salt, "test"); err == nil {
+		t.Error("expected the old password to open nothing")
+	}
+	plaintext, h, err = DecryptWithRecovery(resealed, rk, "test")
+	if err != nil || string(plaintext) != "data" || h.Recovery() == nil {
+		t.Fatalf("DecryptWithRecovery() = %q, %+v, %v", plaintext, h, err)
+	}
+
+	rk[0] ^= 1
+	if _, _, err := DecryptWithRecovery(resealed, rk, "test"); err == nil {
+		t.Error("expected another recovery key to open nothing")
+	}
+	plain, err := Encrypt([]byte("data"), []byte("a password"), salt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecryptWithRecovery(plain, rk, "test"); !errors.Is(err, ErrNoRecovery) {
+		t.Errorf("expected ErrNoRecovery for a vault without a recovery key, got %v", err)
+	}
+}
@@ -44,7 +44,10 @@ func WriteTempFile(content []byte) (string, error) {
 	return f.Name(), nil
 }
 
-// CopyFile copies a file from source to destination
+// CopyFile copies a file from source to destination. The temporary file it
+// writes through is named after src rather than dst, so that copying a file to
+// a longer name, as a vault is to its backup, does not need a longer name
+// still.
 func CopyFile(src, dst string) error {
 	input, err := os.ReadFile(src)
 	if err != nil {
@@ -53,7 +56,7 @@ func CopyFile(src, dst string) error {
 
 	// The copied content is durable even if only the parent directory could not
 	// be synced, so treat ErrDirSync as success.
-	if err := WriteFileAtomic(dst, input, 0600); err != nil && !errors.Is(err, ErrDirSync) {
+	if err := WriteFileAtomicVia(dst, filepath.Base(src), input, 0600); err != nil && !errors.Is(err, ErrDirSync) {
 		return err
 	}
 	return nil
@@ -68,7 +71,16 @@ func CopyFile(src, dst string) error {
 // that the rename survives power loss; if only that sync fails, the file is
 // durably written and the returned error wraps ErrDirSync so callers can treat
 // it as a warning rather than a failed write.
-func WriteFileAtomic(p string, data []byte, defaultPerm os.FileMode) (err error) {
+func WriteFileAtomic(p string, data []byte, defaultPerm os.FileMode) error {
+	return WriteFileAtomicVia(p, "", data, defaultPerm)
+}
+
+// WriteFileAtomicVia is WriteFileAtomic, with the temporary file named
+// <tempBase>.<random>.tmp rather than after p. A file whose name is close to
+// the 255 byte limit has no room for the suffix, and can be written through a
+// temporary file named after a shorter neighbour. An empty tempBase names it
+// after p.
+func WriteFileAtomicVia(p, tempBase string, data []byte, defaultPerm os.FileMode) (err error) {
 	// Resolve symlinks so that the rename replaces the target, not the link.
 	if target, evalErr := filepath.EvalSymlinks(p); evalErr == nil {
 		p = target
@@ -82,7 +94,10 @@ func WriteFileAtomic(p string, data []byte, defaultPerm os.FileMode) (err error)
 		perm = fi.Mode().Perm() &^ 0077
 	}
 
-	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if tempBase == "" {
+		tempBase = filepath.Base(p)
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), tempBase+".*.tmp")
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,7 @@ package fs
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -160,3 +161,21 @@ func TestCopyFile(t *testing.T) {
 		t.Errorf("CopyFile() content = %v, expected %v", string(got), string(content))
 	}
 }
+
+// A backup's name is its vault's and a suffix, so a vault whose name is near
+// the limit has a backup with no room for a temporary file named after it.
+func TestCopyFileToANameAtTheLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, strings.Repeat("a", 233))
+	dst := src + ".20261017T091244Z.bak"
+	if err := os.WriteFile(src, []byte("copy me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatalf("CopyFile() error = %v", err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "copy me" {
+		t.Errorf("CopyFile() content = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,212 @@
+// Package generate makes new secrets: passwords of random characters, and
+// passphrases of random words from the EFF's large wordlist.
+//
+// Every choice is drawn from crypto/rand, as crypto.Salt draws a salt, and
+// made without the bias that reducing a random number modulo the number of
+// choices would give the first few. What is generated is returned in a byte
+// slice the caller can wipe, and no string copy of it is made on the way.
+package generate
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// Class is a set of characters that a password draws from.
+type Class string
+
+// The classes a password can draw from. Symbols are every printable ASCII
+// character that is not a letter, a digit or a space.
+const (
+	Lower  Class = "lower"
+	Upper  Class = "upper"
+	Digit  Class = "digit"
+	Symbol Class = "symbol"
+)
+
+// Classes are the classes in the order they are listed to the user, and the
+// ones a password draws from when it is not told otherwise.
+var Classes = []Class{Lower, Upper, Digit, Symbol}
+
+var classChars = map[Class]string{
+	Lower:  "abcdefghijklmnopqrstuvwxyz",
+	Upper:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	Digit:  "0123456789",
+	Symbol: "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+// Ambiguous are the characters that are easily read as one another, which
+// matters for a password that is read off a screen and typed elsewhere.
+const Ambiguous = "0Oo1Il|"
+
+const (
+	// DefaultLength is the length of a password when it is not given, which
+	// from all four classes is more than 150 bits.
+	DefaultLength = 24
+	// MaxLength bounds a password. Nothing needs more, and a typo of a few
+	// extra digits should not be a wait.
+	MaxLength = 4096
+	// DefaultWords is the number of words in a passphrase when it is not
+	// given, at nearly 13 bits a word.
+	DefaultWords = 6
+	// MaxWords bounds a passphrase, as MaxLength bounds a password.
+	MaxWords = 512
+)
+
+// ParseClasses parses a comma-separated list of classes, such as
+// "lower,digit", ignoring spaces around each one and any listed twice.
+func ParseClasses(s string) ([]Class, error) {
+	var classes []Class
+	for name := range strings.SplitSeq(s, ",") {
+		c := Class(strings.TrimSpace(name))
+		if _, ok := classChars[c]; !ok {
+			return nil, fmt.Errorf("unknown character class %q; the classes are %s", c, classList())
+		}
+		if !slices.Contains(classes, c) {
+			classes = append(classes, c)
+		}
+	}
+	return classes, nil
+}
+
+func classList() string {
+	names := make([]string, len(Classes))
+	for i, c := range Classes {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Password returns length characters drawn from classes, with at least one
+// from each, so a site that demands a digit and a symbol accepts it. With
+// noAmbiguous it leaves out the characters in Ambiguous. The caller is
+// responsible for wiping it.
+func Password(length int, classes []Class, noAmbiguous bool) ([]byte, error) {
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("a password needs at least one character class")
+	}
+	if length < 1 || length > MaxLength {
+		return nil, fmt.Errorf("a password must be from 1 to %d characters long, but was %d", MaxLength, length)
+	}
+	if length < len(classes) {
+		return nil, fmt.Errorf("a password has a character from each of its %d classes, so it cannot be %d characters long", len(classes), length)
+	}
+	sets := make([]string, len(classes))
+	var all strings.Builder
+	for i, c := range classes {
+		set := classChars[c]
+		if noAmbiguous {
+			set = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(Ambiguous, r) {
+					return -1
+				}
+				return r
+			}, set)
+		}
+		sets[i] = set
+		all.WriteString(set)
+	}
+	chars := all.String()
+
+	out := make([]byte, length)
+	// A password missing a class is drawn again whole, rather than have one
+	// of its characters replaced, which would make the replaced positions
+	// more likely to hold the rarer classes than any other.
+	for {
+		for i := range out {
+			n, err := uniform(len(chars))
+			if err != nil {
+				crypto.Wipe(out)
+				return nil, err
+			}
+			out[i] = chars[n]
+		}
+		if hasEvery(out, sets) {
+			return out, nil
+		}
+	}
+}
+
+func hasEvery(b []byte, sets []string) bool {
+	for _, set := range sets {
+		found := false
+		for _, c := range b {
+			if strings.IndexByte(set, c) >= 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//go:embed eff_large_wordlist.txt
+var effLargeWordlist string
+
+// wordlist is the EFF's large wordlist, without the dice rolls that number its
+// lines.
+var wordlist = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(effLargeWordlist), "\n")
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		_, word, _ := strings.Cut(line, "\t")
+		words[i] = word
+	}
+	return words
+})
+
+// Passphrase returns words words from the EFF's large wordlist, joined by
+// separator. The caller is responsible for wiping it.
+func Passphrase(words int, separator string) ([]byte, error) {
+	if words < 1 || words > MaxWords {
+		return nil, fmt.Errorf("a passphrase must be from 1 to %d words long, but was %d", MaxWords, words)
+	}
+	list := wordlist()
+	chosen := make([]int, words)
+	defer clear(chosen)
+	size := (words - 1) * len(separator)
+	for i := range chosen {
+		n, err := uniform(len(list))
+		if err != nil {
+			return nil, err
+		}
+		chosen[i] = n
+		size += len(list[n])
+	}
+	out := make([]byte, 0, size)
+	for i, n := range chosen {
+		if i > 0 {
+			out = append(out, separator...)
+		}
+		out = append(out, list[n]...)
+	}
+	return out, nil
+}
+
+// uniform returns a random number from 0 to n-1, every one as likely as the
+// next. A draw above the largest multiple of n is thrown away and drawn
+// again, since reducing it would favour the smallest numbers.
+func uniform(n int) (int, error) {
+	var b [4]byte
+	defer crypto.Wipe(b[:])
+	limit := (1 << 32) - (1<<32)%uint64(n)
+	for {
+		if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+			return 0, err
+		}
+		if v := uint64(binary.BigEndian.Uint32(b[:])); v < limit {
+			return int(v % uint64(n)), nil
+		}
+	}
+}
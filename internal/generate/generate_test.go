@@ -0,0 +1,116 @@
+package generate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHasEveryClass(t *testing.T) {
+	// The shortest password with every class is where one is most often
+	// missing, and so where drawing it again has to work.
+	for range 100 {
+		p, err := Password(len(Classes), Classes, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range Classes {
+			if !strings.ContainsAny(string(p), classChars[c]) {
+				t.Fatalf("%q has no %s character", p, c)
+			}
+		}
+	}
+}
+
+func TestPasswordDrawsOnlyFromItsClasses(t *testing.T) {
+	p, err := Password(1000, []Class{Digit, Upper}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1000 {
+		t.Fatalf("expected 1000 characters, got %d", len(p))
+	}
+	for _, c := range p {
+		if !strings.ContainsRune(classChars[Digit]+classChars[Upper], rune(c)) {
+			t.Fatalf("%q is neither a digit nor an uppercase letter", c)
+		}
+		if strings.ContainsRune(Ambiguous, rune(c)) {
+			t.Fatalf("%q is ambiguous", c)
+		}
+	}
+}
+
+func TestPasswordRefusesALengthItCannotMake(t *testing.T) {
+	for _, length := range []int{0, -1, 3, MaxLength + 1} {
+		if _, err := Password(length, Classes, false); err == nil {
+			t.Errorf("expected a password of %d characters to be refused", length)
+		}
+	}
+	if _, err := Password(10, nil, false); err == nil {
+		t.Error("expected a password without classes to be refused")
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	classes, err := ParseClasses(" digit, lower ,digit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 2 || classes[0] != Digit || classes[1] != Lower {
+		t.Fatalf("expected digit and lower, got %v", classes)
+	}
+	for _, s := range []string{"", "lower,", "letters"} {
+		if _, err := ParseClasses(s); err == nil {
+			t.Errorf("expected %q to be refused", s)
+		}
+	}
+}
+
+func TestTheWordlistIsTheEFFsLargeOne(t *testing.T) {
+	words := wordlist()
+	if len(words) != 6*6*6*6*6 {
+		t.Fatalf("expected a word for each roll of five dice, got %d", len(words))
+	}
+	if words[0] != "abacus" || words[len(words)-1] != "zoom" {
+		t.Fatalf("expected abacus to zoom, got %s to %s", words[0], words[len(words)-1])
+	}
+	seen := map[string]bool{}
+	for _, w := range words {
+		if w == "" || seen[w] {
+			t.Fatalf("%q is blank or listed twice", w)
+		}
+		seen[w] = true
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	p, err := Passphrase(8, "::")
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Split(string(p), "::")
+	if len(words) != 8 {
+		t.Fatalf("expected 8 words, got %q", p)
+	}
+	if cap(p) != len(p) {
+		t.Errorf("expected the passphrase to be sized once, got a capacity of %d for %d bytes", cap(p), len(p))
+	}
+	if _, err := Passphrase(0, "-"); err == nil {
+		t.Error("expected a passphrase of no words to be refused")
+	}
+}
+
+func TestUniformReachesEveryNumber(t *testing.T) {
+	seen := make([]bool, 7)
+	for range 1000 {
+		n, err := uniform(len(seen))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[n] = true
+	}
+	for n, ok := range seen {
+		if !ok {
+			t.Errorf("%d was never drawn", n)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package identity keeps the user's X25519 identity, which opens the team
+// vaults they are a member of, in $MRS_HOME.
+//
+// The private key is in "identity", sealed under a password the way a vault
+// is, behind the salt it is sealed with. The public key is in "identity.pub",
+// in the clear, as `mrs vault member add` takes it, so that it can be handed
+// to whoever adds the user to a vault without the password being asked for.
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+)
+
+// sealedName is the name that an identity is sealed as, so that a vault file
+// copied over it, or it over one, does not open as the other.
+const sealedName = "identity"
+
+// saltLen is the length of the salt that crypto.Salt returns, which begins
+// the identity's file.
+const saltLen = 32
+
+// ErrNoIdentity reports that the user has not created an identity.
+var ErrNoIdentity = errors.New("you have no identity. Run \"mrs identity create\" to create one")
+
+// paths returns where the identity's private and public keys are kept.
+func paths() (string, string, error) {
+	base, err := config.GetBaseDir()
+	if err != nil {
+		return "", "", err
+	}
+	p := path.Join(base, "identity")
+	return p, p + ".pub", nil
+}
+
+// Create creates an identity, sealed under password, which goes by name. It
+// refuses to replace one that exists, whose vaults would no longer open.
+func Create(name string, password []byte) (crypto.Recipient, error) {
+	if len(password) < 8 {
+		return crypto.Recipient{}, errors.New("password must contain at least 8 characters")
+	}
+	privatePath, publicPath, err := paths()
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	for _, p := range []string{privatePath, publicPath} {
+		if _, err := os.Stat(p); err == nil {
+			return crypto.Recipient{}, fmt.Errorf("an identity already exists in %s. "+
+				"Replacing it would lock you out of every team vault it opens", p)
+		} else if !os.IsNotExist(err) {
+			return crypto.Recipient{}, err
+		}
+	}
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	defer id.Wipe()
+	r, err := crypto.ParseRecipient(id.PublicKey().String() + " " + name)
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	salt, err := crypto.Salt()
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	private := id.Marshal()
+	defer crypto.Wipe(private)
+	sealed, err := crypto.Encrypt(private, password, salt, sealedName)
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	if err := os.MkdirAll(path.Dir(privatePath), 0700); err != nil {
+		return crypto.Recipient{}, err
+	}
+	// The private key is written first, so that a public key is never handed
+	// out for an identity that does not exist.
+	if err := writeFile(privatePath, append([]byte(salt), sealed...)); err != nil {
+		return crypto.Recipient{}, err
+	}
+	if err := writeFile(publicPath, []byte(r.String()+"\n")); err != nil {
+		return crypto.Recipient{}, err
+	}
+	return r, nil
+}
+
+// writeFile writes a file of the identity's, which a failure to sync its
+// directory does not undo.
+func writeFile(p string, data []byte) error {
+	if err := fs.WriteFileAtomic(p, data, 0600); err != nil && !errors.Is(err, fs.ErrDirSync) {
+		return err
+	}
+	return nil
+}
+
+// Public returns the identity's public key, and the name it goes by.
+func Public() (crypto.Recipient, error) {
+	_, publicPath, err := paths()
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	b, err := os.ReadFile(publicPath)
+	if os.IsNotExist(err) {
+		return crypto.Recipient{}, ErrNoIdentity
+	}
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	r, err := crypto.ParseRecipient(string(b))
+	if err != nil {
+		return crypto.Recipient{}, fmt.Errorf("cannot read %s: %w", publicPath, err)
+	}
+	return r, nil
+}
+
+// Open returns the identity that password unseals. The caller is responsible
+// for wiping it.
+func Open(password []byte) (*crypto.Identity, error) {
+	privatePath, _, err := paths()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(privatePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNoIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < saltLen {
+		return nil, fmt.Errorf("cannot read %s: it is too short to be an identity", privatePath)
+	}
+	private, _, err := crypto.Decrypt(b[saltLen:], password, string(b[:saltLen]), sealedName)
+	if err != nil {
+		return nil, errors.New("failed to decrypt your identity")
+	}
+	defer crypto.Wipe(private)
+	return crypto.UnmarshalIdentity(private)
+}
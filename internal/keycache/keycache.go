@@ -0,0 +1,83 @@
+// Package keycache keeps the keys that open vaults between commands, in each
+// of the places the user has set up for them: mrs agent, and the kernel
+// keyring that $MRS_KEYRING names. A command asks here before it asks for a
+// password, and leaves here the key a password derived.
+package keycache
+
+import (
+	"errors"
+
+	"github.com/andornaut/mrs/internal/agent"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/keyring"
+)
+
+// cache is one place keys are kept, and the error it reports when the user
+// has not set it up, which is not a failure.
+type cache struct {
+	get    func(string) (*crypto.Key, error)
+	put    func(string, *crypto.Key) error
+	forget func(string) error
+	lock   func() error
+	unused error
+}
+
+var caches = []cache{
+	{agent.Get, agent.Put, agent.Forget, agent.Lock, agent.ErrNotRunning},
+	{keyring.Get, keyring.Put, keyring.Forget, keyring.Lock, keyring.ErrDisabled},
+}
+
+// Get returns the key kept for the vault file at p by the first place that
+// keeps one, or nil when none does. The error reports the places that could
+// not be asked, which does not stop a key from another being returned. The
+// caller is responsible for wiping the key.
+func Get(p string) (*crypto.Key, error) {
+	var errs []error
+	for _, c := range caches {
+		k, err := c.get(p)
+		if err != nil && !errors.Is(err, c.unused) {
+			errs = append(errs, err)
+		}
+		if k != nil {
+			return k, errors.Join(errs...)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Put keeps the key to the vault file at p in every place that is set up. The
+// caller still owns k.
+func Put(p string, k *crypto.Key) error {
+	_, err := each(func(c cache) error { return c.put(p, k) })
+	return err
+}
+
+// Forget removes the key to the vault file at p from every place that is set
+// up, and returns how many there are.
+func Forget(p string) (int, error) {
+	return each(func(c cache) error { return c.forget(p) })
+}
+
+// Lock removes every key from every place that is set up, and returns how
+// many there are.
+func Lock() (int, error) {
+	return each(func(c cache) error { return c.lock() })
+}
+
+// each runs fn on each place keys are kept, and returns how many of them are
+// set up.
+func each(fn func(cache) error) (int, error) {
+	var errs []error
+	n := 0
+	for _, c := range caches {
+		err := fn(c)
+		if errors.Is(err, c.unused) {
+			continue
+		}
+		n++
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
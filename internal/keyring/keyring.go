@@ -0,0 +1,22 @@
+// Package keyring keeps the keys that open vaults in the Linux kernel's
+// keyring between commands, when $MRS_KEYRING asks for it. It is a lighter
+// alternative to mrs agent: no process is left running, and the key is held
+// in kernel memory, which is never swapped out, until it has gone unused for
+// $MRS_KEYRING_TIMEOUT.
+package keyring
+
+import "errors"
+
+// ErrDisabled reports that $MRS_KEYRING is not set, which to a command that
+// asks for a key means only that it asks for the password instead.
+var ErrDisabled = errors.New("the kernel keyring is not in use")
+
+// ringName names the keyring of mrs's own, within the one $MRS_KEYRING names,
+// that holds the keys, so that they can be cleared at once without touching
+// anything else in it.
+const ringName = "mrs"
+
+// description names the key to the vault file at p.
+func description(p string) string {
+	return "mrs:" + p
+}
@@ -0,0 +1,186 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// perm lets a process that possesses a key, by way of the keyring it is
+// linked into, do anything with it, and anyone else only see that it is
+// there. x/sys/unix does not define the bits, which are keyctl(2)'s.
+const perm = 0x3f000000 | 0x00010000
+
+// Get returns the key kept for the vault file at p, or nil when none is, and
+// starts its timeout over. The caller is responsible for wiping it.
+func Get(p string) (*crypto.Key, error) {
+	ring, err := mrsRing(false)
+	if err != nil || ring == 0 {
+		return nil, err
+	}
+	id, err := unix.KeyctlSearch(ring, "user", description(p), 0)
+	if isMissing(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, keyringError(err)
+	}
+	var b [crypto.MarshalledKeyLen]byte
+	defer crypto.Wipe(b[:])
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, b[:], 0)
+	if isMissing(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, keyringError(err)
+	}
+	if n != len(b) {
+		return nil, fmt.Errorf("the kernel keyring holds a key for %s that is %d bytes, not %d", p, n, len(b))
+	}
+	k, err := crypto.UnmarshalKey(b[:])
+	if err != nil {
+		return nil, err
+	}
+	if err := setTimeout(id); err != nil {
+		k.Wipe()
+		return nil, err
+	}
+	return k, nil
+}
+
+// Put keeps the key to the vault file at p, in place of any kept for it, until
+// it has gone unused for $MRS_KEYRING_TIMEOUT. The caller still owns k.
+func Put(p string, k *crypto.Key) error {
+	ring, err := mrsRing(true)
+	if err != nil {
+		return err
+	}
+	b := k.Marshal()
+	defer crypto.Wipe(b)
+	id, err := unix.AddKey("user", description(p), b, ring)
+	if err != nil {
+		return keyringError(err)
+	}
+	if err := unix.KeyctlSetperm(id, perm); err != nil {
+		return keyringError(err)
+	}
+	return setTimeout(id)
+}
+
+// Forget removes the key kept for the vault file at p, if there is one.
+func Forget(p string) error {
+	ring, err := mrsRing(false)
+	if err != nil || ring == 0 {
+		return err
+	}
+	id, err := unix.KeyctlSearch(ring, "user", description(p), 0)
+	if isMissing(err) {
+		return nil
+	}
+	if err != nil {
+		return keyringError(err)
+	}
+	return invalidate(id, ring)
+}
+
+// Lock removes every key mrs keeps.
+func Lock() error {
+	ring, err := mrsRing(false)
+	if err != nil || ring == 0 {
+		return err
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_CLEAR, ring, 0, 0, 0); err != nil && !isMissing(err) {
+		return keyringError(err)
+	}
+	return nil
+}
+
+// mrsRing returns the keyring that holds mrs's keys, creating it in the one
+// $MRS_KEYRING names when create is set, or 0 when there is none.
+func mrsRing(create bool) (int, error) {
+	name, err := config.Keyring()
+	if err != nil {
+		return 0, err
+	}
+	var parent int
+	switch name {
+	case "":
+		return 0, ErrDisabled
+	case "session":
+		// A process with no session keyring of its own is given the user's
+		// default one to search, but a new one to add to, which goes when it
+		// exits, taking the key with it.
+		session, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_SESSION_KEYRING, false)
+		if err != nil {
+			return 0, keyringError(err)
+		}
+		userSession, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_USER_SESSION_KEYRING, false)
+		if err != nil {
+			return 0, keyringError(err)
+		}
+		if session == userSession {
+			return 0, errors.New("this login has no session keyring to keep keys in, so MRS_KEYRING=session keeps none. " +
+				"Set MRS_KEYRING=user instead")
+		}
+		parent = session
+	case "user":
+		parent = unix.KEY_SPEC_USER_KEYRING
+	}
+	id, err := unix.KeyctlSearch(parent, "keyring", ringName, 0)
+	if err == nil {
+		return id, nil
+	}
+	if !isMissing(err) {
+		return 0, keyringError(err)
+	}
+	if !create {
+		return 0, nil
+	}
+	id, err = unix.AddKey("keyring", ringName, nil, parent)
+	if err != nil {
+		return 0, keyringError(err)
+	}
+	if err := unix.KeyctlSetperm(id, perm); err != nil {
+		return 0, keyringError(err)
+	}
+	return id, nil
+}
+
+// setTimeout has the kernel remove the key once $MRS_KEYRING_TIMEOUT has
+// passed, counting from now.
+func setTimeout(id int) error {
+	d, err := config.KeyringTimeout()
+	if err != nil {
+		return err
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, int(d.Seconds()), 0, 0); err != nil {
+		return keyringError(err)
+	}
+	return nil
+}
+
+// invalidate removes a key at once, or on kernels before 3.5, which cannot,
+// takes it out of the keyring so that it is no longer found.
+func invalidate(id, ring int) error {
+	if _, err := unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0); err == nil || isMissing(err) {
+		return nil
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, ring, 0, 0); err != nil && !isMissing(err) {
+		return keyringError(err)
+	}
+	return nil
+}
+
+// isMissing reports a key that is not there, or that the kernel is about to
+// remove, which is the same to a command that wants it.
+func isMissing(err error) bool {
+	return errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED)
+}
+
+func keyringError(err error) error {
+	return fmt.Errorf("the kernel keyring failed: %w", err)
+}
@@ -0,0 +1,112 @@
+package keyring
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// inNewSession gives the test a session keyring of its own, so that nothing
+// it keeps reaches, or is reached by, anything else the user keeps. A
+// session keyring belongs to a thread, so the test stays on this one, which
+// exits with it rather than carry the keyring on to another test.
+func inNewSession(t *testing.T) {
+	t.Helper()
+	runtime.LockOSThread()
+	// A name of NULL joins a new, anonymous keyring, which KeyctlJoinSessionKeyring
+	// cannot ask for.
+	if _, err := unix.KeyctlInt(unix.KEYCTL_JOIN_SESSION_KEYRING, 0, 0, 0, 0); err != nil {
+		t.Skipf("the kernel keyring is not available: %v", err)
+	}
+	t.Setenv("MRS_KEYRING", "session")
+}
+
+func testKey(t *testing.T) *crypto.Key {
+	t.Helper()
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	k, err := crypto.NewKey(crypto.CurrentParams, raw)
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	return k
+}
+
+func TestTheKeyringHandsBackTheKeyItKept(t *testing.T) {
+	inNewSession(t)
+	if k, err := Get("/vaults/work.salt"); err != nil || k != nil {
+		t.Fatalf("Get() before Put() = %v, %v; want no key", k, err)
+	}
+
+	want := testKey(t)
+	if err := Put("/vaults/work.salt", want); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	k, err := Get("/vaults/work.salt")
+	if err != nil || k == nil {
+		t.Fatalf("Get() = %v, %v; want a key", k, err)
+	}
+	if string(k.Marshal()) != string(want.Marshal()) {
+		t.Errorf("Get() = %x, want %x", k.Marshal(), want.Marshal())
+	}
+	if k, _ := Get("/vaults/personal.salt"); k != nil {
+		t.Error("expected no key for a vault that none was put for")
+	}
+
+	if err := Forget("/vaults/work.salt"); err != nil {
+		t.Fatalf("Forget() failed: %v", err)
+	}
+	if k, _ := Get("/vaults/work.salt"); k != nil {
+		t.Error("expected Forget() to remove the key")
+	}
+}
+
+func TestLockRemovesEveryKey(t *testing.T) {
+	inNewSession(t)
+	for _, p := range []string{"/vaults/a.salt", "/vaults/b.salt"} {
+		if err := Put(p, testKey(t)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	if err := Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	for _, p := range []string{"/vaults/a.salt", "/vaults/b.salt"} {
+		if k, _ := Get(p); k != nil {
+			t.Errorf("expected no key for %s after Lock()", p)
+		}
+	}
+}
+
+func TestTheKernelRemovesAKeyOnceItTimesOut(t *testing.T) {
+	inNewSession(t)
+	t.Setenv("MRS_KEYRING_TIMEOUT", "1s")
+	if err := Put("/vaults/work.salt", testKey(t)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if k, err := Get("/vaults/work.salt"); err != nil || k != nil {
+		t.Errorf("Get() after the timeout = %v, %v; want no key", k, err)
+	}
+}
+
+func TestNothingIsKeptUnlessAsked(t *testing.T) {
+	t.Setenv("MRS_KEYRING", "")
+	if _, err := Get("/vaults/work.salt"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("expected ErrDisabled, got %v", err)
+	}
+	if err := Put("/vaults/work.salt", testKey(t)); !errors.Is(err, ErrDisabled) {
+		t.Errorf("expected ErrDisabled, got %v", err)
+	}
+	t.Setenv("MRS_KEYRING", "thread")
+	if err := Lock(); err == nil || errors.Is(err, ErrDisabled) {
+		t.Errorf("expected an error for a keyring mrs does not use, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package keyring
+
+import (
+	"errors"
+
+	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+var errNotLinux = errors.New("the kernel keyring is only on Linux, so MRS_KEYRING has no effect")
+
+// unsupported returns ErrDisabled when $MRS_KEYRING is not set, and otherwise
+// says that it cannot be honoured here.
+func unsupported() error {
+	ring, err := config.Keyring()
+	if err != nil {
+		return err
+	}
+	if ring == "" {
+		return ErrDisabled
+	}
+	return errNotLinux
+}
+
+// Get returns the key kept for the vault file at p, which is never one here.
+func Get(p string) (*crypto.Key, error) { return nil, unsupported() }
+
+// Put keeps nothing here.
+func Put(p string, k *crypto.Key) error { return unsupported() }
+
+// Forget has nothing to forget here.
+func Forget(p string) error { return unsupported() }
+
+// Lock has nothing to forget here.
+func Lock() error { return unsupported() }
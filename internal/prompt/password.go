@@ -10,10 +10,38 @@ import (
 )
 
 func GivenOrPromptPassword(passwordFile string) ([]byte, error) {
+	return givenOrPrompt(passwordFile, "Vault password")
+}
+
+// GivenOrPromptVaultPassword is GivenOrPromptPassword for a command that opens
+// several vaults in turn, and so names the one whose password it asks for.
+func GivenOrPromptVaultPassword(passwordFile, name string) ([]byte, error) {
+	return givenOrPrompt(passwordFile, "Password for vault "+name)
+}
+
+// GivenOrPromptIdentityPassword returns the password of the user's identity,
+// which opens a team vault in place of a password of the vault's own.
+func GivenOrPromptIdentityPassword(passwordFile string) ([]byte, error) {
+	return givenOrPrompt(passwordFile, "Identity password")
+}
+
+// GivenOrPromptArchivePassword returns the password that an archive of vaults
+// was sealed under, which opens it in place of any vault's own.
+func GivenOrPromptArchivePassword(passwordFile string) ([]byte, error) {
+	return givenOrPrompt(passwordFile, "Archive password")
+}
+
+// VaultPasswordOrSkip prompts for the password of one of several vaults that a
+// command reads, for which an empty answer means to skip it.
+func VaultPasswordOrSkip(name string) ([]byte, error) {
+	return givenOrPrompt("", "Password for vault "+name+" (Enter to skip)")
+}
+
+func givenOrPrompt(passwordFile, msg string) ([]byte, error) {
 	if passwordFile != "" {
 		return readPasswordFile(passwordFile)
 	}
-	p, err := Password("Vault password")
+	p, err := Password(msg)
 	if err != nil {
 		return nil, withFlagHint(err, "--password-file")
 	}
@@ -26,6 +54,18 @@ func GivenOrPromptConfirmedPassword(passwordFile string) ([]byte, error) {
 	return givenOrPromptConfirmed(passwordFile, "Vault password", "--password-file")
 }
 
+// GivenOrPromptConfirmedArchivePassword returns the password to seal an
+// archive of vaults under, from a file or from two prompts that must agree.
+func GivenOrPromptConfirmedArchivePassword(passwordFile string) ([]byte, error) {
+	return givenOrPromptConfirmed(passwordFile, "Archive password", "--password-file")
+}
+
+// GivenOrPromptConfirmedIdentityPassword returns the password for an identity
+// being created, from a file or from two prompts that must agree.
+func GivenOrPromptConfirmedIdentityPassword(passwordFile string) ([]byte, error) {
+	return givenOrPromptConfirmed(passwordFile, "Identity password", "--password-file")
+}
+
 // GivenOrPromptNewPassword returns the password a vault is being changed to,
 // from a file or from two prompts that must agree.
 func GivenOrPromptNewPassword(newPasswordFile string) ([]byte, error) {
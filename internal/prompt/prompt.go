@@ -2,6 +2,7 @@ package prompt
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"golang.org/x/term"
 
 	"github.com/andornaut/mrs/internal/config"
+	"github.com/andornaut/mrs/internal/crypto"
 )
 
 // Prompts are written here rather than to stdout, so that they cannot be
@@ -92,6 +94,78 @@ func Password(msg string) ([]byte, error) {
 	return b, nil
 }
 
+// GivenOrReadValue returns a secret's value, from valueFile or else from stdin,
+// with any trailing line breaks removed, as they are from a password file. At
+// a terminal, it says how to end the value, since nothing else would. The
+// caller is responsible for wiping the returned slice.
+func GivenOrReadValue(valueFile string) ([]byte, error) {
+	if valueFile != "" {
+		b, err := os.ReadFile(valueFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read from value file %q: %w", valueFile, err)
+		}
+		return bytes.TrimRight(b, "\r\n"), nil
+	}
+	if isTerminal(int(os.Stdin.Fd())) {
+		_, _ = fmt.Fprint(promptOut, "Type the value, then press Ctrl-D on a line of its own:\n")
+	}
+	b, err := readAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the value from stdin: %w", err)
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// ReadShares returns what is typed or piped on stdin, for the caller to read
+// recovery shares from. At a terminal, it says how to end them. The caller is
+// responsible for wiping the returned slice.
+func ReadShares() ([]byte, error) {
+	return readText("Type or paste the shares, one a line", "the shares")
+}
+
+// ReadPaperBackup returns what is typed or piped on stdin, for the caller to
+// read a paper backup from. At a terminal, it says how to end it.
+func ReadPaperBackup() ([]byte, error) {
+	return readText("Type or paste the paper backup", "the paper backup")
+}
+
+// readText reads stdin to its end, first saying msg and how to end it at a
+// terminal. what names what is being read in an error.
+func readText(msg, what string) ([]byte, error) {
+	if isTerminal(int(os.Stdin.Fd())) {
+		_, _ = fmt.Fprint(promptOut, msg+", then press Ctrl-D on a line of its own:\n")
+	}
+	b, err := readAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s from stdin: %w", what, err)
+	}
+	return b, nil
+}
+
+// readAll is io.ReadAll, except that it wipes each buffer it outgrows, which
+// would otherwise be left holding the start of the value until it was
+// collected.
+func readAll(r io.Reader) ([]byte, error) {
+	b := make([]byte, 0, 512)
+	for {
+		if len(b) == cap(b) {
+			grown := make([]byte, len(b), 2*cap(b))
+			copy(grown, b)
+			crypto.Wipe(b)
+			b = grown
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if errors.Is(err, io.EOF) {
+			return b, nil
+		}
+		if err != nil {
+			crypto.Wipe(b)
+			return nil, err
+		}
+	}
+}
+
 // TrimmedLine prompts for input and returns the first line of input as a trimmed string
 func TrimmedLine(msg string) (string, error) {
 	_, _ = fmt.Fprint(promptOut, msg+": ")
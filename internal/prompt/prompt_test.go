@@ -63,11 +63,13 @@ func TestEveryPromptIsWrittenAwayFromStdout(t *testing.T) {
 	// The read fails, because the fake terminal is a file. What matters is
 	// where the prompt went on the way there.
 	_, _ = Password("Vault password")
+	_, _ = GivenOrPromptVaultPassword("", "personal")
 
 	for _, want := range []string{
 		"Vault name: ",
 		"Delete vault personal? (y/n) [n]: ",
 		"Vault password: ",
+		"Password for vault personal: ",
 	} {
 		if !strings.Contains(buf.String(), want) {
 			t.Errorf("expected %q to be written away from stdout, got %q", want, buf.String())
@@ -194,6 +196,10 @@ func TestTheFlagThatSuppliesAPasswordIsNamed(t *testing.T) {
 		flag string
 	}{
 		"current password": {GivenOrPromptPassword, "--password-file"},
+		"one of several": {
+			func(f string) ([]byte, error) { return GivenOrPromptVaultPassword(f, "personal") },
+			"--password-file",
+		},
 		"a new vault":      {GivenOrPromptConfirmedPassword, "--password-file"},
 		"changed password": {GivenOrPromptNewPassword, "--new-password-file"},
 	}
@@ -268,3 +274,27 @@ func TestAConfirmationNeedsATerminalOrAFlag(t *testing.T) {
 		t.Errorf("expected no question to be asked, got %q", buf.String())
 	}
 }
+
+func TestAValueIsReadFromStdinOrAFile(t *testing.T) {
+	// Longer than the first buffer, so that reading it has to grow one.
+	long := strings.Repeat("a line of a value\n", 100)
+	withStdin(t, long+"\n")
+	got, err := GivenOrReadValue("")
+	if err != nil {
+		t.Fatalf("GivenOrReadValue() error: %s", err)
+	}
+	if want := strings.TrimSuffix(long, "\n"); string(got) != want {
+		t.Errorf("expected the value without its trailing line breaks, got %q", got)
+	}
+
+	p := filepath.Join(t.TempDir(), "value")
+	if err := os.WriteFile(p, []byte("user: alice\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GivenOrReadValue(p); err != nil || string(got) != "user: alice" {
+		t.Errorf("expected the file's value, got %q, %v", got, err)
+	}
+	if _, err := GivenOrReadValue(filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "value file") {
+		t.Errorf("expected the missing value file to be named, got %v", err)
+	}
+}
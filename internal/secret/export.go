@@ -0,0 +1,306 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// ExportFormats are the formats Export writes.
+var ExportFormats = []cli.Format{
+	cli.FormatText, cli.FormatJSON, cli.FormatNDJSON, cli.FormatCSV, cli.FormatDotenv, cli.FormatK8sSecret,
+}
+
+// Export returns the secrets in a vault in q.Format, along with how many there
+// are. q.Pattern, when set, selects the secrets whose keys match it, as a
+// search does, and q.Field exports the values of that field, leaving out the
+// secrets that do not have it; the rest of q is not used. Text with neither is
+// the vault's plaintext as it was decrypted, byte for byte. The caller is
+// responsible for wiping the returned slice.
+func Export(v vault.UnlockedVault, q Query) ([]byte, int, error) {
+	plaintext, err := v.Decrypt()
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := parseSecrets(plaintext)
+	if err != nil {
+		crypto.Wipe(plaintext)
+		return nil, 0, err
+	}
+	defer b.Wipe()
+	if q.Format == cli.FormatText && q.Pattern == nil && q.Field == "" {
+		return plaintext, b.Len(), nil
+	}
+	crypto.Wipe(plaintext)
+
+	selected := b
+	if q.Pattern != nil {
+		selected = b.SearchKeys(*q.Pattern)
+	}
+	var field []byte
+	if q.Field != "" {
+		field = []byte(q.Field)
+		selected = selected.HavingField(field)
+	}
+	var out []byte
+	switch q.Format {
+	case cli.FormatJSON, cli.FormatNDJSON:
+		out = selected.JSON(v.Name(), q.Format, field)
+	case cli.FormatCSV:
+		out = selected.CSV(field)
+	case cli.FormatDotenv:
+		out, err = selected.Dotenv(field)
+	case cli.FormatK8sSecret:
+		out, err = selected.K8sSecret(v.Name(), field)
+	default:
+		if field != nil {
+			out = selected.FieldBytes(field)
+		} else {
+			out = selected.Bytes()
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, selected.Len(), nil
+}
+
+// lines returns the lines of the secret's value, or, when field is given, the
+// values of that field.
+func (s secret) lines(field []byte) [][]byte {
+	if field != nil {
+		return s.Field(field)
+	}
+	var lines [][]byte
+	for rest := s.Value(); len(rest) > 0; {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte{'\n'})
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// joinLines returns lines joined by newlines, with no newline after the last.
+// It is sized once, and the caller is responsible for wiping it.
+func joinLines(lines [][]byte) []byte {
+	n := 0
+	for _, line := range lines {
+		n += len(line) + 1
+	}
+	out := make([]byte, 0, n)
+	for i, line := range lines {
+		if i > 0 {
+			out = append(out, '\n')
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// CSV returns the secrets as a CSV file with a key column and a value column,
+// which holds the lines of each value, or the values of field, in one cell.
+// The value column is named "value", which mrs import reads back as the lines
+// of a secret, or after the field, which it reads back as that field. Like
+// Bytes, it is sized once and the caller is responsible for wiping it.
+func (s *secretList) CSV(field []byte) []byte {
+	column := []byte("value")
+	if field != nil {
+		column = field
+	}
+	return encodeSized(func(w *sizedWriter) {
+		w.raw("key,")
+		w.csvCell([][]byte{column})
+		w.raw("\n")
+		for _, secret := range s.secrets {
+			w.csvCell([][]byte{secret.Key()})
+			w.raw(",")
+			w.csvCell(secret.lines(field))
+			w.raw("\n")
+		}
+	})
+}
+
+// csvCell writes lines, joined by newlines, as one CSV cell, quoted as
+// encoding/csv would quote it.
+func (w *sizedWriter) csvCell(lines [][]byte) {
+	quoted := len(lines) > 1
+	for _, line := range lines {
+		quoted = quoted || bytes.ContainsAny(line, "\",\r\n")
+	}
+	if len(lines) > 0 && len(lines[0]) > 0 && (lines[0][0] == ' ' || lines[0][0] == '\t') {
+		quoted = true
+	}
+	if !quoted {
+		for _, line := range lines {
+			w.bytes(line)
+		}
+		return
+	}
+	w.raw(`"`)
+	for i, line := range lines {
+		if i > 0 {
+			w.raw("\n")
+		}
+		for rest := line; len(rest) > 0; {
+			before, after, found := bytes.Cut(rest, []byte{'"'})
+			w.bytes(before)
+			if found {
+				w.raw(`""`)
+			}
+			rest = after
+		}
+	}
+	w.raw(`"`)
+}
+
+// Dotenv returns the secrets as a dotenv file, a NAME="value" line for each.
+// NAME is the secret's key, followed by field when it is given, upper-cased,
+// with each run of anything but letters and digits made one _. The value is
+// its lines, or the values of field, joined by \n. It refuses secrets that
+// would share a name, since a later line would silently replace an earlier
+// one. Like Bytes, it is sized once and the caller is responsible for wiping
+// it.
+func (s *secretList) Dotenv(field []byte) ([]byte, error) {
+	names, err := s.exportNames(cli.FormatDotenv, field, func(key []byte) string {
+		name := strings.Trim(strings.ToUpper(mapName(key, isAlnum)), "_")
+		if name != "" && name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+		return name
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encodeSized(func(w *sizedWriter) {
+		for i, secret := range s.secrets {
+			w.raw(names[i])
+			w.raw(`="`)
+			for j, line := range secret.lines(field) {
+				if j > 0 {
+					w.raw(`\n`)
+				}
+				w.dotenvString(line)
+			}
+			w.raw("\"\n")
+		}
+	}), nil
+}
+
+// dotenvString writes b for a double-quoted dotenv value, escaping what a
+// shell would otherwise expand or end the value at.
+func (w *sizedWriter) dotenvString(b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "\\\"$`")
+		if i < 0 {
+			w.bytes(b)
+			return
+		}
+		w.bytes(b[:i])
+		w.raw(`\`)
+		w.bytes(b[i : i+1])
+		b = b[i+1:]
+	}
+}
+
+// K8sSecret returns the secrets as the manifest of a Kubernetes Secret named
+// after the vault, with an entry in its data for each. An entry's key is the
+// secret's key, followed by "." and field when it is given, with each run of
+// what Kubernetes does not allow in one made one _, and its value is the
+// base64 of the secret's lines, or of the values of field, joined by
+// newlines. Like Dotenv, it refuses secrets that would share a key. It is
+// sized once and the caller is responsible for wiping it.
+func (s *secretList) K8sSecret(vaultName string, field []byte) ([]byte, error) {
+	names, err := s.exportNames(cli.FormatK8sSecret, field, func(key []byte) string {
+		return strings.Trim(mapName(key, func(c byte) bool { return isAlnum(c) || c == '-' || c == '.' }), "_")
+	})
+	if err != nil {
+		return nil, err
+	}
+	metadataName := mapName([]byte(strings.ToLower(vaultName)), func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-'
+	})
+	metadataName = strings.Trim(strings.ReplaceAll(metadataName, "_", "-"), "-")
+	if metadataName == "" {
+		metadataName = "mrs"
+	}
+	return encodeSized(func(w *sizedWriter) {
+		w.raw("apiVersion: v1\nkind: Secret\nmetadata:\n  name: ")
+		w.raw(metadataName)
+		w.raw("\ntype: Opaque\n")
+		if len(s.secrets) == 0 {
+			w.raw("data: {}\n")
+			return
+		}
+		w.raw("data:\n")
+		for i, secret := range s.secrets {
+			w.raw(`  "`)
+			w.raw(names[i])
+			w.raw(`": "`)
+			value := joinLines(secret.lines(field))
+			w.base64(value)
+			crypto.Wipe(value)
+			w.raw("\"\n")
+		}
+	}), nil
+}
+
+func (w *sizedWriter) base64(b []byte) {
+	n := base64.StdEncoding.EncodedLen(len(b))
+	if w.sizing {
+		w.n += n
+		return
+	}
+	start := len(w.buf)
+	w.buf = w.buf[:start+n]
+	base64.StdEncoding.Encode(w.buf[start:], b)
+}
+
+// exportNames returns the name format gives each secret, which name makes of
+// its key and field, and refuses a name that is empty or that two secrets
+// would share. The names are printed, so holding them as strings adds no
+// exposure that printing them does not.
+func (s *secretList) exportNames(format cli.Format, field []byte, name func([]byte) string) ([]string, error) {
+	names := make([]string, len(s.secrets))
+	by := make(map[string][]byte, len(s.secrets))
+	for i, secret := range s.secrets {
+		key := secret.Key()
+		if field != nil {
+			key = bytes.Join([][]byte{key, field}, []byte{'.'})
+		}
+		names[i] = name(key)
+		if names[i] == "" {
+			return nil, fmt.Errorf("the secret %q has no letters or digits in its key to name it by in %s", secret.Key(), format)
+		}
+		if other, ok := by[names[i]]; ok {
+			return nil, fmt.Errorf("the secrets %q and %q would both be exported as %s in %s. Narrow the export with --match",
+				other, secret.Key(), names[i], format)
+		}
+		by[names[i]] = secret.Key()
+	}
+	return names, nil
+}
+
+// mapName returns b with each run of the bytes that keep rejects made one _.
+func mapName(b []byte, keep func(byte) bool) string {
+	var sb strings.Builder
+	replacing := false
+	for _, c := range b {
+		if keep(c) {
+			sb.WriteByte(c)
+			replacing = false
+		} else if !replacing {
+			sb.WriteByte('_')
+			replacing = true
+		}
+	}
+	return sb.String()
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,115 @@
+package secret
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/cli"
+)
+
+func exportFixture() *secretList {
+	return newSecretList([]secret{
+		secret("github\nusername: alice\npassword: p\"a$s,s `x` \\n\n"),
+		secret("aws key\nAKIA\n"),
+		secret("9lives\n"),
+	})
+}
+
+// The CSV is built by hand, so encoding/csv is the judge of whether it reads
+// back as the bytes that went in.
+func TestCSVReadsBackAsTheSecrets(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(string(exportFixture().CSV(nil)))).ReadAll()
+	if err != nil {
+		t.Fatalf("not CSV: %v", err)
+	}
+	want := [][]string{
+		{"key", "value"},
+		{"9lives", ""},
+		{"aws key", "AKIA"},
+		{"github", "username: alice\npassword: p\"a$s,s `x` \\n"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %q, got %q", want, records)
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d: expected %q, got %q", i, want[i], records[i])
+		}
+	}
+
+	// mrs import reads its own export back as the secrets that went into it.
+	out := exportFixture().CSV(nil)
+	back, n, err := Convert(cli.FormatCSV, out)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 secrets back, got %d: %v\n%s", n, err, out)
+	}
+	if got, want := string(back), "9lives\n\naws key\nAKIA\n\ngithub\nusername: alice\npassword: p\"a$s,s `x` \\n\n"; got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestDotenvNamesAndQuotesEachSecret(t *testing.T) {
+	out, err := exportFixture().Dotenv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "_9LIVES=\"\"\n" +
+		"AWS_KEY=\"AKIA\"\n" +
+		"GITHUB=\"username: alice\\npassword: p\\\"a\\$s,s \\`x\\` \\\\n\"\n"
+	if string(out) != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out)
+	}
+
+	out, err = exportFixture().HavingField([]byte("PASSWORD")).Dotenv([]byte("PASSWORD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "GITHUB_PASSWORD=\"p\\\"a\\$s,s \\`x\\` \\\\n\"\n" {
+		t.Errorf("got %q", got)
+	}
+
+	// mrs import reads the values back as they were.
+	back, _, err := Convert(cli.FormatDotenv, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(back); got != "GITHUB_PASSWORD\np\"a$s,s `x` \\n\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExportRefusesNamesThatSecretsWouldShare(t *testing.T) {
+	b := newSecretList([]secret{secret("aws-key\na\n"), secret("AWS key\nb\n")})
+	if _, err := b.Dotenv(nil); err == nil || !strings.Contains(err.Error(), "would both be exported as AWS_KEY") {
+		t.Errorf("expected the shared name to be refused, got %v", err)
+	}
+	if _, err := b.K8sSecret("work", nil); err != nil {
+		t.Errorf("expected aws-key and AWS_key to be told apart in a Secret, got %v", err)
+	}
+	b = newSecretList([]secret{secret("***\na\n")})
+	if _, err := b.Dotenv(nil); err == nil || !strings.Contains(err.Error(), "no letters or digits") {
+		t.Errorf("expected a key with nothing to name it by to be refused, got %v", err)
+	}
+}
+
+func TestK8sSecretHoldsEachValueInBase64(t *testing.T) {
+	out, err := exportFixture().K8sSecret("Work Vault", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.StdEncoding.EncodeToString
+	want := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: work-vault\ntype: Opaque\ndata:\n" +
+		"  \"9lives\": \"\"\n" +
+		"  \"aws_key\": \"" + enc([]byte("AKIA")) + "\"\n" +
+		"  \"github\": \"" + enc([]byte("username: alice\npassword: p\"a$s,s `x` \\n")) + "\"\n"
+	if string(out) != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out)
+	}
+
+	out, err = newSecretList(nil).K8sSecret("work", nil)
+	if err != nil || !strings.HasSuffix(string(out), "type: Opaque\ndata: {}\n") {
+		t.Errorf("expected an empty data map, got %q: %v", out, err)
+	}
+}
@@ -0,0 +1,614 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andornaut/mrs/internal/cli"
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/vault"
+)
+
+// ImportFormats are the formats Convert reads.
+var ImportFormats = []cli.Format{
+	cli.FormatCSV, cli.FormatBitwardenJSON, cli.FormatKeePassXML, cli.Format1PasswordCSV, cli.FormatPass, cli.FormatDotenv,
+}
+
+// untitled is the key of an entry that has no title, nor anything else to
+// name it by.
+const untitled = "(untitled)"
+
+// entry is one secret from another password manager, before it is written as
+// mrs writes secrets: its title, which becomes the key, and its fields, which
+// become "name: value" lines. A field with no name is a line of its own.
+//
+// Its strings are what the encoding packages decode an export into, and
+// cannot be wiped. The export they come from is a file of plaintext, so the
+// copies add little to what the user already has to remove.
+type entry struct {
+	title  string
+	fields []field
+}
+
+type field struct {
+	name, value string
+}
+
+func (e *entry) add(name, value string) {
+	if strings.TrimSpace(value) != "" {
+		e.fields = append(e.fields, field{name, value})
+	}
+}
+
+// write appends the entry as one secret. Whatever would end the secret early
+// or break its shape is smoothed over rather than refused, since an export is
+// not something the user can easily fix: the title is one line, a name has
+// no colon, and the blank lines in a value are dropped. A named field's further
+// lines are indented under its first, so that each reads as part of it; a
+// field with no name is lines of the value as they are.
+func (e entry) write(out []byte) []byte {
+	title := strings.Join(strings.Fields(e.title), " ")
+	if title == "" {
+		title = untitled
+	}
+	out = append(out, title...)
+	out = append(out, '\n')
+	for _, f := range e.fields {
+		name := strings.Join(strings.Fields(strings.ReplaceAll(f.name, ":", " ")), " ")
+		first := true
+		for line := range strings.Lines(strings.ReplaceAll(f.value, "\r\n", "\n")) {
+			line = strings.TrimRight(line, "\r\n")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			switch {
+			case name == "":
+			case !first:
+				out = append(out, "  "...)
+			default:
+				out = append(out, name...)
+				out = append(out, ": "...)
+			}
+			out = append(out, line...)
+			out = append(out, '\n')
+			first = false
+		}
+	}
+	return out
+}
+
+// Convert reads another password manager's export, in the given format, and
+// returns it as secrets in the shape a vault is written in, along with how
+// many there are. For pass, src is the password store's directory, and the
+// secrets are read from it with "pass show". The caller owns src and is
+// responsible for wiping both it and the returned slice.
+func Convert(format cli.Format, src []byte) ([]byte, int, error) {
+	var (
+		entries []entry
+		err     error
+	)
+	switch format {
+	case cli.FormatCSV:
+		entries, err = readCSV(src, nil)
+	case cli.Format1PasswordCSV:
+		entries, err = readCSV(src, onePasswordColumns)
+	case cli.FormatBitwardenJSON:
+		entries, err = readBitwarden(src)
+	case cli.FormatKeePassXML:
+		entries, err = readKeePass(src)
+	case cli.FormatPass:
+		entries, err = readPassStore(string(src))
+	case cli.FormatDotenv:
+		entries, err = readDotenv(src)
+	default:
+		return nil, 0, fmt.Errorf("cannot import %s", format)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var plaintext []byte
+	for i, e := range entries {
+		if i > 0 {
+			plaintext = append(plaintext, '\n')
+		}
+		plaintext = e.write(plaintext)
+	}
+	defer crypto.Wipe(plaintext)
+	// Parsed back, as anything typed in an editor is, so that what is stored
+	// is sorted and is certain to read back as the secrets it was written as.
+	b, err := parseSecrets(plaintext)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer b.Wipe()
+	if b.Len() != len(entries) {
+		return nil, 0, fmt.Errorf("%d entries were read, but they make %d secrets", len(entries), b.Len())
+	}
+	return b.Bytes(), b.Len(), nil
+}
+
+// Import adds secrets that Convert returned to a vault, and warns of the keys
+// that the vault's secrets and the new ones share. It returns how many were
+// added. The caller holds the vault's exclusive lock, owns imported and is
+// responsible for wiping it.
+func Import(v vault.UnlockedVault, imported []byte) (int, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Wipe()
+	nb, err := parseSecrets(imported)
+	if err != nil {
+		return 0, err
+	}
+	defer nb.Wipe()
+
+	combined := b.Combined(nb)
+	warnDuplicateKeys(combined)
+	out := combined.Bytes()
+	defer crypto.Wipe(out)
+	if err := v.Write(out); err != nil {
+		return 0, err
+	}
+	return nb.Len(), nil
+}
+
+// ImportNew creates a vault that holds secrets that Convert returned, and warns
+// of the keys that more than one of them share. keyfile is the digest of the
+// keyfile the vault's key is derived from along with password, or nil. The
+// caller owns imported and is responsible for wiping it.
+func ImportNew(name string, password, keyfile, imported []byte, force bool) (vault.UnlockedVault, error) {
+	b, err := parseSecrets(imported)
+	if err != nil {
+		return vault.UnlockedVault{}, err
+	}
+	defer b.Wipe()
+	warnDuplicateKeys(b)
+	return vault.Create(name, password, keyfile, imported, force)
+}
+
+// csvColumns maps the lowercased names of an export's columns to what mrs
+// calls them. The column named key is the entry's title, and one named lines
+// holds "name: value" lines of its own; one named "" is dropped, because it
+// holds a setting of the other tool rather than a secret.
+type csvColumns map[string]string
+
+// csvAliases are the names that the CSV exports of common password managers,
+// browsers among them, give their columns, and the value column of mrs's own.
+var csvAliases = csvColumns{
+	"title": "key", "name": "key", "key": "key",
+	"url": "url", "uri": "url", "website": "url", "login_uri": "url", "origin": "url",
+	"username": "username", "login": "username", "user": "username", "login_username": "username",
+	"password": "password", "login_password": "password",
+	"totp": "totp", "otp": "totp", "login_totp": "totp",
+	"notes": "notes", "note": "notes", "extra": "notes",
+	"fields": "lines", "value": "lines", "favorite": "", "reprompt": "", "type": "",
+}
+
+// onePasswordColumns are the columns of 1Password's CSV export that differ
+// from csvAliases.
+var onePasswordColumns = csvColumns{
+	"otpauth": "totp", "archived": "", "tags": "tags",
+}
+
+// readCSV reads a CSV export whose first row names its columns. extra adds to
+// or overrides csvAliases.
+func readCSV(src []byte, extra csvColumns) ([]entry, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(src, []byte("\ufeff"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	names := make([]string, len(header))
+	title := -1
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		name, ok := extra[h]
+		if !ok {
+			if name, ok = csvAliases[h]; !ok {
+				name = strings.TrimSpace(header[i])
+			}
+		}
+		if name == "key" && title < 0 {
+			title = i
+		}
+		names[i] = name
+	}
+	// A browser's export may have no titles, and its entries are named by
+	// their URLs instead.
+	if title < 0 && !slices.Contains(names, "url") {
+		return nil, fmt.Errorf("the CSV has no title, name or URL column to take keys from, only %s", strings.Join(header, ", "))
+	}
+
+	var entries []entry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		var e entry
+		notes := ""
+		for i, value := range record {
+			switch {
+			case i >= len(names) || names[i] == "" || names[i] == "key":
+			case names[i] == "notes":
+				notes = value
+			case names[i] == "lines":
+				e.add("", value)
+			default:
+				e.add(names[i], value)
+			}
+		}
+		// Last, below the fields, as every importer here puts them.
+		e.add("notes", notes)
+		if title >= 0 && title < len(record) {
+			e.title = record[title]
+		}
+		if strings.TrimSpace(e.title) == "" {
+			e.title = fallbackTitle(e)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// fallbackTitle names an entry that has no title by its URL or user name.
+func fallbackTitle(e entry) string {
+	for _, name := range []string{"url", "username"} {
+		for _, f := range e.fields {
+			if f.name == name {
+				return f.value
+			}
+		}
+	}
+	return untitled
+}
+
+// bitwardenExport is the part of Bitwarden's unencrypted JSON export that
+// holds secrets.
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		FolderID string `json:"folderId"`
+		Name     string `json:"name"`
+		Notes    string `json:"notes"`
+		Login    *struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			TOTP     string `json:"totp"`
+			URIs     []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+		} `json:"login"`
+		Card     json.RawMessage `json:"card"`
+		Identity json.RawMessage `json:"identity"`
+		SSHKey   json.RawMessage `json:"sshKey"`
+		Fields   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	} `json:"items"`
+}
+
+func readBitwarden(src []byte) ([]entry, error) {
+	var export bitwardenExport
+	if err := json.Unmarshal(src, &export); err != nil {
+		return nil, fmt.Errorf("failed to read Bitwarden JSON: %w", err)
+	}
+	if export.Encrypted {
+		return nil, errors.New("the Bitwarden export is encrypted. Export it again as unencrypted JSON")
+	}
+	folders := make(map[string]string, len(export.Folders))
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+	entries := make([]entry, 0, len(export.Items))
+	for _, item := range export.Items {
+		e := entry{title: item.Name}
+		if l := item.Login; l != nil {
+			e.add("username", l.Username)
+			e.add("password", l.Password)
+			for _, u := range l.URIs {
+				e.add("url", u.URI)
+			}
+			e.add("totp", l.TOTP)
+		}
+		for _, raw := range []json.RawMessage{item.Card, item.Identity, item.SSHKey} {
+			fields, err := orderedStrings(raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Bitwarden JSON: %w", err)
+			}
+			e.fields = append(e.fields, fields...)
+		}
+		for _, f := range item.Fields {
+			e.add(f.Name, f.Value)
+		}
+		e.add("folder", folders[item.FolderID])
+		e.add("notes", item.Notes)
+		if strings.TrimSpace(e.title) == "" {
+			e.title = fallbackTitle(e)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// orderedStrings returns the string members of a JSON object in the order they
+// are written in, which decoding into a map would lose.
+func orderedStrings(raw json.RawMessage) ([]field, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object, got %s", raw)
+	}
+	var fields []field
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if err := d.Decode(&value); err != nil {
+			return nil, err
+		}
+		if s, ok := value.(string); ok && strings.TrimSpace(s) != "" {
+			fields = append(fields, field{t.(string), s})
+		}
+	}
+	return fields, nil
+}
+
+// keepassFile is the part of KeePass's XML export that holds secrets. Its
+// protected values are written unprotected, as plain text.
+type keepassFile struct {
+	Meta struct {
+		RecycleBinUUID string `xml:"RecycleBinUUID"`
+	} `xml:"Meta"`
+	Root struct {
+		Groups []keepassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keepassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keepassEntry `xml:"Entry"`
+	Groups  []keepassGroup `xml:"Group"`
+}
+
+// keepassEntry is an entry as it is now. Its earlier versions are in a
+// History element of their own, which is not read.
+type keepassEntry struct {
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+}
+
+// keepassNames are the names KeePass gives its standard fields, and the order
+// they are written in.
+var keepassNames = []struct{ keepass, mrs string }{
+	{"UserName", "username"}, {"Password", "password"}, {"URL", "url"}, {"otp", "totp"},
+}
+
+func readKeePass(src []byte) ([]entry, error) {
+	var f keepassFile
+	if err := xml.Unmarshal(src, &f); err != nil {
+		return nil, fmt.Errorf("failed to read KeePass XML: %w", err)
+	}
+	var entries []entry
+	var walk func(g keepassGroup, path string)
+	walk = func(g keepassGroup, path string) {
+		// What has been deleted stays in the recycle bin until it is emptied,
+		// and is not brought back by a move to another tool.
+		if g.UUID != "" && g.UUID == f.Meta.RecycleBinUUID {
+			return
+		}
+		for _, ke := range g.Entries {
+			values := make(map[string]string, len(ke.Strings))
+			var order []string
+			for _, s := range ke.Strings {
+				values[s.Key] = s.Value
+				order = append(order, s.Key)
+			}
+			e := entry{title: values["Title"]}
+			for _, n := range keepassNames {
+				e.add(n.mrs, values[n.keepass])
+			}
+			for _, k := range order {
+				if k != "Title" && k != "Notes" && !slices.ContainsFunc(keepassNames, func(n struct{ keepass, mrs string }) bool { return n.keepass == k }) {
+					e.add(k, values[k])
+				}
+			}
+			e.add("group", path)
+			e.add("notes", values["Notes"])
+			if strings.TrimSpace(e.title) == "" {
+				e.title = fallbackTitle(e)
+			}
+			entries = append(entries, e)
+		}
+		for _, sub := range g.Groups {
+			walk(sub, strings.TrimPrefix(path+"/"+sub.Name, "/"))
+		}
+	}
+	// The top group is the database itself, whose name every entry would
+	// otherwise share.
+	for _, g := range f.Root.Groups {
+		walk(g, "")
+	}
+	return entries, nil
+}
+
+// passShow runs "pass show" for an entry of the password store in dir. It is a
+// variable so that the tests can read a store without gpg.
+var passShow = func(dir, name string) ([]byte, error) {
+	cmd := exec.Command("pass", "show", name)
+	cmd.Env = append(os.Environ(), "PASSWORD_STORE_DIR="+dir)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pass show %s failed: %w", name, err)
+	}
+	return out, nil
+}
+
+// readPassStore reads each entry of the password store in dir with pass, which
+// decrypts it with gpg. By pass's convention an entry's first line is its
+// password, and its further lines are its other fields, which are kept as they
+// are, but for an otpauth:// URI, which is a totp field.
+func readPassStore(dir string) ([]entry, error) {
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != dir {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".gpg") {
+			rel, err := filepath.Rel(dir, strings.TrimSuffix(p, ".gpg"))
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the password store: %w", err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no entries found in the password store %s", dir)
+	}
+
+	entries := make([]entry, 0, len(names))
+	for _, name := range names {
+		out, err := passShow(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		e := entry{title: name}
+		for i, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			switch {
+			case i == 0:
+				e.add("password", line)
+			case strings.HasPrefix(line, "otpauth://"):
+				e.add("totp", line)
+			default:
+				e.add("", line)
+			}
+		}
+		crypto.Wipe(out)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readDotenv reads NAME=value lines, each of which is a secret whose key is
+// NAME and whose value is value. It reads what a shell would: an export
+// before the name, a value in single quotes taken as it is, and one in double
+// quotes with its \n, \", \\ and \$ escapes. Comments and blank lines are
+// skipped.
+func readDotenv(src []byte) ([]entry, error) {
+	var entries []entry
+	lines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n := i + 1
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d is not NAME=value", n)
+		}
+		value = strings.TrimLeft(value, " \t")
+		if quote := byte(0); len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+			quote = value[0]
+			// A quoted value may go on over several lines.
+			for !closesQuote(value[1:], quote) {
+				if i++; i == len(lines) {
+					return nil, fmt.Errorf("line %d opens a quote that is never closed", n)
+				}
+				value += "\n" + lines[i]
+			}
+			end := closingQuote(value[1:], quote) + 1
+			value = value[1:end]
+			if quote == '"' {
+				value = unescapeDouble(value)
+			}
+		} else if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimRight(value[:j], " \t")
+		} else {
+			value = strings.TrimRight(value, " \t")
+		}
+		e := entry{title: name}
+		e.add("", value)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func closesQuote(s string, quote byte) bool { return closingQuote(s, quote) >= 0 }
+
+// closingQuote returns the index of the quote that ends s, or -1. In double
+// quotes a backslash escapes the character after it.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$', '`':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,168 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/cli"
+)
+
+func convert(t *testing.T, format cli.Format, src string) string {
+	t.Helper()
+	b, n, err := Convert(format, []byte(src))
+	if err != nil {
+		t.Fatalf("Convert(%s) failed: %v", format, err)
+	}
+	if got := strings.Count(string(b), "\n\n") + 1; n != got && len(b) > 0 {
+		t.Errorf("Convert(%s) counted %d secrets, but wrote %d", format, n, got)
+	}
+	return string(b)
+}
+
+func TestConvertCSV(t *testing.T) {
+	src := "\ufeffName,URL,Username,Password,Notes,Favorite,Fields\n" +
+		"GitHub,https://github.com,alice,\"pa,ss\",\"first line\n\nsecond: line\",1,\"pin: 1234\"\n" +
+		"\"Bank\nAccount\",,bob,hunter2,,0,\n" +
+		",https://untitled.example,carol,pw,,,\n"
+	want := "Bank Account\nusername: bob\npassword: hunter2\n\n" +
+		"GitHub\nurl: https://github.com\nusername: alice\npassword: pa,ss\npin: 1234\nnotes: first line\n  second: line\n\n" +
+		"https://untitled.example\nurl: https://untitled.example\nusername: carol\npassword: pw\n"
+	if got := convert(t, cli.FormatCSV, src); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	// A browser's export has no titles, so its URLs name its entries.
+	if got := convert(t, cli.FormatCSV, "url,username,password\nhttps://a.example,u,p\n"); got != "https://a.example\nurl: https://a.example\nusername: u\npassword: p\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, _, err := Convert(cli.FormatCSV, []byte("username,password\nu,p\n")); err == nil || !strings.Contains(err.Error(), "no title, name or URL column") {
+		t.Errorf("expected a CSV with nothing to name its entries by to be refused, got %v", err)
+	}
+}
+
+func TestConvert1PasswordCSV(t *testing.T) {
+	src := "Title,Website,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n" +
+		"AWS,https://aws.amazon.com,root,s3cret,otpauth://totp/aws?secret=ABC,false,false,work,MFA on\n"
+	want := "AWS\nurl: https://aws.amazon.com\nusername: root\npassword: s3cret\ntotp: otpauth://totp/aws?secret=ABC\ntags: work\nnotes: MFA on\n"
+	if got := convert(t, cli.Format1PasswordCSV, src); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestConvertBitwardenJSON(t *testing.T) {
+	src := `{"encrypted": false,
+	"folders": [{"id": "f1", "name": "Work"}],
+	"items": [
+		{"type": 1, "name": "GitHub", "folderId": "f1", "notes": null,
+		 "login": {"username": "alice", "password": "pw", "totp": null,
+		           "uris": [{"match": null, "uri": "https://github.com"}, {"uri": "https://gist.github.com"}]},
+		 "fields": [{"name": "recovery", "value": "abcd-efgh", "type": 1}, {"name": "linked", "value": null, "type": 3}]},
+		{"type": 3, "name": "Visa", "folderId": null, "notes": "call the bank",
+		 "card": {"cardholderName": "Alice", "brand": "Visa", "number": "4111", "expMonth": "1", "expYear": "2030", "code": "123"}},
+		{"type": 2, "name": "Note", "notes": "line one\nline two", "secureNote": {"type": 0}}
+	]}`
+	want := "GitHub\nusername: alice\npassword: pw\nurl: https://github.com\nurl: https://gist.github.com\nrecovery: abcd-efgh\nfolder: Work\n\n" +
+		"Note\nnotes: line one\n  line two\n\n" +
+		"Visa\ncardholderName: Alice\nbrand: Visa\nnumber: 4111\nexpMonth: 1\nexpYear: 2030\ncode: 123\nnotes: call the bank\n"
+	if got := convert(t, cli.FormatBitwardenJSON, src); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if _, _, err := Convert(cli.FormatBitwardenJSON, []byte(`{"encrypted": true, "items": []}`)); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("expected an encrypted export to be refused, got %v", err)
+	}
+}
+
+func TestConvertKeePassXML(t *testing.T) {
+	src := `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta><RecycleBinUUID>BIN</RecycleBinUUID></Meta>
+	<Root>
+		<Group>
+			<UUID>ROOT</UUID><Name>Database</Name>
+			<Entry>
+				<String><Key>Notes</Key><Value>a &amp; b</Value></String>
+				<String><Key>Password</Key><Value ProtectInMemory="True">pw</Value></String>
+				<String><Key>Title</Key><Value>Router</Value></String>
+				<String><Key>UserName</Key><Value>admin</Value></String>
+				<String><Key>PIN</Key><Value>0000</Value></String>
+				<History><Entry><String><Key>Title</Key><Value>Old router</Value></String></Entry></History>
+			</Entry>
+			<Group>
+				<UUID>G1</UUID><Name>Internet</Name>
+				<Group>
+					<UUID>G2</UUID><Name>Email</Name>
+					<Entry>
+						<String><Key>Title</Key><Value>Mail</Value></String>
+						<String><Key>URL</Key><Value>https://mail.example</Value></String>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>BIN</UUID><Name>Recycle Bin</Name>
+				<Entry><String><Key>Title</Key><Value>Deleted</Value></String></Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+	want := "Mail\nurl: https://mail.example\ngroup: Internet/Email\n\n" +
+		"Router\nusername: admin\npassword: pw\nPIN: 0000\nnotes: a & b\n"
+	if got := convert(t, cli.FormatKeePassXML, src); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestConvertPassStore(t *testing.T) {
+	dir := t.TempDir()
+	shown := map[string]string{
+		"email/work":  "hunter2\nuser: alice\n\notpauth://totp/work?secret=ABC\n",
+		"bank":        "1234\n",
+		".git/config": "not an entry\n",
+	}
+	for name := range shown {
+		p := filepath.Join(dir, name+".gpg")
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(f func(string, string) ([]byte, error)) { passShow = f }(passShow)
+	passShow = func(d, name string) ([]byte, error) {
+		if d != dir {
+			t.Errorf("expected pass to be run on %s, got %s", dir, d)
+		}
+		return []byte(shown[name]), nil
+	}
+
+	want := "bank\npassword: 1234\n\nemail/work\npassword: hunter2\nuser: alice\ntotp: otpauth://totp/work?secret=ABC\n"
+	if got := convert(t, cli.FormatPass, dir); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestConvertDotenv(t *testing.T) {
+	src := "# comment\n" +
+		"export API_KEY=abc123\n" +
+		"PLAIN = spaced value # trailing comment\n" +
+		"SINGLE='it''s $raw'\n" +
+		"DOUBLE=\"a \\\"b\\\"\\nc \\$d\"\n" +
+		"MULTI=\"line one\n\nline two\"\n" +
+		"EMPTY=\n"
+	want := "API_KEY\nabc123\n\n" +
+		"DOUBLE\na \"b\"\nc $d\n\n" +
+		"EMPTY\n\n" +
+		"MULTI\nline one\nline two\n\n" +
+		"PLAIN\nspaced value\n\n" +
+		"SINGLE\nit\n"
+	if got := convert(t, cli.FormatDotenv, src); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	for _, bad := range []string{"NOEQUALS\n", "TWO WORDS=x\n", "OPEN=\"never closed\n"} {
+		if _, _, err := Convert(cli.FormatDotenv, []byte(bad)); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+}
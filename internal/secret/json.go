@@ -0,0 +1,137 @@
+package secret
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/andornaut/mrs/internal/cli"
+)
+
+// JSON returns the secrets as --format json or ndjson prints them, from the
+// vault named vault. Each secret is an object with its key and the lines of
+// its value, or, when field is given, the values of that field. json is one
+// object that holds them along with the vault's name and how many there are;
+// ndjson is one object a line, each with the vault's name.
+//
+// It is built by hand rather than by encoding/json, which would copy every key
+// and line into a string that cannot be wiped. Like Bytes, it is sized once
+// and the caller is responsible for wiping it.
+func (s *secretList) JSON(vault string, format cli.Format, field []byte) []byte {
+	return encodeSized(func(w *sizedWriter) {
+		if format == cli.FormatNDJSON {
+			for _, secret := range s.secrets {
+				w.raw(`{"vault":`)
+				w.jsonString([]byte(vault))
+				w.raw(",")
+				secret.writeJSON(w, field)
+				w.raw("}\n")
+			}
+			return
+		}
+		w.raw(`{"vault":`)
+		w.jsonString([]byte(vault))
+		w.raw(`,"count":`)
+		w.raw(strconv.Itoa(len(s.secrets)))
+		w.raw(`,"secrets":[`)
+		for i, secret := range s.secrets {
+			if i > 0 {
+				w.raw(",")
+			}
+			w.raw("{")
+			secret.writeJSON(w, field)
+			w.raw("}")
+		}
+		w.raw("]}\n")
+	})
+}
+
+// writeJSON writes the secret's members: its key, and its value's lines or the
+// values of field.
+func (s secret) writeJSON(w *sizedWriter, field []byte) {
+	w.raw(`"key":`)
+	w.jsonString(s.Key())
+	if field != nil {
+		w.raw(`,"field":`)
+		w.jsonString(field)
+	}
+	w.raw(`,"value":[`)
+	for i, line := range s.lines(field) {
+		if i > 0 {
+			w.raw(",")
+		}
+		w.jsonString(line)
+	}
+	w.raw("]")
+}
+
+// sizedWriter writes into a buffer, or, while sizing, only counts how long the
+// buffer has to be. See encodeSized.
+type sizedWriter struct {
+	buf    []byte
+	n      int
+	sizing bool
+}
+
+// encodeSized runs write twice: once to size the buffer and once to fill it, so
+// that growing it cannot leave a half-filled copy of the secrets behind.
+func encodeSized(write func(*sizedWriter)) []byte {
+	w := &sizedWriter{sizing: true}
+	write(w)
+	w.buf, w.sizing = make([]byte, 0, w.n), false
+	write(w)
+	return w.buf
+}
+
+func (w *sizedWriter) raw(s string) {
+	if w.sizing {
+		w.n += len(s)
+		return
+	}
+	w.buf = append(w.buf, s...)
+}
+
+// jsonString writes b as a JSON string, escaped as encoding/json would escape
+// it, short of its HTML escaping. Bytes that are not UTF-8 are written as U+FFFD,
+// which is all a JSON string can hold of them, and U+2028 and U+2029 are
+// escaped so that the output is also valid JavaScript.
+func (w *sizedWriter) jsonString(b []byte) {
+	const hex = "0123456789abcdef"
+	w.raw(`"`)
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		switch {
+		case r == '"':
+			w.raw(`\"`)
+		case r == '\\':
+			w.raw(`\\`)
+		case r == '\n':
+			w.raw(`\n`)
+		case r == '\r':
+			w.raw(`\r`)
+		case r == '\t':
+			w.raw(`\t`)
+		case r < 0x20:
+			w.raw(`\u00`)
+			w.raw(hex[r>>4 : r>>4+1])
+			w.raw(hex[r&0xf : r&0xf+1])
+		case r == utf8.RuneError && n == 1:
+			w.raw(`\ufffd`)
+		case r == '\u2028':
+			w.raw(`\u2028`)
+		case r == '\u2029':
+			w.raw(`\u2029`)
+		default:
+			w.bytes(b[:n])
+		}
+		b = b[n:]
+	}
+	w.raw(`"`)
+}
+
+func (w *sizedWriter) bytes(b []byte) {
+	if w.sizing {
+		w.n += len(b)
+		return
+	}
+	w.buf = append(w.buf, b...)
+}
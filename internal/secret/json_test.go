@@ -0,0 +1,85 @@
+package secret
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/cli"
+)
+
+// The writer is built by hand, so encoding/json is the judge of whether what
+// it writes is JSON, and of whether it reads back as the bytes that went in.
+func TestJSONReadsBackAsTheSecrets(t *testing.T) {
+	lines := []string{
+		`quote " and backslash \`,
+		"\ttab, carriage return \r, bell \a and escape \x1b",
+		"naïve ☕ and U+2028 \u2028 and U+2029 \u2029",
+		"",
+		"user: alice",
+	}
+	b := newSecretList([]secret{
+		secret("key only\n"),
+		secret("a \"key\"\n" + strings.Join(lines, "\n") + "\n"),
+	})
+
+	var doc struct {
+		Vault   string `json:"vault"`
+		Count   int    `json:"count"`
+		Secrets []struct {
+			Key   string   `json:"key"`
+			Value []string `json:"value"`
+		} `json:"secrets"`
+	}
+	out := b.JSON("wörk", cli.FormatJSON, nil)
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, out)
+	}
+	if doc.Vault != "wörk" || doc.Count != 2 || len(doc.Secrets) != 2 {
+		t.Fatalf("expected vault wörk with 2 secrets, got %+v", doc)
+	}
+	if got := doc.Secrets[0]; got.Key != `a "key"` || !slices.Equal(got.Value, lines) {
+		t.Errorf("expected key %q with %q, got %q with %q", `a "key"`, lines, got.Key, got.Value)
+	}
+	// A secret with no value has an empty list of lines, not null.
+	if !strings.Contains(string(out), `{"key":"key only","value":[]}`) {
+		t.Errorf("expected an empty value list, got %s", out)
+	}
+	if out[len(out)-1] != '\n' {
+		t.Errorf("expected a final newline, got %q", out)
+	}
+	if len(out) != cap(out) {
+		t.Errorf("expected a buffer sized once, got len %d and cap %d", len(out), cap(out))
+	}
+}
+
+func TestJSONWritesWhatItCannotReadBackAsReplacementCharacters(t *testing.T) {
+	b := newSecretList([]secret{secret("bad \xff\xfe bytes\n")})
+	var doc struct {
+		Key string `json:"key"`
+	}
+	out := b.JSON("work", cli.FormatNDJSON, nil)
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, out)
+	}
+	if doc.Key != "bad \ufffd\ufffd bytes" {
+		t.Errorf("got %q", doc.Key)
+	}
+}
+
+func TestNDJSONIsOneSecretALine(t *testing.T) {
+	b := newSecretList([]secret{
+		secret("Apple\nurl: https://apple.example\npassword: red\n"),
+		secret("Banana\npassword: yellow\n"),
+	})
+	out := string(b.JSON("work", cli.FormatNDJSON, []byte("password")))
+	want := `{"vault":"work","key":"Apple","field":"password","value":["red"]}` + "\n" +
+		`{"vault":"work","key":"Banana","field":"password","value":["yellow"]}` + "\n"
+	if out != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out)
+	}
+	if got := b.WithKey([]byte("nothing")).JSON("work", cli.FormatNDJSON, nil); len(got) != 0 {
+		t.Errorf("expected nothing for no secrets, got %q", got)
+	}
+}
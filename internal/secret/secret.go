@@ -1,13 +1,18 @@
 package secret
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/andornaut/mrs/internal/cli"
 	"github.com/andornaut/mrs/internal/crypto"
 	"github.com/andornaut/mrs/internal/prompt"
+	"github.com/andornaut/mrs/internal/totp"
 	"github.com/andornaut/mrs/internal/vault"
 )
 
@@ -82,8 +87,22 @@ func Edit(assumeYes bool, v vault.UnlockedVault) (bool, error) {
 // merge them, and a search returns each of them, so the user is told rather
 // than left to discover it.
 func warnDuplicateKeys(b *secretList) {
-	// The keys counted here are the keys printed below, so holding one as a
-	// string adds no exposure that the warning itself does not.
+	for _, d := range duplicateKeys(b) {
+		fmt.Fprintf(os.Stderr, "Warning: %d secrets share the key %q\n", d.n, d.key)
+	}
+}
+
+// duplicate is a key that n secrets share.
+type duplicate struct {
+	key string
+	n   int
+}
+
+// duplicateKeys returns the keys that more than one secret shares, in the
+// order that the secrets are sorted in.
+func duplicateKeys(b *secretList) []duplicate {
+	// The keys counted here are the keys the caller prints, so holding one as
+	// a string adds no exposure that printing it does not.
 	counts := make(map[string]int, b.Len())
 	var duplicated []string
 	for _, s := range b.secrets {
@@ -93,9 +112,29 @@ func warnDuplicateKeys(b *secretList) {
 			duplicated = append(duplicated, k)
 		}
 	}
+	ds := make([]duplicate, 0, len(duplicated))
 	for _, k := range duplicated {
-		fmt.Fprintf(os.Stderr, "Warning: %d secrets share the key %q\n", counts[k], k)
+		ds = append(ds, duplicate{k, counts[k]})
 	}
+	return ds
+}
+
+// Summary is what a vault holds, counted rather than shown.
+type Summary struct {
+	Secrets       int
+	DuplicateKeys int
+}
+
+// Summarize counts the secrets in a vault's plaintext, and the keys that more
+// than one of them share. The caller owns plaintext and is responsible for
+// wiping it.
+func Summarize(plaintext []byte) (Summary, error) {
+	b, err := parseSecrets(plaintext)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer b.Wipe()
+	return Summary{Secrets: b.Len(), DuplicateKeys: len(duplicateKeys(b))}, nil
 }
 
 // Validate reports whether mrs can read the given secrets back. Every command
@@ -110,21 +149,393 @@ func Validate(b []byte) error {
 	return nil
 }
 
-// Search returns the secrets from a vault that match a regular expression, in
-// the shape a vault is written in, along with how many matched. The caller is
-// responsible for wiping the returned slice.
-func Search(v vault.UnlockedVault, r regexp.Regexp, includeValues bool) ([]byte, int, error) {
+// Query is what a search looks for in a vault's secrets, and what it returns
+// of each that it finds.
+type Query struct {
+	Pattern *regexp.Regexp
+	// IncludeValues matches Pattern against whole secrets rather than keys.
+	IncludeValues bool
+	// MatchField matches Pattern against the values of the field of this name
+	// only. See secret.Field.
+	MatchField string
+	// Field returns the values of the field of this name, rather than whole
+	// secrets, and leaves out any secret that does not have it.
+	Field string
+	// Format is the shape to return the matches in: as a vault is written, or
+	// as JSON. See secretList.JSON.
+	Format cli.Format
+}
+
+// Search returns the secrets from a vault that match a query, in the shape a
+// vault is written in or as the values of q.Field, along with how many
+// matched. The caller is responsible for wiping the returned slice.
+func Search(v vault.UnlockedVault, q Query) ([]byte, int, error) {
 	b, err := readSecrets(v)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer b.Wipe()
 
-	matched := b.SearchKeys(r)
-	if includeValues {
-		matched = b.SearchKeysAndValues(r)
-	}
+	matched, field := q.match(b)
 	// Bytes copies, which has to happen before the deferred wipe: a match holds
 	// the same memory as the secret it was found in.
+	switch {
+	case q.Format == cli.FormatJSON || q.Format == cli.FormatNDJSON:
+		return matched.JSON(v.Name(), q.Format, field), matched.Len(), nil
+	case field != nil:
+		return matched.FieldBytes(field), matched.Len(), nil
+	}
 	return matched.Bytes(), matched.Len(), nil
 }
+
+// SearchOne returns the key and value of the one secret in a vault that
+// matches a query, or with q.Field that field's values, joined by newlines with
+// no newline after the last, as Lookup joins them. When not exactly one
+// matched, it returns only how many did. q.Format is ignored. The caller is
+// responsible for wiping the returned slice.
+func SearchOne(v vault.UnlockedVault, q Query) (string, []byte, int, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	defer b.Wipe()
+
+	matched, field := q.match(b)
+	if matched.Len() != 1 {
+		return "", nil, matched.Len(), nil
+	}
+	s := matched.secrets[0]
+	return string(s.Key()), joinLines(s.lines(field)), 1, nil
+}
+
+// match returns the secrets in b that q matches, and the name of the field it
+// returns of each, or nil for whole secrets. What it returns shares b's
+// memory.
+func (q Query) match(b *secretList) (*secretList, []byte) {
+	var matched *secretList
+	switch {
+	case q.MatchField != "":
+		matched = b.SearchField(*q.Pattern, []byte(q.MatchField))
+	case q.IncludeValues:
+		matched = b.SearchKeysAndValues(*q.Pattern)
+	default:
+		matched = b.SearchKeys(*q.Pattern)
+	}
+	var field []byte
+	if q.Field != "" {
+		field = []byte(q.Field)
+		matched = matched.HavingField(field)
+	}
+	return matched, field
+}
+
+// Result is what a search found in one of several vaults: as Search returned
+// it, and how many secrets it matched.
+type Result struct {
+	Vault   string
+	Count   int
+	Secrets []byte
+}
+
+// JoinResults returns the results of a search of several vaults as one. Text
+// puts each vault that had a match under a line that names it and counts its
+// matches. JSON is one object that holds each vault's, and how many secrets
+// matched in all; NDJSON is each vault's lines in turn. Like Bytes, it is
+// sized once and the caller is responsible for wiping it.
+func JoinResults(format cli.Format, results []Result) []byte {
+	return encodeSized(func(w *sizedWriter) {
+		switch format {
+		case cli.FormatNDJSON:
+			for _, r := range results {
+				w.bytes(r.Secrets)
+			}
+		case cli.FormatJSON:
+			total := 0
+			for _, r := range results {
+				total += r.Count
+			}
+			w.raw(`{"count":`)
+			w.raw(strconv.Itoa(total))
+			w.raw(`,"vaults":[`)
+			for i, r := range results {
+				if i > 0 {
+					w.raw(",")
+				}
+				w.bytes(bytes.TrimSuffix(r.Secrets, []byte{'\n'}))
+			}
+			w.raw("]}\n")
+		default:
+			first := true
+			for _, r := range results {
+				if r.Count == 0 {
+					continue
+				}
+				if !first {
+					w.raw("\n")
+				}
+				first = false
+				w.raw(fmt.Sprintf("== %s: %d %s ==\n\n", r.Vault, r.Count, cli.Plural(r.Count, "secret")))
+				w.bytes(r.Secrets)
+			}
+		}
+	})
+}
+
+// ErrNoSuchKey reports that no secret in a vault has the key asked for.
+var ErrNoSuchKey = errors.New("no secret has the key")
+
+// ErrAmbiguousKey reports that more than one secret in a vault has the key
+// asked for, so there is no telling which one was meant.
+var ErrAmbiguousKey = errors.New("more than one secret has the key")
+
+// ErrNoSuchField reports that the secret asked for has no field of the name
+// asked for.
+var ErrNoSuchField = errors.New("has no field")
+
+// Ref names a value in a vault: the value of the secret whose key is Key,
+// ignoring case, or, when Field is set, that field's value in it.
+type Ref struct {
+	Key   string
+	Field string
+}
+
+func (r Ref) String() string {
+	if r.Field == "" {
+		return r.Key
+	}
+	return r.Key + ":" + r.Field
+}
+
+// Lookup returns the value each ref names, reading the vault once however many
+// there are. A value is its lines, or its field's values, joined by newlines
+// with no newline after the last, as a shell's $(mrs get key) would have it. It
+// returns ErrNoSuchKey, ErrAmbiguousKey or ErrNoSuchField for the first ref
+// that names no single value. The caller is responsible for wiping each of the
+// returned slices.
+func Lookup(v vault.UnlockedVault, refs []Ref) ([][]byte, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Wipe()
+
+	values := make([][]byte, 0, len(refs))
+	for _, r := range refs {
+		value, err := lookup(b, v, r)
+		if err != nil {
+			for _, value := range values {
+				crypto.Wipe(value)
+			}
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// one returns the one secret in s whose key is key, ignoring case. It returns
+// ErrNoSuchKey or ErrAmbiguousKey, naming v, when there is not exactly one.
+func (s *secretList) one(key string, v vault.UnlockedVault) (secret, error) {
+	matched := s.WithKey([]byte(key))
+	switch matched.Len() {
+	case 0:
+		return nil, fmt.Errorf("%w %q in vault %s", ErrNoSuchKey, key, v)
+	case 1:
+		return matched.secrets[0], nil
+	default:
+		return nil, fmt.Errorf("%w %q in vault %s: %d secrets share it", ErrAmbiguousKey, key, v, matched.Len())
+	}
+}
+
+// lookup returns a copy of the value r names in b. See Lookup.
+func lookup(b *secretList, v vault.UnlockedVault, r Ref) ([]byte, error) {
+	s, err := b.one(r.Key, v)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	if r.Field != "" {
+		if lines = s.Field([]byte(r.Field)); len(lines) == 0 {
+			return nil, fmt.Errorf("the secret %q in vault %s %w %q", r.Key, v, ErrNoSuchField, r.Field)
+		}
+	} else {
+		lines = s.lines(nil)
+	}
+	return joinLines(lines), nil
+}
+
+// Get returns the value of the one secret in a vault whose key is key,
+// ignoring case: every line after the key, or only the lineth of them when
+// line is more than zero. It returns ErrNoSuchKey or ErrAmbiguousKey when there
+// is not exactly one such secret. The caller is responsible for wiping the
+// returned slice.
+func Get(v vault.UnlockedVault, key string, line int) ([]byte, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Wipe()
+
+	s, err := b.one(key, v)
+	if err != nil {
+		return nil, err
+	}
+	value := s.Value()
+	if line > 0 {
+		lines := bytes.SplitAfter(value, []byte{'\n'})
+		if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+		if line > len(lines) {
+			return nil, fmt.Errorf("the secret %q in vault %s has %d value %s, so there is no line %d",
+				key, v, len(lines), cli.Plural(len(lines), "line"), line)
+		}
+		value = lines[line-1]
+	}
+	// A copy, which has to be made before the deferred wipe: the value shares
+	// the memory of the secret it was read from.
+	return append([]byte{}, value...), nil
+}
+
+// TOTPSeed returns the two-factor seed in the one secret in a vault whose key
+// is key, ignoring case: the first line of its value that is an
+// otpauth://totp/ URI, or failing one, the value of its totp field, which
+// may be a URI or a bare seed. It returns ErrNoSuchField when the secret has
+// neither. The caller is responsible for wiping the returned slice.
+func TOTPSeed(v vault.UnlockedVault, key string) ([]byte, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Wipe()
+
+	s, err := b.one(key, v)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range s.lines(nil) {
+		if line = bytes.TrimSpace(line); totp.IsURI(line) {
+			return append([]byte{}, line...), nil
+		}
+	}
+	if values := s.Field([]byte("totp")); len(values) > 0 {
+		return append([]byte{}, bytes.TrimSpace(values[0])...), nil
+	}
+	return nil, fmt.Errorf("the secret %q in vault %s has no otpauth://totp/ line, and %w \"totp\"", key, v, ErrNoSuchField)
+}
+
+// Set gives the secret whose key is key, ignoring case, the value value, and
+// adds it if there is none. value is the lines after the key; a trailing
+// newline is optional. When several secrets have the key it returns
+// ErrAmbiguousKey, unless all is set, when they are replaced by the one. It
+// reports how many secrets it replaced. The caller owns value and is
+// responsible for wiping it.
+func Set(v vault.UnlockedVault, key string, value []byte, all bool) (int, error) {
+	s, err := newSecret(key, value)
+	if err != nil {
+		return 0, err
+	}
+	defer crypto.Wipe(s)
+
+	b, err := readSecrets(v)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Wipe()
+
+	n := b.WithKey([]byte(key)).Len()
+	if n > 1 && !all {
+		return 0, fmt.Errorf("%w %q in vault %s: %d secrets share it", ErrAmbiguousKey, key, v, n)
+	}
+	// The list built here shares the memory of b and s, which are wiped.
+	out := b.WithoutKey([]byte(key)).Combined(&secretList{[]secret{s}}).Bytes()
+	defer crypto.Wipe(out)
+	if err := v.Write(out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Create adds a secret whose key is key and whose value is value. Unlike Set,
+// it refuses a key that a secret already has, ignoring case: a value made up
+// for a new secret would otherwise replace one that may still be in use. The
+// caller owns value and is responsible for wiping it.
+func Create(v vault.UnlockedVault, key string, value []byte) error {
+	s, err := newSecret(key, value)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(s)
+
+	b, err := readSecrets(v)
+	if err != nil {
+		return err
+	}
+	defer b.Wipe()
+
+	if b.WithKey([]byte(key)).Len() > 0 {
+		return fmt.Errorf("vault %s already has a secret %q. Use \"mrs set\" to replace its value", v, key)
+	}
+	out := b.Combined(&secretList{[]secret{s}}).Bytes()
+	defer crypto.Wipe(out)
+	return v.Write(out)
+}
+
+// Remove removes the secret whose key is key, ignoring case. It returns
+// ErrNoSuchKey when there is none, and ErrAmbiguousKey when there are several,
+// unless all is set, when it removes every one. It reports how many it
+// removed.
+func Remove(v vault.UnlockedVault, key string, all bool) (int, error) {
+	b, err := readSecrets(v)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Wipe()
+
+	switch n := b.WithKey([]byte(key)).Len(); {
+	case n == 0:
+		return 0, fmt.Errorf("%w %q in vault %s", ErrNoSuchKey, key, v)
+	case n > 1 && !all:
+		return 0, fmt.Errorf("%w %q in vault %s: %d secrets share it", ErrAmbiguousKey, key, v, n)
+	default:
+		out := b.WithoutKey([]byte(key)).Bytes()
+		defer crypto.Wipe(out)
+		if err := v.Write(out); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+}
+
+// newSecret returns the secret with the given key and value, and refuses one
+// that would not read back as a single secret with that key: a key that is
+// blank or has more than one line, or a value with a blank line in it, which
+// would split it in two. The caller is responsible for wiping it.
+func newSecret(key string, value []byte) (secret, error) {
+	if strings.TrimSpace(key) == "" {
+		return nil, errors.New("a secret's key cannot be blank")
+	}
+	if strings.ContainsAny(key, "\r\n") {
+		return nil, errors.New("a secret's key is its first line, so it cannot contain a line break")
+	}
+	value = bytes.TrimRight(value, "\r\n")
+	s := make(secret, 0, len(key)+1+len(value)+1)
+	s = append(s, key...)
+	s = append(s, '\n')
+	if len(value) > 0 {
+		s = append(s, value...)
+		s = append(s, '\n')
+	}
+	defer crypto.Wipe(s)
+	// What is stored is what parsing makes of it, as for a secret typed in an
+	// editor, so that a value with Windows line endings is stored without them.
+	parsed, err := parseSecrets(s)
+	if err != nil {
+		return nil, err
+	}
+	defer parsed.Wipe()
+	if parsed.Len() != 1 {
+		return nil, errors.New("a secret's value cannot contain a blank line, which is what separates one secret from the next")
+	}
+	return append(secret{}, parsed.secrets[0]...), nil
+}
@@ -5,6 +5,7 @@ import (
 	"slices"
 	"testing"
 
+	"github.com/andornaut/mrs/internal/cli"
 	"github.com/andornaut/mrs/internal/crypto"
 )
 
@@ -152,6 +153,62 @@ func TestSecretListSearch(t *testing.T) {
 	}
 }
 
+func TestSecretField(t *testing.T) {
+	s := secret("GitHub\nhttps://github.com\nUser: alice\nurl:\thttps://github.com/alice\nnote:\n  user : indented\nuser: again\n")
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		// Whitespace around a field's name is not part of it.
+		{"user", []string{"alice", "indented", "again"}},
+		{"USER", []string{"alice", "indented", "again"}},
+		{"url", []string{"https://github.com/alice"}},
+		// A colon that ends the line names a field with an empty value.
+		{"note", []string{""}},
+		// A colon followed by anything but whitespace is part of the line, not
+		// the end of a field's name.
+		{"https", nil},
+		// The key is not one of the secret's fields.
+		{"GitHub", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range s.Field([]byte(tt.name)) {
+				got = append(got, string(v))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretListSearchField(t *testing.T) {
+	b := newSecretList([]secret{
+		secret("Apple\nurl: https://apple.example\npassword: red"),
+		secret("Banana\nnote: url is apple.example\npassword: yellow"),
+		secret("Cherry\nurl: https://cherry.example"),
+	})
+
+	got := b.SearchField(*regexp.MustCompile("(?i)apple"), []byte("url"))
+	if got.Len() != 1 || string(got.secrets[0].Key()) != "Apple" {
+		t.Fatalf("expected only Apple to match in its url, got %d secrets", got.Len())
+	}
+
+	having := b.HavingField([]byte("password"))
+	if having.Len() != 2 {
+		t.Fatalf("expected 2 secrets with a password, got %d", having.Len())
+	}
+	if got, want := string(having.FieldBytes([]byte("password"))), "red\nyellow\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := b.WithKey([]byte("nothing")).FieldBytes([]byte("password")); got != nil {
+		t.Errorf("expected no bytes for no secrets, got %q", got)
+	}
+}
+
 // Secrets are ordered by key ignoring case, so "apple" sorts before "Zebra"
 // rather than after every capital letter, which comparing bytes would give.
 // Keys that differ only in length are ordered by it, so a key that is another's
@@ -190,6 +247,63 @@ val`)})
 	}
 }
 
+func TestSummarize(t *testing.T) {
+	got, err := Summarize([]byte("b\n1\n\na\n2\n\nB\n3\n\na\n4\n\na\n5\n"))
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	// "a" is shared three times and counts once; "b" and "B" are different keys.
+	if want := (Summary{Secrets: 5, DuplicateKeys: 1}); got != want {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSecretListWithKey(t *testing.T) {
+	b, err := parseSecrets([]byte("Straße\n1\n\nSTRASSE\n2\n\nstraße\n3\n\nstraßen\n4\n"))
+	if err != nil {
+		t.Fatalf("parseSecrets failed: %v", err)
+	}
+	// Folded rune by rune, as lessFold sorts, so ß is not SS and a longer key
+	// is not a match.
+	got := b.WithKey([]byte("STRAßE"))
+	if got.Len() != 2 {
+		t.Fatalf("expected 2 secrets, got %q", got.Bytes())
+	}
+	if string(got.secrets[0].Value()) != "1\n" && string(got.secrets[0].Value()) != "3\n" {
+		t.Errorf("unexpected value %q", got.secrets[0].Value())
+	}
+	if v := secret("only a key\n").Value(); len(v) != 0 {
+		t.Errorf("expected a secret that is only a key to have no value, got %q", v)
+	}
+}
+
+func TestNewSecretReadsBackAsOneSecret(t *testing.T) {
+	tests := []struct {
+		key, value, want string
+		wantErr          bool
+	}{
+		{"key", "value", "key\nvalue\n", false},
+		{"key", "value\n\n", "key\nvalue\n", false},
+		{"key", "two\r\nlines\r\n", "key\ntwo\nlines\n", false},
+		{"key", "", "key\n", false},
+		{"key", "first\n\nsecond", "", true},
+		{"key", "\nafter a blank line", "", true},
+		{"key", "first\n   \nsecond", "", true},
+		{"", "value", "", true},
+		{"two\nlines", "value", "", true},
+	}
+	for _, tt := range tests {
+		s, err := newSecret(tt.key, []byte(tt.value))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSecret(%q, %q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if string(s) != tt.want {
+			t.Errorf("newSecret(%q, %q) = %q, want %q", tt.key, tt.value, s, tt.want)
+		}
+	}
+}
+
 func TestSecretKey(t *testing.T) {
 	s := secret(`My Key
 My Value
@@ -238,3 +352,27 @@ func TestASecretListOwnsAndWipesItsSecrets(t *testing.T) {
 		}
 	}
 }
+
+func TestJoinResults(t *testing.T) {
+	work := newSecretList([]secret{secret("aws\nid: 1\n")})
+	results := []Result{
+		{Vault: "home", Count: 0, Secrets: newSecretList(nil).JSON("home", cli.FormatJSON, nil)},
+		{Vault: "work", Count: 1, Secrets: work.JSON("work", cli.FormatJSON, nil)},
+	}
+	want := `{"count":1,"vaults":[{"vault":"home","count":0,"secrets":[]},` +
+		`{"vault":"work","count":1,"secrets":[{"key":"aws","value":["id: 1"]}]}]}` + "\n"
+	if got := string(JoinResults(cli.FormatJSON, results)); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	// Text leaves out the vaults that matched nothing.
+	results = []Result{
+		{Vault: "home", Count: 0},
+		{Vault: "work", Count: 1, Secrets: work.Bytes()},
+		{Vault: "zoo", Count: 2, Secrets: []byte("a\n\nb\n")},
+	}
+	want = "== work: 1 secret ==\n\naws\nid: 1\n\n== zoo: 2 secrets ==\n\na\n\nb\n"
+	if got := string(JoinResults(cli.FormatText, results)); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
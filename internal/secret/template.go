@@ -0,0 +1,117 @@
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Placeholder is one {{ mrs "vault/key" "field" }} in a template: the value it
+// names, and where in the template it is. Vault is empty for a placeholder
+// that names only a key, which is looked up in the vault the command was given.
+type Placeholder struct {
+	Vault string
+	Ref   Ref
+	start int
+	end   int
+}
+
+// placeholderUsage is how a placeholder is written, for the error that says one
+// was not.
+const placeholderUsage = `{{ mrs "vault/key" }} or {{ mrs "vault/key" "field" }}`
+
+// ParseTemplate returns the placeholders in a template, in the order they
+// appear. Only a {{ that goes on to "mrs" opens one, so the {{ of another
+// template language is left alone for it to fill; one that does but is not
+// written as a placeholder is an error, which names the template and line.
+func ParseTemplate(name string, tmpl []byte) ([]Placeholder, error) {
+	var placeholders []Placeholder
+	for i := 0; ; {
+		j := bytes.Index(tmpl[i:], []byte("{{"))
+		if j < 0 {
+			return placeholders, nil
+		}
+		start := i + j
+		rest := bytes.TrimLeftFunc(tmpl[start+2:], unicode.IsSpace)
+		after, ok := bytes.CutPrefix(rest, []byte("mrs"))
+		if !ok || len(after) == 0 || !unicode.IsSpace(rune(after[0])) {
+			i = start + 2
+			continue
+		}
+		p, n, err := parsePlaceholder(after)
+		if err != nil {
+			line := 1 + bytes.Count(tmpl[:start], []byte{'\n'})
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		p.start, p.end = start, len(tmpl)-len(after)+n
+		placeholders = append(placeholders, p)
+		i = p.end
+	}
+}
+
+// parsePlaceholder reads what follows the "mrs" of a placeholder, through its
+// closing }}, and returns how many bytes that was.
+func parsePlaceholder(b []byte) (Placeholder, int, error) {
+	var args []string
+	rest := b
+	for {
+		rest = bytes.TrimLeftFunc(rest, unicode.IsSpace)
+		if after, ok := bytes.CutPrefix(rest, []byte("}}")); ok {
+			rest = after
+			break
+		}
+		if len(args) == 2 {
+			return Placeholder{}, 0, fmt.Errorf("a placeholder is %s", placeholderUsage)
+		}
+		quoted, err := strconv.QuotedPrefix(string(rest))
+		if err != nil || quoted[0] == '\'' {
+			return Placeholder{}, 0, fmt.Errorf("a placeholder is %s, with each argument in double quotes", placeholderUsage)
+		}
+		arg, err := strconv.Unquote(quoted)
+		if err != nil {
+			return Placeholder{}, 0, err
+		}
+		args = append(args, arg)
+		rest = rest[len(quoted):]
+	}
+	if len(args) == 0 {
+		return Placeholder{}, 0, fmt.Errorf("a placeholder is %s, and names a key", placeholderUsage)
+	}
+	var p Placeholder
+	p.Ref.Key = args[0]
+	if vaultName, key, ok := strings.Cut(args[0], "/"); ok {
+		p.Vault, p.Ref.Key = vaultName, key
+		if strings.TrimSpace(vaultName) == "" {
+			return Placeholder{}, 0, fmt.Errorf("the placeholder for %q names no vault before its /", args[0])
+		}
+	}
+	if strings.TrimSpace(p.Ref.Key) == "" {
+		return Placeholder{}, 0, fmt.Errorf("the placeholder for %q names no key", args[0])
+	}
+	if len(args) == 2 {
+		if p.Ref.Field = strings.TrimSpace(args[1]); p.Ref.Field == "" {
+			return Placeholder{}, 0, fmt.Errorf("the placeholder for %q names a blank field", args[0])
+		}
+	}
+	return p, len(b) - len(rest), nil
+}
+
+// Render returns the template with each placeholder replaced by the value at
+// the same index in values. Like Bytes, it is sized once, and the caller is
+// responsible for wiping it.
+func Render(tmpl []byte, placeholders []Placeholder, values [][]byte) []byte {
+	n := len(tmpl)
+	for i, p := range placeholders {
+		n += len(values[i]) - (p.end - p.start)
+	}
+	out := make([]byte, 0, n)
+	last := 0
+	for i, p := range placeholders {
+		out = append(out, tmpl[last:p.start]...)
+		out = append(out, values[i]...)
+		last = p.end
+	}
+	return append(out, tmpl[last:]...)
+}
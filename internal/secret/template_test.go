@@ -0,0 +1,61 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tmpl := "user: {{ mrs \"work/postgres\" \"user\" }}\n" +
+		"pass: {{mrs \"work/a/b\"}}\n" +
+		"helm: {{ .Values.mrs }} {{ mrsx }}\n" +
+		"key:  {{ mrs `token` }}\n"
+	placeholders, err := ParseTemplate("t", []byte(tmpl))
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+	want := []Placeholder{
+		{Vault: "work", Ref: Ref{Key: "postgres", Field: "user"}},
+		// Only the first / ends the vault's name; a key may have its own.
+		{Vault: "work", Ref: Ref{Key: "a/b"}},
+		{Ref: Ref{Key: "token"}},
+	}
+	if len(placeholders) != len(want) {
+		t.Fatalf("expected %d placeholders, got %+v", len(want), placeholders)
+	}
+	for i, p := range placeholders {
+		if p.Vault != want[i].Vault || p.Ref != want[i].Ref {
+			t.Errorf("placeholder %d: expected %+v, got %+v", i, want[i], p)
+		}
+	}
+
+	out := Render([]byte(tmpl), placeholders, [][]byte{[]byte("app"), []byte("s3cret"), []byte("t0k")})
+	wantOut := "user: app\npass: s3cret\nhelm: {{ .Values.mrs }} {{ mrsx }}\nkey:  t0k\n"
+	if string(out) != wantOut {
+		t.Errorf("expected %q, got %q", wantOut, out)
+	}
+	if len(out) != cap(out) {
+		t.Errorf("expected a buffer sized once, got len %d and cap %d", len(out), cap(out))
+	}
+}
+
+func TestParseTemplateRefusesAMalformedPlaceholder(t *testing.T) {
+	tests := []struct {
+		tmpl, want string
+	}{
+		{"{{ mrs }}", "names a key"},
+		{"a\nb {{ mrs work/key }}", "t:2: a placeholder is"},
+		{`{{ mrs 'k' }}`, "in double quotes"},
+		{`{{ mrs "k" "f" "x" }}`, "a placeholder is"},
+		{`{{ mrs "k"`, "a placeholder is"},
+		{`{{ mrs "/k" }}`, "names no vault"},
+		{`{{ mrs "work/" }}`, "names no key"},
+		{`{{ mrs "k" " " }}`, "names a blank field"},
+	}
+	for _, tt := range tests {
+		_, err := ParseTemplate("t", []byte(tt.tmpl))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseTemplate(%q) error = %v, want one containing %q", tt.tmpl, err, tt.want)
+		}
+	}
+}
@@ -24,6 +24,15 @@ func (s secret) Key() []byte {
 	return s
 }
 
+// Value returns the lines after the secret's key, each ending in a newline,
+// and nothing for a secret that is only a key. It shares the secret's memory.
+func (s secret) Value() []byte {
+	if _, after, ok := bytes.Cut(s, []byte{'\n'}); ok {
+		return after
+	}
+	return nil
+}
+
 func (s secret) Less(o secret) bool {
 	return lessFold(s.Key(), o.Key())
 }
@@ -44,6 +53,55 @@ func lessFold(a, b []byte) bool {
 	return len(a) < len(b)
 }
 
+// equalFold reports whether a and b are the same ignoring case, which is when
+// neither sorts before the other by lessFold. It folds as it reads, for the
+// same reason lessFold does.
+func equalFold(a, b []byte) bool {
+	for len(a) > 0 && len(b) > 0 {
+		ra, na := utf8.DecodeRune(a)
+		rb, nb := utf8.DecodeRune(b)
+		if unicode.ToLower(ra) != unicode.ToLower(rb) {
+			return false
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) == len(b)
+}
+
+// Field returns the values of the lines in the secret's value that read
+// "<name>: <value>", where name is name ignoring case, in the order they
+// appear. A field's name is everything before the line's first colon, and the
+// colon has to be followed by whitespace or end the line, so that a line such
+// as "https://example.com" is not taken for a field named https. Fields are
+// only read, never written: a secret is stored as it was typed, whether or
+// not its lines look like fields. What it returns shares the secret's memory.
+func (s secret) Field(name []byte) [][]byte {
+	var values [][]byte
+	for rest := s.Value(); len(rest) > 0; {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte{'\n'})
+		n, v, ok := bytes.Cut(line, []byte{':'})
+		if !ok || (len(v) > 0 && v[0] != ' ' && v[0] != '\t') {
+			continue
+		}
+		if equalFold(bytes.TrimSpace(n), name) {
+			values = append(values, bytes.TrimLeft(v, " \t"))
+		}
+	}
+	return values
+}
+
+// MatchField reports whether any of the secret's values of the field name
+// match r.
+func (s secret) MatchField(r regexp.Regexp, name []byte) bool {
+	for _, v := range s.Field(name) {
+		if r.Match(v) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s secret) MatchKey(r regexp.Regexp) bool {
 	return r.Match(s.Key())
 }
@@ -94,6 +152,70 @@ func (s *secretList) SearchKeysAndValues(r regexp.Regexp) *secretList {
 	})
 }
 
+// SearchField returns secrets that have a field named name whose value matches
+// the given regular expression
+func (s *secretList) SearchField(r regexp.Regexp, name []byte) *secretList {
+	return s.search(r, func(s secret, r regexp.Regexp) bool {
+		return s.MatchField(r, name)
+	})
+}
+
+// HavingField returns the secrets that have a field named name
+func (s *secretList) HavingField(name []byte) *secretList {
+	var secrets []secret
+	for _, secret := range s.secrets {
+		if len(secret.Field(name)) > 0 {
+			secrets = append(secrets, secret)
+		}
+	}
+	return newSecretList(secrets)
+}
+
+// FieldBytes returns the values of the field named name in each secret, one
+// to a line. Like Bytes, it is a copy sized once, and the caller is
+// responsible for wiping it.
+func (s *secretList) FieldBytes(name []byte) []byte {
+	var values [][]byte
+	n := 0
+	for _, secret := range s.secrets {
+		for _, v := range secret.Field(name) {
+			values = append(values, v)
+			n += len(v) + 1
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	out := make([]byte, 0, n)
+	for _, v := range values {
+		out = append(out, v...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// WithKey returns the secrets whose key is key, ignoring case
+func (s *secretList) WithKey(key []byte) *secretList {
+	var secrets []secret
+	for _, secret := range s.secrets {
+		if equalFold(secret.Key(), key) {
+			secrets = append(secrets, secret)
+		}
+	}
+	return newSecretList(secrets)
+}
+
+// WithoutKey returns the secrets whose key is not key, ignoring case
+func (s *secretList) WithoutKey(key []byte) *secretList {
+	var secrets []secret
+	for _, secret := range s.secrets {
+		if !equalFold(secret.Key(), key) {
+			secrets = append(secrets, secret)
+		}
+	}
+	return newSecretList(secrets)
+}
+
 func (s *secretList) search(r regexp.Regexp, match func(secret, regexp.Regexp) bool) *secretList {
 	var secrets []secret
 	for _, secret := range s.secrets {
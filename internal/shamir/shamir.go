@@ -0,0 +1,130 @@
+// Package shamir splits a secret into shares, any threshold of which recover
+// it and fewer of which say nothing about it, as Shamir described in "How to
+// Share a Secret" (1979).
+//
+// Each byte of the secret is the constant term of its own random polynomial of
+// degree threshold-1 over GF(2^8), with the polynomial AES uses, and a share is
+// every polynomial evaluated at the same nonzero x. The field is worked in
+// without tables, so that no lookup indexed by a secret byte can be timed.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// Share is one of the shares that Split returns: the point x at which each of
+// the secret's polynomials was evaluated, and the values, one a byte of the
+// secret.
+type Share struct {
+	X byte
+	Y []byte
+}
+
+// Wipe zeroes the share's values.
+func (s *Share) Wipe() {
+	crypto.Wipe(s.Y)
+}
+
+// Split returns n shares of secret, numbered 1 to n, any threshold of which
+// Combine recovers it from. The caller still owns secret, and is responsible
+// for wiping the shares.
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("there is no secret to split")
+	}
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("cannot split a secret into %d shares with a threshold of %d: "+
+			"the threshold must be from 2 to the number of shares, which is at most 255", n, threshold)
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+	coefficients := make([]byte, threshold)
+	defer crypto.Wipe(coefficients)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Y[b] = evaluate(coefficients, shares[i].X)
+		}
+	}
+	return shares, nil
+}
+
+// Combine returns the secret that shares were split from. It cannot tell
+// fewer shares than the threshold, or shares of different secrets, from the
+// right ones: either gives another secret, which the caller has to be able to
+// tell apart from the one it wanted. The caller is responsible for wiping it.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("a secret is recovered from at least 2 shares")
+	}
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.X == 0 {
+			return nil, errors.New("a share cannot be numbered 0")
+		}
+		if seen[s.X] {
+			return nil, fmt.Errorf("share %d was given more than once", s.X)
+		}
+		seen[s.X] = true
+		if len(s.Y) != len(shares[0].Y) {
+			return nil, errors.New("the shares are of secrets of different lengths")
+		}
+	}
+	secret := make([]byte, len(shares[0].Y))
+	// Lagrange interpolation at x = 0, where each polynomial's value is its
+	// constant term. Subtraction is addition, which is XOR, in GF(2^8).
+	for j, sj := range shares {
+		basis := byte(1)
+		for m, sm := range shares {
+			if m != j {
+				basis = mul(basis, mul(sm.X, inverse(sm.X^sj.X)))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(sj.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// evaluate returns the polynomial whose coefficients are c, lowest first, at x.
+func evaluate(c []byte, x byte) byte {
+	var y byte
+	for i := len(c) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ c[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1, in the same eight
+// steps whatever its operands are.
+func mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// inverse returns a's multiplicative inverse, a^254, which is 0 for 0.
+func inverse(a byte) byte {
+	x := mul(a, a)
+	r := x
+	for range 6 {
+		x = mul(x, x)
+		r = mul(r, x)
+	}
+	return r
+}
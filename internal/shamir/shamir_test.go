@@ -0,0 +1,60 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEveryNonzeroElementHasAnInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := mul(byte(a), inverse(byte(a))); got != 1 {
+			t.Fatalf("%d × inverse(%d) = %d, want 1", a, a, got)
+		}
+	}
+	if mul(0x57, 0x83) != 0xc1 {
+		t.Errorf("mul(0x57, 0x83) = %#x, want 0xc1, as FIPS 197 works it", mul(0x57, 0x83))
+	}
+}
+
+// Any threshold of the shares recovers the secret, in any order, and one fewer
+// recovers something else.
+func TestAnyThresholdOfTheSharesRecoversTheSecret(t *testing.T) {
+	secret := []byte("a 32-byte key, or near enough it")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Split() failed: %v", err)
+	}
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var some []Share
+		for _, i := range pick {
+			some = append(some, shares[i])
+		}
+		got, err := Combine(some)
+		if err != nil || !bytes.Equal(got, secret) {
+			t.Errorf("Combine(%v) = %q, %v; want %q", pick, got, err, secret)
+		}
+	}
+	if got, _ := Combine(shares[:2]); bytes.Equal(got, secret) {
+		t.Error("expected two shares of a threshold of three to recover something else")
+	}
+}
+
+func TestSharesThatCannotBeCombinedAreRefused(t *testing.T) {
+	shares, _ := Split([]byte("secret"), 3, 2)
+	other, _ := Split([]byte("longer secret"), 3, 2)
+	for desc, some := range map[string][]Share{
+		"one share":          shares[:1],
+		"a repeated share":   {shares[0], shares[0]},
+		"different lengths":  {shares[0], other[1]},
+		"a share numbered 0": {shares[0], {X: 0, Y: shares[1].Y}},
+	} {
+		if _, err := Combine(some); err == nil {
+			t.Errorf("expected %s to be refused", desc)
+		}
+	}
+	for _, nk := range [][2]int{{3, 1}, {3, 4}, {256, 2}} {
+		if _, err := Split([]byte("secret"), nk[0], nk[1]); err == nil {
+			t.Errorf("expected Split() into %d with a threshold of %d to be refused", nk[0], nk[1])
+		}
+	}
+}
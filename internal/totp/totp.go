@@ -0,0 +1,208 @@
+// Package totp computes the time-based one-time passwords of RFC 6238 from the
+// seeds that authenticator apps are given, as an otpauth://totp/ URI or as the
+// bare base32 seed that a site shows beside its QR code.
+//
+// A seed opens an account as surely as its password does, so it is parsed
+// from the bytes it is stored in without a string copy that could not be
+// wiped, and no error repeats it.
+package totp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// The parameters of a seed that does not say, which are what every
+// authenticator app assumes.
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+)
+
+// Key is a seed and the parameters its codes are computed with.
+type Key struct {
+	seed   []byte
+	hash   func() hash.Hash
+	digits int
+	period time.Duration
+}
+
+var algorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// uriPrefix begins a URI that Parse reads as one. Its scheme and type are
+// matched ignoring case, as a URI's scheme is.
+const uriPrefix = "otpauth://totp/"
+
+// IsURI reports whether b is an otpauth://totp/ URI.
+func IsURI(b []byte) bool {
+	return len(b) >= len(uriPrefix) && strings.EqualFold(string(b[:len(uriPrefix)]), uriPrefix)
+}
+
+// Parse reads an otpauth://totp/ URI, with its secret, algorithm, digits and
+// period parameters, or a bare base32 seed, which has the default parameters.
+// The caller still owns b, and is responsible for wiping the Key.
+func Parse(b []byte) (*Key, error) {
+	b = bytes.TrimSpace(b)
+	k := &Key{hash: sha1.New, digits: DefaultDigits, period: DefaultPeriod}
+	if !IsURI(b) {
+		if bytes.HasPrefix(bytes.ToLower(b), []byte("otpauth://")) {
+			return nil, errors.New("only otpauth://totp/ URIs are supported, not counter-based hotp ones")
+		}
+		seed, err := decodeSeed(b)
+		if err != nil {
+			return nil, err
+		}
+		k.seed = seed
+		return k, nil
+	}
+	_, query, _ := bytes.Cut(b[len(uriPrefix):], []byte{'?'})
+	query, _, _ = bytes.Cut(query, []byte{'#'})
+	var seen bool
+	for param := range bytes.SplitSeq(query, []byte{'&'}) {
+		name, value, _ := bytes.Cut(param, []byte{'='})
+		switch strings.ToLower(string(name)) {
+		case "secret":
+			if seen {
+				k.Wipe()
+				return nil, errors.New("the otpauth URI has more than one secret")
+			}
+			seed, err := decodeSeed(value)
+			if err != nil {
+				return nil, err
+			}
+			k.seed, seen = seed, true
+		case "algorithm":
+			if err := k.setAlgorithm(value); err != nil {
+				k.Wipe()
+				return nil, err
+			}
+		case "digits":
+			n, err := strconv.Atoi(string(value))
+			if err != nil || n < 6 || n > 8 {
+				k.Wipe()
+				return nil, fmt.Errorf("the otpauth URI's digits must be 6, 7 or 8, but is %q", value)
+			}
+			k.digits = n
+		case "period":
+			n, err := strconv.Atoi(string(value))
+			if err != nil || n < 1 || n > 24*60*60 {
+				k.Wipe()
+				return nil, fmt.Errorf("the otpauth URI's period must be a number of seconds from 1 to a day, but is %q", value)
+			}
+			k.period = time.Duration(n) * time.Second
+		}
+	}
+	if !seen {
+		return nil, errors.New("the otpauth URI has no secret")
+	}
+	return k, nil
+}
+
+func (k *Key) setAlgorithm(value []byte) error {
+	name, err := url.QueryUnescape(string(value))
+	if err != nil {
+		return fmt.Errorf("the otpauth URI's algorithm %q is not escaped properly", value)
+	}
+	h, ok := algorithms[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("the otpauth URI's algorithm must be SHA1, SHA256 or SHA512, but is %q", value)
+	}
+	k.hash = h
+	return nil
+}
+
+// decodeSeed decodes a base32 seed as sites write it: in either case, perhaps
+// in groups separated by spaces or dashes, and with or without its padding,
+// any of which a URI may have escaped. The caller is responsible for wiping
+// the result.
+func decodeSeed(b []byte) ([]byte, error) {
+	invalid := errors.New("the TOTP seed is not valid base32")
+	clean := make([]byte, 0, len(b))
+	defer func() { crypto.Wipe(clean) }()
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '%':
+			var unescaped [1]byte
+			if i+2 >= len(b) {
+				return nil, invalid
+			}
+			if _, err := hex.Decode(unescaped[:], b[i+1:i+3]); err != nil {
+				return nil, invalid
+			}
+			c = unescaped[0]
+			i += 2
+		case '+':
+			c = ' '
+		}
+		switch {
+		case c == ' ' || c == '-' || c == '=':
+			continue
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		}
+		clean = append(clean, c)
+	}
+	if len(clean) == 0 {
+		return nil, errors.New("the TOTP seed is empty")
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	seed := make([]byte, enc.DecodedLen(len(clean)))
+	n, err := enc.Decode(seed, clean)
+	if err != nil {
+		crypto.Wipe(seed)
+		// Not err, which says where the seed went wrong, and with what.
+		return nil, invalid
+	}
+	return seed[:n], nil
+}
+
+// Code returns the code for the time t, and how long it remains the current
+// one.
+func (k *Key) Code(t time.Time) (string, time.Duration) {
+	period := int64(k.period / time.Second)
+	counter := t.Unix() / period
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	// hmac keeps what it derives from the seed in state of its own, which
+	// cannot be wiped; it lives only as long as this call.
+	mac := hmac.New(k.hash, k.seed)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	defer crypto.Wipe(sum)
+	// RFC 4226's dynamic truncation: the low nibble of the last byte picks
+	// four bytes, of which the top bit is dropped.
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range k.digits {
+		mod *= 10
+	}
+	code := fmt.Sprintf("%0*d", k.digits, n%mod)
+	next := time.Unix((counter+1)*period, 0)
+	return code, next.Sub(t)
+}
+
+// Wipe clears the seed.
+func (k *Key) Wipe() {
+	crypto.Wipe(k.seed)
+}
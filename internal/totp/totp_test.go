@@ -0,0 +1,98 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test vectors of RFC 6238's appendix B, whose seeds are ASCII digits
+// repeated to the length of each algorithm's output.
+func TestCodeMatchesRFC6238(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	for _, tc := range []struct {
+		unix  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	} {
+		for alg, want := range tc.codes {
+			uri := "otpauth://totp/Example:alice?secret=" +
+				base32.StdEncoding.EncodeToString([]byte(seeds[alg])) +
+				"&algorithm=" + strings.ToLower(alg) + "&digits=8&issuer=Example"
+			k, err := Parse([]byte(uri))
+			if err != nil {
+				t.Fatalf("%s: %v", alg, err)
+			}
+			if got, _ := k.Code(time.Unix(tc.unix, 0)); got != want {
+				t.Errorf("%s at %d: got %s, want %s", alg, tc.unix, got, want)
+			}
+			k.Wipe()
+		}
+	}
+}
+
+func TestCodeSaysHowLongItIsCurrent(t *testing.T) {
+	k, err := Parse([]byte("otpauth://totp/x?period=60&secret=GEZDGNBVGY3TQOJQ"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, remaining := k.Code(time.Unix(1000*60+45, 0))
+	if len(code) != DefaultDigits {
+		t.Errorf("expected %d digits, got %q", DefaultDigits, code)
+	}
+	if remaining != 15*time.Second {
+		t.Errorf("expected 15s remaining, got %s", remaining)
+	}
+}
+
+// A bare seed is read as sites show it, and opens the same codes as the URI
+// that carries it.
+func TestABareSeedIsTheURIsSeed(t *testing.T) {
+	now := time.Now()
+	uri, err := Parse([]byte("otpauth://TOTP/x?secret=JBSWY3DPEHPK3PXP%3D"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := Parse([]byte("  jbsw y3dp ehpk 3pxp\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := uri.Code(now)
+	if got, _ := bare.Code(now); got != want {
+		t.Errorf("got %s from the bare seed and %s from the URI", got, want)
+	}
+}
+
+func TestParseRefusesWithoutRepeatingTheSeed(t *testing.T) {
+	for _, s := range []string{
+		"otpauth://totp/x?secret=SECRETSEED1",
+		"otpauth://totp/x?issuer=nobody",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=12",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&secret=JBSWY3DPEHPK3PXP",
+		"otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP&counter=1",
+		"not a seed!",
+		"",
+	} {
+		_, err := Parse([]byte(s))
+		if err == nil {
+			t.Errorf("expected %q to be refused", s)
+			continue
+		}
+		if strings.Contains(err.Error(), "SEED") || strings.Contains(err.Error(), "JBSW") {
+			t.Errorf("the error for %q repeats the seed: %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package vault
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+)
+
+// An archive holds every vault's file, and with it each one's backups if
+// asked, encrypted as they are on disk, for moving them to another machine. It
+// is a tar stream of a manifest, which names each vault and its salt and gives
+// each file's size and SHA-256, followed by the files, under vaults/. A sealed
+// archive is that stream encrypted again, under a password of its own:
+//
+//	mrs-sealed-archive\n | salt | \n | the stream, as crypto.Encrypt seals it
+const (
+	archiveVersion      = 1
+	archiveManifestName = "manifest.json"
+	archiveDir          = "vaults/"
+	archiveSealedMagic  = "mrs-sealed-archive\n"
+	// archiveSealedName is what a sealed archive is sealed as, in place of
+	// the name of a vault.
+	archiveSealedName = "archive"
+)
+
+type archiveManifest struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Vaults  []archivedVault `json:"vaults"`
+}
+
+type archivedVault struct {
+	Name string `json:"name"`
+	Salt string `json:"salt"`
+	archivedFile
+	Backups []archivedFile `json:"backups,omitempty"`
+}
+
+type archivedFile struct {
+	File   string `json:"file"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func newArchivedFile(name string, data []byte) archivedFile {
+	sum := sha256.Sum256(data)
+	return archivedFile{File: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// CreateArchive returns an archive of every vault, and of its backups when
+// withBackups is true, along with the vaults it holds. Nothing is decrypted,
+// so nothing is asked for, and lock files and leftover temporary files are
+// never among what it holds.
+func CreateArchive(withBackups bool) ([]byte, []Vault, error) {
+	vs, err := All()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(vs) == 0 {
+		return nil, nil, errors.New("there are no vaults to archive")
+	}
+	m := archiveManifest{Version: archiveVersion, Created: time.Now().UTC().Truncate(time.Second)}
+	files := map[string][]byte{}
+	var order []string
+	add := func(p string) (archivedFile, error) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return archivedFile{}, err
+		}
+		name := filepath.Base(p)
+		files[name] = data
+		order = append(order, name)
+		return newArchivedFile(name, data), nil
+	}
+	for _, v := range vs {
+		af, err := add(v.Path())
+		if err != nil {
+			return nil, nil, err
+		}
+		av := archivedVault{Name: v.Name(), Salt: v.Salt(), archivedFile: af}
+		if withBackups {
+			paths, err := Backups(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, p := range paths {
+				af, err := add(p)
+				if err != nil {
+					return nil, nil, err
+				}
+				av.Backups = append(av.Backups, af)
+			}
+		}
+		m.Vaults = append(m.Vaults, av)
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	write := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: m.Created}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(archiveManifestName, append(manifest, '\n')); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range order {
+		if err := write(archiveDir+name, files[name]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), vs, nil
+}
+
+// SealArchive encrypts an archive under password, which the caller still
+// owns.
+func SealArchive(archive, password []byte) ([]byte, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	salt, err := crypto.Salt()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := crypto.Encrypt(archive, password, salt, archiveSealedName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(archiveSealedMagic+salt+"\n"), ciphertext...), nil
+}
+
+// IsSealedArchive reports whether data is an archive that SealArchive sealed,
+// which OpenArchive needs its password to open.
+func IsSealedArchive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(archiveSealedMagic))
+}
+
+// OpenArchive decrypts an archive that SealArchive sealed under password,
+// which the caller still owns.
+func OpenArchive(data, password []byte) ([]byte, error) {
+	salt, ciphertext, ok := bytes.Cut(bytes.TrimPrefix(data, []byte(archiveSealedMagic)), []byte("\n"))
+	if !ok || validateSalt(string(salt)) != nil {
+		return nil, errors.New("the sealed archive is damaged: it does not begin with the salt it was sealed with")
+	}
+	archive, _, err := crypto.Decrypt(ciphertext, password, string(salt), archiveSealedName)
+	if err != nil {
+		return nil, fmt.Errorf("%w the archive: the password is not the one it was sealed under, or the archive is damaged", ErrCannotDecrypt)
+	}
+	return archive, nil
+}
+
+// RestoredVault is a vault that RestoreArchive restored: where it was
+// restored to, and what it was called in the archive, which differs for a
+// vault renamed because its name was taken.
+type RestoredVault struct {
+	Vault
+	From    string
+	Backups int
+}
+
+// RestoreArchive restores every vault in an archive, and any backups it holds,
+// under the name and salt each had, once every file in it is found to match
+// its manifest. A vault whose name is taken is refused, and nothing restored,
+// unless rename is true, when it is restored as <name>-restored instead.
+// Renaming a vault whose name is sealed into it re-encrypts it, so password is
+// called for what opens it, with whether it is a team vault; the caller owns
+// what it returns, and RestoreArchive wipes it. keyfile is the digest of the
+// keyfile of any such vault that has one, which the caller still owns. force
+// breaks the lock of another process on each vault's name.
+//
+// The vaults are restored one at a time, so an error partway through is
+// returned along with those that were restored before it.
+func RestoreArchive(archive []byte, rename bool, password func(name string, team bool) ([]byte, error), keyfile []byte, force bool) ([]RestoredVault, error) {
+	m, files, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	var collisions []string
+	for _, av := range m.Vaults {
+		taken[av.Name] = true
+		exists, err := Exists(av.Name)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			collisions = append(collisions, av.Name)
+		}
+	}
+	if len(collisions) == 1 && !rename {
+		return nil, fmt.Errorf("a vault named %q already exists here, so nothing was restored. Delete or rename it, "+
+			"or give --rename to restore the archive's as %s-restored", collisions[0], collisions[0])
+	}
+	if len(collisions) > 1 && !rename {
+		return nil, fmt.Errorf("vaults named %s already exist here, so nothing was restored. Delete or rename them, "+
+			"or give --rename to restore the archive's as <name>-restored", strings.Join(collisions, ", "))
+	}
+
+	var restored []RestoredVault
+	for _, av := range m.Vaults {
+		target := av.Name
+		if exists, err := Exists(av.Name); err != nil {
+			return restored, err
+		} else if exists {
+			if target, err = freeName(av.Name, taken); err != nil {
+				return restored, err
+			}
+			taken[target] = true
+		}
+		r, err := restoreArchived(av, files, target, password, keyfile, force)
+		if err != nil {
+			return restored, err
+		}
+		restored = append(restored, r)
+	}
+	return restored, nil
+}
+
+// freeName returns a name for a vault restored in place of one called name,
+// which neither a vault here nor one in the archive has.
+func freeName(name string, taken map[string]bool) (string, error) {
+	for i := 1; ; i++ {
+		candidate := name + "-restored"
+		if i > 1 {
+			candidate += "-" + strconv.Itoa(i)
+		}
+		if err := ValidateName(candidate); err != nil {
+			return "", fmt.Errorf("cannot rename vault %s to restore it: %w", name, err)
+		}
+		exists, err := Exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists && !taken[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// restoreArchived restores one vault of an archive, and its backups, as the
+// vault named target.
+func restoreArchived(av archivedVault, files map[string][]byte, target string, password func(string, bool) ([]byte, error), keyfile []byte, force bool) (RestoredVault, error) {
+	p, err := toPath(target)
+	if err != nil {
+		return RestoredVault{}, err
+	}
+	unlock, err := Vault(p).ExclusiveLockForce(force)
+	if err != nil {
+		return RestoredVault{}, err
+	}
+	defer unlock()
+	// Asked again under the lock, which is the answer that counts.
+	if exists, err := Exists(target); err != nil {
+		return RestoredVault{}, err
+	} else if exists {
+		return RestoredVault{}, fmt.Errorf("a vault named %q already exists", target)
+	}
+	if p, err = toPathWithSalt(target, av.Salt); err != nil {
+		return RestoredVault{}, err
+	}
+	source, v := Vault(filepath.Join(filepath.Dir(p), av.File)), Vault(p)
+	data := files[av.File]
+
+	var pw []byte
+	if target != av.Name {
+		h, _ := crypto.ReadHeader(data)
+		if h.Name != "" {
+			if pw, err = password(av.Name, h.IsTeam()); err != nil {
+				return RestoredVault{}, err
+			}
+			defer crypto.Wipe(pw)
+			plaintext, ciphertext, err := reseal(data, h, source, v, pw, keyfile)
+			crypto.Wipe(plaintext)
+			if plaintext == nil {
+				if h.Keyfile && keyfile == nil {
+					return RestoredVault{}, fmt.Errorf("vault %s from the archive %w, to be restored as %s. Give the keyfile with --keyfile",
+						av.Name, crypto.ErrNeedsKeyfile, target)
+				}
+				return RestoredVault{}, fmt.Errorf("%w vault %s from the archive, to restore it as %s", ErrCannotDecrypt, av.Name, target)
+			}
+			if err != nil {
+				return RestoredVault{}, err
+			}
+			data = ciphertext
+		}
+	}
+	if err := writeRestored(v.Path(), "", data); err != nil {
+		return RestoredVault{}, err
+	}
+
+	r := RestoredVault{Vault: v, From: av.Name}
+	for _, b := range av.Backups {
+		data := files[b.File]
+		dst := v.Path() + strings.TrimPrefix(b.File, av.File)
+		if pw != nil {
+			h, _ := crypto.ReadHeader(data)
+			plaintext, ciphertext, err := reseal(data, h, source, v, pw, keyfile)
+			crypto.Wipe(plaintext)
+			switch {
+			case plaintext == nil:
+				warnf("the backup %s of vault %s does not open with its current password, so it was restored "+
+					"without being re-encrypted, and opens only as vault %s", filepath.Base(dst), target, av.Name)
+			case err != nil:
+				return r, err
+			default:
+				data = ciphertext
+			}
+		}
+		// Named after the vault, since a backup's own name leaves no room for
+		// a temporary file's suffix.
+		if err := writeRestored(dst, filepath.Base(v.Path()), data); err != nil {
+			return r, fmt.Errorf("restored vault %s but not all of its backups: %w", target, err)
+		}
+		r.Backups++
+	}
+	return r, nil
+}
+
+// writeRestored writes a vault's file, or one of its backups, that was
+// restored from elsewhere, as fs.WriteFileAtomicVia does.
+func writeRestored(p, tempBase string, data []byte) error {
+	if err := fs.WriteFileAtomicVia(p, tempBase, data, 0600); err != nil {
+		if errors.Is(err, fs.ErrDirSync) {
+			warnf("%s was restored but %s", filepath.Base(p), err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// errNotAnArchive reports data that is not an archive at all, rather than one
+// with a mistake in it.
+var errNotAnArchive = errors.New("is not an archive of vaults, which \"mrs archive create\" writes")
+
+// readArchive reads an archive's manifest and files, and checks that they
+// agree: that every vault's file and backups are there, with the names, sizes
+// and SHA-256 the manifest gives, and nothing else is.
+func readArchive(archive []byte) (archiveManifest, map[string][]byte, error) {
+	var m archiveManifest
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) && !first {
+			break
+		}
+		if err != nil || (first && hdr.Name != archiveManifestName) {
+			if first {
+				return m, nil, fmt.Errorf("the file %w", errNotAnArchive)
+			}
+			return m, nil, fmt.Errorf("the archive is damaged: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return m, nil, fmt.Errorf("the archive holds %q, which is not a file", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return m, nil, fmt.Errorf("the archive is damaged: %w", err)
+		}
+		if first {
+			if err := json.Unmarshal(data, &m); err != nil {
+				return m, nil, fmt.Errorf("the archive's manifest is damaged: %w", err)
+			}
+			if m.Version != archiveVersion {
+				return m, nil, errors.New("the archive was written by a newer version of mrs, which can read it")
+			}
+			continue
+		}
+		name, ok := strings.CutPrefix(hdr.Name, archiveDir)
+		// A name that would reach outside the vault directory is refused here,
+		// before any manifest entry is compared with it.
+		if !ok || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return m, nil, fmt.Errorf("the archive holds %q, which is not a vault's file", hdr.Name)
+		}
+		if _, dup := files[name]; dup {
+			return m, nil, fmt.Errorf("the archive holds %s twice", name)
+		}
+		files[name] = data
+	}
+
+	listed := map[string]bool{}
+	check := func(af archivedFile) error {
+		if listed[af.File] {
+			return fmt.Errorf("the archive's manifest lists %s twice", af.File)
+		}
+		listed[af.File] = true
+		data, ok := files[af.File]
+		if !ok {
+			return fmt.Errorf("the archive is missing %s, which its manifest lists", af.File)
+		}
+		if got := newArchivedFile(af.File, data); got != af {
+			return fmt.Errorf("%s in the archive does not match its size and SHA-256 in the manifest", af.File)
+		}
+		return nil
+	}
+	names := map[string]bool{}
+	for _, av := range m.Vaults {
+		if err := validateFilename(av.File); err != nil {
+			return m, nil, fmt.Errorf("the archive's manifest lists %q as a vault: %w", av.File, err)
+		}
+		if av.File != av.Name+"."+av.Salt {
+			return m, nil, fmt.Errorf("the archive's manifest lists %s as vault %s with salt %s", av.File, av.Name, av.Salt)
+		}
+		if names[av.Name] {
+			return m, nil, fmt.Errorf("the archive holds two vaults named %s", av.Name)
+		}
+		names[av.Name] = true
+		if err := check(av.archivedFile); err != nil {
+			return m, nil, err
+		}
+		h, err := crypto.ReadHeader(files[av.File])
+		if err != nil {
+			return m, nil, fmt.Errorf("%s in the archive is not a vault: %w", av.File, err)
+		}
+		if h.Name != "" && h.Name != av.Name {
+			return m, nil, fmt.Errorf("%s in the archive was sealed as vault %s", av.File, h.Name)
+		}
+		for _, b := range av.Backups {
+			if _, ok := backupTime(Vault(av.File), b.File); !ok && b.File != av.File+".bak" {
+				return m, nil, fmt.Errorf("the archive's manifest lists %s as a backup of vault %s", b.File, av.Name)
+			}
+			if err := check(b); err != nil {
+				return m, nil, err
+			}
+		}
+	}
+	if len(m.Vaults) == 0 {
+		return m, nil, errors.New("the archive holds no vaults")
+	}
+	for name := range files {
+		if !listed[name] {
+			return m, nil, fmt.Errorf("the archive holds %s, which its manifest does not list", name)
+		}
+	}
+	return m, files, nil
+}
@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// createVaults creates a vault for each name, with the same password, and
+// saves each once more so that it has a backup.
+func createVaults(t *testing.T, password string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		u, err := Create(name, []byte(password), nil, []byte("a key\nv1\n"), false)
+		if err != nil {
+			t.Fatalf("Create() failed: %v", err)
+		}
+		if err := u.Write([]byte("a key\nv2\n")); err != nil {
+			t.Fatal(err)
+		}
+		u.Wipe()
+	}
+}
+
+func TestAnArchiveRestoresEveryVaultAndItsBackups(t *testing.T) {
+	newVaultDir(t)
+	createVaults(t, "a password", "personal", "work")
+	vs, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{}
+	for _, v := range vs {
+		paths, _ := Backups(v)
+		for _, p := range append(paths, v.Path()) {
+			if want[p], err = os.ReadFile(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	archive, archived, err := CreateArchive(true)
+	if err != nil || len(archived) != 2 {
+		t.Fatalf("CreateArchive() = %v, %v; want 2 vaults", archived, err)
+	}
+	sealed, err := SealArchive(archive, []byte("an archive password"))
+	if err != nil {
+		t.Fatalf("SealArchive() failed: %v", err)
+	}
+	if !IsSealedArchive(sealed) || IsSealedArchive(archive) {
+		t.Fatal("expected only the sealed archive to be reported as sealed")
+	}
+	if _, err := OpenArchive(sealed, []byte("another password")); !errors.Is(err, ErrCannotDecrypt) {
+		t.Errorf("expected the wrong password to be refused, got %v", err)
+	}
+	if archive, err = OpenArchive(sealed, []byte("an archive password")); err != nil {
+		t.Fatalf("OpenArchive() failed: %v", err)
+	}
+
+	for _, v := range vs {
+		if err := Delete(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restored, err := RestoreArchive(archive, false, nil, nil, false)
+	if err != nil || len(restored) != 2 {
+		t.Fatalf("RestoreArchive() = %v, %v; want 2 vaults", restored, err)
+	}
+	for p, b := range want {
+		if got, err := os.ReadFile(p); err != nil || !bytes.Equal(got, b) {
+			t.Errorf("expected %s to be restored byte for byte, got %v", p, err)
+		}
+	}
+}
+
+// A vault whose name is taken is refused, and nothing is restored, unless it
+// is renamed, which re-encrypts it under the new name.
+func TestAVaultWhoseNameIsTakenIsRestoredOnlyUnderANewName(t *testing.T) {
+	newVaultDir(t)
+	createVaults(t, "a password", "personal", "work")
+	archive, _, err := CreateArchive(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	personal, err := Exact("personal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(personal); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RestoreArchive(archive, false, nil, nil, false); err == nil || !strings.Contains(err.Error(), `"work" already exists`) {
+		t.Fatalf("expected the taken name to be refused, got %v", err)
+	}
+	if exists, _ := Exists("personal"); exists {
+		t.Fatal("expected nothing to be restored alongside a vault whose name is taken")
+	}
+
+	var asked []string
+	password := func(name string, team bool) ([]byte, error) {
+		asked = append(asked, name)
+		return []byte("a password"), nil
+	}
+	restored, err := RestoreArchive(archive, true, password, nil, false)
+	if err != nil {
+		t.Fatalf("RestoreArchive() failed: %v", err)
+	}
+	if len(restored) != 2 || restored[1].Name() != "work-restored" || restored[1].From != "work" || restored[1].Backups != 1 {
+		t.Fatalf("RestoreArchive() = %+v, want personal, and work as work-restored with its backup", restored)
+	}
+	if len(asked) != 1 || asked[0] != "work" {
+		t.Errorf("expected the password of only the renamed vault to be asked for, got %v", asked)
+	}
+	u := restored[1].Unlocked([]byte("a password"))
+	defer u.Wipe()
+	if plaintext, err := u.Decrypt(); err != nil || string(plaintext) != "a key\nv2\n" {
+		t.Errorf("expected work-restored to open under its new name, got %q, %v", plaintext, err)
+	}
+	if plaintext, err := DecryptBackup(restored[1].Vault, mustBackup(t, restored[1].Vault), []byte("a password"), nil); err != nil || string(plaintext) != "a key\nv1\n" {
+		t.Errorf("expected work-restored's backup to open under its new name, got %q, %v", plaintext, err)
+	}
+}
+
+func mustBackup(t *testing.T, v Vault) string {
+	t.Helper()
+	paths, err := Backups(v)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("Backups(%s) = %v, %v; want one", v, paths, err)
+	}
+	return paths[0]
+}
+
+// rewriteArchive returns archive with each file passed through edit, which
+// returns what to write in its place, or nil to leave it out, and then extra.
+func rewriteArchive(t *testing.T, archive []byte, edit func(name string, data []byte) []byte, extra ...string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	write := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if data = edit(hdr.Name, data); data != nil {
+			write(hdr.Name, data)
+		}
+	}
+	for _, name := range extra {
+		write(name, []byte("contents"))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestAnArchiveThatDoesNotMatchItsManifestIsRefused(t *testing.T) {
+	newVaultDir(t)
+	createVaults(t, "a password", "work")
+	archive, _, err := CreateArchive(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Exact("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(v); err != nil {
+		t.Fatal(err)
+	}
+	keep := func(name string, data []byte) []byte { return data }
+	for desc, tc := range map[string]struct {
+		archive []byte
+		want    string
+	}{
+		"a changed file": {rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			if strings.HasPrefix(name, archiveDir) {
+				data[len(data)-1] ^= 1
+			}
+			return data
+		}), "does not match its size and SHA-256"},
+		"a missing file": {rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			if strings.HasPrefix(name, archiveDir) {
+				return nil
+			}
+			return data
+		}), "is missing"},
+		"a path outside the vault directory": {rewriteArchive(t, archive, keep, "vaults/../../evil"), "is not a vault's file"},
+		"a file the manifest does not list":  {rewriteArchive(t, archive, keep, "vaults/other.lock"), "does not list"},
+		"another kind of file":               {[]byte("db\nhunter2\n"), "is not an archive"},
+	} {
+		if _, err := RestoreArchive(tc.archive, false, nil, nil, false); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected %s to be refused with %q, got %v", desc, tc.want, err)
+		}
+	}
+	if exists, _ := Exists("work"); exists {
+		t.Error("expected nothing to be restored from an archive that does not match its manifest")
+	}
+}
@@ -0,0 +1,204 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+)
+
+// backupTimeFormat names each backup of a vault <name>.<salt>.<time>.bak, in
+// UTC so that the names sort in the order the backups were made. It has no
+// fractional seconds, because the name of a vault at the longest a name may be
+// leaves room for no more. See maxNameLen.
+const backupTimeFormat = "20060102T150405Z"
+
+// Backups returns the paths of a vault's backups, oldest first. Each is the
+// vault as it was before one of its saves. A <name>.<salt>.bak left by a
+// version of mrs that kept a single backup is the oldest of them.
+func Backups(v Vault) ([]string, error) {
+	matches, err := filepath.Glob(v.Path() + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	if _, err := os.Stat(v.Path() + ".bak"); err == nil {
+		paths = append(paths, v.Path()+".bak")
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var timed []string
+	for _, m := range matches {
+		if _, ok := backupTime(v, m); ok {
+			timed = append(timed, m)
+		}
+	}
+	slices.Sort(timed)
+	return append(paths, timed...), nil
+}
+
+// backupTime returns when the backup at p was made, and false for a path that
+// is not one of v's timestamped backups.
+func backupTime(v Vault, p string) (time.Time, bool) {
+	s, ok := strings.CutPrefix(p, v.Path()+".")
+	if !ok {
+		return time.Time{}, false
+	}
+	if s, ok = strings.CutSuffix(s, ".bak"); !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, s)
+	return t, err == nil
+}
+
+// backup copies the vault's file to a new backup, then removes the oldest
+// backups beyond keep. A backup is named for when it was made, or for a second
+// after the newest one if that is later, so that two saves within a second, or
+// a clock set back, still leave every backup with a name that sorts after the
+// one before it.
+//
+// The backups that are kept are never rewritten, so the group and other bits
+// that a save clears from the file it writes are cleared from them here.
+// This is best-effort, as it is for the vault directory.
+func backup(v Vault, keep int) error {
+	if keep > 0 {
+		existing, err := Backups(v)
+		if err != nil {
+			return err
+		}
+		for _, p := range existing {
+			if fi, statErr := os.Stat(p); statErr == nil && fi.Mode().Perm()&0077 != 0 {
+				_ = os.Chmod(p, fi.Mode().Perm()&^0077)
+			}
+		}
+		t := time.Now().UTC().Truncate(time.Second)
+		if len(existing) > 0 {
+			if newest, ok := backupTime(v, existing[len(existing)-1]); ok && !t.After(newest) {
+				t = newest.Add(time.Second)
+			}
+		}
+		if err := fs.CopyFile(v.Path(), v.Path()+"."+t.Format(backupTimeFormat)+".bak"); err != nil {
+			return err
+		}
+	}
+	if err := pruneBackups(v, keep); err != nil {
+		warnf("failed to remove old backups of vault %s: %s", v.Name(), err)
+	}
+	return nil
+}
+
+// pruneBackups removes the oldest of a vault's backups, so that keep remain.
+func pruneBackups(v Vault, keep int) error {
+	paths, err := Backups(v)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		paths = paths[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// removeBackups removes every backup of a vault.
+func removeBackups(v Vault) error {
+	return pruneBackups(v, 0)
+}
+
+// BackupTime returns when the backup at p was made: the time in its name, or,
+// for the single backup that an earlier version kept, when it was written.
+func BackupTime(v Vault, p string) (time.Time, error) {
+	if t, ok := backupTime(v, p); ok {
+		return t, nil
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// DecryptBackup returns the contents of the backup at p, which is one of the
+// paths that Backups returns. A backup made before a change of password opens
+// only with the password the vault had then, and with the keyfile only if it
+// had one. The caller is responsible for wiping the returned slice, and still
+// owns password and keyfile.
+func DecryptBackup(v Vault, p string, password, keyfile []byte) ([]byte, error) {
+	u := v.UnlockedWithKeyfile(password, keyfile)
+	defer u.wipeKey()
+	defer u.wipeRecovery()
+	return u.decryptBackup(p)
+}
+
+func (v *UnlockedVault) decryptBackup(p string) ([]byte, error) {
+	b, _, err := v.decryptFile(p, fmt.Sprintf("backup %s of vault %s", filepath.Base(p), v.Name()))
+	return b, err
+}
+
+// Restore replaces a vault's contents with those of the backup at p, which is
+// one of the paths that Backups returns, and keeps what it replaces as a new
+// backup. The caller holds the vault's exclusive lock.
+//
+// The vault is written under the password that opened the backup, and with
+// the keyfile only if the backup was sealed with it. It reports whether the
+// vault's file opened the same way: a backup from before a change of password
+// brings the earlier password back with it. The vault keeps the recovery key
+// it has when that password opens it, and otherwise warns if the shares of
+// that key stop opening it.
+func Restore(v Vault, p string, password, keyfile []byte) (bool, error) {
+	u := v.UnlockedWithKeyfile(password, keyfile)
+	defer u.wipeKey()
+	plaintext, err := u.decryptBackup(p)
+	if err != nil {
+		return false, fmt.Errorf("%w. A backup made before a change of password opens only with the password the vault had then", err)
+	}
+	defer crypto.Wipe(plaintext)
+	defer u.wipeRecovery()
+
+	// Opened apart from u, which seals the vault as the backup was sealed.
+	cur := v.UnlockedWithKeyfile(password, keyfile)
+	defer cur.wipeKey()
+	current, _, err := cur.decryptFile(v.Path(), "vault "+v.Name())
+	crypto.Wipe(current)
+	samePassword := err == nil
+	// The current file's recovery key can be kept only once it is open.
+	lost := recoveryID(v)
+	if r := cur.getRecovery(); samePassword && r != nil {
+		u.setRecovery(r)
+	} else if r := u.getRecovery(); r != nil && bytes.Equal(r.ID(), lost) {
+		lost = nil
+	}
+
+	if err := u.Write(plaintext); err != nil {
+		return false, err
+	}
+	if !samePassword && lost != nil {
+		warnf("the recovery shares of vault %s no longer unlock it, because the backup was made under another "+
+			"password. Run \"mrs vault recovery split %s\" to print new ones", v, v)
+	}
+	return samePassword, nil
+}
+
+// recoveryID returns the id of the split of the recovery key that opens the
+// vault's file, or nil for one that none opens or that cannot be read.
+func recoveryID(v Vault) []byte {
+	b, err := os.ReadFile(v.Path())
+	if err != nil {
+		return nil
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return nil
+	}
+	return h.RecoveryID
+}
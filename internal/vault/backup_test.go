@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeVersion stands in for a save: it replaces the vault's file with the
+// given contents, after backing up what was there.
+func writeVersion(t *testing.T, v Vault, keep int, contents string) {
+	t.Helper()
+	if err := backup(v, keep); err != nil {
+		t.Fatalf("backup() failed: %v", err)
+	}
+	if err := os.WriteFile(v.Path(), []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", v.Path(), err)
+	}
+}
+
+func backedUp(t *testing.T, v Vault) []string {
+	t.Helper()
+	paths, err := Backups(v)
+	if err != nil {
+		t.Fatalf("Backups() failed: %v", err)
+	}
+	var got []string
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", p, err)
+		}
+		got = append(got, string(b))
+	}
+	return got
+}
+
+// Saves come faster than once a second here, which is also the case that a
+// single backup lost secrets to: two bad saves in a row.
+func TestBackupsKeepTheNewestVersionsOldestFirst(t *testing.T) {
+	dir := newVaultDir(t)
+	v := Vault(filepath.Join(dir, "test."+testSalt))
+	if err := os.WriteFile(v.Path(), []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, contents := range []string{"v2", "v3", "v4"} {
+		writeVersion(t, v, 2, contents)
+	}
+
+	if got, want := backedUp(t, v), []string{"v2", "v3"}; !slices.Equal(got, want) {
+		t.Errorf("expected backups %q, got %q", want, got)
+	}
+}
+
+func TestTheSingleBackupOfAnEarlierVersionIsTheOldest(t *testing.T) {
+	dir := newVaultDir(t)
+	v := Vault(filepath.Join(dir, "test."+testSalt))
+	if err := os.WriteFile(v.Path()+".bak", []byte("v0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(v.Path(), []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	writeVersion(t, v, 2, "v2")
+	if got, want := backedUp(t, v), []string{"v0", "v1"}; !slices.Equal(got, want) {
+		t.Errorf("expected backups %q, got %q", want, got)
+	}
+	// And is the first to be pruned.
+	writeVersion(t, v, 2, "v3")
+	if got, want := backedUp(t, v), []string{"v1", "v2"}; !slices.Equal(got, want) {
+		t.Errorf("expected backups %q, got %q", want, got)
+	}
+}
+
+func TestKeepingNoBackupsRemovesThem(t *testing.T) {
+	dir := newVaultDir(t)
+	v := Vault(filepath.Join(dir, "test."+testSalt))
+	if err := os.WriteFile(v.Path(), []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeVersion(t, v, 2, "v2")
+	writeVersion(t, v, 0, "v3")
+
+	if got := backedUp(t, v); len(got) != 0 {
+		t.Errorf("expected no backups, got %q", got)
+	}
+}
+
+func TestBackupsIgnoresFilesThatAreNotBackups(t *testing.T) {
+	dir := newVaultDir(t)
+	writeFile(t, dir, "test."+testSalt)
+	for _, name := range []string{
+		"test." + testSalt + ".not-a-time.bak",
+		"test." + testSalt + ".1234.tmp",
+		"testing." + testSalt + ".20261017T091244Z.bak",
+	} {
+		writeFile(t, dir, name)
+	}
+
+	v, err := Exact("test")
+	if err != nil {
+		t.Fatalf("Exact() failed: %v", err)
+	}
+	if got := backedUp(t, v); len(got) != 0 {
+		t.Errorf("expected no backups, got %q", got)
+	}
+}
+
+func TestRenameMovesEveryBackup(t *testing.T) {
+	dir := newVaultDir(t)
+	for _, name := range []string{
+		"src." + testSalt,
+		"src." + testSalt + ".bak",
+		"src." + testSalt + ".20261017T091244Z.bak",
+		"src." + testSalt + ".20261017T091245Z.bak",
+	} {
+		writeFile(t, dir, name)
+	}
+
+	src, err := Exact("src")
+	if err != nil {
+		t.Fatalf("Exact() failed: %v", err)
+	}
+	noPassword := func() ([]byte, error) { return nil, errors.New("unexpected password prompt") }
+	if err := Rename(src, "dst", noPassword, nil); err != nil {
+		t.Fatalf("Rename() failed: %v", err)
+	}
+
+	want := []string{
+		"dst." + testSalt,
+		"dst." + testSalt + ".20261017T091244Z.bak",
+		"dst." + testSalt + ".20261017T091245Z.bak",
+		"dst." + testSalt + ".bak",
+	}
+	if got := entriesIn(t, dir); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRestoreWritesTheBackupAndKeepsWhatItReplaces(t *testing.T) {
+	newVaultDir(t)
+	password := []byte("a password")
+	v, err := Create("test", append([]byte{}, password...), nil, []byte("a key\nv1\n"), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := v.Write([]byte("a key\nv2\n")); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	backups, err := Backups(v.Vault)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v, %v", backups, err)
+	}
+
+	samePassword, err := Restore(v.Vault, backups[0], password, nil)
+	if err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if !samePassword {
+		t.Error("expected the vault to have opened with the backup's password")
+	}
+	u := v.Vault.Unlocked(password)
+	defer u.Wipe()
+	b, err := u.Decrypt()
+	if err != nil || string(b) != "a key\nv1\n" {
+		t.Errorf("expected the backup's secrets, got %q, %v", b, err)
+	}
+	if got := backedUp(t, v.Vault); len(got) != 2 {
+		t.Errorf("expected the replaced version to be kept as a backup, got %d backups", len(got))
+	}
+}
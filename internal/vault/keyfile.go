@@ -0,0 +1,44 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// ReadKeyfile returns the digest of the keyfile at p, as UnlockedWithKeyfile
+// and Create take it, or nil when p is empty. The caller is responsible for
+// wiping it.
+func ReadKeyfile(p string) ([]byte, error) {
+	if p == "" {
+		return nil, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyfile: %w", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		return nil, fmt.Errorf("could not read keyfile: %s is a directory", p)
+	}
+	digest, err := crypto.KeyfileDigest(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyfile %s: %w", p, err)
+	}
+	return digest, nil
+}
+
+// UsesKeyfile reports whether v's key is derived from a keyfile as well as its
+// password, which its header says without its being decrypted.
+func UsesKeyfile(v Vault) (bool, error) {
+	b, err := os.ReadFile(v.Path())
+	if err != nil {
+		return false, err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return false, fmt.Errorf("cannot read vault file %q: %w", v.Path(), err)
+	}
+	return h.Keyfile, nil
+}
@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// A keyfile given for a vault without one opens it, since it is not needed,
+// and is not sealed into it by the save that follows: only a change of
+// password adds one.
+func TestAKeyfileIsSealedInOnlyWhenAsked(t *testing.T) {
+	dir := newVaultDir(t)
+	p := filepath.Join(dir, "keyfile")
+	if err := os.WriteFile(p, []byte("the contents of a keyfile"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyfile, err := ReadKeyfile(p)
+	if err != nil {
+		t.Fatalf("ReadKeyfile() failed: %v", err)
+	}
+	password := []byte("a password")
+
+	u, err := Create("test", append([]byte{}, password...), nil, []byte("a key\nv1\n"), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	u.Wipe()
+	v, err := Exact("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u = v.UnlockedWithKeyfile(append([]byte{}, password...), keyfile)
+	plaintext, err := u.Decrypt()
+	if err != nil {
+		t.Fatalf("expected a keyfile that is not needed to be ignored: %v", err)
+	}
+	if err := u.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	u.Wipe()
+	if uses, err := UsesKeyfile(v); err != nil || uses {
+		t.Fatalf("UsesKeyfile() = %t, %v after a save; want false", uses, err)
+	}
+
+	u, err = ChangePassword(v, append([]byte{}, password...), nil, append([]byte{}, password...), keyfile)
+	if err != nil {
+		t.Fatalf("ChangePassword() failed: %v", err)
+	}
+	u.Wipe()
+	if uses, err := UsesKeyfile(v); err != nil || !uses {
+		t.Fatalf("UsesKeyfile() = %t, %v after a change of password; want true", uses, err)
+	}
+	u = v.Unlocked(append([]byte{}, password...))
+	defer u.Wipe()
+	if _, err := u.Decrypt(); !errors.Is(err, ErrCannotDecrypt) || !errors.Is(err, crypto.ErrNeedsKeyfile) {
+		t.Errorf("expected the vault to need its keyfile, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// A paper backup is a vault's file, encrypted as it is on disk, written out as
+// text to be printed, and typed or scanned back in. Each line that is not a
+// comment is
+//
+//	<tag> <content> <checksum>
+//
+// where the checksum is the start of the SHA-256 of the tag and the content,
+// in base32, so that a line that is mistyped or misread is caught as that
+// line. The header's tags are the words below, and say what the file is; the
+// file's are line numbers, from 1, and its content is base32 in groups of
+// eight. The whole file's SHA-256 is in the header as well, so that a line
+// that is left out is caught too.
+const (
+	paperMagic       = "mrs-paper-backup"
+	paperVersion     = 1
+	paperLineBytes   = 30
+	paperGroup       = 8
+	paperChecksumLen = 3
+)
+
+// The header's tags, in the order they are written.
+const (
+	paperTagVault  = "vault"
+	paperTagSalt   = "salt"
+	paperTagFormat = "format"
+	paperTagBytes  = "bytes"
+	paperTagSHA256 = "sha256"
+)
+
+var paperEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// paperMisreadings maps what a person or a scanner may read a base32 letter
+// as back to the letter, since base32 has no 0, 1 or 8 of its own.
+var paperMisreadings = strings.NewReplacer("0", "O", "1", "I", "8", "B")
+
+// PaperBackup returns v's file as a paper backup, which PaperRestore reads. It
+// is the file as it is, encrypted, so it needs no password, and opens with the
+// one the vault had when it was written.
+func PaperBackup(v Vault) ([]byte, error) {
+	data, err := os.ReadFile(v.Path())
+	if err != nil {
+		return nil, err
+	}
+	h, err := crypto.ReadHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vault file %q: %w", v.Path(), err)
+	}
+	sum := sha256.Sum256(data)
+	lines := (len(data) + paperLineBytes - 1) / paperLineBytes
+	width := len(strconv.Itoa(lines))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# A paper backup of vault %s, which \"mrs vault paper-restore\" reads back.\n", v)
+	fmt.Fprintf(&b, "# It is the vault encrypted, and opens with the password it had when printed.\n")
+	fmt.Fprintf(&b, "# Each line ends in a checksum of the rest of it.\n")
+	writePaperLine(&b, paperMagic, strconv.Itoa(paperVersion))
+	writePaperLine(&b, paperTagVault, v.Name())
+	writePaperLine(&b, paperTagSalt, v.Salt())
+	writePaperLine(&b, paperTagFormat, strconv.Itoa(int(h.Version)))
+	writePaperLine(&b, paperTagBytes, strconv.Itoa(len(data)))
+	writePaperLine(&b, paperTagSHA256, hex.EncodeToString(sum[:]))
+	for i := range lines {
+		chunk := data[i*paperLineBytes : min((i+1)*paperLineBytes, len(data))]
+		encoded := paperEncoding.EncodeToString(chunk)
+		var groups []string
+		for j := 0; j < len(encoded); j += paperGroup {
+			groups = append(groups, encoded[j:min(j+paperGroup, len(encoded))])
+		}
+		tag := fmt.Sprintf("%0*d", width, i+1)
+		fmt.Fprintf(&b, "%s %s  %s\n", tag, strings.Join(groups, " "), paperChecksum(strconv.Itoa(i+1), encoded))
+	}
+	return b.Bytes(), nil
+}
+
+func writePaperLine(b *bytes.Buffer, tag, content string) {
+	fmt.Fprintf(b, "%-6s %s  %s\n", tag, content, paperChecksum(tag, content))
+}
+
+// paperChecksum returns the checksum of a line with the given tag and content.
+func paperChecksum(tag, content string) string {
+	sum := sha256.Sum256([]byte(paperMagic + "\x00" + tag + "\x00" + content))
+	return paperEncoding.EncodeToString(sum[:paperChecksumLen])
+}
+
+// paperBackup is what a paper backup says of the file it holds.
+type paperBackup struct {
+	name   string
+	salt   string
+	format int
+	data   []byte
+}
+
+// PaperRestore recreates the vault a paper backup holds, with the name and
+// salt it had, and returns it. It refuses to replace a vault of that name,
+// which force does not change: force only breaks the lock of another process.
+func PaperRestore(text []byte, force bool) (Vault, error) {
+	pb, err := parsePaper(text)
+	if err != nil {
+		return "", err
+	}
+	if err := validateFilename(pb.name + "." + pb.salt); err != nil {
+		return "", fmt.Errorf("the paper backup does not name a vault file: %w", err)
+	}
+	p, err := toPath(pb.name)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := Vault(p).ExclusiveLockForce(force)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	exists, err := Exists(pb.name)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("a vault named %q already exists. Delete or rename it to restore the paper backup", pb.name)
+	}
+	if p, err = toPathWithSalt(pb.name, pb.salt); err != nil {
+		return "", err
+	}
+	if err := writeRestored(p, "", pb.data); err != nil {
+		return "", err
+	}
+	return Vault(p), nil
+}
+
+// errNotAPaperBackup reports text that does not begin as a paper backup does,
+// rather than one with a mistake in it.
+var errNotAPaperBackup = errors.New("is not a paper backup of a vault: one begins \"" + paperMagic + "\"")
+
+// parsePaper reads a paper backup, checking each line's checksum, and then the
+// file's, and that the file's header agrees with the paper's.
+func parsePaper(text []byte) (paperBackup, error) {
+	header := map[string]string{}
+	var chunks []string
+	n := 0
+	for i, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		lineErr := func(format string, args ...any) error {
+			return fmt.Errorf("line %d: "+format, append([]any{i + 1}, args...)...)
+		}
+		tag := strings.ToLower(fields[0])
+		if n == 0 && tag != paperMagic {
+			return paperBackup{}, fmt.Errorf("line %d %w", i+1, errNotAPaperBackup)
+		}
+		n++
+		if len(fields) < 3 {
+			return paperBackup{}, lineErr("%q should end in a checksum", fields[0])
+		}
+		content := strings.Join(fields[1:len(fields)-1], "")
+		checksum := strings.ToUpper(paperMisreadings.Replace(fields[len(fields)-1]))
+
+		number, err := strconv.Atoi(tag)
+		if err != nil {
+			if tag == paperTagSHA256 {
+				content = strings.ToLower(content)
+			}
+			if paperChecksum(tag, content) != checksum {
+				return paperBackup{}, lineErr("the %s line is misread: its checksum does not match the rest of it", tag)
+			}
+			if _, seen := header[tag]; seen {
+				return paperBackup{}, lineErr("the %s line is given twice", tag)
+			}
+			header[tag] = content
+			// A newer version may write the rest differently.
+			if tag == paperMagic && content != strconv.Itoa(paperVersion) {
+				return paperBackup{}, errors.New("the paper backup was written by a newer version of mrs, which can read it")
+			}
+			continue
+		}
+		content = strings.ToUpper(paperMisreadings.Replace(content))
+		if paperChecksum(strconv.Itoa(number), content) != checksum {
+			return paperBackup{}, lineErr("the file's line %d is misread: its checksum does not match the rest of it", number)
+		}
+		if number != len(chunks)+1 {
+			return paperBackup{}, lineErr("the file's line %d is missing, or out of order", len(chunks)+1)
+		}
+		chunks = append(chunks, content)
+	}
+	if n == 0 {
+		return paperBackup{}, errors.New("there is no paper backup to read")
+	}
+	for _, tag := range []string{paperTagVault, paperTagSalt, paperTagFormat, paperTagBytes, paperTagSHA256} {
+		if _, ok := header[tag]; !ok {
+			return paperBackup{}, fmt.Errorf("the paper backup's %s line is missing", tag)
+		}
+	}
+
+	pb := paperBackup{name: header[paperTagVault], salt: header[paperTagSalt]}
+	size, err := strconv.Atoi(header[paperTagBytes])
+	if err != nil {
+		return paperBackup{}, fmt.Errorf("the paper backup's size, %q, is not a number", header[paperTagBytes])
+	}
+	if pb.format, err = strconv.Atoi(header[paperTagFormat]); err != nil {
+		return paperBackup{}, fmt.Errorf("the paper backup's format, %q, is not a number", header[paperTagFormat])
+	}
+	if want := (size + paperLineBytes - 1) / paperLineBytes; len(chunks) != want {
+		return paperBackup{}, fmt.Errorf("the paper backup holds %d lines of the file, but the file is %d, "+
+			"so the last %d are missing", len(chunks), want, want-len(chunks))
+	}
+	for i, chunk := range chunks {
+		b, err := paperEncoding.DecodeString(chunk)
+		if err != nil {
+			return paperBackup{}, fmt.Errorf("the file's line %d is not base32: %w", i+1, err)
+		}
+		pb.data = append(pb.data, b...)
+	}
+	sum := sha256.Sum256(pb.data)
+	if len(pb.data) != size || hex.EncodeToString(sum[:]) != header[paperTagSHA256] {
+		return paperBackup{}, errors.New("the file the paper backup holds does not match its SHA-256, " +
+			"though every line matches its checksum. A line may be repeated, or the header is another backup's")
+	}
+	h, err := crypto.ReadHeader(pb.data)
+	if err != nil {
+		return paperBackup{}, fmt.Errorf("the file the paper backup holds is not a vault: %w", err)
+	}
+	if int(h.Version) != pb.format {
+		return paperBackup{}, fmt.Errorf("the paper backup says its file is format %d, but it is format %d", pb.format, h.Version)
+	}
+	if h.Name != "" && h.Name != pb.name {
+		return paperBackup{}, fmt.Errorf("the paper backup says it is of vault %s, but its file was sealed as vault %s", pb.name, h.Name)
+	}
+	return pb, nil
+}
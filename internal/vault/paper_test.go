@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A paper backup typed back in, in lower case, with its groups run together
+// and base32's letters read as digits, recreates the vault's file byte for
+// byte, under the same name and salt.
+func TestAPaperBackupRecreatesTheVaultFile(t *testing.T) {
+	newVaultDir(t)
+	u, err := Create("test", []byte("a password"), nil, []byte(strings.Repeat("a key\nsome value\n", 20)), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	u.Wipe()
+	v, err := Exact("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(v.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paper, err := PaperBackup(v)
+	if err != nil {
+		t.Fatalf("PaperBackup() failed: %v", err)
+	}
+
+	var typed []string
+	for _, line := range strings.Split(string(paper), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[0][0] >= '0' && fields[0][0] <= '9' {
+			content := strings.Join(fields[1:len(fields)-1], "")
+			content = strings.NewReplacer("O", "0", "I", "1", "B", "8").Replace(strings.ToLower(content))
+			line = fields[0] + " " + content + " " + fields[len(fields)-1]
+		}
+		typed = append(typed, line)
+	}
+	if _, err := PaperRestore([]byte(strings.Join(typed, "\n")), false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected a vault of the same name to be left alone, got %v", err)
+	}
+	if err := Delete(v); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := PaperRestore([]byte(strings.Join(typed, "\n")), false)
+	if err != nil {
+		t.Fatalf("PaperRestore() failed: %v", err)
+	}
+	if restored != v {
+		t.Errorf("PaperRestore() = %s, want %s", restored, v)
+	}
+	if got, err := os.ReadFile(restored.Path()); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("expected the vault's file byte for byte, got %v", err)
+	}
+}
+
+func TestAPaperBackupWithAMistakeIsRefusedAtTheLine(t *testing.T) {
+	newVaultDir(t)
+	u, err := Create("test", []byte("a password"), nil, []byte(strings.Repeat("a key\nsome value\n", 5)), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	u.Wipe()
+	v, err := Exact("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paper, err := PaperBackup(v)
+	if err != nil {
+		t.Fatalf("PaperBackup() failed: %v", err)
+	}
+	if err := Delete(v); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(paper), "\n")
+	// The comment, and then the header, which is six lines.
+	first := 9
+	if !strings.HasPrefix(lines[first], "1 ") {
+		t.Fatalf("expected the file's first line at %d, got %q", first+1, lines[first])
+	}
+	edit := func(f func([]string) []string) []byte {
+		return []byte(strings.Join(f(append([]string{}, lines...)), "\n"))
+	}
+	for desc, tc := range map[string]struct {
+		text []byte
+		want string
+	}{
+		"a changed letter": {edit(func(l []string) []string {
+			letter := map[bool]string{true: "B", false: "A"}[l[first+1][2] == 'A']
+			l[first+1] = l[first+1][:2] + letter + l[first+1][3:]
+			return l
+		}), "line 11: the file's line 2 is misread"},
+		"a missing line": {edit(func(l []string) []string {
+			return append(l[:first+1], l[first+2:]...)
+		}), "the file's line 2 is missing"},
+		"a missing last line": {edit(func(l []string) []string {
+			return l[:len(l)-2]
+		}), "are missing"},
+		"a changed salt": {edit(func(l []string) []string {
+			l[5] = strings.Replace(l[5], "salt   ", "salt   x", 1)
+			return l
+		}), "the salt line is misread"},
+		"another document": {[]byte("db\nhunter2\n"), "is not a paper backup"},
+	} {
+		if _, err := PaperRestore(tc.text, false); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected %s to be refused with %q, got %v", desc, tc.want, err)
+		}
+	}
+	if exists, err := Exists("test"); err != nil || exists {
+		t.Errorf("expected no vault to be restored, got %t, %v", exists, err)
+	}
+}
@@ -0,0 +1,280 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/shamir"
+)
+
+// A recovery share is printed as one line:
+//
+//	mrs-share <vault> <threshold>-of-<total> #<number> <payload>
+//
+// where the payload is base32, in dash-separated groups of five, of
+//
+//	version(1) | split(4) | share(crypto.RecoveryKeyLen) | checksum(4)
+//
+// split is the id of the split, which the vault's header carries too, so that
+// shares of two splits are not combined into a key that opens nothing, and so
+// that shares of a split the vault no longer has are refused as that. The
+// checksum is the start of the SHA-256 of everything else on the line, so
+// that a mistyped share is caught as the one that is mistyped.
+const (
+	sharePrefix  = "mrs-share"
+	shareVersion = 1
+	splitLen     = crypto.RecoveryIDLen
+	checksumLen  = 4
+	shareGroup   = 5
+)
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryShare is one of the shares that SplitRecovery splits a vault's
+// recovery key into, any Threshold of which unlock it.
+type RecoveryShare struct {
+	Vault     string
+	Threshold int
+	Total     int
+	split     [splitLen]byte
+	shamir.Share
+}
+
+// SplitRecovery gives v a new recovery key, which opens it as well as its
+// password does, and splits that key into total shares, any threshold of
+// which Recover unlocks it with. The vault is sealed again to carry the key,
+// and the shares of any split before this one no longer unlock it. Changing
+// its password or keyfile leaves the shares working. The caller still owns
+// password and keyfile, holds the vault's exclusive lock, and is responsible
+// for wiping the shares.
+func SplitRecovery(v Vault, password, keyfile []byte, threshold, total int) ([]RecoveryShare, error) {
+	if err := RequirePassword(v); err != nil {
+		return nil, err
+	}
+	u := v.UnlockedWithKeyfile(password, keyfile)
+	defer u.key.Wipe()
+	plaintext, err := u.Decrypt()
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(plaintext)
+	r, key, err := crypto.NewRecovery()
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(key)
+	u.setRecovery(r)
+	defer r.Wipe()
+	parts, err := shamir.Split(key, total, threshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.Write(plaintext); err != nil {
+		for _, part := range parts {
+			part.Wipe()
+		}
+		return nil, err
+	}
+	shares := make([]RecoveryShare, len(parts))
+	for i, part := range parts {
+		shares[i] = RecoveryShare{Vault: v.Name(), Threshold: threshold, Total: total, Share: part}
+		copy(shares[i].split[:], r.ID())
+	}
+	return shares, nil
+}
+
+// Recover unlocks v with shares that SplitRecovery made, and changes its
+// password to newPassword, and its keyfile to newKeyfile, or to none, as
+// ChangePassword does. The vault keeps its recovery key, so the same shares
+// unlock it again. A share given more than once counts once. The caller still
+// owns the shares, newPassword and newKeyfile, and holds the vault's exclusive
+// lock.
+func Recover(v Vault, shares []RecoveryShare, newPassword, newKeyfile []byte) (UnlockedVault, error) {
+	if err := validatePassword(newPassword); err != nil {
+		return UnlockedVault{}, fmt.Errorf("invalid new password: %w", err)
+	}
+	if err := RequirePassword(v); err != nil {
+		return UnlockedVault{}, err
+	}
+	if len(shares) == 0 {
+		return UnlockedVault{}, fmt.Errorf("no shares were given to unlock vault %s with", v)
+	}
+	b, err := os.ReadFile(v.Path())
+	if err != nil {
+		return UnlockedVault{}, err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return UnlockedVault{}, fmt.Errorf("cannot read vault %s: %w", v, err)
+	}
+	first := shares[0]
+	if !bytes.Equal(first.split[:], h.RecoveryID) {
+		switch {
+		case first.Vault != v.Name():
+			return UnlockedVault{}, fmt.Errorf("share #%d is one of vault %s's, not of vault %s's", first.X, first.Vault, v)
+		case h.RecoveryID == nil:
+			return UnlockedVault{}, fmt.Errorf("vault %s %w, so no shares unlock it: a backup from before they were "+
+				"split was restored since", v, crypto.ErrNoRecovery)
+		default:
+			return UnlockedVault{}, fmt.Errorf("the shares are from a split of vault %s that a later split replaced, "+
+				"or that a backup from before it did when it was restored", v)
+		}
+	}
+	// Distinct by number, so that a share pasted twice is not counted twice.
+	var distinct []shamir.Share
+	for _, s := range shares {
+		if s.split != first.split || s.Threshold != first.Threshold || s.Total != first.Total {
+			return UnlockedVault{}, fmt.Errorf("shares #%d and #%d are from different splits of vault %s, "+
+				"and only shares of one split unlock it", first.X, s.X, v)
+		}
+		if !slices.ContainsFunc(distinct, func(d shamir.Share) bool { return d.X == s.X }) {
+			distinct = append(distinct, s.Share)
+		}
+	}
+	if len(distinct) < first.Threshold {
+		given := fmt.Sprintf("%d different shares were", len(distinct))
+		if len(distinct) == 1 {
+			given = "1 share was"
+		}
+		return UnlockedVault{}, fmt.Errorf("vault %s unlocks with any %d of its %d shares, but %s given",
+			v, first.Threshold, first.Total, given)
+	}
+	key, err := shamir.Combine(distinct[:first.Threshold])
+	if err != nil {
+		return UnlockedVault{}, err
+	}
+	defer crypto.Wipe(key)
+	plaintext, h, err := crypto.DecryptWithRecovery(b, key, v.Name())
+	if err != nil {
+		return UnlockedVault{}, decryptError("vault "+v.Name()+" with its shares", err)
+	}
+	defer crypto.Wipe(plaintext)
+	u := v.UnlockedWithKeyfile(newPassword, newKeyfile)
+	u.setRecovery(h.Recovery())
+	if err := u.Write(plaintext); err != nil {
+		h.Recovery().Wipe()
+		return UnlockedVault{}, err
+	}
+	return u, nil
+}
+
+// Marshal returns the share as the one line that ParseRecoveryShare reads,
+// without a line break. The caller is responsible for wiping it.
+func (s RecoveryShare) Marshal() []byte {
+	payload := append([]byte{shareVersion}, s.split[:]...)
+	payload = append(payload, s.Y...)
+	sum := s.checksum(payload)
+	payload = append(payload, sum[:checksumLen]...)
+	defer crypto.Wipe(payload)
+	encoded := make([]byte, shareEncoding.EncodedLen(len(payload)))
+	defer crypto.Wipe(encoded)
+	shareEncoding.Encode(encoded, payload)
+
+	b := fmt.Appendf(nil, "%s %s %d-of-%d #%d ", sharePrefix, s.Vault, s.Threshold, s.Total, s.X)
+	for i := 0; i < len(encoded); i += shareGroup {
+		if i > 0 {
+			b = append(b, '-')
+		}
+		b = append(b, encoded[i:min(i+shareGroup, len(encoded))]...)
+	}
+	return b
+}
+
+// checksum returns the SHA-256 of the share's line up to its checksum, which
+// payload ends at.
+func (s RecoveryShare) checksum(payload []byte) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d\x00", sharePrefix, s.Vault, s.Threshold, s.Total, s.X)
+	h.Write(payload)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// errNotAShare reports a line that is not a share at all, rather than one with
+// a mistake in it.
+var errNotAShare = errors.New("is not a share of a vault: a share begins \"" + sharePrefix + "\"")
+
+// ParseRecoveryShares reads a share from each line of text that is not blank
+// or a comment, which begins with "#". The caller still owns text, and is
+// responsible for wiping the shares.
+func ParseRecoveryShares(text []byte) ([]RecoveryShare, error) {
+	var shares []RecoveryShare
+	for i, line := range bytes.Split(text, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		s, err := ParseRecoveryShare(line)
+		if errors.Is(err, errNotAShare) {
+			err = fmt.Errorf("line %d %w", i+1, err)
+		} else if err != nil {
+			err = fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if err != nil {
+			for _, s := range shares {
+				s.Wipe()
+			}
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
+
+// ParseRecoveryShare reads a share from the line Marshal wrote. Its payload is
+// read in either case, with or without its dashes, and across spaces, as it
+// may have been typed back in. The caller still owns line, and is responsible
+// for wiping the share.
+func ParseRecoveryShare(line []byte) (RecoveryShare, error) {
+	fields := bytes.Fields(line)
+	if len(fields) < 5 || string(fields[0]) != sharePrefix {
+		return RecoveryShare{}, errNotAShare
+	}
+	s := RecoveryShare{Vault: string(fields[1])}
+	threshold, total, ok := bytes.Cut(fields[2], []byte("-of-"))
+	var err error
+	if !ok {
+		return RecoveryShare{}, fmt.Errorf("%q should say how many shares of how many unlock the vault, as 3-of-5", fields[2])
+	}
+	if s.Threshold, err = strconv.Atoi(string(threshold)); err == nil {
+		s.Total, err = strconv.Atoi(string(total))
+	}
+	if err != nil || s.Threshold < 2 || s.Threshold > s.Total || s.Total > 255 {
+		return RecoveryShare{}, fmt.Errorf("%q should say how many shares of how many unlock the vault, as 3-of-5", fields[2])
+	}
+	number, ok := bytes.CutPrefix(fields[3], []byte("#"))
+	x, err := strconv.Atoi(string(number))
+	if !ok || err != nil || x < 1 || x > s.Total {
+		return RecoveryShare{}, fmt.Errorf("%q should be the share's number, from #1 to #%d", fields[3], s.Total)
+	}
+	s.X = byte(x)
+
+	encoded := bytes.ToUpper(bytes.ReplaceAll(bytes.Join(fields[4:], nil), []byte("-"), nil))
+	defer crypto.Wipe(encoded)
+	payload := make([]byte, shareEncoding.DecodedLen(len(encoded)))
+	defer crypto.Wipe(payload)
+	n, err := shareEncoding.Decode(payload, encoded)
+	if err != nil || n != 1+splitLen+crypto.RecoveryKeyLen+checksumLen {
+		return RecoveryShare{}, fmt.Errorf("share #%d is mistyped: its payload is not what a share holds", s.X)
+	}
+	payload = payload[:n]
+	if payload[0] != shareVersion {
+		return RecoveryShare{}, fmt.Errorf("share #%d was split by a newer version of mrs, which can read it", s.X)
+	}
+	body, sum := payload[:n-checksumLen], payload[n-checksumLen:]
+	want := s.checksum(body)
+	if !crypto.SecureCompare(sum, want[:checksumLen]) {
+		return RecoveryShare{}, fmt.Errorf("share #%d is mistyped: its checksum does not match the rest of it", s.X)
+	}
+	copy(s.split[:], body[1:1+splitLen])
+	s.Y = bytes.Clone(body[1+splitLen:])
+	return s, nil
+}
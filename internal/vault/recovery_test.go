@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// Any threshold of different shares, read back from the lines they are
+// printed as, unlock the vault and give it a new password, as often as it
+// needs one and whatever its password was changed to in between, until the
+// vault's recovery key is split again.
+func TestRecoverySharesUnlockTheVaultUntilTheyAreSplitAgain(t *testing.T) {
+	newVaultDir(t)
+	password := []byte("a password")
+	u, err := Create("test", append([]byte{}, password...), nil, []byte("a key\nv1\n"), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	u.Wipe()
+	v, err := Exact("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitRecovery(v, password, nil, 2, 3)
+	if err != nil {
+		t.Fatalf("SplitRecovery() failed: %v", err)
+	}
+	var parsed []RecoveryShare
+	for _, s := range shares {
+		// As it may be typed back in from paper, in lower case and with spaces
+		// for dashes.
+		fields := bytes.SplitN(s.Marshal(), []byte(" "), 5)
+		fields[4] = bytes.ReplaceAll(bytes.ToLower(fields[4]), []byte("-"), []byte(" "))
+		line := bytes.Join(fields, []byte(" "))
+		p, err := ParseRecoveryShare(line)
+		if err != nil {
+			t.Fatalf("ParseRecoveryShare(%q) failed: %v", line, err)
+		}
+		parsed = append(parsed, p)
+	}
+	opens := func(password string) {
+		t.Helper()
+		u := v.Unlocked([]byte(password))
+		defer u.Wipe()
+		if plaintext, err := u.Decrypt(); err != nil || string(plaintext) != "a key\nv1\n" {
+			t.Fatalf("expected %q to open the vault, got %q, %v", password, plaintext, err)
+		}
+	}
+
+	if _, err := Recover(v, parsed[:1], []byte("a new password"), nil); err == nil || !strings.Contains(err.Error(), "any 2 of its 3") {
+		t.Errorf("expected one share of a threshold of two to be refused, got %v", err)
+	}
+	if _, err := Recover(v, []RecoveryShare{parsed[0], parsed[0]}, []byte("a new password"), nil); err == nil || !strings.Contains(err.Error(), "1 share was given") {
+		t.Errorf("expected a share given twice to count once, got %v", err)
+	}
+	u, err = Recover(v, []RecoveryShare{parsed[2], parsed[2], parsed[0]}, []byte("a new password"), nil)
+	if err != nil {
+		t.Fatalf("Recover() failed: %v", err)
+	}
+	u.Wipe()
+	opens("a new password")
+
+	// Neither the recovery nor a change of password since touches the shares.
+	u, err = ChangePassword(v, []byte("a new password"), nil, []byte("another password"), nil)
+	if err != nil {
+		t.Fatalf("ChangePassword() failed: %v", err)
+	}
+	u.Wipe()
+	u, err = Recover(v, parsed[1:], []byte("a third password"), nil)
+	if err != nil {
+		t.Fatalf("expected the shares to unlock the vault after its password changed, got %v", err)
+	}
+	u.Wipe()
+	opens("a third password")
+
+	again, err := SplitRecovery(v, []byte("a third password"), nil, 2, 2)
+	if err != nil {
+		t.Fatalf("SplitRecovery() failed: %v", err)
+	}
+	for _, s := range again {
+		s.Wipe()
+	}
+	if _, err := Recover(v, parsed[:2], []byte("a fourth password"), nil); err == nil || !strings.Contains(err.Error(), "a later split replaced") {
+		t.Errorf("expected the shares of a split that was replaced to be refused, got %v", err)
+	}
+	opens("a third password")
+}
+
+func TestAMistypedShareIsCaughtByItsChecksum(t *testing.T) {
+	s := RecoveryShare{Vault: "test", Threshold: 2, Total: 3, split: [splitLen]byte{1, 2, 3, 4}}
+	s.X, s.Y = 2, bytes.Repeat([]byte{0x5a}, crypto.RecoveryKeyLen)
+	line := s.Marshal()
+	if _, err := ParseRecoveryShare(line); err != nil {
+		t.Fatalf("ParseRecoveryShare(%q) failed: %v", line, err)
+	}
+	// The first letter of the payload's second group of five.
+	changed := bytes.Clone(line)
+	i := bytes.LastIndexByte(changed, ' ') + 6
+	changed[i] = map[bool]byte{true: 'B', false: 'A'}[changed[i] == 'A']
+	for desc, mistyped := range map[string][]byte{
+		"a changed payload": changed,
+		"another vault":     bytes.Replace(line, []byte(" test "), []byte(" work "), 1),
+		"another number":    bytes.Replace(line, []byte("#2"), []byte("#3"), 1),
+	} {
+		if _, err := ParseRecoveryShare(mistyped); err == nil || !strings.Contains(err.Error(), "mistyped") {
+			t.Errorf("expected %s to be caught as mistyped, got %v", desc, err)
+		}
+	}
+	if _, err := ParseRecoveryShare([]byte("not a share")); err != errNotAShare {
+		t.Errorf("expected a line that is not a share to be told apart, got %v", err)
+	}
+}
+
+// Restoring a backup from before the split, under the password the vault
+// still has, keeps the recovery key that the shares unlock.
+func TestRestoringABackupKeepsTheRecoveryKey(t *testing.T) {
+	newVaultDir(t)
+	password := []byte("a password")
+	u, err := Create("test", append([]byte{}, password...), nil, []byte("a key\nv1\n"), false)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	u.Wipe()
+	shares, err := SplitRecovery(u.Vault, password, nil, 2, 2)
+	if err != nil {
+		t.Fatalf("SplitRecovery() failed: %v", err)
+	}
+	backups, err := Backups(u.Vault)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v, %v", backups, err)
+	}
+	if _, err := Restore(u.Vault, backups[0], password, nil); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	u, err = Recover(u.Vault, shares, []byte("a new password"), nil)
+	if err != nil {
+		t.Fatalf("expected the shares to unlock the restored vault, got %v", err)
+	}
+	u.Wipe()
+}
@@ -0,0 +1,180 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andornaut/mrs/internal/crypto"
+)
+
+// isTeam reports whether the vault file at p is a team vault's.
+func isTeam(p string) (bool, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return false, fmt.Errorf("cannot read vault file %q: %w", p, err)
+	}
+	return h.IsTeam(), nil
+}
+
+// IsTeam reports whether v is a team vault, which opens with the user's
+// identity rather than a password of its own.
+func IsTeam(v Vault) (bool, error) {
+	return isTeam(v.Path())
+}
+
+// RequirePassword refuses a team vault, which has no password of its own to
+// change: it opens with each of its members' identities.
+func RequirePassword(v Vault) error {
+	team, err := isTeam(v.Path())
+	if err != nil {
+		return err
+	}
+	if team {
+		return fmt.Errorf("vault %s is a team vault, which has no password of its own: "+
+			"it opens with each of its members' identities", v)
+	}
+	return nil
+}
+
+// CreateTeam creates a team vault holding contents, whose one member is
+// founder. Its key is random, so nothing is asked for to create it.
+func CreateTeam(name string, founder crypto.Recipient, contents []byte, force bool) (UnlockedVault, error) {
+	return create(name, contents, force, func(v Vault) (UnlockedVault, error) {
+		k, err := crypto.NewTeamKey()
+		if err != nil {
+			return UnlockedVault{}, err
+		}
+		return UnlockedVault{Vault: v, key: k, members: &[]crypto.Recipient{founder}}, nil
+	})
+}
+
+// Members returns who a team vault is sealed to, which its header says without
+// its being decrypted.
+func Members(v Vault) ([]crypto.Recipient, error) {
+	b, err := os.ReadFile(v.Path())
+	if err != nil {
+		return nil, err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vault file %q: %w", v.Path(), err)
+	}
+	if !h.IsTeam() {
+		return nil, errNotTeam(v)
+	}
+	return h.Recipients, nil
+}
+
+func errNotTeam(v Vault) error {
+	return fmt.Errorf("vault %s is not a team vault: it opens with its password, and has no members. "+
+		"Create a team vault with \"mrs vault create --team\"", v)
+}
+
+// AddMember seals a team vault to r as well as to its members, under the key
+// it already has. The caller holds the vault's exclusive lock.
+func AddMember(v Vault, password []byte, r crypto.Recipient) error {
+	u, plaintext, err := openTeam(v, password)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(plaintext)
+	defer u.Wipe()
+	members := u.getMembers()
+	for _, m := range members {
+		if m.PublicKey == r.PublicKey {
+			return fmt.Errorf("%s is already a member of vault %s", m, v)
+		}
+	}
+	u.setMembers(append(members, r))
+	return u.Write(plaintext)
+}
+
+// RemoveMember unseals a team vault from the member whose public key or name
+// is who, and returns them. The vault is re-keyed, so that the key the member
+// could unwrap before opens none of its saves from now on; its backups are
+// sealed as they were, and still open with it. The caller holds the vault's
+// exclusive lock.
+func RemoveMember(v Vault, password []byte, who string) (crypto.Recipient, error) {
+	u, plaintext, err := openTeam(v, password)
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	defer crypto.Wipe(plaintext)
+	defer u.Wipe()
+	members := u.getMembers()
+	i, err := findMember(v, members, who)
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	removed := members[i]
+	if len(members) == 1 {
+		return crypto.Recipient{}, fmt.Errorf("%s is the only member of vault %s, which would open for no one without them. "+
+			"Delete the vault instead", removed, v)
+	}
+	k, err := crypto.NewTeamKey()
+	if err != nil {
+		return crypto.Recipient{}, err
+	}
+	u.key.Wipe()
+	u.key = k
+	u.setMembers(append(members[:i:i], members[i+1:]...))
+	if err := u.Write(plaintext); err != nil {
+		return crypto.Recipient{}, err
+	}
+	return removed, nil
+}
+
+// openTeam decrypts a team vault with the identity that password unseals.
+func openTeam(v Vault, password []byte) (UnlockedVault, []byte, error) {
+	team, err := isTeam(v.Path())
+	if err != nil {
+		return UnlockedVault{}, nil, err
+	}
+	if !team {
+		return UnlockedVault{}, nil, errNotTeam(v)
+	}
+	u := v.Unlocked(password)
+	plaintext, err := u.Decrypt()
+	if err != nil {
+		return UnlockedVault{}, nil, err
+	}
+	return u, plaintext, nil
+}
+
+// findMember returns the index of the member whose public key is who, or
+// failing that, of the one member whose name is who.
+func findMember(v Vault, members []crypto.Recipient, who string) (int, error) {
+	if who == "" {
+		return 0, errors.New("name a member by their public key or their name")
+	}
+	for i, m := range members {
+		if m.PublicKey.String() == who {
+			return i, nil
+		}
+	}
+	var named []int
+	for i, m := range members {
+		if m.Name == who {
+			named = append(named, i)
+		}
+	}
+	switch len(named) {
+	case 0:
+		return 0, fmt.Errorf("vault %s has no member whose public key or name is %q. "+
+			"Run \"mrs vault member list %s\" to list them", v, who, v)
+	case 1:
+		return named[0], nil
+	}
+	keys := make([]string, len(named))
+	for j, i := range named {
+		keys[j] = members[i].PublicKey.String()
+	}
+	return 0, fmt.Errorf("%d members of vault %s are named %q: %s. Use the public key of the one you mean",
+		len(named), v, who, strings.Join(keys, ", "))
+}
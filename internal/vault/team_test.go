@@ -0,0 +1,63 @@
+package vault
+
+import (
+	"os"
+	"testing"
+
+	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/identity"
+)
+
+// Removing a member re-keys the vault, so that the key they could unwrap
+// before does not open what is saved after.
+func TestRemovingAMemberRekeysTheVault(t *testing.T) {
+	newVaultDir(t)
+	password := []byte("identity password")
+	me, err := identity.Create("me", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Wipe()
+
+	u, err := CreateTeam("team", me, []byte("k\nv\n"), false)
+	if err != nil {
+		t.Fatalf("CreateTeam() failed: %v", err)
+	}
+	u.Wipe()
+	v, err := Exact("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddMember(v, append([]byte{}, password...), crypto.Recipient{PublicKey: bob.PublicKey(), Name: "bob"}); err != nil {
+		t.Fatalf("AddMember() failed: %v", err)
+	}
+	before, _ := os.ReadFile(v.Path())
+	_, _, bobsKey, err := crypto.DecryptWithIdentity(before, bob, "team")
+	if err != nil {
+		t.Fatalf("expected bob to open the vault once added: %v", err)
+	}
+	defer bobsKey.Wipe()
+
+	removed, err := RemoveMember(v, append([]byte{}, password...), "bob")
+	if err != nil || removed.Name != "bob" {
+		t.Fatalf("RemoveMember() = %+v, %v", removed, err)
+	}
+	after, _ := os.ReadFile(v.Path())
+	if _, _, err := crypto.DecryptWithKey(after, bobsKey, "team"); err == nil {
+		t.Error("expected the key bob held to no longer open the vault")
+	}
+	members, err := Members(v)
+	if err != nil || len(members) != 1 || members[0] != me {
+		t.Errorf("Members() = %+v, %v; want only %+v", members, err, me)
+	}
+	if _, err := RemoveMember(v, append([]byte{}, password...), "me"); err == nil {
+		t.Error("expected the last member to be kept")
+	}
+	if _, err := ChangePassword(v, password, nil, []byte("a new password"), nil); err == nil {
+		t.Error("expected a team vault's password to be unchangeable")
+	}
+}
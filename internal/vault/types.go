@@ -9,8 +9,10 @@ import (
 
 	"github.com/gofrs/flock"
 
+	"github.com/andornaut/mrs/internal/config"
 	"github.com/andornaut/mrs/internal/crypto"
 	"github.com/andornaut/mrs/internal/fs"
+	"github.com/andornaut/mrs/internal/identity"
 )
 
 // Vault is a secrets store, held as the path of the file it is stored in. The
@@ -51,9 +53,36 @@ func (v Vault) String() string {
 
 // Unlocked returns a UnlockedVault
 func (v Vault) Unlocked(password []byte) UnlockedVault {
-	return UnlockedVault{v, password}
+	return v.UnlockedWithKeyfile(password, nil)
 }
 
+// UnlockedWithKeyfile returns a UnlockedVault that opens with a password and
+// the digest of a keyfile, as ReadKeyfile returns it. The keyfile is used only
+// for a vault whose file says it needs one, so one given for a vault that does
+// not is never sealed into it. Unlike the password, the caller still owns
+// keyfile, and is responsible for wiping it, so that one keyfile can open
+// several vaults.
+func (v Vault) UnlockedWithKeyfile(password, keyfile []byte) UnlockedVault {
+	return UnlockedVault{Vault: v, password: password, keyfile: &keyfile, key: &crypto.Key{}, members: new([]crypto.Recipient),
+		recovery: new(*crypto.Recovery)}
+}
+
+// UnlockedWithKey returns a UnlockedVault that opens with a key the agent held
+// for it rather than a password. It writes with the same key, so a vault
+// written with older key derivation parameters keeps them until it is written
+// with its password. The key is copied, and the caller still owns k.
+func (v Vault) UnlockedWithKey(k *crypto.Key) UnlockedVault {
+	key := *k
+	return UnlockedVault{Vault: v, key: &key, members: new([]crypto.Recipient), recovery: new(*crypto.Recovery)}
+}
+
+// ErrCannotDecrypt reports a vault that the password does not open. The cipher
+// cannot tell a wrong password from a damaged file, so neither can this.
+var ErrCannotDecrypt = errors.New("failed to decrypt")
+
+// errLocked reports a vault whose lock another process holds.
+var errLocked = errors.New("is currently locked by another process")
+
 // ExclusiveLock acquires an exclusive lock on the vault.
 // It returns an unlock function and any error encountered.
 func (v Vault) ExclusiveLock() (func(), error) {
@@ -66,7 +95,7 @@ func (v Vault) ExclusiveLock() (func(), error) {
 		return nil, fmt.Errorf("could not acquire lock on vault %s: %w", v.Name(), err)
 	}
 	if !locked {
-		return nil, fmt.Errorf("vault %s is currently locked by another process", v.Name())
+		return nil, fmt.Errorf("vault %s %w", v.Name(), errLocked)
 	}
 	return func() { _ = f.Unlock() }, nil
 }
@@ -93,6 +122,20 @@ func (v Vault) RemoveLock() error {
 	return nil
 }
 
+// IsLocked reports whether another process holds the vault's lock. It takes
+// the lock to find out, and releases it at once if it could.
+func (v Vault) IsLocked() (bool, error) {
+	unlock, err := v.ExclusiveLock()
+	if err != nil {
+		if errors.Is(err, errLocked) {
+			return true, nil
+		}
+		return false, err
+	}
+	unlock()
+	return false, nil
+}
+
 func (v Vault) lockPath() string {
 	return filepath.Join(filepath.Dir(v.Path()), v.Name()+".lock")
 }
@@ -106,6 +149,37 @@ type UnlockedVault struct {
 	Vault
 
 	password []byte
+	// keyfile is the digest of the keyfile that the vault's key is derived
+	// from along with password, or nil for a vault without one. Copies share
+	// it, as they share key, so that one given for a vault without one is
+	// forgotten by every copy once any has found that out.
+	keyfile *[]byte
+	// key is the vault's derived key, once there is one: from the agent, or
+	// from the password once it has opened the vault. Copies share it, so
+	// that a key derived through a copy is there for the caller to keep.
+	key *crypto.Key
+	// members are who a team vault is sealed to, as of the file it was last
+	// decrypted from, and who Write seals it to. Copies share them, as they
+	// share key, since the copy that decrypts is not always the one that
+	// writes.
+	members *[]crypto.Recipient
+	// recovery is what seals a vault that a recovery key opens, as of the
+	// file it was last decrypted from, and what Write seals it with, so that
+	// the shares of its recovery key go on opening it. Copies share it, as
+	// they share members.
+	recovery **crypto.Recovery
+}
+
+// Key returns the key that opened the vault, for the agent to hold, or nil
+// before one has, or for a vault from before the header, whose key mrs does
+// not cache. The caller owns the returned Key and is responsible for wiping
+// it.
+func (v *UnlockedVault) Key() *crypto.Key {
+	if v.key == nil || v.key.KDF == 0 {
+		return nil
+	}
+	k := *v.key
+	return &k
 }
 
 // Decrypt returns the vault's plaintext. The caller owns the returned slice and
@@ -113,54 +187,266 @@ type UnlockedVault struct {
 // so that no copy of the plaintext exists without an owner that can wipe it: a
 // bytes.Reader hides the buffer it reads from, and nothing could reach it.
 func (v *UnlockedVault) Decrypt() ([]byte, error) {
-	b, err := os.ReadFile(v.Path())
+	b, _, err := v.decrypt()
+	return b, err
+}
+
+// DecryptWithHeader is Decrypt, also returning the header that the vault's
+// file was sealed under. A vault from before the header has a zero Version,
+// and the key derivation parameters that turned out to open it.
+func (v *UnlockedVault) DecryptWithHeader() ([]byte, crypto.Header, error) {
+	b, s, err := v.decrypt()
+	return b, s.Header, err
+}
+
+// sealing is how a vault's file turned out to be sealed when it was opened.
+type sealing struct {
+	crypto.Header
+	// newlinePassword is set for a vault that only opened with a newline
+	// appended to its password. See the CLEANUP in decrypt.
+	newlinePassword bool
+}
+
+// current reports whether Write would seal the vault the same way now.
+func (s sealing) current() bool {
+	return s.IsCurrent() && !s.newlinePassword
+}
+
+// decrypt is Decrypt, also reporting how the vault was sealed.
+func (v *UnlockedVault) decrypt() ([]byte, sealing, error) {
+	return v.decryptFile(v.Path(), "vault "+v.Name())
+}
+
+// decryptFile decrypts the file at p as the vault: either the vault's own, or
+// one of its backups, which are sealed with the same name and salt. what names
+// the file in errors.
+func (v *UnlockedVault) decryptFile(p, what string) ([]byte, sealing, error) {
+	b, err := os.ReadFile(p)
 	if err != nil {
-		return nil, err
+		return nil, sealing{}, err
 	}
 	salt := v.Salt()
 	if salt == "" {
 		// A vault's key is derived from the salt in its filename, so there is
 		// nothing to decrypt with. findVaults rejects such a file, so reaching
 		// here means a Vault was built from a path directly.
-		return nil, fmt.Errorf("vault %s has no salt in its filename", v.Name())
+		return nil, sealing{}, fmt.Errorf("vault %s has no salt in its filename", v.Name())
+	}
+	var s sealing
+	if v.key != nil && v.key.KDF != 0 {
+		decrypted, h, err := crypto.DecryptWithKey(b, v.key, v.Name())
+		if err == nil {
+			s.Header = h
+			v.setMembers(h.Recipients)
+			v.setRecovery(h.Recovery())
+			return decrypted, s, nil
+		}
+		if v.password == nil {
+			return nil, sealing{}, decryptError(what, err)
+		}
+		// The password may open what the key no longer does: a backup from
+		// before the password was changed.
+	}
+	header, _ := crypto.ReadHeader(b)
+	if header.IsTeam() {
+		return v.decryptTeam(b, what)
+	}
+	decrypted, h, k, err := crypto.DecryptKey(b, v.password, v.getKeyfile(), salt, v.Name())
+	if k != nil {
+		if v.key != nil && v.key.KDF == 0 {
+			*v.key = *k
+		}
+		k.Wipe()
+	}
+	if errors.Is(err, crypto.ErrUnsupportedFormat) || errors.Is(err, crypto.ErrWrongName) ||
+		errors.Is(err, crypto.ErrNeedsKeyfile) {
+		return nil, sealing{}, decryptError(what, err)
+	}
+	if err != nil && header.Keyfile {
+		// Either the password or the keyfile is wrong, and the cipher cannot
+		// say which, but the user is told that it may be either.
+		return nil, sealing{}, fmt.Errorf("%w %s, which opens with its password and its keyfile together. "+
+			"One of them is not the one it was sealed with", ErrCannotDecrypt, what)
 	}
-	decrypted, err := crypto.Decrypt(b, v.password, salt)
 	if err != nil {
 		// CLEANUP (added 2026-08-13): vaults created with --password-file
 		// before trailing newlines were trimmed may include the newline in
 		// their password. Retry with it re-appended; saving re-encrypts with
 		// the trimmed password. Removable once every such vault has been saved
-		// at least once, which the warning below asks the user to do.
+		// or upgraded at least once, which the warning below asks the user to
+		// do.
 		for _, suffix := range []string{"\n", "\r\n"} {
 			legacyPassword := append(append([]byte{}, v.password...), suffix...)
-			decrypted, err = crypto.Decrypt(b, legacyPassword, salt)
+			decrypted, h, err = crypto.Decrypt(b, legacyPassword, salt, v.Name())
 			crypto.Wipe(legacyPassword)
 			if err == nil {
+				s.newlinePassword = true
 				warnf("vault %s was encrypted with a password that ends in a newline. "+
-					"It will be re-encrypted with the trimmed password the next time you save it.",
-					v.Name())
+					"It will be re-encrypted with the trimmed password the next time you save it, "+
+					"or when you run \"mrs vault upgrade %s\".",
+					v.Name(), v.Name())
 				break
 			}
 		}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt vault %s", v)
+		return nil, sealing{}, decryptError(what, err)
+	}
+	if !h.Keyfile {
+		// A keyfile given for a vault without one is not sealed into it.
+		v.setKeyfile(nil)
 	}
-	return decrypted, nil
+	v.setRecovery(h.Recovery())
+	s.Header = h
+	return decrypted, s, nil
+}
+
+// decryptTeam decrypts a team vault's file with the user's identity, which
+// the password unseals.
+func (v *UnlockedVault) decryptTeam(b []byte, what string) ([]byte, sealing, error) {
+	id, err := identity.Open(v.password)
+	if err != nil {
+		return nil, sealing{}, fmt.Errorf("%s is a team vault, which opens with your identity, but %w", what, err)
+	}
+	defer id.Wipe()
+	decrypted, h, k, err := crypto.DecryptWithIdentity(b, id, v.Name())
+	if err != nil {
+		if errors.Is(err, crypto.ErrNotMember) {
+			return nil, sealing{}, fmt.Errorf("failed to decrypt %s: it %w, %s. Ask one of its members to add you",
+				what, err, id.PublicKey())
+		}
+		return nil, sealing{}, decryptError(what, err)
+	}
+	if v.key != nil && v.key.KDF == 0 {
+		*v.key = *k
+	}
+	k.Wipe()
+	v.setMembers(h.Recipients)
+	return decrypted, sealing{Header: h}, nil
+}
+
+func (v *UnlockedVault) setKeyfile(keyfile []byte) {
+	if v.keyfile == nil {
+		v.keyfile = new([]byte)
+	}
+	*v.keyfile = keyfile
+}
+
+func (v *UnlockedVault) getKeyfile() []byte {
+	if v.keyfile == nil {
+		return nil
+	}
+	return *v.keyfile
+}
+
+func (v *UnlockedVault) setMembers(ms []crypto.Recipient) {
+	if v.members == nil {
+		v.members = new([]crypto.Recipient)
+	}
+	*v.members = ms
+}
+
+func (v *UnlockedVault) getMembers() []crypto.Recipient {
+	if v.members == nil {
+		return nil
+	}
+	return *v.members
+}
+
+// setRecovery sets what Write seals the vault with for its recovery key, or
+// that it has none, and takes ownership of r.
+func (v *UnlockedVault) setRecovery(r *crypto.Recovery) {
+	if v.recovery == nil {
+		v.recovery = new(*crypto.Recovery)
+	}
+	if *v.recovery != nil && *v.recovery != r {
+		(*v.recovery).Wipe()
+	}
+	*v.recovery = r
+}
+
+func (v *UnlockedVault) getRecovery() *crypto.Recovery {
+	if v.recovery == nil {
+		return nil
+	}
+	return *v.recovery
+}
+
+// wipeRecovery wipes the data key that a vault a recovery key opens was
+// opened with, as wipeKey does the key.
+func (v *UnlockedVault) wipeRecovery() {
+	if r := v.getRecovery(); r != nil {
+		r.Wipe()
+	}
+}
+
+// decryptError says why the file that what names did not open.
+func decryptError(what string, err error) error {
+	switch {
+	case errors.Is(err, crypto.ErrUnsupportedFormat):
+		// No password would open it, so the user is told why rather than
+		// left to retype theirs.
+		return fmt.Errorf("failed to decrypt %s: %w", what, err)
+	case errors.Is(err, crypto.ErrWrongName):
+		// The password was right, so saying only that decryption failed would
+		// send the user looking for a typo that is not there.
+		return fmt.Errorf("failed to decrypt %s: its file %w. It was renamed or copied by hand; "+
+			"rename it back, then use \"mrs vault rename\"", what, err)
+	case errors.Is(err, crypto.ErrNeedsKeyfile):
+		// No password alone would open it, so the user is told what else to
+		// give rather than left to retype theirs.
+		return fmt.Errorf("%w %s: it %w. Give the keyfile with --keyfile", ErrCannotDecrypt, what, err)
+	}
+	return fmt.Errorf("%w %s", ErrCannotDecrypt, what)
 }
 
 // Write encrypts plaintext into the vault. The caller owns plaintext and is
 // responsible for wiping it.
 func (v *UnlockedVault) Write(plaintext []byte) error {
-	ciphertext, err := crypto.Encrypt(plaintext, v.password, v.Salt())
+	// A setting that cannot be read is not worth failing a save over, after
+	// the user may have spent a while in an editor on it.
+	keep, err := config.Backups()
+	if err != nil {
+		warnf("%s, so %d backups are kept", err, config.DefaultBackups)
+		keep = config.DefaultBackups
+	}
+	// With its password, a vault is written the way it would be now; with
+	// only the agent's key, the way that key was derived. A team vault is
+	// sealed to its members under its own key, however it was opened.
+	var ciphertext []byte
+	switch {
+	case v.key != nil && v.key.KDF == crypto.X25519:
+		ciphertext, err = crypto.EncryptToRecipients(plaintext, v.key, v.Name(), v.getMembers())
+	case v.password == nil && v.key != nil && v.key.KDF != 0:
+		ciphertext, err = crypto.EncryptWithRecovery(plaintext, v.key, v.getRecovery(), v.Name())
+	default:
+		// A team vault that did not open has no key to seal it under, and
+		// sealing it under the password instead would turn it into a vault
+		// that opens with the user's identity password and nothing else.
+		if team, teamErr := isTeam(v.Path()); teamErr == nil && team {
+			return fmt.Errorf("vault %s is a team vault that has not been opened, so it is unchanged", v)
+		}
+		keyfile := v.getKeyfile()
+		var k *crypto.Key
+		if k, err = crypto.DeriveKey(v.password, keyfile, v.Salt()); err == nil {
+			ciphertext, err = crypto.EncryptWithRecovery(plaintext, k, v.getRecovery(), v.Name())
+			k.Wipe()
+		}
+		// Any key held was derived for how the file was sealed before.
+		current := crypto.CurrentParams
+		current.Keyfile = keyfile != nil
+		if v.key != nil && v.key.Params != current {
+			v.key.Wipe()
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to encrypt secrets, so vault %s is unchanged", v)
 	}
 
 	// A vault being written for the first time has nothing to back up.
 	if _, statErr := os.Stat(v.Path()); statErr == nil {
-		if copyErr := fs.CopyFile(v.Path(), v.Path()+".bak"); copyErr != nil {
-			warnf("failed to create backup for vault %s: %s", v.Name(), copyErr)
+		if backupErr := backup(v.Vault, keep); backupErr != nil {
+			warnf("failed to create backup for vault %s: %s", v.Name(), backupErr)
 		}
 	}
 
@@ -180,12 +466,25 @@ func (v *UnlockedVault) Write(plaintext []byte) error {
 	return nil
 }
 
-// Wipe wipes the vault's password from memory.
+// wipeKey wipes the key that opened the vault, but not its password, so that a
+// function given a password that its caller owns leaves no key derived from it
+// behind.
+func (v *UnlockedVault) wipeKey() {
+	if v.key != nil {
+		v.key.Wipe()
+	}
+}
+
+// Wipe wipes the vault's password and keys from memory.
 func (v *UnlockedVault) Wipe() {
 	crypto.Wipe(v.password)
+	if v.key != nil {
+		v.key.Wipe()
+	}
+	v.wipeRecovery()
 }
 
-func (v *UnlockedVault) changePassword(p []byte) error {
+func (v *UnlockedVault) changePassword(p, keyfile []byte) error {
 	b, err := v.Decrypt()
 	if err != nil {
 		return err
@@ -193,5 +492,9 @@ func (v *UnlockedVault) changePassword(p []byte) error {
 	defer crypto.Wipe(b)
 
 	v.password = p
+	v.setKeyfile(keyfile)
+	if v.key != nil {
+		v.key.Wipe()
+	}
 	return v.Write(b)
 }
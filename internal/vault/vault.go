@@ -7,9 +7,12 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/andornaut/mrs/internal/config"
 	"github.com/andornaut/mrs/internal/crypto"
+	"github.com/andornaut/mrs/internal/fs"
+	"github.com/andornaut/mrs/internal/identity"
 )
 
 // All returns a slice of all vaults
@@ -115,14 +118,27 @@ func named(vs []Vault, name string) (Vault, bool) {
 	return "", false
 }
 
-// ChangePassword changes a vault's password. It re-keys the vault, so it takes
-// the whole name rather than a prefix that could reach a neighbouring one.
-func ChangePassword(v Vault, oldPassword, newPassword []byte) (UnlockedVault, error) {
+// ChangePassword changes a vault's password, and the keyfile its key is
+// derived from along with it: newKeyfile is the digest of the one it is sealed
+// with from now on, or nil for none; the caller still owns both keyfiles. It
+// re-keys the vault, so it takes the whole name rather than a prefix that could
+// reach a neighbouring one.
+func ChangePassword(v Vault, oldPassword, oldKeyfile, newPassword, newKeyfile []byte) (UnlockedVault, error) {
+	u := v.UnlockedWithKeyfile(oldPassword, oldKeyfile)
+	defer u.wipeKey()
+	return changePassword(u, newPassword, newKeyfile)
+}
+
+// changePassword is ChangePassword for a vault that u opens, with its old
+// password or with a key.
+func changePassword(u UnlockedVault, newPassword, newKeyfile []byte) (UnlockedVault, error) {
 	if err := validatePassword(newPassword); err != nil {
 		return UnlockedVault{}, fmt.Errorf("invalid new password: %w", err)
 	}
-	u := v.Unlocked(oldPassword)
-	if err := u.changePassword(newPassword); err != nil {
+	if err := RequirePassword(u.Vault); err != nil {
+		return UnlockedVault{}, err
+	}
+	if err := u.changePassword(newPassword, newKeyfile); err != nil {
 		return UnlockedVault{}, err
 	}
 	return u, nil
@@ -130,12 +146,22 @@ func ChangePassword(v Vault, oldPassword, newPassword []byte) (UnlockedVault, er
 
 // Create creates a vault holding the given secrets, which may be empty. The
 // caller reads and validates any import file, so that a vault is never created
-// from contents that mrs cannot read back.
-func Create(name string, password, contents []byte, force bool) (UnlockedVault, error) {
-	if err := ValidateName(name); err != nil {
+// from contents that mrs cannot read back. keyfile is the digest of the
+// keyfile that the vault's key is derived from along with password, or nil
+// for none.
+func Create(name string, password, keyfile, contents []byte, force bool) (UnlockedVault, error) {
+	if err := validatePassword(password); err != nil {
 		return UnlockedVault{}, err
 	}
-	if err := validatePassword(password); err != nil {
+	return create(name, contents, force, func(v Vault) (UnlockedVault, error) {
+		return v.UnlockedWithKeyfile(password, keyfile), nil
+	})
+}
+
+// create creates a vault holding contents, unlocked as unlocked returns it
+// once its path is known.
+func create(name string, contents []byte, force bool, unlocked func(Vault) (UnlockedVault, error)) (UnlockedVault, error) {
+	if err := ValidateName(name); err != nil {
 		return UnlockedVault{}, err
 	}
 
@@ -170,43 +196,68 @@ func Create(name string, password, contents []byte, force bool) (UnlockedVault,
 	if err != nil {
 		return UnlockedVault{}, err
 	}
-	u := Vault(p).Unlocked(password)
+	u, err := unlocked(Vault(p))
+	if err != nil {
+		return UnlockedVault{}, err
+	}
 	if err = u.Write(contents); err != nil {
+		u.Wipe()
 		return UnlockedVault{}, err
 	}
 	return u, nil
 }
 
-// Delete deletes a vault, along with its backup and temporary files
+// Delete deletes a vault, along with its backups and temporary files
 func Delete(v Vault) error {
 	if err := os.Remove(v.Path()); err != nil {
 		return err
 	}
 	// The vault itself is gone. Removing the temporary files is best-effort and
 	// only warns, but a leftover backup still holds the secrets, so failing to
-	// remove it is reported as an error that makes clear the vault was deleted.
+	// remove one is reported as an error that makes clear the vault was deleted.
 	// The lock file is left in place, as by other commands, and is harmless
 	// because it is re-lockable once no process holds it.
 	if err := removeTempFiles(v.Path()); err != nil {
 		warnf("failed to remove temporary files for vault %s: %s", v.Name(), err)
 	}
-	if err := os.Remove(v.Path() + ".bak"); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("deleted vault %s but failed to remove its backup, which still contains your secrets: %w", v.Name(), err)
+	if err := removeBackups(v); err != nil {
+		return fmt.Errorf("deleted vault %s but failed to remove its backups, which still contain your secrets: %w", v.Name(), err)
 	}
 	return nil
 }
 
-// Export returns a vault's secrets. The caller resolves the vault, so that
-// export reports a name it cannot find the way every other command does, and
-// is responsible for wiping the returned slice.
-func Export(v Vault, password []byte) ([]byte, error) {
-	u := v.Unlocked(password)
-	defer u.Wipe()
-	return u.Decrypt()
+// Upgrade re-encrypts a vault the way Write seals one now, if it was sealed any
+// other way, and reports whether it was along with how it had been. That is a
+// vault from before the header, one written with older key derivation
+// parameters, or one whose password ends in a newline that is no longer
+// typed. The caller holds the vault's lock. A vault with a keyfile keeps it,
+// and keyfile is ignored for one without.
+func Upgrade(v Vault, password, keyfile []byte) (crypto.Header, bool, error) {
+	u := v.UnlockedWithKeyfile(password, keyfile)
+	defer u.wipeKey()
+	defer u.wipeRecovery()
+	plaintext, s, err := u.decrypt()
+	if err != nil {
+		return crypto.Header{}, false, err
+	}
+	defer crypto.Wipe(plaintext)
+	if s.current() {
+		return s.Header, false, nil
+	}
+	if err := u.Write(plaintext); err != nil {
+		return crypto.Header{}, false, err
+	}
+	return s.Header, true, nil
 }
 
-// Rename renames a vault
-func Rename(sourceVault Vault, targetName string) error {
+// Rename renames a vault. A vault written since its file began to carry its
+// name has that name sealed into it, so renaming one re-encrypts it under the
+// new name, and password is called for what to decrypt it with. It is called
+// only once the rename is known to be possible, and only for such a vault: an
+// older one is moved without being decrypted, as every vault once was. The
+// caller owns the returned password and Rename wipes it. keyfile is the
+// digest of the vault's keyfile, which the caller still owns, or nil.
+func Rename(sourceVault Vault, targetName string, password func() ([]byte, error), keyfile []byte) error {
 	sourceName := sourceVault.Name()
 	if sourceName == targetName {
 		return fmt.Errorf("the source and target vault names cannot both be %q", sourceName)
@@ -223,29 +274,171 @@ func Rename(sourceVault Vault, targetName string) error {
 	if exists {
 		return fmt.Errorf("a vault named %q already exists", targetName)
 	}
-	// The target keeps the source's salt, because renaming does not decrypt.
+	// The target keeps the source's salt, which a vault's key is derived from,
+	// so that a vault moved without being decrypted still opens.
 	targetPath, err := toPathWithSalt(targetName, sourceVault.Salt())
 	if err != nil {
 		return err
 	}
-	if err := os.Rename(sourceVault.Path(), targetPath); err != nil {
+	bound, err := bindsName(sourceVault.Path())
+	if err != nil {
+		return err
+	}
+	var p []byte
+	if bound {
+		if p, err = password(); err != nil {
+			return err
+		}
+		defer crypto.Wipe(p)
+		if err := rebind(sourceVault, Vault(targetPath), p, keyfile); err != nil {
+			return err
+		}
+	} else if err := os.Rename(sourceVault.Path(), targetPath); err != nil {
 		return err
 	}
 	// The vault itself is renamed. Removing the temporary files is best-effort
-	// and only warns, but the backup still holds the secrets, so failing to move
-	// it out from under the old name is reported as an error that makes clear
+	// and only warns, but the backups still hold the secrets, so failing to move
+	// one out from under the old name is reported as an error that makes clear
 	// the vault was renamed. The lock file is left in place, as by other
 	// commands, and is harmless because it is re-lockable once no process holds
 	// it.
 	if err := removeTempFiles(sourceVault.Path()); err != nil {
 		warnf("failed to remove temporary files for vault %s: %s", sourceVault.Name(), err)
 	}
-	if err := os.Rename(sourceVault.Path()+".bak", targetPath+".bak"); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("renamed vault %s to %s but failed to move its backup, which still contains your secrets under the old name: %w", sourceName, targetName, err)
+	if err := moveBackups(sourceVault, Vault(targetPath), p, keyfile); err != nil {
+		return fmt.Errorf("renamed vault %s to %s but failed to move its backups, which still contain your secrets under the old name: %w", sourceName, targetName, err)
 	}
 	return nil
 }
 
+// bindsName reports whether the vault file at p has its name sealed into it.
+func bindsName(p string) (bool, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil {
+		return false, fmt.Errorf("cannot read vault file %q: %w", p, err)
+	}
+	return h.Name != "", nil
+}
+
+// rebind re-encrypts the source vault as the target, and removes the source.
+// The target is written in full before the source is removed, so that an
+// interruption leaves a vault under one name or the other, and at worst both.
+func rebind(source, target Vault, password, keyfile []byte) error {
+	src := source.UnlockedWithKeyfile(password, keyfile)
+	defer src.wipeKey()
+	defer src.wipeRecovery()
+	plaintext, err := src.Decrypt()
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(plaintext)
+	// The target is sealed as the source was opened, so that a team vault is
+	// sealed to its members under its own key rather than under the password.
+	dst := src
+	dst.Vault = target
+	if err := dst.Write(plaintext); err != nil {
+		return err
+	}
+	return os.Remove(source.Path())
+}
+
+// moveBackups moves each of the source vault's backups to the target, keeping
+// the time in its name, and carries on past one that fails so that as few as
+// possible are left under the old name.
+func moveBackups(source, target Vault, password, keyfile []byte) error {
+	paths, err := Backups(source)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, src := range paths {
+		dst := target.Path() + strings.TrimPrefix(src, source.Path())
+		if err := moveBackup(src, dst, source, target, password, keyfile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// moveBackup moves one of the source vault's backups to the target's. A
+// backup with the source's name sealed into it is re-encrypted under the
+// target's when password opens it. One that password does not open predates a
+// change of password, and is moved as it is, with a warning that it still
+// opens only as the vault it was.
+func moveBackup(src, dst string, source, target Vault, password, keyfile []byte) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	h, err := crypto.ReadHeader(b)
+	if err != nil || h.Name == "" {
+		return os.Rename(src, dst)
+	}
+	plaintext, ciphertext, err := reseal(b, h, source, target, password, keyfile)
+	if plaintext == nil {
+		warnf("the backup %s of vault %s does not open with its current password, so it was moved "+
+			"without being re-encrypted, and opens only as vault %s", filepath.Base(dst), target.Name(), source.Name())
+		return os.Rename(src, dst)
+	}
+	defer crypto.Wipe(plaintext)
+	if err != nil {
+		return err
+	}
+	// Named after the vault, since a backup's own name leaves no room for a
+	// temporary file's suffix.
+	if err := fs.WriteFileAtomicVia(dst, filepath.Base(target.Path()), ciphertext, 0600); err != nil && !errors.Is(err, fs.ErrDirSync) {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// reseal decrypts the file b, whose header is h, as the source vault, and
+// encrypts it again as the target. It returns nil plaintext for a file that
+// password does not open, and otherwise the plaintext for the caller to wipe.
+// A backup is sealed again with the keyfile only if it was sealed with one,
+// and with the recovery key it had, if any, so that its shares go on
+// opening it. A team vault's is opened with the identity that password
+// unseals, and sealed again to the members it had, under the key it had.
+func reseal(b []byte, h crypto.Header, source, target Vault, password, keyfile []byte) ([]byte, []byte, error) {
+	if !h.IsTeam() {
+		if !h.Keyfile {
+			keyfile = nil
+		}
+		plaintext, h, k, err := crypto.DecryptKey(b, password, keyfile, source.Salt(), source.Name())
+		if err != nil {
+			return nil, nil, nil
+		}
+		if k != nil {
+			k.Wipe()
+		}
+		if r := h.Recovery(); r != nil {
+			defer r.Wipe()
+		}
+		if k, err = crypto.DeriveKey(password, keyfile, target.Salt()); err != nil {
+			return plaintext, nil, err
+		}
+		defer k.Wipe()
+		ciphertext, err := crypto.EncryptWithRecovery(plaintext, k, h.Recovery(), target.Name())
+		return plaintext, ciphertext, err
+	}
+	id, err := identity.Open(password)
+	if err != nil {
+		return nil, nil, nil
+	}
+	defer id.Wipe()
+	plaintext, h, k, err := crypto.DecryptWithIdentity(b, id, source.Name())
+	if err != nil {
+		return nil, nil, nil
+	}
+	defer k.Wipe()
+	ciphertext, err := crypto.EncryptToRecipients(plaintext, k, target.Name(), h.Recipients)
+	return plaintext, ciphertext, err
+}
+
 // warnf prints a best-effort warning to stderr for cleanup failures that must
 // not fail the surrounding operation.
 func warnf(format string, args ...any) {
@@ -255,7 +448,7 @@ func warnf(format string, args ...any) {
 // removeTempFiles removes leftover temporary files from interrupted or failed
 // atomic writes of the vault at vaultPath.
 func removeTempFiles(vaultPath string) error {
-	matches, err := filepath.Glob(vaultPath + ".*.tmp")
+	matches, err := tempFiles(vaultPath)
 	if err != nil {
 		return err
 	}
@@ -268,6 +461,52 @@ func removeTempFiles(vaultPath string) error {
 	return errors.Join(errs...)
 }
 
+// tempFiles returns the temporary files that atomic writes of the vault at
+// vaultPath left behind. Outside a write that holds the vault's lock, every
+// one of them is stale.
+func tempFiles(vaultPath string) ([]string, error) {
+	return filepath.Glob(vaultPath + ".*.tmp")
+}
+
+// Info is what can be learned about a vault without its password.
+type Info struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+	Salt     string
+	// Backups are the vault as it was before each of its last saves, oldest
+	// first.
+	Backups []string
+	// TempFiles are left over from writes that were interrupted.
+	TempFiles []string
+	// Locked reports whether another process holds the vault's lock.
+	Locked bool
+}
+
+// Stat returns what can be learned about a vault without its password.
+func Stat(v Vault) (Info, error) {
+	fi, err := os.Stat(v.Path())
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Path:     v.Path(),
+		Size:     fi.Size(),
+		Modified: fi.ModTime(),
+		Salt:     v.Salt(),
+	}
+	if info.Backups, err = Backups(v); err != nil {
+		return Info{}, err
+	}
+	if info.TempFiles, err = tempFiles(v.Path()); err != nil {
+		return Info{}, err
+	}
+	if info.Locked, err = v.IsLocked(); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
 // Exists reports whether a vault with exactly the given name exists, whatever
 // salt its filename carries. A command may use it to refuse early, but Create
 // asks again while holding the lock, which is the answer that decides.
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -55,6 +56,7 @@ func TestFindVaultsIgnoresEverythingThatIsNotAVault(t *testing.T) {
 	for _, name := range []string{
 		"test.lock",
 		"test." + testSalt + ".bak",
+		"test." + testSalt + ".20261017T091244Z.bak",
 		"test." + testSalt + ".1234.tmp",
 		".DS_Store",
 		".test.swp",
@@ -78,6 +80,8 @@ func TestDeleteRemovesTheVaultAndItsCompanionFiles(t *testing.T) {
 	for _, name := range []string{
 		"test." + testSalt,
 		"test." + testSalt + ".bak",
+		"test." + testSalt + ".20261017T091244Z.bak",
+		"test." + testSalt + ".20261017T091245Z.bak",
 		"test." + testSalt + ".1234.tmp",
 		"test.lock",
 	} {
@@ -138,7 +142,10 @@ func TestRenameReportsBackupMoveFailure(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Exact() failed: %v", err)
 	}
-	if err = Rename(src, "dst"); err == nil {
+	// Arbitrary bytes carry no header, so nothing is decrypted and no
+	// password is asked for.
+	noPassword := func() ([]byte, error) { return nil, errors.New("unexpected password prompt") }
+	if err = Rename(src, "dst", noPassword, nil); err == nil {
 		t.Fatal("expected Rename() to return an error when the backup cannot be moved")
 	}
 	// The vault itself must still have been renamed, so that the error names
@@ -39,11 +39,18 @@ func run() int {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
 	go func() {
 		s := <-c
+		// While mrs exec runs a command, the command is what the signal is
+		// for: it is passed on, unless the terminal sent it to the command
+		// already, and mrs exits with the command's status once the command
+		// has gone.
+		for cmd.ForwardSignal(s) {
+			s = <-c
+		}
 		cleanup()
 		// 128+signum, as a shell reports a command its signal killed. mrs
-		// gives 1, 2 and 3 meanings of its own, so a run cut short must not
-		// exit with any of them: an interrupted search did not finish looking,
-		// and is neither a failure nor a search that matched nothing.
+		// gives 1 to 4 meanings of its own, so a run cut short must not exit
+		// with any of them: an interrupted search did not finish looking, and
+		// is neither a failure nor a search that matched nothing.
 		code := exitInterrupted
 		if sig, ok := s.(syscall.Signal); ok {
 			code = 128 + int(sig)
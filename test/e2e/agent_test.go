@@ -0,0 +1,137 @@
+package e2e
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Capability 14: typing a password once a session, with `agent`, which holds
+// the key each vault's password derives on a socket in $XDG_RUNTIME_DIR, for
+// the commands that would otherwise ask for the password again.
+
+// startAgent runs mrs agent in a runtime directory of the lab's own, and
+// returns it once it is listening. It is stopped when the test ends.
+func (l *lab) startAgent(args ...string) *exec.Cmd {
+	l.t.Helper()
+	dir := l.t.TempDir()
+	l.Setenv("XDG_RUNTIME_DIR", dir)
+	cmd := l.Start(append([]string{"agent"}, args...)...)
+	l.t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	waitForFile(l.t, filepath.Join(dir, "mrs-agent.sock"))
+	return cmd
+}
+
+func TestTheAgentSparesThePassword(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	l.startAgent()
+
+	// Nothing has given the agent a key yet, and there is no terminal to ask
+	// for the password on.
+	l.Run("get", "-v", "work", "github").AssertFailed()
+
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK().AssertStdoutExactly("gh-token\n")
+	l.Run("get", "-v", "work", "github").AssertOK().AssertStdoutExactly("gh-token\n")
+	l.Run("search", "-v", "work", "git").AssertOK().AssertStdout("gh-token")
+
+	// A vault written with the agent's key still opens with its password.
+	l.RunStdin("mail-pw\n", "set", "-v", "work", "mail").AssertOK()
+	l.Run("get", "-v", "work", "-p", pwFile, "mail").AssertOK().AssertStdoutExactly("mail-pw\n")
+
+	l.Run("agent", "lock").AssertOK().AssertStderr("The agent forgot every key it held")
+	l.Run("get", "-v", "work", "github").AssertFailed()
+}
+
+// mrs exec is done with the vault before the command it runs starts, and
+// leaves the key with the agent then, as every other command does.
+func TestTheAgentKeepsTheKeyExecOpenedTheVaultWith(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("work", "a password", "github\ngh-token\n")
+	l.startAgent()
+
+	r := l.RunTTY("a password\n", "exec", "-v", "work", "--env", "T=github", "--", "sh", "-c", `printf '%s' "$T"`).AssertOK()
+	if n := strings.Count(r.Output, "Vault password"); n != 1 {
+		t.Fatalf("expected one prompt for the password, got %d\n%s", n, r.Output)
+	}
+	// There is no terminal to ask on again.
+	l.Run("get", "-v", "work", "github").AssertOK().AssertStdoutExactly("gh-token\n")
+}
+
+// A key is held for one vault file, and a vault whose password changed, or
+// whose file was replaced by one that key does not open, asks for its
+// password again rather than fail on a key it no longer fits.
+func TestTheAgentDoesNotOutliveAPassword(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	l.seedVault("personal", "another password", "mail\nmail-pw\n")
+	l.startAgent()
+
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK()
+	l.Run("get", "-v", "personal", "mail").AssertFailed()
+
+	before := readFile(t, l.VaultPath("work"))
+	newPwFile := l.PasswordFile("new-pw", "a new password")
+	l.Run("vault", "change-password", "work", "-p", pwFile, "--new-password-file", newPwFile).AssertOK()
+	l.Run("get", "-v", "work", "github").AssertFailed()
+
+	l.Run("get", "-v", "work", "-p", newPwFile, "github").AssertOK()
+	if err := os.WriteFile(l.VaultPath("work"), []byte(before), 0600); err != nil {
+		t.Fatalf("failed to put the old vault file back: %v", err)
+	}
+	l.Run("get", "-v", "work", "github").AssertFailed().AssertNoOutput("gh-token")
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK().AssertStdoutExactly("gh-token\n")
+}
+
+func TestTheAgentForgetsOnceIdle(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	l.startAgent("--timeout", "1s")
+
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK()
+	l.Run("get", "-v", "work", "github").AssertOK()
+	time.Sleep(1500 * time.Millisecond)
+	l.Run("get", "-v", "work", "github").AssertFailed()
+}
+
+// One agent holds the keys of a session: a second is refused while the first
+// runs, and takes the place of one that was killed.
+func TestOnlyOneAgentRuns(t *testing.T) {
+	l := newLab(t)
+	first := l.startAgent()
+	l.Run("agent").AssertFailed().AssertStderr("an agent is already running on " + filepath.Join(l.Env["XDG_RUNTIME_DIR"], "mrs-agent.sock"))
+
+	if err := first.Process.Signal(syscall.SIGKILL); err != nil {
+		t.Fatalf("failed to kill the agent: %v", err)
+	}
+	_ = first.Wait()
+	second := l.Start("agent")
+	t.Cleanup(func() {
+		_ = second.Process.Kill()
+		_ = second.Wait()
+	})
+	deadline := time.Now().Add(15 * time.Second)
+	for l.Run("agent", "lock").Stderr != "The agent forgot every key it held\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the agent to replace the socket the killed one left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertFileMode(t, filepath.Join(l.Env["XDG_RUNTIME_DIR"], "mrs-agent.sock"), 0600)
+}
+
+func TestTheAgentNeedsARuntimeDirectory(t *testing.T) {
+	l := newLab(t)
+	l.Unsetenv("XDG_RUNTIME_DIR")
+	l.Run("agent").AssertFailed().AssertStderr("XDG_RUNTIME_DIR is not set")
+	l.Run("agent", "lock").AssertOK().AssertStderr("No agent is running")
+	l.Run("agent", "--timeout", "0s").AssertUsageError()
+	l.Run("agent", "unlock").AssertUsageError()
+}
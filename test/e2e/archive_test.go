@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Capability 23: moving every vault to another machine, with `archive create`,
+// which writes them, and with --backups their backups, to one file with a
+// manifest of their names, salts and checksums, and `archive restore`, which
+// checks every file against it and restores the vaults under the salts they
+// had.
+
+func TestAnArchiveMovesEveryVaultToAnotherMachine(t *testing.T) {
+	l := newLab(t)
+	workPw := l.seedVault("work", "a password", "db\nhunter2\n")
+	personalPw := l.seedVault("personal", "another password", "bank\nswordfish\n")
+	l.Run("set", "db", "-v", "work", "-p", workPw, "--value-file", l.WriteFile("value", "correct horse\n")).AssertOK()
+	workPath := l.VaultPath("work")
+	archivePw := l.PasswordFile("archive.pw", "an archive password")
+	archive := filepath.Join(l.Home, "vaults.mrs")
+
+	l.Run("archive", "create", archive, "-p", archivePw).AssertUsageError()
+	l.Run("archive", "create", archive, "--backups", "--encrypt", "-p", archivePw).
+		AssertOK().
+		AssertStderr("Archived 2 vaults and their backups")
+	l.Run("archive", "create", archive).AssertFailed().AssertStderr("already exists")
+
+	// Another machine, with no vaults yet.
+	if err := os.RemoveAll(l.VaultDir()); err != nil {
+		t.Fatal(err)
+	}
+	l.Run("archive", "restore", archive, "-p", personalPw).AssertFailed()
+	l.Run("vault", "list").AssertOK().AssertNoOutput("work")
+	l.Run("archive", "restore", archive, "-p", archivePw).
+		AssertOK().
+		AssertStderr("Restored vault work, with 1 backup")
+	if l.VaultPath("work") != workPath {
+		t.Fatalf("expected work to be restored under its salt, at %s", workPath)
+	}
+	l.Run("get", "db", "-v", "work", "-p", workPw).AssertOK().AssertStdout("correct horse")
+	l.Run("get", "bank", "-v", "personal", "-p", personalPw).AssertOK().AssertStdout("swordfish")
+	if len(l.Backups("work")) != 1 {
+		t.Fatalf("expected work's backup to be restored, got %v", l.Backups("work"))
+	}
+}
+
+func TestAnArchiveRestoresOverATakenNameOnlyWithRename(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "db\nhunter2\n")
+	archive := filepath.Join(l.Home, "vaults.mrs")
+	l.Run("archive", "create", archive).AssertOK().AssertStderr("Archived 1 vault to")
+
+	l.Run("archive", "restore", archive).
+		AssertFailed().
+		AssertStderr(`a vault named "work" already exists here`).
+		AssertStderr("--rename")
+	l.Run("archive", "restore", archive, "--rename", "--vault-password-file", pwFile).
+		AssertOK().
+		AssertStderr("Restored vault work as work-restored")
+	l.Run("get", "db", "-v", "work-restored", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+	l.Run("get", "db", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+
+	l.Run("archive", "restore", l.WriteFile("notes.txt", "db\nhunter2\n")).AssertFailed().AssertStderr("is not an archive")
+	l.Run("archive", "restore").AssertUsageError()
+}
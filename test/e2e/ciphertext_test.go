@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -146,7 +147,7 @@ func TestATamperedBackupIsRefused(t *testing.T) {
 	l.editorWrites("a key\nsecond-value\n")
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
 
-	backup := l.VaultPath("personal") + ".bak"
+	backup := l.latestBackup("personal")
 	tamper(t, backup, func(b []byte) []byte {
 		b[len(b)/2] ^= 0x01
 		return b
@@ -247,3 +248,110 @@ func TestNoTemporaryFileIsLeftInTheVaultDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestAVaultFileNamesHowItsKeyIsDerived(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("personal", "a password", "a key\na value\n")
+	path := l.VaultPath("personal")
+
+	// The header says what to derive the key with, so that opening a vault
+	// never has to try one derivation after another, and raising the cost
+	// later does not make every existing vault slower to open.
+	salt := strings.TrimPrefix(filepath.Base(path), "personal.")
+	kdf, ok := headerDecrypts(t, path, "a password", salt)
+	if !ok {
+		t.Fatal("expected the vault to decrypt with the key its header describes")
+	}
+	if kdf != argon2idID {
+		t.Fatalf("expected a new vault to derive its key with Argon2id, got KDF %d", kdf)
+	}
+}
+
+func TestAVaultFromANewerVersionSaysSo(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\nthe-secret-value\n")
+
+	tamper(t, l.VaultPath("personal"), func(b []byte) []byte {
+		b[4] = keyfileVersion + 1
+		return b
+	})
+	// No password would open it, so retyping one is not the remedy.
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertFailed().
+		AssertStderr("failed to decrypt").
+		AssertStderr("cannot read").
+		AssertNoOutput("the-secret-value")
+}
+
+func TestAVaultFileRenamedByHandIsRefused(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\nthe-secret-value\n")
+	path := l.VaultPath("personal")
+	salt := strings.TrimPrefix(filepath.Base(path), "personal.")
+
+	// The name is sealed into the file, so the file cannot pass for another
+	// vault, even with the same salt and password.
+	if err := os.Rename(path, filepath.Join(l.VaultDir(), "work."+salt)); err != nil {
+		t.Fatalf("failed to rename the vault file: %s", err)
+	}
+	// The password is right, so the answer says what is actually wrong.
+	l.Run("export", "-v", "work", "-p", pwFile).
+		AssertFailed().
+		AssertStderr("failed to decrypt vault work").
+		AssertStderr(`holds the secrets of another vault, "personal"`).
+		AssertStderr("mrs vault rename").
+		AssertNoOutput("the-secret-value")
+}
+
+func TestAVaultFileCopiedOverAnotherIsRefused(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\npersonal-value\n")
+	l.seedVault("work", "a password", "a key\nwork-value\n")
+
+	// Shared storage that syncs the vault directory can be written by
+	// someone other than the vault's owner, who may replace one vault's
+	// file with another's under its name.
+	workPath := l.VaultPath("work")
+	salt := strings.TrimPrefix(filepath.Base(workPath), "work.")
+	if err := os.Remove(l.VaultPath("personal")); err != nil {
+		t.Fatalf("failed to remove the vault file: %s", err)
+	}
+	copyFile(t, workPath, filepath.Join(l.VaultDir(), "personal."+salt))
+
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertFailed().
+		AssertStderr(`holds the secrets of another vault, "work"`).
+		AssertNoOutput("work-value")
+	l.Run("export", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("work-value")
+}
+
+func TestRenameSealsTheNewNameIntoTheVaultAndItsBackup(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\nfirst-value\n")
+	l.editorWrites("a key\nsecond-value\n")
+	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
+
+	// Sealing the new name in re-encrypts the vault, which needs its
+	// password, and without a terminal there is nothing to ask it from.
+	l.Run("vault", "rename", "personal", "renamed").
+		AssertFailed().
+		AssertStderr("--password-file")
+	l.Run("vault", "list").AssertOK().AssertStdoutEquals("personal")
+
+	l.Run("vault", "rename", "personal", "renamed", "-p", pwFile).AssertOK()
+
+	path := l.VaultPath("renamed")
+	if got := sealedName(t, path); got != "renamed" {
+		t.Fatalf("expected the vault to be sealed as renamed, got %q", got)
+	}
+	backup := l.latestBackup("renamed")
+	if got := sealedName(t, backup); got != "renamed" {
+		t.Fatalf("expected the backup to be sealed as renamed, got %q", got)
+	}
+	// So that restoring the backup, by copying it over the vault, still
+	// works under the new name.
+	copyFile(t, backup, path)
+	l.Run("export", "-v", "renamed", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("a key\nfirst-value\n")
+}
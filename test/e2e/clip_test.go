@@ -0,0 +1,111 @@
+package e2e
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Capability 18: copying a secret without printing it, with `search --clip`,
+// which hands the value of the one secret that matches to the clipboard
+// program $MRS_CLIPBOARD names, and clears it after $MRS_CLIPBOARD_TIMEOUT if
+// the clipboard still holds it.
+
+const clipVault = "github\nuser: alice\npassword: gh-password\n\n" +
+	"gitlab\ngl-token\n\n" +
+	"mail\nmail-pw\n"
+
+// waitForClipboard waits for the clipboard kept at p to hold want.
+func waitForClipboard(t *testing.T, p, want string) {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		b, _ := os.ReadFile(p)
+		if string(b) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the clipboard to hold %q; it holds %q", want, b)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestClipCopiesAndThenClears(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", clipVault)
+	clip := l.fakeClipboard()
+	l.Setenv("MRS_CLIPBOARD_TIMEOUT", "1s")
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "^github", "--field", "password").
+		AssertOK().
+		AssertStdoutExactly("").
+		AssertStderr(`Copied secret "github" from vault work to the clipboard, which will be cleared in 1s`)
+	if got := readFile(t, clip); got != "gh-password" {
+		t.Fatalf("expected the clipboard to hold the password, got %q", got)
+	}
+	waitForClipboard(t, clip, "")
+
+	// Without --field, the value is every line after the key.
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "mail").AssertOK()
+	if got := readFile(t, clip); got != "mail-pw" {
+		t.Fatalf("expected the clipboard to hold the value, got %q", got)
+	}
+}
+
+// What the user copied after mrs did is theirs, and is not cleared.
+func TestClipLeavesWhatWasCopiedSince(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", clipVault)
+	clip := l.fakeClipboard()
+	l.Setenv("MRS_CLIPBOARD_TIMEOUT", "2s")
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "mail").AssertOK()
+	if err := os.WriteFile(clip, []byte("something else"), 0600); err != nil {
+		t.Fatalf("failed to copy something else: %v", err)
+	}
+	time.Sleep(4 * time.Second)
+	if got := readFile(t, clip); got != "something else" {
+		t.Fatalf("expected what was copied since to be left, got %q", got)
+	}
+}
+
+func TestClipNeedsOneSecret(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", clipVault)
+	clip := l.fakeClipboard()
+
+	r := l.Run("search", "-v", "work", "-p", pwFile, "--clip", "^git")
+	if r.ExitCode != 4 {
+		t.Fatalf("expected a search that matched two to exit 4, got %d\n%s", r.ExitCode, r.describe())
+	}
+	r.AssertStderr(`more than one secret matched "^git" in vault work: 2 did`)
+	if r := l.Run("search", "-v", "work", "-p", pwFile, "--clip", "nothing"); r.ExitCode != 3 {
+		t.Fatalf("expected a search that matched nothing to exit 3, got %d\n%s", r.ExitCode, r.describe())
+	}
+	if _, err := os.Stat(clip); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to have been copied, got %v", err)
+	}
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "--format", "json", "mail").AssertUsageError()
+	l.Run("search", "-p", pwFile, "--clip", "--all-vaults", "mail").AssertUsageError()
+}
+
+// A clipboard mrs cannot read back is one it could not tell whether to clear,
+// so it is refused before the vault is opened.
+func TestClipNeedsAClipboardItCanReadBack(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", clipVault)
+	l.Setenv("MRS_CLIPBOARD", "my-clipboard --copy")
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "mail").
+		AssertFailed().
+		AssertStderr("Set MRS_CLIPBOARD_PASTE").
+		AssertNoOutput("mail-pw")
+
+	l.Setenv("PATH", t.TempDir())
+	l.Unsetenv("MRS_CLIPBOARD")
+	l.Run("search", "-v", "work", "-p", pwFile, "--clip", "mail").
+		AssertFailed().
+		AssertStderr("no clipboard program was found")
+}
@@ -403,11 +403,12 @@ func TestNothingButDataIsEverWrittenToStdout(t *testing.T) {
 	}
 }
 
-// 2 is kept for a wrong invocation and 3 for a search that matched nothing, so
-// that a script can tell those apart from a command that ran and failed.
+// 2 is kept for a wrong invocation, 3 for a search or get that matched nothing
+// and 4 for a get that matched several, so that a script can tell those apart
+// from a command that ran and failed.
 func TestExitCodesDistinguishUsageFromFailureFromNoMatch(t *testing.T) {
 	l := newLab(t)
-	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+	pwFile := l.seedVault("work", "a password", "a key\na value\n\nshared\none\n\nSHARED\ntwo\n")
 
 	for _, c := range []struct {
 		desc string
@@ -427,6 +428,10 @@ func TestExitCodesDistinguishUsageFromFailureFromNoMatch(t *testing.T) {
 		{"an argument a command does not take", []string{"vault", "list", "extra"}, 2},
 		{"a search with nothing to search for", []string{"search", "-v", "work", "-p", pwFile}, 2},
 		{"a search that matched nothing", []string{"search", "-v", "work", "-p", pwFile, "zzz"}, 3},
+		{"a get with no key", []string{"get", "-v", "work", "-p", pwFile}, 2},
+		{"a get that matched", []string{"get", "-v", "work", "-p", pwFile, "a key"}, 0},
+		{"a get that matched nothing", []string{"get", "-v", "work", "-p", pwFile, "zzz"}, 3},
+		{"a get that matched several", []string{"get", "-v", "work", "-p", pwFile, "shared"}, 4},
 	} {
 		t.Run(c.desc, func(t *testing.T) {
 			if r := l.Run(c.args...); r.ExitCode != c.want {
@@ -451,7 +456,7 @@ func TestOnlyDataIsWrittenToStdoutWhenACommandSucceeds(t *testing.T) {
 		{"create", []string{"vault", "create", "second", "-p", pwFile}, ""},
 		{"add", []string{"add", "-v", "second", "-p", pwFile}, ""},
 		{"edit", []string{"edit", "-v", "second", "-p", pwFile}, ""},
-		{"rename", []string{"vault", "rename", "second", "third"}, ""},
+		{"rename", []string{"vault", "rename", "second", "third", "-p", pwFile}, ""},
 		{"change-password", []string{"vault", "change-password", "third", "-p", pwFile, "-n", pwFile}, ""},
 		{"delete", []string{"vault", "delete", "third", "--yes"}, ""},
 		{"list", []string{"vault", "list"}, "work\n"},
@@ -0,0 +1,334 @@
+package e2e
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Capability 12: handing secrets to another program, with `exec`, which sets
+// them as environment variables of a command it runs and waits for, and with
+// `inject`, which fills the placeholders in a template with them.
+
+const execVault = "postgres\nuser: app\npassword: pg-secret\n\n" +
+	"github\ngh-token\n\n" +
+	"https://host:8443\nhost-value\n"
+
+func TestExecSetsTheEnvironment(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	// A field, a whole value of one line, and a key with a colon of its own,
+	// which a trailing colon names whole.
+	l.Run("exec", "-v", "work", "-p", pwFile,
+		"--env", "DB_PASSWORD=postgres:password",
+		"--env", "TOKEN=GitHub",
+		"--env", "HOST=https://host:8443:",
+		"--", "sh", "-c", `printf '%s|%s|%s' "$DB_PASSWORD" "$TOKEN" "$HOST"`).
+		AssertOK().
+		AssertStdoutExactly("pg-secret|gh-token|host-value")
+}
+
+func TestExecSetsAValueOfSeveralLinesWithoutItsLastNewline(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	l.Run("exec", "-v", "work", "-p", pwFile, "--env", "PG=postgres", "--", "sh", "-c", `printf '[%s]' "$PG"`).
+		AssertOK().
+		AssertStdoutExactly("[user: app\npassword: pg-secret]")
+}
+
+func TestExecOverridesAVariableAlreadySet(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+	l.Setenv("TOKEN", "from the parent")
+
+	l.Run("exec", "-v", "work", "-p", pwFile, "--env", "TOKEN=github", "--", "sh", "-c", `env | grep '^TOKEN='`).
+		AssertOK().
+		AssertStdoutExactly("TOKEN=gh-token\n")
+}
+
+func TestExecTakesTheCommandsFlagsAsItsOwn(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	// Without a "--", mrs's flags end at the command's name, so -v and -p
+	// after it belong to the command.
+	l.Run("exec", "-v", "work", "-p", pwFile, "--env", "T=github", "sh", "-c", `printf '%s %s' "$0" "$T"`, "-v").
+		AssertOK().
+		AssertStdoutExactly("-v gh-token")
+}
+
+func TestExecExitsWithTheCommandsStatus(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	for _, c := range []struct {
+		desc string
+		args []string
+		want int
+	}{
+		{"a command that worked", []string{"true"}, 0},
+		{"a command that failed", []string{"sh", "-c", "exit 7"}, 7},
+		// 1, 2 and 3 are mrs's own, but once the command has started, its
+		// status is the one that counts.
+		{"a command that exits 2", []string{"sh", "-c", "exit 2"}, 2},
+		{"a command its signal killed", []string{"sh", "-c", "kill -TERM $$"}, 128 + int(syscall.SIGTERM)},
+		{"a command that is not there", []string{"no-such-command"}, 127},
+		{"a command that cannot run", []string{l.WriteFile("not-executable", "#!/bin/sh\n")}, 126},
+	} {
+		t.Run(c.desc, func(t *testing.T) {
+			args := append([]string{"exec", "-v", "work", "-p", pwFile, "--env", "T=github", "--"}, c.args...)
+			if r := l.Run(args...); r.ExitCode != c.want {
+				t.Errorf("expected exit %d, got %d\n%s", c.want, r.ExitCode, r.describe())
+			}
+		})
+	}
+	l.Run("exec", "-v", "work", "-p", pwFile, "--env", "T=github", "--", "sh", "-c", "echo failing >&2; exit 7").
+		AssertNoOutput("Error")
+	l.Run("exec", "-v", "work", "-p", pwFile, "--env", "T=github", "--", "no-such-command").
+		AssertStderr("failed to run no-such-command")
+}
+
+func TestExecRunsNothingForAValueItCannotFind(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault+"\ngithub\nanother token\n")
+	ran := filepath.Join(l.UserHome, "ran")
+
+	for _, c := range []struct {
+		env, want string
+		status    int
+	}{
+		{"T=nosuch", "no secret has the key \"nosuch\" in vault work", 3},
+		{"T=postgres:pin", "the secret \"postgres\" in vault work has no field \"pin\"", 3},
+		{"T=github", "more than one secret has the key \"github\"", 4},
+	} {
+		r := l.Run("exec", "-v", "work", "-p", pwFile, "--env", c.env, "--", "touch", ran).
+			AssertFailed().
+			AssertStderr(c.want)
+		if r.ExitCode != c.status {
+			t.Errorf("expected exit %d for %s, got %d", c.status, c.env, r.ExitCode)
+		}
+		assertNotExists(t, ran)
+	}
+}
+
+func TestExecChecksItsArguments(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--env", "T=github"}, "requires a command to run"},
+		{[]string{"--", "true"}, "requires at least one --env"},
+		{[]string{"--env", "T", "--", "true"}, "--env takes NAME=key"},
+		{[]string{"--env", "1T=github", "--", "true"}, "does not name an environment variable"},
+		{[]string{"--env", "T=:password", "--", "true"}, "names no key"},
+		{[]string{"--env", "T=github", "--env", "T=postgres", "--", "true"}, "--env sets T more than once"},
+	} {
+		args := append([]string{"exec", "-v", "work", "-p", pwFile}, c.args...)
+		l.Run(args...).AssertUsageError().AssertStderr(c.want)
+	}
+}
+
+func TestExecPassesSignalsOnToTheCommand(t *testing.T) {
+	for _, s := range []struct {
+		name string
+		sig  syscall.Signal
+	}{
+		{"SIGTERM", syscall.SIGTERM},
+		{"SIGHUP", syscall.SIGHUP},
+	} {
+		t.Run(s.name, func(t *testing.T) {
+			l := newLab(t)
+			pwFile := l.seedVault("work", "a password", execVault)
+			ready := filepath.Join(l.UserHome, "ready")
+			caught := filepath.Join(l.UserHome, "caught")
+
+			// The command catches the signal and exits with a status of its
+			// own, which mrs exits with in place of 128+signum: the command
+			// chose how to end, and mrs stayed to report it.
+			script := `trap 'echo "$T" > "$CAUGHT"; exit 9' ` + strings.TrimPrefix(s.name, "SIG") +
+				`; touch "$READY"; while :; do sleep 0.1; done`
+			l.Setenv("READY", ready)
+			l.Setenv("CAUGHT", caught)
+			cmd := l.Start("exec", "-v", "work", "-p", pwFile, "--env", "T=github", "--", "sh", "-c", script)
+			waitForFile(t, ready)
+
+			if err := cmd.Process.Signal(s.sig); err != nil {
+				t.Fatalf("failed to signal mrs: %s", err)
+			}
+			state, err := cmd.Process.Wait()
+			if err != nil {
+				t.Fatalf("failed to wait for mrs: %s", err)
+			}
+			if got := state.ExitCode(); got != 9 {
+				t.Fatalf("expected mrs to exit with the command's 9 after %s, got %d", s.name, got)
+			}
+			if b, err := os.ReadFile(caught); err != nil || string(b) != "gh-token\n" {
+				t.Fatalf("expected the command to catch %s with its secret set, got %q (err: %v)", s.name, b, err)
+			}
+		})
+	}
+}
+
+// A terminal sends Ctrl-C and Ctrl-\ to every process in its foreground group,
+// which is the command as well as mrs, so mrs leaves them to the command
+// rather than send it a second one, which many programs read as a demand to
+// stop at once.
+func TestExecLeavesATerminalsInterruptToTheCommand(t *testing.T) {
+	for _, s := range []struct {
+		name string
+		sig  syscall.Signal
+	}{
+		{"SIGINT", syscall.SIGINT},
+		{"SIGQUIT", syscall.SIGQUIT},
+	} {
+		t.Run(s.name, func(t *testing.T) {
+			l := newLab(t)
+			pwFile := l.seedVault("work", "a password", execVault)
+			ready := filepath.Join(l.UserHome, "ready")
+			caught := filepath.Join(l.UserHome, "caught")
+
+			script := `trap 'echo caught >> "$CAUGHT"' ` + strings.TrimPrefix(s.name, "SIG") +
+				`; trap 'exit 9' TERM; touch "$READY"; while :; do sleep 0.1; done`
+			l.Setenv("READY", ready)
+			l.Setenv("CAUGHT", caught)
+			// A process group of its own, as a shell gives a job, for the
+			// signal to go to as a terminal's does.
+			cmd := exec.Command(mrsBin, "exec", "-v", "work", "-p", pwFile, "--env", "T=github", "--", "sh", "-c", script)
+			cmd.Env = l.environ()
+			cmd.Dir = l.UserHome
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("failed to start mrs: %s", err)
+			}
+			waitForFile(t, ready)
+
+			if err := syscall.Kill(-cmd.Process.Pid, s.sig); err != nil {
+				t.Fatalf("failed to signal mrs's process group: %s", err)
+			}
+			waitForFile(t, caught)
+			// Time for a second one to arrive, were mrs to send it.
+			time.Sleep(500 * time.Millisecond)
+			if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+				t.Fatalf("failed to signal mrs: %s", err)
+			}
+			state, err := cmd.Process.Wait()
+			if err != nil {
+				t.Fatalf("failed to wait for mrs: %s", err)
+			}
+			if got := state.ExitCode(); got != 9 {
+				t.Fatalf("expected mrs to stay for the command, and exit with its 9, got %d", got)
+			}
+			if b, err := os.ReadFile(caught); err != nil || string(b) != "caught\n" {
+				t.Fatalf("expected the command to catch %s once, got %q (err: %v)", s.name, b, err)
+			}
+		})
+	}
+}
+
+func TestInjectFillsATemplate(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+	l.seedVault("personal", "a password", "mail\nuser: me@example.org\n")
+	tmpl := l.WriteFile("config.tmpl",
+		"db:\n  user: {{ mrs \"work/postgres\" \"user\" }}\n  password: {{ mrs \"work/postgres\" \"password\" }}\n"+
+			"mail: {{ mrs \"personal/mail\" \"user\" }}\n"+
+			"token: {{ mrs \"work/github\" }}\n"+
+			"left alone: {{ .Values.token }}\n")
+	out := filepath.Join(l.UserHome, "config.yaml")
+
+	l.Run("inject", "-i", tmpl, "-o", out, "-p", pwFile).
+		AssertOK().
+		AssertStderr("Filled 4 placeholders from 2 vaults into " + out)
+	want := "db:\n  user: app\n  password: pg-secret\nmail: me@example.org\ntoken: gh-token\n" +
+		"left alone: {{ .Values.token }}\n"
+	if got := readFile(t, out); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+	assertFileMode(t, out, 0600)
+}
+
+func TestInjectWritesToStdoutWithoutAnOutputFile(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+	// A placeholder that names no vault reads the one --vault names.
+	tmpl := l.WriteFile("t", "TOKEN={{ mrs \"github\" }}\n")
+
+	l.Run("inject", "-v", "work", "-p", pwFile, "-i", tmpl).
+		AssertOK().
+		AssertStdoutExactly("TOKEN=gh-token\n")
+}
+
+func TestInjectTightensAnOutputFileOthersCouldRead(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+	tmpl := l.WriteFile("t", "{{ mrs \"work/github\" }}\n")
+	out := l.WriteFile("out", "old contents\n")
+	if err := os.Chmod(out, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Run("inject", "-p", pwFile, "-i", tmpl, "-o", out).AssertOK()
+	assertFileMode(t, out, 0600)
+	if got := readFile(t, out); got != "gh-token\n" {
+		t.Fatalf("expected the filled template, got %q", got)
+	}
+}
+
+func TestInjectWritesNothingForAReferenceItCannotResolve(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+	out := filepath.Join(l.UserHome, "out")
+
+	for _, c := range []struct {
+		tmpl, want string
+	}{
+		{`{{ mrs "work/github" }} {{ mrs "work/nosuch" }}`, `no secret has the key "nosuch" in vault work`},
+		{`{{ mrs "work/postgres" "pin" }}`, `the secret "postgres" in vault work has no field "pin"`},
+		{`{{ mrs "nosuch/github" }}`, `vault "nosuch" not found`},
+		// A template names a vault in full, not by the start of its name.
+		{`{{ mrs "wo/github" }}`, `vault "wo" not found. Did you mean "work"?`},
+		{"line one\n{{ mrs work/github }}", "t:2: a placeholder is"},
+	} {
+		tmpl := l.WriteFile("t", c.tmpl)
+		l.Run("inject", "-p", pwFile, "-i", tmpl, "-o", out).
+			AssertFailed().
+			AssertStderr(c.want)
+		assertNotExists(t, out)
+	}
+}
+
+func TestInjectRequiresATemplate(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", execVault)
+
+	l.Run("inject", "-p", pwFile).
+		AssertUsageError().
+		AssertStderr("requires --input-file")
+	l.Run("inject", "-p", pwFile, "-i", filepath.Join(l.UserHome, "nosuch")).
+		AssertFailed().
+		AssertStderr("failed to read template")
+}
+
+func TestInjectAsksForEachVaultsPasswordOnce(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("work", "a password", execVault)
+	tmpl := l.WriteFile("t", strings.Repeat("{{ mrs \"work/github\" }} {{ mrs \"work/postgres\" \"user\" }}\n", 3))
+
+	// A second prompt would wait for an answer that never comes.
+	r := l.RunTTY("a password\n", "inject", "-i", tmpl).AssertOK()
+	if n := strings.Count(r.Output, "Password for vault work"); n != 1 {
+		t.Fatalf("expected one prompt for vault work, got %d\n%s", n, r.Output)
+	}
+	if n := strings.Count(r.Output, "gh-token app"); n != 3 {
+		t.Fatalf("expected the template filled three times, got\n%s", r.Output)
+	}
+}
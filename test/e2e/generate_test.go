@@ -0,0 +1,85 @@
+package e2e
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Capability 16: making up new secrets, with `generate`, which prints a
+// password or passphrase, and `add --generate`, which stores one as a new
+// secret without it passing through an editor or the clipboard.
+
+func TestGeneratePrintsAPassword(t *testing.T) {
+	l := newLab(t)
+
+	out := l.Run("generate").AssertOK().Stdout
+	if !regexp.MustCompile(`^[[:graph:]]{24}\n$`).MatchString(out) {
+		t.Fatalf("expected a password of 24 printable characters, got %q", out)
+	}
+	if again := l.Run("generate").AssertOK().Stdout; again == out {
+		t.Fatalf("two passwords were the same: %q", out)
+	}
+
+	out = l.Run("generate", "--length", "64", "--classes", "lower,digit", "--no-ambiguous").AssertOK().Stdout
+	if !regexp.MustCompile(`^[a-z2-9]{64}\n$`).MatchString(out) || strings.ContainsAny(out, "lo1") {
+		t.Fatalf("expected 64 lowercase letters and digits without ambiguous ones, got %q", out)
+	}
+}
+
+func TestGeneratePrintsAPassphrase(t *testing.T) {
+	l := newLab(t)
+
+	out := l.Run("generate", "--words", "5", "--separator", " ").AssertOK().Stdout
+	if !regexp.MustCompile(`^[a-z-]+( [a-z-]+){4}\n$`).MatchString(out) {
+		t.Fatalf("expected five words separated by spaces, got %q", out)
+	}
+}
+
+func TestGenerateRefusesWhatItCannotMake(t *testing.T) {
+	l := newLab(t)
+
+	l.Run("generate", "--words", "4", "--length", "10").AssertUsageError().AssertStderr("--words makes a passphrase")
+	l.Run("generate", "--separator", "_").AssertUsageError().AssertStderr("needs --words")
+	l.Run("generate", "--classes", "lower,emoji").AssertUsageError().AssertStderr(`unknown character class "emoji"`)
+	l.Run("generate", "--length", "3").AssertUsageError().AssertStderr("each of its 4 classes")
+	l.Run("generate", "--length", "0", "--classes", "digit").AssertUsageError()
+	l.Run("generate", "--words", "0").AssertUsageError()
+}
+
+func TestAddGenerateStoresANewSecret(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	// An editor that fails, which add --generate must not open.
+	l.Setenv("EDITOR", "false")
+
+	printed := l.Run("add", "-v", "work", "-p", pwFile, "--generate", "wifi", "--words", "4", "--print").
+		AssertOK().
+		AssertStderr(`Added secret "wifi" to vault work`).
+		Stdout
+	if strings.Count(printed, "-") != 3 {
+		t.Fatalf("expected a passphrase of four words, got %q", printed)
+	}
+	l.Run("get", "-v", "work", "-p", pwFile, "wifi").AssertOK().AssertStdoutExactly(printed)
+
+	// Without --print the value goes only into the vault.
+	l.Run("add", "-v", "work", "-p", pwFile, "--generate", "mail").AssertOK().AssertStdoutExactly("")
+	stored := l.Run("get", "-v", "work", "-p", pwFile, "mail").AssertOK().Stdout
+	if len(stored) != 25 {
+		t.Fatalf("expected a password of 24 characters, got %q", stored)
+	}
+}
+
+func TestAddGenerateRefusesAKeyInUse(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+
+	l.Run("add", "-v", "work", "-p", pwFile, "--generate", "GitHub", "--print").
+		AssertFailed().
+		AssertStderr(`vault work already has a secret "GitHub"`).
+		AssertStdoutExactly("")
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK().AssertStdoutExactly("gh-token\n")
+
+	l.Run("add", "-v", "work", "-p", pwFile, "--print").AssertUsageError().AssertStderr("--generate <key>")
+	l.Run("add", "-v", "work", "-p", pwFile, "--generate", " ").AssertUsageError()
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -20,10 +21,13 @@ var (
 	mrsBin string
 	// editorBin is the path to the scriptable fake editor.
 	editorBin string
+	// clipboardBin is the path to the fake clipboard.
+	clipboardBin string
 )
 
-// TestMain builds the binary under test and the fake editor once, so that every
-// test runs against a real executable rather than in-process code.
+// TestMain builds the binary under test, the fake editor and the fake
+// clipboard once, so that every test runs against a real executable rather
+// than in-process code.
 func TestMain(m *testing.M) {
 	os.Exit(runMain(m))
 }
@@ -46,6 +50,11 @@ func runMain(m *testing.M) int {
 		fmt.Fprintf(os.Stderr, "failed to build fake editor: %s\n", err)
 		return 1
 	}
+	clipboardBin = filepath.Join(buildDir, "fake-clipboard")
+	if err := build(clipboardBin, "./testdata/fakeclipboard"); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build fake clipboard: %s\n", err)
+		return 1
+	}
 	return m.Run()
 }
 
@@ -137,7 +146,7 @@ type lab struct {
 // newLab returns an isolated installation of mrs backed by real directories.
 // Each lab has its own directories and environment and drives mrs as a
 // subprocess, so tests share nothing and run in parallel, which matters because
-// every command derives a key with 600,000 PBKDF2 iterations by design.
+// every command derives a key with Argon2id at 64 MiB by design.
 func newLab(t *testing.T) *lab {
 	t.Helper()
 	t.Parallel()
@@ -321,6 +330,29 @@ func (l *lab) VaultPath(name string) string {
 	return vaults[0]
 }
 
+// Backups returns the paths of a vault's backups, oldest first. Each is named
+// for the time it was made, which sorts in the order they were made.
+func (l *lab) Backups(name string) []string {
+	l.t.Helper()
+	matches, err := filepath.Glob(l.VaultPath(name) + ".*.bak")
+	if err != nil {
+		l.t.Fatalf("failed to glob for backups of vault %s: %s", name, err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// latestBackup returns the path of a vault's newest backup, and fails the test
+// if it has none.
+func (l *lab) latestBackup(name string) string {
+	l.t.Helper()
+	backups := l.Backups(name)
+	if len(backups) == 0 {
+		l.t.Fatalf("expected vault %s to have a backup (all files: %v)", name, l.Vaults())
+	}
+	return backups[len(backups)-1]
+}
+
 // createVault creates a vault non-interactively and returns its password file.
 func (l *lab) createVault(name, password string) string {
 	l.t.Helper()
@@ -359,6 +391,16 @@ func (l *lab) captureEditorInput() func() string {
 	return func() string { return readFile(l.t, p) }
 }
 
+// fakeClipboard points mrs at a clipboard kept in a file of the lab's own, and
+// returns that file's path.
+func (l *lab) fakeClipboard() string {
+	p := filepath.Join(filepath.Dir(l.Home), "clipboard")
+	l.Setenv("MRS_CLIPBOARD", clipboardBin+" copy")
+	l.Setenv("MRS_CLIPBOARD_PASTE", clipboardBin+" paste")
+	l.Setenv("FAKE_CLIPBOARD_FILE", p)
+	return p
+}
+
 // export returns a vault's decrypted contents.
 func (l *lab) export(name, pwFile string) string {
 	l.t.Helper()
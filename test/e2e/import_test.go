@@ -0,0 +1,130 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Capability 13: moving secrets in from another password manager, with
+// `import`, which reads its export into the key and name: value lines of an mrs
+// secret.
+
+const bitwardenExport = `{"encrypted": false, "folders": [], "items": [
+	{"type": 1, "name": "github", "notes": null,
+	 "login": {"username": "alice", "password": "gh-pw", "uris": [{"uri": "https://github.com"}]}}
+]}`
+
+func TestImportAddsToAnExistingVault(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\nthe old one\n\nmail\nmail-pw\n")
+	export := l.WriteFile("bitwarden.json", bitwardenExport)
+
+	// The vault already has a github, so the import warns of a second one
+	// rather than replace what may be the newer of the two.
+	l.Run("import", "-v", "work", "-p", pwFile, "--format", "bitwarden-json", export).
+		AssertOK().
+		AssertStdoutExactly("").
+		AssertStderr("Imported 1 secret from " + export + " into vault work").
+		AssertStderr(`Warning: 2 secrets share the key "github"`)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--field", "password", "github").
+		AssertOK().
+		AssertStdoutExactly("gh-pw\n")
+	l.Run("get", "-v", "work", "-p", pwFile, "mail").AssertOK()
+}
+
+func TestImportCreateMakesANewVault(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.PasswordFile("pw", "a password")
+	export := l.WriteFile("secrets.env", "export API_KEY=abc123\nDB_URL=\"postgres://db/app\"\n")
+
+	l.Run("import", "--create", "-v", "app", "-p", pwFile, "--format", "dotenv", export).
+		AssertOK().
+		AssertStderr("Created vault app with 2 secrets from " + export)
+
+	l.Run("export", "-v", "app", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("API_KEY\nabc123\n\nDB_URL\npostgres://db/app\n")
+
+	// A second --create would overwrite the first, so it is refused.
+	l.Run("import", "--create", "-v", "app", "-p", pwFile, "--format", "dotenv", export).
+		AssertFailed().
+		AssertStderr("already exists")
+}
+
+func TestImportReadsAPasswordStore(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "")
+	store := filepath.Join(l.UserHome, ".password-store")
+	if err := os.MkdirAll(filepath.Join(store, "email"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store, "email", "work.gpg"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A stand-in for pass, which would decrypt the entry with gpg.
+	bin := filepath.Join(l.UserHome, "bin")
+	if err := os.MkdirAll(bin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fake := "#!/bin/sh\n[ \"$1 $2\" = \"show email/work\" ] || exit 1\nprintf 'hunter2\\nuser: alice\\n'\n"
+	if err := os.WriteFile(filepath.Join(bin, "pass"), []byte(fake), 0700); err != nil {
+		t.Fatal(err)
+	}
+	l.Setenv("PATH", bin+string(os.PathListSeparator)+l.Env["PATH"])
+
+	l.Run("import", "-v", "work", "-p", pwFile, "--format", "pass").
+		AssertOK().
+		AssertStderr("Imported 1 secret from the password store " + store)
+
+	l.Run("get", "-v", "work", "-p", pwFile, "email/work").
+		AssertOK().
+		AssertStdoutExactly("password: hunter2\nuser: alice\n")
+}
+
+func TestImportLeavesTheVaultUnchangedForAnExportItCannotRead(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+	broken := l.WriteFile("broken.json", `{"items": [`)
+
+	l.Run("import", "-v", "work", "-p", pwFile, "--format", "bitwarden-json", broken).
+		AssertFailed().
+		AssertStderr("could not import " + broken)
+	l.Run("import", "-v", "work", "-p", pwFile, "--format", "csv", filepath.Join(l.UserHome, "nosuch.csv")).
+		AssertFailed().
+		AssertStderr("could not read from import file")
+
+	l.Run("export", "-v", "work", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("a key\na value\n")
+}
+
+func TestImportChecksItsArguments(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "")
+	export := l.WriteFile("export.csv", "name,password\na,b\n")
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{export}, "requires --format"},
+		{[]string{"--format", "lastpass", export}, `--format must be`},
+		{[]string{"--format", "csv"}, "requires the file to import"},
+		{[]string{"--format", "csv", export, "another"}, "and no more"},
+	} {
+		args := append([]string{"import", "-v", "work", "-p", pwFile}, c.args...)
+		l.Run(args...).
+			AssertUsageError().
+			AssertStderr(c.want)
+	}
+	l.Run("import", "--create", "-p", pwFile, "--format", "csv", export).
+		AssertUsageError().
+		AssertStderr("--create requires --vault")
+
+	if got := l.export("work", pwFile); strings.TrimSpace(got) != "" {
+		t.Fatalf("expected the vault to be left empty, got %q", got)
+	}
+}
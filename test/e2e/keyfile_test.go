@@ -0,0 +1,72 @@
+package e2e
+
+import "testing"
+
+// Capability 20: a keyfile as a second factor, which `vault create --keyfile`
+// and `vault change-password --new-keyfile` derive a vault's key from along
+// with its password, and which every command that opens the vault takes as
+// --keyfile.
+
+func TestAVaultWithAKeyfileOpensOnlyWithBoth(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.PasswordFile("pw", "a password")
+	keyfile := l.WriteFile("mrs.key", "the contents of a keyfile on a usb stick")
+	other := l.WriteFile("other.key", "the contents of another keyfile")
+	l.Run("vault", "create", "work", "-p", pwFile, "--keyfile", keyfile, "-i", l.WriteFile("import", "db\nhunter2\n")).
+		AssertOK().
+		AssertStderr("keyfile")
+
+	// Without it, the user is told what is missing rather than that the
+	// password is wrong.
+	l.Run("export", "-v", "work", "-p", pwFile).
+		AssertFailed().
+		AssertStderr("needs its keyfile").
+		AssertStderr("--keyfile").
+		AssertNoOutput("hunter2")
+	l.Run("vault", "info", "work", "-p", pwFile).AssertFailed().AssertStderr("needs its keyfile")
+	l.Run("export", "-v", "work", "-p", pwFile, "--keyfile", other).
+		AssertFailed().
+		AssertStderr("password and its keyfile").
+		AssertNoOutput("hunter2")
+
+	l.Run("export", "-v", "work", "-p", pwFile, "--keyfile", keyfile).AssertOK().AssertStdout("hunter2")
+	l.RunStdin("token\n", "set", "-v", "work", "-p", pwFile, "--keyfile", keyfile, "api").AssertOK()
+	l.Run("vault", "info", "work", "-p", pwFile, "--keyfile", keyfile).AssertOK().AssertStdout("Keyfile:         yes")
+	l.Run("vault", "info", "work", "-p", pwFile, "--keyfile", keyfile, "--format", "json").AssertOK().AssertStdout(`"keyfile": true`)
+
+	// A keyfile given for a vault without one is not needed, and not sealed
+	// into it.
+	plainPw := l.seedVault("personal", "another password", "k\nv\n")
+	l.RunStdin("w\n", "set", "-v", "personal", "-p", plainPw, "--keyfile", keyfile, "k").AssertOK()
+	l.Run("export", "-v", "personal", "-p", plainPw).AssertOK().AssertStdout("w")
+}
+
+func TestChangePasswordAddsReplacesAndRemovesAKeyfile(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "db\nhunter2\n")
+	keyfile := l.WriteFile("mrs.key", "the contents of a keyfile on a usb stick")
+	other := l.WriteFile("other.key", "the contents of another keyfile")
+
+	l.Run("vault", "change-password", "work", "-p", pwFile, "-n", pwFile, "--new-keyfile", keyfile).AssertOK()
+	l.Run("export", "-v", "work", "-p", pwFile).AssertFailed().AssertStderr("needs its keyfile")
+	l.Run("export", "-v", "work", "-p", pwFile, "--keyfile", keyfile).AssertOK().AssertStdout("hunter2")
+
+	// A new password keeps the keyfile the vault has.
+	newPw := l.PasswordFile("new.pw", "a new password")
+	l.Run("vault", "change-password", "work", "-p", pwFile, "--keyfile", keyfile, "-n", newPw).AssertOK()
+	l.Run("export", "-v", "work", "-p", newPw).AssertFailed().AssertStderr("needs its keyfile")
+
+	l.Run("vault", "change-password", "work", "-p", newPw, "--keyfile", keyfile, "-n", newPw, "--new-keyfile", other).AssertOK()
+	l.Run("export", "-v", "work", "-p", newPw, "--keyfile", keyfile).AssertFailed()
+	l.Run("vault", "rename", "work", "job", "-p", newPw, "--keyfile", other).AssertOK()
+	l.Run("export", "-v", "job", "-p", newPw, "--keyfile", other).AssertOK().AssertStdout("hunter2")
+
+	l.Run("vault", "change-password", "job", "-p", newPw, "--new-keyfile", keyfile, "--remove-keyfile").AssertUsageError()
+	l.Run("vault", "change-password", "job", "-p", newPw, "--keyfile", other, "-n", newPw, "--remove-keyfile").
+		AssertOK().
+		AssertStderr("no longer has a keyfile")
+	l.Run("export", "-v", "job", "-p", newPw).AssertOK().AssertStdout("hunter2")
+	l.Run("vault", "create", "empty", "-p", newPw, "--keyfile", l.WriteFile("empty.key", "")).
+		AssertFailed().
+		AssertStderr("keyfile is empty")
+}
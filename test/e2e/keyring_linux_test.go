@@ -0,0 +1,98 @@
+package e2e
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capability 15: typing a password once a session without a process left
+// running, with $MRS_KEYRING, which keeps each vault's key in the kernel
+// keyring until it times out or `lock` removes it.
+
+// inNewSessionKeyring gives the mrs processes the test starts a session
+// keyring of their own, which they inherit from the thread that starts them.
+// The test stays on that thread, which exits with it rather than carry the
+// keyring on to another test.
+func (l *lab) inNewSessionKeyring() {
+	l.t.Helper()
+	runtime.LockOSThread()
+	// A name of NULL joins a new, anonymous keyring.
+	if _, err := unix.KeyctlInt(unix.KEYCTL_JOIN_SESSION_KEYRING, 0, 0, 0, 0); err != nil {
+		l.t.Skipf("the kernel keyring is not available: %v", err)
+	}
+	l.Setenv("MRS_KEYRING", "session")
+}
+
+func TestTheKeyringSparesThePassword(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	l.seedVault("personal", "another password", "mail\nmail-pw\n")
+	l.inNewSessionKeyring()
+
+	l.Run("get", "-v", "work", "github").AssertFailed()
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK().AssertStdoutExactly("gh-token\n")
+	l.Run("get", "-v", "work", "github").AssertOK().AssertStdoutExactly("gh-token\n")
+	l.Run("export", "-v", "work").AssertOK().AssertStdout("gh-token")
+	l.RunStdin("a new token\n", "set", "-v", "work", "github").AssertOK()
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK().AssertStdoutExactly("a new token\n")
+
+	l.Run("lock", "work").AssertOK().AssertStderr("Forgot the key to vault work")
+	l.Run("get", "-v", "work", "github").AssertFailed()
+
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK()
+	l.Run("lock").AssertOK().AssertStderr("Forgot every vault's key")
+	l.Run("get", "-v", "work", "github").AssertFailed()
+
+	// A new password leaves nothing behind that the old one derived.
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK()
+	newPwFile := l.PasswordFile("new-pw", "a new password")
+	l.Run("vault", "change-password", "work", "-p", pwFile, "--new-password-file", newPwFile).AssertOK()
+	l.Run("get", "-v", "work", "github").AssertFailed()
+}
+
+func TestTheKeyringForgetsOnceTimedOut(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+	l.inNewSessionKeyring()
+	// Long enough for the second get to find the key while the rest of the
+	// suite runs alongside.
+	const timeout = 3 * time.Second
+	l.Setenv("MRS_KEYRING_TIMEOUT", timeout.String())
+
+	l.Run("get", "-v", "work", "-p", pwFile, "github").AssertOK()
+	l.Run("get", "-v", "work", "github").AssertOK()
+	time.Sleep(timeout + timeout/2)
+	l.Run("get", "-v", "work", "github").AssertFailed()
+}
+
+// A keyring that cannot be used is reported, and the command goes on with its
+// password as it would without one.
+func TestAKeyringThatCannotBeUsedIsReported(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "github\ngh-token\n")
+
+	l.Setenv("MRS_KEYRING", "thread")
+	l.Run("get", "-v", "work", "-p", pwFile, "github").
+		AssertOK().
+		AssertStdoutExactly("gh-token\n").
+		AssertStderr(`Warning: MRS_KEYRING must be session or user, but is "thread"`)
+
+	// Without a session keyring of its own, a process is handed one to add
+	// to that goes when it exits, which would keep nothing.
+	session, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_SESSION_KEYRING, false)
+	if err != nil {
+		t.Skipf("the kernel keyring is not available: %v", err)
+	}
+	userSession, _ := unix.KeyctlGetKeyringID(unix.KEY_SPEC_USER_SESSION_KEYRING, false)
+	if session != userSession {
+		t.Skip("the tests run in a session keyring, so there is no lack of one to report")
+	}
+	l.Setenv("MRS_KEYRING", "session")
+	l.Run("get", "-v", "work", "-p", pwFile, "github").
+		AssertOK().
+		AssertStderr("this login has no session keyring to keep keys in")
+	l.Run("lock").AssertFailed().AssertStderr("Set MRS_KEYRING=user instead")
+}
@@ -5,12 +5,14 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 
 	"github.com/andornaut/mrs/internal/crypto"
@@ -72,6 +74,76 @@ func decrypts(t *testing.T, path, password, salt string, iterations int) bool {
 	return err == nil
 }
 
+// The header a vault file has begun with since format version 1, spelled out
+// here rather than taken from mrs's crypto package for the reason gcm gives:
+//
+//	"mrs\x00" | version | kdf | iterations | memory KiB | threads | name length | name
+//
+// Version 2 added the name, and authenticates the whole header as the
+// ciphertext's associated data. Version 3 is a team vault's, which follows
+// the name with its members, and version 4 is a vault with a keyfile, which
+// follows it with a byte of flags.
+const (
+	headerMagic       = "mrs\x00"
+	fixedHeaderLen    = 15
+	pbkdf2ID          = 1
+	argon2idID        = 2
+	formatVersion     = 2
+	recipientsVersion = 3
+	keyfileVersion    = 4
+)
+
+// headerDecrypts reports the KDF that a headed vault file names, and whether
+// the file decrypts with a key derived the way its header says.
+func headerDecrypts(t *testing.T, path, password, salt string) (byte, bool) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	if len(b) < fixedHeaderLen+1 || string(b[:4]) != headerMagic || b[4] != formatVersion {
+		return 0, false
+	}
+	n := fixedHeaderLen + 1 + int(b[fixedHeaderLen])
+	if len(b) < n {
+		return 0, false
+	}
+	kdf, iterations := b[5], binary.BigEndian.Uint32(b[6:10])
+	var k []byte
+	switch kdf {
+	case pbkdf2ID:
+		k = pbkdf2.Key([]byte(password), []byte(salt), int(iterations), 32, sha256.New)
+	case argon2idID:
+		k = argon2.IDKey([]byte(password), []byte(salt), iterations, binary.BigEndian.Uint32(b[10:14]), b[14], 32)
+	default:
+		return kdf, false
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		t.Fatalf("failed to build the fixture cipher: %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("failed to build the fixture cipher: %s", err)
+	}
+	header, body := b[:n], b[n:]
+	if len(body) < aead.NonceSize() {
+		return kdf, false
+	}
+	_, err = aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], header)
+	return kdf, err == nil
+}
+
+// sealedName returns the vault name that a headed vault file carries.
+func sealedName(t *testing.T, path string) string {
+	t.Helper()
+	b := []byte(readFile(t, path))
+	if len(b) < fixedHeaderLen+1 || len(b) < fixedHeaderLen+1+int(b[fixedHeaderLen]) {
+		t.Fatalf("expected %s to carry a header with a name", path)
+	}
+	return string(b[fixedHeaderLen+1 : fixedHeaderLen+1+int(b[fixedHeaderLen])])
+}
+
 // writeVaultFile writes a vault file directly, as an older mrs would have left
 // it, and returns its path.
 func (l *lab) writeVaultFile(filename, password, contents, salt string, iterations int) string {
@@ -146,8 +218,8 @@ func TestAVaultAtTheOldIterationCountIsUpgradedWhenItIsSaved(t *testing.T) {
 	if filepath.Base(l.VaultPath("personal")) != "personal."+salt {
 		t.Fatalf("expected the salt to be kept, got %q", l.VaultPath("personal"))
 	}
-	if !decrypts(t, p, "a password", salt, crypto.CurrentIterations) {
-		t.Fatal("expected the saved vault to use the current iteration count")
+	if kdf, ok := headerDecrypts(t, p, "a password", salt); !ok || kdf != argon2idID {
+		t.Fatal("expected the saved vault to be headed and derive its key with Argon2id")
 	}
 	if decrypts(t, p, "a password", salt, crypto.LegacyIterations) {
 		t.Fatal("expected the saved vault to no longer use the old iteration count")
@@ -160,7 +232,9 @@ func TestAnOldVaultKeepsItsSaltWhenRenamed(t *testing.T) {
 	l.writeVaultFile("personal."+salt, "a password", "a key\nold-value\n", salt, crypto.LegacyIterations)
 	pwFile := l.PasswordFile("pw", "a password")
 
-	// Renaming does not decrypt, so the salt has to travel with the file.
+	// A vault this old has no name sealed into it, so renaming it does not
+	// decrypt it, or ask for a password, and the salt has to travel with the
+	// file.
 	l.Run("vault", "rename", "personal", "archive").AssertOK()
 	l.Run("vault", "list").AssertOK().AssertStdoutEquals("archive")
 	if got := filepath.Base(l.VaultPath("archive")); got != "archive."+salt {
@@ -215,12 +289,74 @@ func TestTheBackupHoldsTheVersionBeforeTheSave(t *testing.T) {
 
 	// Copying the backup over the vault is the documented way back from an
 	// edit, so it has to decrypt and hold what was there before.
-	copyFile(t, vaultPath+".bak", vaultPath)
+	copyFile(t, l.latestBackup("personal"), vaultPath)
 	l.Run("export", "-v", "personal", "-p", pwFile).
 		AssertOK().
 		AssertStdoutExactly("a key\nfirst-value\n")
 }
 
+func TestTwoBadSavesInARowLeaveTheGoodVersionInABackup(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\ngood-value\n")
+	vaultPath := l.VaultPath("personal")
+	for _, bad := range []string{"a key\nbad-value\n", "a key\nworse-value\n"} {
+		l.editorWrites(bad)
+		l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
+	}
+
+	backups := l.Backups("personal")
+	if len(backups) != 2 {
+		t.Fatalf("expected a backup from before each save, got %v", backups)
+	}
+	copyFile(t, backups[0], vaultPath)
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("a key\ngood-value\n")
+}
+
+func TestOnlyTheConfiguredNumberOfBackupsIsKept(t *testing.T) {
+	l := newLab(t)
+	l.Setenv("MRS_BACKUPS", "2")
+	pwFile := l.seedVault("personal", "a password", "a key\nv1\n")
+	for _, v := range []string{"v2", "v3", "v4"} {
+		l.editorWrites("a key\n" + v + "\n")
+		l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
+	}
+
+	// The oldest go first, so v1 is gone and v2 and v3 remain.
+	backups := l.Backups("personal")
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+	for i, want := range []string{"v2", "v3"} {
+		copyFile(t, backups[i], l.VaultPath("personal"))
+		l.Run("export", "-v", "personal", "-p", pwFile).AssertOK().AssertStdoutExactly("a key\n" + want + "\n")
+	}
+
+	// And none at all when asked for none.
+	l.Setenv("MRS_BACKUPS", "0")
+	l.editorWrites("a key\nv5\n")
+	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
+	if backups := l.Backups("personal"); len(backups) != 0 {
+		t.Fatalf("expected no backups, got %v", backups)
+	}
+}
+
+func TestAnInvalidNumberOfBackupsDoesNotLoseAnEdit(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\na-value\n")
+
+	// The setting is only read once the edit is done, so it falls back to the
+	// default rather than throwing the edit away.
+	l.Setenv("MRS_BACKUPS", "some")
+	l.editorWrites("a key\nanother-value\n")
+	l.Run("edit", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStderr("MRS_BACKUPS must be a number")
+	l.Run("export", "-v", "personal", "-p", pwFile).AssertOK().AssertStdoutExactly("a key\nanother-value\n")
+	l.latestBackup("personal")
+}
+
 func TestTheBackupIsNotReadableByOthers(t *testing.T) {
 	l := newLab(t)
 	pwFile := l.seedVault("personal", "a password", "a key\na-value\n")
@@ -230,7 +366,7 @@ func TestTheBackupIsNotReadableByOthers(t *testing.T) {
 
 	// A backup holds the same secrets as the vault, so it is guarded the same.
 	assertFileMode(t, l.VaultPath("personal"), 0600)
-	assertFileMode(t, l.VaultPath("personal")+".bak", 0600)
+	assertFileMode(t, l.latestBackup("personal"), 0600)
 }
 
 func TestALeftoverTemporaryFileIsRemovedOnSave(t *testing.T) {
@@ -262,3 +398,97 @@ func copyFile(t *testing.T, src, dst string) {
 		t.Fatalf("failed to write %s: %s", dst, err)
 	}
 }
+
+func TestUpgradeReEncryptsAnOldVaultAndLeavesACurrentOneAlone(t *testing.T) {
+	l := newLab(t)
+	salt := strings.Repeat("d", 32)
+	old := l.writeVaultFile("old."+salt, "a password", "a key\nold-value\n", salt, crypto.LegacyIterations)
+	pwFile := l.seedVault("new", "a password", "a key\nnew-value\n")
+	current := readFile(t, l.VaultPath("new"))
+
+	l.Run("vault", "upgrade", "--all", "-p", pwFile).
+		AssertOK().
+		AssertStderr("Upgraded vault old from pbkdf2-sha256, 4096 iterations").
+		AssertStderr("Vault new is already current").
+		AssertNoOutput("old-value")
+
+	if kdf, ok := headerDecrypts(t, old, "a password", salt); !ok || kdf != argon2idID {
+		t.Fatal("expected the old vault to be re-encrypted with Argon2id under a header")
+	}
+	// Left alone means not rewritten, rather than rewritten the same way.
+	if readFile(t, l.VaultPath("new")) != current {
+		t.Fatal("expected the current vault to be left untouched")
+	}
+	l.Run("export", "-v", "old", "-p", pwFile).AssertOK().AssertStdoutExactly("a key\nold-value\n")
+
+	l.Run("vault", "upgrade", "old", "-p", pwFile).
+		AssertOK().
+		AssertStderr("Vault old is already current")
+}
+
+func TestUpgradeTrimsAPasswordThatEndsInANewline(t *testing.T) {
+	l := newLab(t)
+	salt := strings.Repeat("e", 32)
+	l.writeVaultFile("personal."+salt, "a password\n", "a key\na-value\n", salt, crypto.CurrentIterations)
+	pwFile := l.PasswordFile("pw", "a password\n")
+
+	l.Run("vault", "upgrade", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStderr("mrs vault upgrade personal").
+		AssertStderr("Upgraded vault personal")
+
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("a key\na-value\n").
+		AssertNoOutput("ends in a newline")
+}
+
+func TestUpgradeReportsAVaultItCannotOpenAndUpgradesTheRest(t *testing.T) {
+	l := newLab(t)
+	for _, name := range []string{"first", "second"} {
+		salt := strings.Repeat(name[:1], 32)
+		l.writeVaultFile(name+"."+salt, name+" password", "a key\na-value\n", salt, crypto.LegacyIterations)
+	}
+	pwFile := l.PasswordFile("pw", "second password")
+
+	l.Run("vault", "upgrade", "first", "second", "-p", pwFile).
+		AssertFailed().
+		AssertStderr("Failed to upgrade vault first: failed to decrypt vault first").
+		AssertStderr("Upgraded vault second").
+		AssertStderr("failed to upgrade 1 of 2 vaults")
+}
+
+func TestInfoReportsHowAnOldVaultWasOpened(t *testing.T) {
+	l := newLab(t)
+	salt := strings.Repeat("d", 32)
+	l.writeVaultFile("old."+salt, "a password", "a key\na value\n", salt, crypto.LegacyIterations)
+	pwFile := l.PasswordFile("pw", "a password")
+
+	// A file with no header says nothing about itself; the parameters come
+	// from whichever derivation opened it.
+	l.Run("vault", "info", "old", "-p", pwFile).
+		AssertOK().
+		AssertStdout("0 (no header)").
+		AssertStdout("pbkdf2-sha256, 4096 iterations").
+		AssertStdout(`no, run "mrs vault upgrade old"`)
+}
+
+func TestUpgradeTakesNamesOrAll(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.createVault("personal", "a password")
+
+	l.Run("vault", "upgrade", "-p", pwFile).
+		AssertUsageError().
+		AssertStderr("requires the name of a vault, or --all")
+	l.Run("vault", "upgrade", "personal", "--all", "-p", pwFile).
+		AssertUsageError().
+		AssertStderr("not both")
+	// It changes vaults, so like every other command that does, it takes
+	// whole names and refuses a prefix before asking for anything.
+	l.Run("vault", "upgrade", "pers", "-p", pwFile).
+		AssertFailed().
+		AssertStderr(`Did you mean "personal"`)
+	l.Run("vault", "upgrade", "--all", "-p", pwFile).
+		AssertOK().
+		AssertStderr("Vault personal is already current")
+}
@@ -46,6 +46,8 @@ func TestASecondWriterIsRefusedWhileAVaultIsOpen(t *testing.T) {
 	for _, args := range [][]string{
 		{"add", "-v", "work", "-p", pwFile},
 		{"edit", "-v", "work", "-p", pwFile},
+		{"set", "-v", "work", "-p", pwFile, "a key"},
+		{"rm", "-v", "work", "-p", pwFile, "a key"},
 	} {
 		l.Run(args...).
 			AssertFailed().
@@ -179,3 +181,15 @@ func TestALockFileIsNotMistakenForAVault(t *testing.T) {
 	l.Run("vault", "list").AssertOK().AssertStdoutEquals("work")
 	l.Run("vault", "default").AssertOK().AssertStdoutEquals("work")
 }
+
+func TestInfoReportsAHeldLock(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+	release := l.heldVault("work", pwFile)
+	defer release()
+
+	// info is a reader, so the lock does not stop it, and it says who has it.
+	l.Run("vault", "info", "work", "-p", pwFile).
+		AssertOK().
+		AssertStdout("Locked:          yes")
+}
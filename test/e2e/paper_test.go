@@ -0,0 +1,69 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Capability 22: keeping a vault on paper, with `vault paper-backup`, which
+// prints its file, encrypted, as text with a checksum on every line, and
+// `vault paper-restore`, which reads that text back in and recreates the file
+// under the name and salt it had.
+
+func TestAPaperBackupRestoresTheVaultUnderItsSalt(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "db\nhunter2\n")
+	path := l.VaultPath("work")
+	paper := l.Run("vault", "paper-backup", "work").AssertOK().AssertNoOutput("hunter2")
+	if !strings.Contains(paper.Stdout, "salt   "+strings.SplitN(filepath.Base(path), ".", 2)[1]) {
+		t.Fatalf("expected the paper backup to give the vault's salt\n%s", paper.Stdout)
+	}
+
+	l.RunStdin(paper.Stdout, "vault", "paper-restore").AssertFailed().AssertStderr("already exists")
+	l.Run("vault", "delete", "work", "-y").AssertOK()
+	// Typed back in, with the file's lines in lower case. The salt's case is
+	// its own.
+	lines := strings.Split(paper.Stdout, "\n")
+	for i, line := range lines {
+		if line != "" && line[0] >= '0' && line[0] <= '9' {
+			lines[i] = strings.ToLower(line)
+		}
+	}
+	l.Run("vault", "paper-restore", "-i", l.WriteFile("paper.txt", strings.Join(lines, "\n"))).
+		AssertOK().
+		AssertStderr("Restored vault work")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the vault to be restored at %s: %v", path, err)
+	}
+	l.Run("export", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+}
+
+func TestAMisreadPaperBackupIsRefusedAtTheLine(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("work", "a password", "db\nhunter2\n")
+	paper := l.Run("vault", "paper-backup", "work").AssertOK()
+	l.Run("vault", "delete", "work", "-y").AssertOK()
+
+	lines := strings.Split(paper.Stdout, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "1 ") {
+			fields := strings.Fields(line)
+			// A checksum misread as another.
+			fields[len(fields)-1] = strings.Repeat("A", 5)
+			if strings.HasSuffix(line, "AAAAA") {
+				fields[len(fields)-1] = strings.Repeat("B", 5)
+			}
+			lines[i] = strings.Join(fields, " ")
+			l.RunStdin(strings.Join(lines, "\n"), "vault", "paper-restore").
+				AssertFailed().
+				AssertStderr(fmt.Sprintf("line %d: the file's line 1 is misread", i+1))
+			break
+		}
+	}
+	l.Run("vault", "list").AssertOK().AssertNoOutput("work")
+	l.RunStdin("db\nhunter2\n", "vault", "paper-restore").AssertFailed().AssertStderr("is not a paper backup")
+	l.Run("vault", "paper-restore", "extra").AssertUsageError()
+}
@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -41,6 +42,125 @@ func TestExportOfAnEmptyVaultPrintsNothing(t *testing.T) {
 		AssertStdoutExactly("")
 }
 
+func TestExportFormatJSONReadsBackAsTheSecrets(t *testing.T) {
+	l := newLab(t)
+	// Lines that JSON has to escape, and one that is not UTF-8 at all.
+	pwFile := l.seedVault("work", "a password",
+		"a \"quoted\" key\n\tpath: C:\\Users\n\nbytes\nnot utf-8: \xff\n")
+
+	r := l.Run("export", "-v", "work", "-p", pwFile, "--format", "json").AssertOK()
+	var got struct {
+		Vault   string `json:"vault"`
+		Count   int    `json:"count"`
+		Secrets []struct {
+			Key   string   `json:"key"`
+			Value []string `json:"value"`
+		} `json:"secrets"`
+	}
+	if err := json.Unmarshal([]byte(r.Stdout), &got); err != nil {
+		t.Fatalf("expected JSON on stdout: %v\n%s", err, r.Stdout)
+	}
+	if got.Vault != "work" || got.Count != 2 {
+		t.Fatalf("expected 2 secrets from vault work, got %+v", got)
+	}
+	if s := got.Secrets[0]; s.Key != `a "quoted" key` || len(s.Value) != 1 || s.Value[0] != "\tpath: C:\\Users" {
+		t.Errorf("expected the quoted key and its line, got %+v", s)
+	}
+	if s := got.Secrets[1]; len(s.Value) != 1 || s.Value[0] != "not utf-8: \ufffd" {
+		t.Errorf("expected a byte that is not UTF-8 as U+FFFD, got %+v", s)
+	}
+}
+
+func TestExportFormatNDJSONOfAnEmptyVaultPrintsNothing(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.createVault("empty", "a password")
+
+	l.Run("export", "-v", "empty", "-p", pwFile, "--format", "ndjson").
+		AssertOK().
+		AssertStdoutExactly("")
+	l.Run("export", "-v", "empty", "-p", pwFile, "--format", "json").
+		AssertOK().
+		AssertStdoutExactly(`{"vault":"empty","count":0,"secrets":[]}` + "\n")
+}
+
+// --match selects as search does: a case-insensitive regular expression
+// against the key.
+func TestExportMatchSelectsAsSearchDoes(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password",
+		"AWS prod\nid: AKIA1\nsecret: s1\n\naws staging\nid: AKIA2\nsecret: s2\n\ngithub\npassword: gh\n")
+
+	l.Run("export", "-v", "work", "-p", pwFile, "--format", "dotenv", "--match", "^aws").
+		AssertOK().
+		AssertStdoutExactly("AWS_PROD=\"id: AKIA1\\nsecret: s1\"\nAWS_STAGING=\"id: AKIA2\\nsecret: s2\"\n")
+	l.Run("export", "-v", "work", "-p", pwFile, "--format", "dotenv", "--match", "prod", "--field", "secret").
+		AssertOK().
+		AssertStdoutExactly("AWS_PROD_SECRET=\"s1\"\n")
+	l.Run("export", "-v", "work", "-p", pwFile, "--match", "^git").
+		AssertOK().
+		AssertStdoutExactly("github\npassword: gh\n")
+}
+
+func TestExportFormatK8sSecretHoldsTheValuesInBase64(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "db\nuser: app\npassword: hunter2\n\nmail\nnot a field\n")
+
+	// Secrets without the field are left out, as search leaves them out.
+	l.Run("export", "-v", "work", "-p", pwFile, "--format", "k8s-secret", "--field", "password").
+		AssertOK().
+		AssertStdoutExactly("apiVersion: v1\nkind: Secret\nmetadata:\n  name: work\ntype: Opaque\ndata:\n" +
+			"  \"db.password\": \"aHVudGVyMg==\"\n")
+}
+
+func TestExportFormatCSVImportsBack(t *testing.T) {
+	l := newLab(t)
+	contents := "github\nusername: alice\npassword: \"quoted\", with a comma\n\nwifi\nhunter2\n"
+	pwFile := l.seedVault("work", "a password", contents)
+
+	csv := l.Run("export", "-v", "work", "-p", pwFile, "--format", "csv").AssertOK().Stdout
+	export := l.WriteFile("export.csv", csv)
+	l.Run("import", "--create", "-v", "copy", "-p", pwFile, "--format", "csv", export).AssertOK()
+
+	l.Run("export", "-v", "copy", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly(contents)
+}
+
+func TestExportThatSelectsNothingExitsAsASearchDoes(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+
+	r := l.Run("export", "-v", "work", "-p", pwFile, "--format", "json", "--match", "nothing-like-this").
+		AssertStdoutExactly(`{"vault":"work","count":0,"secrets":[]}` + "\n").
+		AssertStderr(`No secrets matched "nothing-like-this" in vault work`)
+	if r.ExitCode != 3 {
+		t.Fatalf("expected exit 3 for an export that selected nothing\n%s", r.describe())
+	}
+	r = l.Run("export", "-v", "work", "-p", pwFile, "--format", "dotenv", "--field", "password").
+		AssertStderr(`No secrets have a field "password" in vault work`)
+	if r.ExitCode != 3 {
+		t.Fatalf("expected exit 3 for an export that selected nothing\n%s", r.describe())
+	}
+}
+
+func TestExportRefusesWhatItCannotName(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "api-key\na\n\nAPI key\nb\n")
+
+	l.Run("export", "-v", "work", "-p", pwFile, "--format", "dotenv").
+		AssertFailed().
+		AssertStderr("would both be exported as API_KEY").
+		AssertStdoutExactly("")
+	l.Run("export", "-v", "work", "-p", pwFile, "--format", "yaml").
+		AssertUsageError().
+		AssertStderr(`--format must be text, json, ndjson, csv, dotenv or k8s-secret, but is "yaml"`)
+	l.Run("export", "-v", "work", "-p", pwFile, "--field", "a:b").
+		AssertUsageError()
+	l.Run("export", "-v", "work", "-p", pwFile, "--match", "(").
+		AssertFailed().
+		AssertStderr("invalid regular expression")
+}
+
 func TestExportRejectsAWrongPassword(t *testing.T) {
 	l := newLab(t)
 	l.seedVault("work", "a password", "a key\nthe-secret-value\n")
@@ -71,7 +191,9 @@ func TestExportLeavesTheVaultUntouched(t *testing.T) {
 	if after := readFile(t, l.VaultPath("work")); after != before {
 		t.Fatal("expected an export to leave the vault file unchanged")
 	}
-	assertNotExists(t, l.VaultPath("work")+".bak")
+	if backups := l.Backups("work"); len(backups) != 0 {
+		t.Fatalf("expected no backup, got %v", backups)
+	}
 }
 
 func TestExportNeedsAPasswordItCanRead(t *testing.T) {
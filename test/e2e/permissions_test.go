@@ -66,13 +66,14 @@ func TestALoosenedBackupIsTightenedToo(t *testing.T) {
 	pwFile := l.seedVault("personal", "a password", "a key\nfirst-value\n")
 	l.editorWrites("a key\nsecond-value\n")
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
-	backup := l.VaultPath("personal") + ".bak"
+	backup := l.latestBackup("personal")
 
 	chmod(t, backup, 0644)
 	l.editorWrites("a key\nthird-value\n")
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
 
-	// A backup holds the same secrets as the vault, so it is guarded alike.
+	// A backup holds the same secrets as the vault, so it is guarded alike,
+	// including one that the save did not write.
 	assertFileMode(t, backup, 0600)
 }
 
@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"strings"
+	"testing"
+)
+
+// Capability 21: recovering a vault whose password is forgotten, with
+// `vault recovery split`, which prints a recovery key for it as shares any few
+// of which unlock it, and `vault recovery unlock`, which reads them back and
+// gives the vault a new password.
+
+func TestAThresholdOfSharesUnlocksAVaultWithANewPassword(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a forgettable password", "db\nhunter2\n")
+	split := l.Run("vault", "recovery", "split", "work", "-p", pwFile, "--shares", "5", "--threshold", "3").
+		AssertOK().
+		AssertStderr("any 3 of which")
+	shares := strings.Split(strings.TrimSpace(split.Stdout), "\n")
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, one a line, got %q", split.Stdout)
+	}
+	for _, s := range shares {
+		if !strings.HasPrefix(s, "mrs-share work 3-of-5 #") {
+			t.Fatalf("expected each share to name its vault and threshold, got %q", s)
+		}
+	}
+
+	newPw := l.PasswordFile("new.pw", "a new password")
+	l.RunStdin(shares[0]+"\n"+shares[3]+"\n", "vault", "recovery", "unlock", "work", "-n", newPw).
+		AssertFailed().
+		AssertStderr("any 3 of its 5")
+	l.RunStdin(shares[0]+"\n"+shares[3]+"\n"+shares[0]+"\n", "vault", "recovery", "unlock", "work", "-n", newPw).
+		AssertFailed().
+		AssertStderr("2 different shares were given")
+
+	// A typo in one share is caught as that share.
+	mistyped := []byte(shares[4])
+	mistyped[len(mistyped)-3] ^= 'A' ^ 'B'
+	l.RunStdin(shares[0]+"\n"+shares[3]+"\n"+string(mistyped)+"\n", "vault", "recovery", "unlock", "work", "-n", newPw).
+		AssertFailed().
+		AssertStderr("line 3: share #5 is mistyped")
+
+	l.RunStdin("# from the safe\n"+shares[4]+"\n\n"+shares[0]+"\n"+strings.ToLower(shares[2])+"\n",
+		"vault", "recovery", "unlock", "work", "-n", newPw).
+		AssertOK().
+		AssertStderr("changed its password")
+	l.Run("export", "-v", "work", "-p", newPw).AssertOK().AssertStdout("hunter2")
+	l.Run("export", "-v", "work", "-p", pwFile).AssertFailed()
+
+	// The shares go on unlocking the vault, whatever its password is changed to
+	// in between.
+	otherPw := l.PasswordFile("other.pw", "another password")
+	l.Run("vault", "change-password", "work", "-p", newPw, "-n", otherPw).AssertOK()
+	l.RunStdin(strings.Join(shares[1:4], "\n"), "vault", "recovery", "unlock", "work", "-n", pwFile).
+		AssertOK().
+		AssertStderr("changed its password")
+	l.Run("export", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+
+	// Until the vault is split again, which replaces them.
+	l.Run("vault", "recovery", "split", "work", "-p", pwFile, "--shares", "2", "--threshold", "2").
+		AssertOK().
+		AssertStderr("no longer do")
+	l.Run("vault", "info", "work", "-p", pwFile).AssertOK().AssertStdout("Recovery key:    yes")
+	l.RunStdin(strings.Join(shares[1:4], "\n"), "vault", "recovery", "unlock", "work", "-n", newPw).
+		AssertFailed().
+		AssertStderr("a later split replaced")
+	l.Run("export", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+}
+
+func TestSharesAreRefusedForAnotherVaultOrAMissingCount(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "db\nhunter2\n")
+	l.seedVault("personal", "another password", "k\nv\n")
+	l.Run("vault", "recovery", "split", "work", "-p", pwFile, "--shares", "3").AssertUsageError()
+	l.Run("vault", "recovery", "split", "work", "-p", pwFile, "--shares", "3", "--threshold", "4").AssertUsageError()
+
+	split := l.Run("vault", "recovery", "split", "work", "-p", pwFile, "--shares", "3", "--threshold", "2").AssertOK()
+	l.RunStdin(split.Stdout, "vault", "recovery", "unlock", "personal", "-n", pwFile).
+		AssertFailed().
+		AssertStderr("not of vault personal's")
+	l.RunStdin("db hunter2\n", "vault", "recovery", "unlock", "work", "-n", pwFile).
+		AssertFailed().
+		AssertStderr("line 1 is not a share")
+	l.Run("export", "-v", "work", "-p", pwFile).AssertOK().AssertStdout("hunter2")
+}
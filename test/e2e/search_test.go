@@ -1,12 +1,15 @@
 package e2e
 
 import (
+	"encoding/json"
+	"slices"
 	"strings"
 	"testing"
 )
 
 // Capability 3: retrieving secrets with `search`, which decrypts a vault and
-// prints whole secrets to stdout.
+// prints whole secrets to stdout, and with `get`, which prints one secret's
+// value and nothing else.
 
 // searchVault is the vault the search tests read. Its keys differ from its
 // values, so that a test can tell which of the two a search looked at.
@@ -255,7 +258,9 @@ func TestSearchLeavesTheVaultUntouched(t *testing.T) {
 		t.Fatal("expected a search to leave the vault file unchanged")
 	}
 	// A read is not a write, so it must not roll a backup either.
-	assertNotExists(t, l.VaultPath("work")+".bak")
+	if backups := l.Backups("work"); len(backups) != 0 {
+		t.Fatalf("expected no backup, got %v", backups)
+	}
 }
 
 func TestSearchLeavesNoPlaintextBehind(t *testing.T) {
@@ -294,3 +299,291 @@ func TestSearchReportsAMissingPasswordFile(t *testing.T) {
 		AssertFailed().
 		AssertStderr("password file")
 }
+
+func TestSearchFieldPrintsOnlyThatFieldsValue(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "github", "--field", "token").
+		AssertOK().
+		AssertStdoutExactly("abc123\n").
+		AssertStderr("1 secret matched \"github\" in vault work")
+
+	// A field's name is matched ignoring case, and every secret that matched
+	// prints its value on a line of its own.
+	l.Run("search", "-v", "work", "-p", pwFile, "--full", "user", "--field", "USER").
+		AssertOK().
+		AssertStdoutExactly("bob\nalice\n").
+		AssertStderr("2 secrets matched")
+}
+
+func TestSearchFieldLeavesOutSecretsWithoutTheField(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--full", "user", "--field", "pass").
+		AssertOK().
+		AssertStdoutExactly("sekrit\n").
+		AssertStderr("1 secret matched")
+
+	l.Run("search", "-v", "work", "-p", pwFile, "github", "--field", "pin").
+		AssertFailed().
+		AssertStderr("No secrets that have a field \"pin\" matched \"github\" in vault work")
+}
+
+func TestSearchMatchFieldLooksOnlyAtThatField(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password",
+		"work github\nurl: https://github.com/acme\n\n"+
+			"home\nurl: https://example.org\nnote: see github\n")
+
+	// "github" is in the first secret's key and the second's note, neither of
+	// which --match-field looks at.
+	l.Run("search", "-v", "work", "-p", pwFile, "--match-field", "url", "github").
+		AssertOK().
+		AssertStdoutExactly("work github\nurl: https://github.com/acme\n")
+	l.Run("search", "-v", "work", "-p", pwFile, "--match-field", "note", "example").
+		AssertFailed().
+		AssertStderr("No secrets matched")
+	l.Run("search", "-v", "work", "-p", pwFile, "--match-field", "url", "example", "--field", "url").
+		AssertOK().
+		AssertStdoutExactly("https://example.org\n")
+}
+
+func TestSearchFieldsDoNotChangeHowSecretsAreStored(t *testing.T) {
+	l := newLab(t)
+	// Lines that look like fields and lines that do not, spaced unevenly.
+	contents := "site\nurl:https://x.example\n  user :  alice  \npassword:\tp:w\n"
+	pwFile := l.seedVault("work", "a password", contents)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "site", "--field", "user").
+		AssertOK().
+		AssertStdoutExactly("alice  \n")
+	l.Run("search", "-v", "work", "-p", pwFile, "site", "--field", "password").
+		AssertOK().
+		AssertStdoutExactly("p:w\n")
+	l.Run("search", "-v", "work", "-p", pwFile, "site").
+		AssertOK().
+		AssertStdoutExactly(contents)
+}
+
+func TestSearchFieldFlagsAreChecked(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "github", "--field", "").
+		AssertUsageError().
+		AssertStderr("--field takes the name of a field")
+	l.Run("search", "-v", "work", "-p", pwFile, "github", "--match-field", "a:b").
+		AssertUsageError().
+		AssertStderr("--match-field takes the name of a field")
+	l.Run("search", "-v", "work", "-p", pwFile, "github", "--full", "--match-field", "url").
+		AssertUsageError().
+		AssertStderr("cannot be used together")
+}
+
+// searchJSON is what `search --format json` prints.
+type searchJSON struct {
+	Vault   string `json:"vault"`
+	Count   int    `json:"count"`
+	Secrets []struct {
+		Key   string   `json:"key"`
+		Field string   `json:"field"`
+		Value []string `json:"value"`
+	} `json:"secrets"`
+}
+
+func TestSearchFormatJSONPrintsOneObject(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	r := l.Run("search", "-v", "work", "-p", pwFile, "--format", "json", "--full", "user").
+		AssertOK().
+		AssertStderr("2 secrets matched")
+	var got searchJSON
+	if err := json.Unmarshal([]byte(r.Stdout), &got); err != nil {
+		t.Fatalf("expected JSON on stdout: %v\n%s", err, r.Stdout)
+	}
+	if got.Vault != "work" || got.Count != 2 || len(got.Secrets) != 2 {
+		t.Fatalf("expected 2 secrets from vault work, got %+v", got)
+	}
+	if s := got.Secrets[1]; s.Key != "github" || !slices.Equal(s.Value, []string{"user: alice", "token: abc123"}) {
+		t.Errorf("expected github and its two lines, got %+v", s)
+	}
+}
+
+func TestSearchFormatNDJSONPrintsOneSecretALine(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--format", "ndjson", "--full", "user", "--field", "user").
+		AssertOK().
+		AssertStdoutExactly(`{"vault":"work","key":"email","field":"user","value":["bob"]}` + "\n" +
+			`{"vault":"work","key":"github","field":"user","value":["alice"]}` + "\n")
+}
+
+func TestSearchFormatJSONReportsNoMatchesAsAnEmptyList(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	// Still exit 3, as a text search that finds nothing does, but with an
+	// object a program can read the count from.
+	l.Run("search", "-v", "work", "-p", pwFile, "--format", "json", "nothing-like-this").
+		AssertFailed().
+		AssertStdoutExactly(`{"vault":"work","count":0,"secrets":[]}` + "\n")
+	l.Run("search", "-v", "work", "-p", pwFile, "--format", "ndjson", "nothing-like-this").
+		AssertFailed().
+		AssertStdoutExactly("")
+}
+
+func TestSearchRejectsAnUnknownFormat(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("search", "-v", "work", "-p", pwFile, "--format", "yaml", "github").
+		AssertUsageError().
+		AssertStderr("--format must be text, json or ndjson, but is \"yaml\"").
+		AssertNoOutput("abc123")
+}
+
+func TestGetPrintsOnlyTheValue(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	// No report on stderr, so that the value can be used as it is.
+	r := l.Run("get", "-v", "work", "-p", pwFile, "github").
+		AssertOK().
+		AssertStdoutExactly("user: alice\ntoken: abc123\n")
+	if r.Stderr != "" {
+		t.Fatalf("expected nothing on stderr, got %q", r.Stderr)
+	}
+}
+
+func TestGetMatchesTheWholeKeyIgnoringCase(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault+"\nBANK\nsort code: 12-34-56\n")
+
+	l.Run("get", "-v", "work", "-p", pwFile, "Bank Account").
+		AssertOK().
+		AssertStdoutExactly("pin: 9999\n")
+	// Not a regular expression, and not a prefix.
+	for _, key := range []string{"bank.*", "git", "github "} {
+		l.Run("get", "-v", "work", "-p", pwFile, key).
+			AssertFailed().
+			AssertStderr("no secret has the key")
+	}
+}
+
+func TestGetPrintsOneLineOfTheValue(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+
+	l.Run("get", "-v", "work", "-p", pwFile, "--line", "2", "github").
+		AssertOK().
+		AssertStdoutExactly("token: abc123\n")
+	l.Run("get", "-v", "work", "-p", pwFile, "-l", "1", "github").
+		AssertOK().
+		AssertStdoutExactly("user: alice\n")
+	l.Run("get", "-v", "work", "-p", pwFile, "--line", "3", "github").
+		AssertFailed().
+		AssertStderr("has 2 value lines, so there is no line 3")
+	l.Run("get", "-v", "work", "-p", pwFile, "--line", "0", "github").AssertUsageError()
+}
+
+func TestGetRefusesAKeyThatSeveralSecretsShare(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault+"\nGitHub\ntoken: def456\n")
+
+	// Printing either would be a guess, and printing both would hand a script
+	// two values where it expects one.
+	r := l.Run("get", "-v", "work", "-p", pwFile, "github").
+		AssertFailed().
+		AssertStderr("2 secrets share it")
+	if r.Stdout != "" {
+		t.Fatalf("expected nothing on stdout, got %q", r.Stdout)
+	}
+}
+
+func TestSearchAllVaultsGroupsWhatMatchedByVault(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "aws prod\nid: AKIA1\n\ngithub\nuser: alice\n")
+	l.seedVault("home", "a password", "AWS root\nid: AKIA2\n")
+	l.seedVault("mail", "a password", "email\nuser: bob\n")
+	l.seedVault("other", "another password", "aws other\nid: AKIA3\n")
+
+	// The vault another password opens is skipped, not the search.
+	l.Run("search", "--all-vaults", "-p", pwFile, "aws").
+		AssertOK().
+		AssertStdoutExactly("== home: 1 secret ==\n\nAWS root\nid: AKIA2\n\n" +
+			"== work: 1 secret ==\n\naws prod\nid: AKIA1\n").
+		AssertStderr(`1 secret matched "aws" in vault home`).
+		AssertStderr("Warning: skipped vault other, which the password did not open").
+		AssertStderr(`2 secrets matched "aws" in 2 of 3 vaults searched`)
+
+	r := l.Run("search", "--all-vaults", "-p", pwFile, "--format", "json", "--field", "id", "aws").AssertOK()
+	var got struct {
+		Count  int `json:"count"`
+		Vaults []struct {
+			Vault   string `json:"vault"`
+			Count   int    `json:"count"`
+			Secrets []struct {
+				Key   string   `json:"key"`
+				Value []string `json:"value"`
+			} `json:"secrets"`
+		} `json:"vaults"`
+	}
+	if err := json.Unmarshal([]byte(r.Stdout), &got); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, r.Stdout)
+	}
+	// Every vault searched is there, including one that matched nothing.
+	if got.Count != 2 || len(got.Vaults) != 3 || got.Vaults[1].Vault != "mail" || got.Vaults[1].Count != 0 ||
+		got.Vaults[2].Secrets[0].Value[0] != "AKIA1" {
+		t.Fatalf("expected 2 matches among home, mail and work, got %+v", got)
+	}
+}
+
+func TestSearchAllVaultsExitsThreeOnlyWhenNothingMatchedAnywhere(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", searchVault)
+	l.seedVault("home", "a password", "aws\nid: 1\n")
+
+	r := l.Run("search", "--all-vaults", "-p", pwFile, "nothing-like-this").
+		AssertStdoutExactly("").
+		AssertStderr(`No secrets matched "nothing-like-this" in 2 vaults`)
+	if r.ExitCode != 3 {
+		t.Fatalf("expected exit 3\n%s", r.describe())
+	}
+	l.Run("search", "--all-vaults", "-p", pwFile, "aws").AssertOK()
+
+	// A search that could open no vault at all did not search, and fails.
+	wrong := l.PasswordFile("wrong.pw", "not the password")
+	l.Run("search", "--all-vaults", "-p", wrong, "aws").
+		AssertFailed().
+		AssertStderr("could not open any of the 2 vaults")
+	l.Run("search", "--all-vaults", "-v", "work", "-p", pwFile, "aws").
+		AssertUsageError().
+		AssertStderr("cannot be used with --vault")
+}
+
+func TestSearchAllVaultsAsksOncePerPassword(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("alpha", "first password", "aws a\nid: 1\n")
+	l.seedVault("beta", "second password", "aws b\nid: 2\n")
+	l.seedVault("delta", "forgotten password", "aws d\nid: 4\n")
+	l.seedVault("gamma", "first password", "aws g\nid: 3\n")
+
+	// alpha asks for the first password and gamma opens with it unasked; beta
+	// asks for the second, and delta is skipped with an empty answer.
+	r := l.RunTTY("first password\nsecond password\n\n", "search", "--all-vaults", "aws").AssertOK()
+	for _, name := range []string{"alpha", "beta", "delta"} {
+		if n := strings.Count(r.Output, "Password for vault "+name); n != 1 {
+			t.Fatalf("expected one prompt for vault %s, got %d\n%s", name, n, r.Output)
+		}
+	}
+	if strings.Contains(r.Output, "Password for vault gamma") {
+		t.Fatalf("expected gamma to open with the password alpha did\n%s", r.Output)
+	}
+	if !strings.Contains(r.Output, "aws g") || strings.Contains(r.Output, "aws d") {
+		t.Fatalf("expected gamma searched and delta skipped\n%s", r.Output)
+	}
+}
@@ -8,7 +8,8 @@ import (
 )
 
 // Capability 2: authoring secrets with `add` and `edit`, through a real editor
-// process editing a real plaintext file.
+// process editing a real plaintext file, and with `set` and `rm`, which a
+// script can run without one.
 
 func TestAddWritesSecretsToAnEmptyVault(t *testing.T) {
 	l := newLab(t)
@@ -104,9 +105,7 @@ func TestEditToEmptyIsConfirmedFirst(t *testing.T) {
 
 	l.Run("export", "-v", "personal", "-p", pwFile).AssertOK().AssertStdoutEquals("")
 	// The backup written before the save is the user's way back.
-	if _, err := os.Stat(l.VaultPath("personal") + ".bak"); err != nil {
-		t.Fatalf("expected a backup of the emptied vault: %s", err)
-	}
+	l.latestBackup("personal")
 }
 
 func TestEditToEmptyIsRefusedByDefault(t *testing.T) {
@@ -401,3 +400,96 @@ func assertNoPlaintextUnder(t *testing.T, dir string, secrets ...string) {
 		t.Fatalf("failed to walk %s: %s", dir, err)
 	}
 }
+
+func TestSetAddsASecretFromStdin(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "zebra key\nzebra value\n")
+	// The editor would fail the test if it were opened.
+	l.Setenv("FAKE_EDITOR_MODE", "fail")
+
+	l.RunStdin("user: alice\ntoken: abc123\n", "set", "-v", "personal", "-p", pwFile, "alpha key").
+		AssertOK().
+		AssertStderr(`Added secret "alpha key" to vault personal`)
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("alpha key\nuser: alice\ntoken: abc123\n\nzebra key\nzebra value\n")
+}
+
+func TestSetReplacesTheSecretWithTheKeyIgnoringCase(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "API Token\nold-value\n\nother\nother value\n")
+	valueFile := l.WriteFile("value", "new-value\n")
+
+	l.Run("set", "-v", "personal", "-p", pwFile, "--value-file", valueFile, "api token").
+		AssertOK().
+		AssertStderr(`Replaced secret "api token" in vault personal`)
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("api token\nnew-value\n\nother\nother value\n")
+	l.latestBackup("personal")
+}
+
+func TestSetRefusesAValueThatWouldSplitIntoTwoSecrets(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\na value\n")
+	before := readFile(t, l.VaultPath("personal"))
+
+	l.RunStdin("first\n\nsecond\n", "set", "-v", "personal", "-p", pwFile, "new key").
+		AssertFailed().
+		AssertStderr("cannot contain a blank line")
+	l.Run("set", "-v", "personal", "-p", pwFile, "  ").
+		AssertFailed().
+		AssertStderr("cannot be blank")
+	if readFile(t, l.VaultPath("personal")) != before {
+		t.Fatal("expected the vault to be left unchanged")
+	}
+}
+
+func TestSetAndRmRefuseAKeyThatSeveralSecretsShareUnlessToldAll(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "github\none\n\nGitHub\ntwo\n\nother\nthree\n")
+
+	for _, args := range [][]string{
+		{"set", "-v", "personal", "-p", pwFile, "github"},
+		{"rm", "-v", "personal", "-p", pwFile, "github"},
+	} {
+		r := l.RunStdin("new\n", args...).AssertFailed().AssertStderr("--all")
+		if r.ExitCode != 4 {
+			t.Fatalf("expected exit 4 for a key several secrets share, got %d", r.ExitCode)
+		}
+	}
+
+	l.RunStdin("merged\n", "set", "-v", "personal", "-p", pwFile, "--all", "github").
+		AssertOK().
+		AssertStderr(`Replaced 2 secrets "github" in vault personal with one`)
+	l.Run("export", "-v", "personal", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("github\nmerged\n\nother\nthree\n")
+}
+
+func TestRmRemovesASecret(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("personal", "a password", "a key\na value\n\nb key\nb value\n")
+
+	l.Run("rm", "-v", "personal", "-p", pwFile, "A KEY").
+		AssertOK().
+		AssertStderr(`Removed 1 secret "A KEY" from vault personal`)
+	l.Run("export", "-v", "personal", "-p", pwFile).AssertOK().AssertStdoutExactly("b key\nb value\n")
+
+	r := l.Run("rm", "-v", "personal", "-p", pwFile, "a key").
+		AssertFailed().
+		AssertStderr("no secret has the key")
+	if r.ExitCode != 3 {
+		t.Fatalf("expected exit 3 for a key no secret has, got %d", r.ExitCode)
+	}
+}
+
+func TestSetFromAPipeNeedsAPasswordFile(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("personal", "a password", "a key\na value\n")
+
+	// The value is on stdin, so the password cannot be.
+	l.RunStdin("a value\n", "set", "-v", "personal", "a key").
+		AssertFailed().
+		AssertStderr("--password-file")
+}
@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Capability 19: team vaults, which `vault create --team` seals to the
+// identity that `identity create` makes rather than to a password, and which
+// `vault member add` and `vault member remove` share with other identities and
+// take back from them, re-keying the vault as they do.
+
+// asBob points mrs at a second user's $MRS_HOME, which has an identity of its
+// own and the same vaults, and returns a function that points it back.
+func (l *lab) asBob() func() {
+	l.t.Helper()
+	home := filepath.Join(filepath.Dir(l.Home), "bob-home")
+	if _, err := os.Stat(home); os.IsNotExist(err) {
+		if err := os.MkdirAll(home, 0700); err != nil {
+			l.t.Fatal(err)
+		}
+		if err := os.Symlink(l.VaultDir(), filepath.Join(home, "vaults")); err != nil {
+			l.t.Fatal(err)
+		}
+	}
+	l.Setenv("MRS_HOME", home)
+	return func() { l.Setenv("MRS_HOME", l.Home) }
+}
+
+func TestATeamVaultOpensForEachMemberUntilTheyAreRemoved(t *testing.T) {
+	l := newLab(t)
+	alicePw := l.PasswordFile("alice.pw", "alice's password")
+	bobPw := l.PasswordFile("bob.pw", "bob's password")
+	l.Run("identity", "create", "--name", "alice", "-p", alicePw).AssertOK().AssertStderr("mrs1")
+	alice := l.Run("identity", "show").AssertOK().Stdout
+	l.Run("vault", "create", "--team", "ops", "-i", l.WriteFile("ops.import", "db\nhunter2\n")).AssertOK()
+	l.Run("vault", "member", "list", "ops").AssertOK().AssertStdoutExactly(alice)
+
+	back := l.asBob()
+	l.Run("identity", "create", "--name", "bob", "-p", bobPw).AssertOK()
+	bob := strings.TrimSpace(l.Run("identity", "show").AssertOK().Stdout)
+	l.Run("export", "-v", "ops", "-p", bobPw).
+		AssertFailed().
+		AssertStderr("not a member").
+		AssertNoOutput("hunter2")
+	back()
+
+	l.Run(append([]string{"vault", "member", "add", "ops", "-p", alicePw}, strings.Fields(bob)...)...).AssertOK()
+	l.Run("vault", "member", "list", "ops").AssertOK().AssertStdoutExactly(alice + bob + "\n")
+	l.Run("vault", "info", "ops", "-p", alicePw).AssertOK().AssertStdout(bob)
+
+	back = l.asBob()
+	l.RunStdin("token\n", "set", "-v", "ops", "-p", bobPw, "api").AssertOK()
+	l.Run("export", "-v", "ops", "-p", bobPw).AssertOK().AssertStdout("hunter2").AssertStdout("token")
+	back()
+
+	l.Run("vault", "member", "remove", "ops", "bob", "-p", alicePw).AssertOK().AssertStderr("re-keyed")
+	l.Run("vault", "member", "list", "ops").AssertOK().AssertStdoutExactly(alice)
+	l.RunStdin("rotated\n", "set", "-v", "ops", "-p", alicePw, "db").AssertOK()
+	l.Run("export", "-v", "ops", "-p", alicePw).AssertOK().AssertStdout("rotated")
+
+	back = l.asBob()
+	l.Run("export", "-v", "ops", "-p", bobPw).
+		AssertFailed().
+		AssertStderr("not a member").
+		AssertNoOutput("rotated")
+	back()
+}
+
+func TestATeamVaultRefusesWhatOnlyAPasswordVaultCanDo(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.createVault("personal", "a password")
+	l.Run("vault", "create", "--team", "ops").AssertFailed().AssertStderr("mrs identity create")
+
+	alicePw := l.PasswordFile("alice.pw", "alice's password")
+	l.Run("identity", "create", "-p", alicePw).AssertOK()
+	l.Run("identity", "create", "-p", alicePw).AssertFailed().AssertStderr("already exists")
+	l.Run("vault", "create", "--team", "ops", "-p", alicePw).AssertUsageError()
+	l.Run("vault", "create", "--team", "ops").AssertOK()
+
+	l.Run("vault", "change-password", "ops", "-p", alicePw, "-n", pwFile).
+		AssertFailed().
+		AssertStderr("team vault")
+	l.Run("vault", "member", "remove", "ops", strings.Fields(l.Run("identity", "show").Stdout)[0], "-p", alicePw).
+		AssertFailed().
+		AssertStderr("only member")
+	l.Run("vault", "member", "add", "ops", "not-a-key", "-p", alicePw).AssertUsageError()
+	l.Run("vault", "member", "list", "personal").AssertFailed().AssertStderr("not a team vault")
+	l.Run("export", "-v", "ops", "-p", pwFile).AssertFailed().AssertStderr("failed to decrypt your identity")
+}
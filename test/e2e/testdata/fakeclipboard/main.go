@@ -0,0 +1,53 @@
+// Command fake-clipboard stands in for the clipboard programs, wl-copy and
+// wl-paste or their like, in the end-to-end tests. mrs runs it as
+// $MRS_CLIPBOARD and $MRS_CLIPBOARD_PASTE, and the clipboard it keeps is a
+// file, so that a test can read it, and replace it as a user copying
+// something else would.
+//
+//	fake-clipboard copy   replaces the clipboard with its stdin
+//	fake-clipboard paste  prints the clipboard
+//
+//	FAKE_CLIPBOARD_FILE  the file the clipboard is kept in
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	p := os.Getenv("FAKE_CLIPBOARD_FILE")
+	if p == "" || len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "fake-clipboard: expected copy or paste, and FAKE_CLIPBOARD_FILE")
+		return 2
+	}
+	switch os.Args[1] {
+	case "copy":
+		b, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			err = os.WriteFile(p, b, 0600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fake-clipboard: could not copy: %s\n", err)
+			return 1
+		}
+	case "paste":
+		b, err := os.ReadFile(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "fake-clipboard: could not paste: %s\n", err)
+			return 1
+		}
+		_, _ = os.Stdout.Write(b)
+	default:
+		fmt.Fprintf(os.Stderr, "fake-clipboard: unknown command %q\n", os.Args[1])
+		return 2
+	}
+	return 0
+}
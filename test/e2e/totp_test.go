@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Capability 17: two-factor codes, with `totp`, which computes the current
+// code from an otpauth://totp/ URI or totp field stored in a secret, without
+// ever printing the seed it is computed from.
+
+// totpSeed is RFC 6238's SHA-1 seed, "12345678901234567890", in base32.
+const totpSeed = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// codes returns the 6-digit SHA-1 codes of totpSeed around now, since the one
+// mrs computed may be from the 30 seconds before or after the test's.
+func codes(now time.Time) []string {
+	var out []string
+	for _, d := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		var msg [8]byte
+		binary.BigEndian.PutUint64(msg[:], uint64(now.Add(d).Unix()/30))
+		mac := hmac.New(sha1.New, []byte("12345678901234567890"))
+		mac.Write(msg[:])
+		sum := mac.Sum(nil)
+		offset := sum[len(sum)-1] & 0x0f
+		n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+		out = append(out, fmt.Sprintf("%06d\n", n%1000000))
+	}
+	return out
+}
+
+func TestTOTPPrintsTheCurrentCode(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password",
+		"github\nuser: alice\notpauth://totp/GitHub:alice?secret="+totpSeed+"&issuer=GitHub\n\n"+
+			"aws\npassword: hunter2\ntotp: "+totpSeed+"\n")
+
+	for _, key := range []string{"GitHub", "aws"} {
+		r := l.Run("totp", "-v", "work", "-p", pwFile, key).
+			AssertOK().
+			AssertNoOutput(totpSeed)
+		found := false
+		for _, want := range codes(time.Now()) {
+			found = found || r.Stdout == want
+		}
+		if !found {
+			t.Errorf("%s: expected one of %q, got %q", key, codes(time.Now()), r.Stdout)
+		}
+		if !regexp.MustCompile(`^Valid for \d+ more seconds?\n$`).MatchString(r.Stderr) {
+			t.Errorf("%s: expected the seconds remaining on stderr, got %q", key, r.Stderr)
+		}
+	}
+}
+
+func TestTOTPReadsTheURIsParameters(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password",
+		"vpn\ntotp: otpauth://totp/vpn?secret="+totpSeed+"&digits=8&algorithm=SHA256&period=60\n")
+
+	if out := l.Run("totp", "-v", "work", "-p", pwFile, "vpn").AssertOK().Stdout; !regexp.MustCompile(`^\d{8}\n$`).MatchString(out) {
+		t.Fatalf("expected an 8-digit code, got %q", out)
+	}
+}
+
+func TestTOTPRefusesWhatItCannotUse(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password",
+		"github\ngh-token\n\n"+
+			"broken\ntotp: otpauth://totp/x?secret=NOT-BASE32!&issuer=x\n\n"+
+			"dup\none\n\ndup\ntwo\n")
+
+	l.Run("totp", "-v", "work", "-p", pwFile, "github").
+		AssertFailed().
+		AssertStderr(`has no otpauth://totp/ line, and has no field "totp"`)
+	if code := l.Run("totp", "-v", "work", "-p", pwFile, "github").ExitCode; code != 3 {
+		t.Errorf("expected a secret without a seed to exit 3, got %d", code)
+	}
+	l.Run("totp", "-v", "work", "-p", pwFile, "nothing").AssertFailed()
+	l.Run("totp", "-v", "work", "-p", pwFile, "broken").
+		AssertFailed().
+		AssertStderr("not valid base32").
+		AssertNoOutput("NOT-BASE32")
+	if code := l.Run("totp", "-v", "work", "-p", pwFile, "dup").ExitCode; code != 4 {
+		t.Errorf("expected a key several secrets share to exit 4, got %d", code)
+	}
+	l.Run("totp", "-v", "work").AssertUsageError()
+}
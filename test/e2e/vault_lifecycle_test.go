@@ -1,6 +1,8 @@
 package e2e
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -183,6 +185,51 @@ func TestDefaultPrintsTheOnlyVault(t *testing.T) {
 	l.Run("vault", "default", "--path").AssertOK().AssertStdoutEquals(l.VaultPath("personal"))
 }
 
+func TestListAndDefaultPrintJSON(t *testing.T) {
+	l := newLab(t)
+	l.createVault("personal", "a password")
+	l.createVault("work", "a password")
+
+	r := l.Run("vault", "list", "--format", "json").AssertOK()
+	var list struct {
+		Count  int `json:"count"`
+		Vaults []struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"vaults"`
+	}
+	if err := json.Unmarshal([]byte(r.Stdout), &list); err != nil {
+		t.Fatalf("expected JSON on stdout: %v\n%s", err, r.Stdout)
+	}
+	if list.Count != 2 || list.Vaults[0].Name != "personal" || list.Vaults[1].Path != l.VaultPath("work") {
+		t.Errorf("expected personal and work with their paths, got %+v", list)
+	}
+
+	l.Run("vault", "list", "--format", "ndjson").
+		AssertOK().
+		AssertStdoutExactly(`{"name":"personal","path":"` + l.VaultPath("personal") + `"}` + "\n" +
+			`{"name":"work","path":"` + l.VaultPath("work") + `"}` + "\n")
+
+	l.Setenv("MRS_DEFAULT_VAULT_NAME", "work")
+	l.Run("vault", "default", "--format", "json").
+		AssertOK().
+		AssertStdoutExactly(`{"name":"work","path":"` + l.VaultPath("work") + `"}` + "\n")
+}
+
+func TestListFormatIsChecked(t *testing.T) {
+	l := newLab(t)
+	l.createVault("personal", "a password")
+
+	l.Run("vault", "list", "--format", "xml").
+		AssertUsageError().
+		AssertStderr("--format must be text, json or ndjson")
+	// A JSON object has the path already, so --path is not quietly dropped.
+	l.Run("vault", "default", "--format", "json", "--path").
+		AssertUsageError().
+		AssertStderr("--path prints paths as text")
+	l.Run("vault", "list", "--format", "text").AssertOK().AssertStdoutEquals("personal")
+}
+
 // A caller reading the default vault out of this command gets an error rather
 // than an empty line and a success.
 func TestDefaultFailsWhenNoVaultsExist(t *testing.T) {
@@ -237,9 +284,7 @@ func TestListIgnoresLockBackupAndTempFiles(t *testing.T) {
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
 
 	vaultPath := l.VaultPath("personal")
-	if _, err := os.Stat(vaultPath + ".bak"); err != nil {
-		t.Fatalf("expected a backup file next to the vault: %s", err)
-	}
+	l.latestBackup("personal")
 	// A leftover temporary file from an interrupted write.
 	if err := os.WriteFile(vaultPath+".1234.tmp", []byte("x"), 0600); err != nil {
 		t.Fatalf("failed to write temp file: %s", err)
@@ -258,7 +303,7 @@ func TestRenamePreservesTheSecrets(t *testing.T) {
 	l := newLab(t)
 	pwFile := l.seedVault("personal", "a password", "a key\na value\n")
 
-	l.Run("vault", "rename", "personal", "renamed").
+	l.Run("vault", "rename", "personal", "renamed", "-p", pwFile).
 		AssertOK().
 		AssertStderr("Renamed vault personal to renamed")
 
@@ -322,16 +367,28 @@ func TestRenameMovesTheBackupFile(t *testing.T) {
 	l.Setenv("FAKE_EDITOR_CONTENT", "a key\na value\n")
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
 
-	oldBackup := l.VaultPath("personal") + ".bak"
-	if _, err := os.Stat(oldBackup); err != nil {
-		t.Fatalf("expected a backup to exist before the rename: %s", err)
+	l.editorAppends("\nb key\nb value\n")
+	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
+	oldPath := l.VaultPath("personal")
+	oldBackups := l.Backups("personal")
+	if len(oldBackups) != 2 {
+		t.Fatalf("expected two backups before the rename, got %v", oldBackups)
 	}
 
-	l.Run("vault", "rename", "personal", "renamed").AssertOK()
+	l.Run("vault", "rename", "personal", "renamed", "-p", pwFile).AssertOK()
 
-	assertNotExists(t, oldBackup)
-	if _, err := os.Stat(l.VaultPath("renamed") + ".bak"); err != nil {
-		t.Fatalf("expected the backup to move with the vault: %s", err)
+	for _, b := range oldBackups {
+		assertNotExists(t, b)
+	}
+	// Each keeps the time it was made.
+	newBackups := l.Backups("renamed")
+	for i, b := range newBackups {
+		if strings.TrimPrefix(b, l.VaultPath("renamed")) != strings.TrimPrefix(oldBackups[i], oldPath) {
+			t.Fatalf("expected the backups %v to move with the vault, got %v", oldBackups, newBackups)
+		}
+	}
+	if len(newBackups) != len(oldBackups) {
+		t.Fatalf("expected the backups %v to move with the vault, got %v", oldBackups, newBackups)
 	}
 }
 
@@ -371,10 +428,7 @@ func TestDeleteRemovesTheBackupFile(t *testing.T) {
 	l.Setenv("FAKE_EDITOR_CONTENT", "a key\na value\n")
 	l.Run("edit", "-v", "personal", "-p", pwFile).AssertOK()
 
-	backup := l.VaultPath("personal") + ".bak"
-	if _, err := os.Stat(backup); err != nil {
-		t.Fatalf("expected a backup to exist before the delete: %s", err)
-	}
+	backup := l.latestBackup("personal")
 
 	l.Run("vault", "delete", "personal", "--yes").AssertOK()
 
@@ -563,7 +617,7 @@ func TestAnExactNameIsReachableByEveryCommand(t *testing.T) {
 		AssertOK().
 		AssertStderr("Changed password of vault work")
 
-	l.Run("vault", "rename", "work", "current").AssertOK()
+	l.Run("vault", "rename", "work", "current", "-p", newPw).AssertOK()
 	l.Run("vault", "list").AssertOK().AssertStdoutEquals("current\nwork-archive")
 
 	l.Run("vault", "delete", "work-archive", "--yes").AssertOK()
@@ -599,15 +653,172 @@ func TestVaultNamesAreCaseSensitive(t *testing.T) {
 		AssertNoOutput("lower value")
 }
 
+func TestInfoDescribesAVaultWithoutPrintingItsSecrets(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\nthe-secret-value\n\nb key\nb value\n\na key\nanother value\n")
+	// A second save leaves a backup, and a crashed one a temp file.
+	l.editorAppends("\nc key\nc value\n")
+	l.Run("edit", "-v", "work", "-p", pwFile).AssertOK()
+	tmp := l.VaultPath("work") + ".1234.tmp"
+	if err := os.WriteFile(tmp, []byte("x"), 0600); err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+
+	r := l.Run("vault", "info", "work", "-p", pwFile).AssertOK()
+	for _, want := range []string{
+		"Path:", l.VaultPath("work"),
+		"Salt:", strings.SplitN(filepath.Base(l.VaultPath("work")), ".", 2)[1],
+		"Format:          2",
+		"argon2id, 3 iterations, 64 MiB, 4 threads",
+		"Current:         yes",
+		"Backups:         1",
+		tmp,
+		"Locked:          no",
+		"Secrets:         4",
+		"Duplicate keys:  1",
+	} {
+		r.AssertStdout(want)
+	}
+	r.AssertNoOutput("the-secret-value")
+}
+
+func TestInfoPrintsJSON(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+
+	l.Run("vault", "info", "work", "-p", pwFile, "--format", "csv").AssertUsageError()
+	r := l.Run("vault", "info", "work", "-p", pwFile, "--format", "json").AssertOK()
+	var got struct {
+		Name          string   `json:"name"`
+		Path          string   `json:"path"`
+		Size          int64    `json:"size"`
+		FormatVersion int      `json:"format_version"`
+		KDF           string   `json:"kdf"`
+		Iterations    int      `json:"iterations"`
+		MemoryKiB     int      `json:"memory_kib"`
+		Current       bool     `json:"current"`
+		Backups       []string `json:"backups"`
+		TempFiles     []string `json:"temp_files"`
+		Secrets       int      `json:"secrets"`
+		DuplicateKeys int      `json:"duplicate_keys"`
+	}
+	if err := json.Unmarshal([]byte(r.Stdout), &got); err != nil {
+		t.Fatalf("expected JSON, got %q: %s", r.Stdout, err)
+	}
+	if got.Name != "work" || got.Path != l.VaultPath("work") || got.Size == 0 {
+		t.Fatalf("unexpected file details: %+v", got)
+	}
+	if got.FormatVersion != 2 || got.KDF != "argon2id" || got.Iterations != 3 || got.MemoryKiB != 64*1024 || !got.Current {
+		t.Fatalf("unexpected key derivation: %+v", got)
+	}
+	if got.Backups == nil || len(got.Backups) != 0 || got.TempFiles == nil || len(got.TempFiles) != 0 {
+		t.Fatalf("expected empty lists of backups and temp files: %+v", got)
+	}
+	if got.Secrets != 1 || got.DuplicateKeys != 0 {
+		t.Fatalf("unexpected counts: %+v", got)
+	}
+
+	r = l.Run("vault", "info", "work", "-p", pwFile, "--format", "ndjson").AssertOK()
+	if strings.Count(r.Stdout, "\n") != 1 || !strings.Contains(r.Stdout, `"name":"work"`) {
+		t.Fatalf("expected one JSON object on one line, got %q", r.Stdout)
+	}
+}
+
+func TestInfoRefusesAWrongPasswordAndAPrefix(t *testing.T) {
+	l := newLab(t)
+	l.seedVault("work", "a password", "a key\na value\n")
+
+	l.Run("vault", "info", "work", "-p", l.PasswordFile("wrong.pw", "not the password")).
+		AssertFailed().
+		AssertStderr("failed to decrypt vault work")
+	l.Run("vault", "info", "wo", "-p", l.PasswordFile("pw", "a password")).AssertFailed()
+	l.Run("vault", "info").AssertUsageError()
+}
+
+func TestRestoreListsBackupsNewestFirstAndRestoresOne(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\ngood-value\n\nb key\nb value\n")
+	l.editorWrites("a key\nbad-value\n")
+	l.Run("edit", "-v", "work", "-p", pwFile).AssertOK()
+	l.editorWrites("")
+	l.Run("edit", "-v", "work", "-p", pwFile, "--yes").AssertOK()
+
+	r := l.Run("vault", "restore", "work", "-p", pwFile).AssertOK().AssertNoOutput("good-value")
+	lines := strings.Split(strings.TrimSpace(r.Stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "GENERATION") {
+		t.Fatalf("expected a heading and two backups, got %q", r.Stdout)
+	}
+	for i, secrets := range []string{"1", "2"} {
+		fields := strings.Fields(lines[i+1])
+		if len(fields) != 3 || fields[0] != fmt.Sprint(i+1) || fields[2] != secrets {
+			t.Fatalf("expected generation %d to hold %s secrets, got %q", i+1, secrets, lines[i+1])
+		}
+	}
+
+	l.Run("vault", "restore", "work", "--from", "2", "-p", pwFile).
+		AssertOK().
+		AssertStderr("Restored vault work from the backup made")
+	l.Run("export", "-v", "work", "-p", pwFile).
+		AssertOK().
+		AssertStdoutExactly("a key\ngood-value\n\nb key\nb value\n")
+	// What the restore replaced is kept, so a restore can be undone too.
+	if backups := l.Backups("work"); len(backups) != 3 {
+		t.Fatalf("expected the emptied vault to be kept as a third backup, got %v", backups)
+	}
+}
+
+func TestRestoreOfABackupFromBeforeAChangeOfPassword(t *testing.T) {
+	l := newLab(t)
+	oldPw := l.seedVault("work", "the old password", "a key\nold-value\n")
+	newPw := l.PasswordFile("new.pw", "the new password")
+	l.Run("vault", "change-password", "work", "-p", oldPw, "-n", newPw).AssertOK()
+
+	l.Run("vault", "restore", "work", "-p", newPw).
+		AssertOK().
+		AssertStdout("does not open with this password").
+		AssertStderr("opens only with the password the vault had then")
+	l.Run("vault", "restore", "work", "--from", "1", "-p", newPw).
+		AssertFailed().
+		AssertStderr("opens only with the password the vault had then")
+
+	// With the password it had then, it is restored, and brings that password
+	// back with it.
+	l.Run("vault", "restore", "work", "--from", "1", "-p", oldPw).
+		AssertOK().
+		AssertStderr("now opens with the password it had then")
+	l.Run("export", "-v", "work", "-p", oldPw).AssertOK().AssertStdoutExactly("a key\nold-value\n")
+}
+
+func TestRestoreRefusesAGenerationThatIsNotThere(t *testing.T) {
+	l := newLab(t)
+	pwFile := l.seedVault("work", "a password", "a key\na value\n")
+
+	l.Run("vault", "restore", "work", "-p", pwFile).
+		AssertOK().
+		AssertStderr("Vault work has no backups")
+	l.Run("vault", "restore", "work", "--from", "1", "-p", pwFile).
+		AssertFailed().
+		AssertStderr("vault work has no backups")
+
+	l.editorAppends("\nb key\nb value\n")
+	l.Run("edit", "-v", "work", "-p", pwFile).AssertOK()
+	for _, from := range []string{"0", "2"} {
+		l.Run("vault", "restore", "work", "--from", from, "-p", pwFile).
+			AssertFailed().
+			AssertStderr("--from must be from 1 to 1")
+	}
+	l.Run("vault", "restore", "wo", "--from", "1", "-p", pwFile).AssertFailed()
+}
+
 func TestHelpDocumentsEveryCommand(t *testing.T) {
 	l := newLab(t)
 
 	root := l.Run("help").AssertOK()
-	for _, c := range []string{"add", "edit", "export", "search", "vault"} {
+	for _, c := range []string{"add", "agent", "archive", "edit", "exec", "export", "generate", "get", "identity", "import", "inject", "lock", "rm", "search", "set", "totp", "vault"} {
 		root.AssertStdout(c)
 	}
 	vaultHelp := l.Run("help", "vault").AssertOK()
-	for _, c := range []string{"change-password", "create", "default", "delete", "list", "rename"} {
+	for _, c := range []string{"change-password", "create", "default", "delete", "info", "list", "member", "paper-backup", "paper-restore", "recovery", "rename", "restore", "upgrade"} {
 		vaultHelp.AssertStdout(c)
 	}
 }